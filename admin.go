@@ -0,0 +1,581 @@
+package dgws
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/page"
+	"github.com/darwinOrg/go-common/result"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const AdminTokenHeader = "X-Dgws-Admin-Token"
+
+var ErrAdminUnprotected = errors.New("websocket admin routes require Token or AllowRoles")
+
+// AdminOptions 管理接口的鉴权与业务的websocket路由相互独立
+type AdminOptions struct {
+	RouterGroup *gin.RouterGroup
+	// Token 非空时校验请求头X-Dgws-Admin-Token, 此时不再要求登录
+	Token string
+	// PreHandlersChain 自定义鉴权, 在登录校验之前执行
+	PreHandlersChain gin.HandlersChain
+	// NonLogin Token为空时是否跳过登录校验
+	NonLogin bool
+	// AllowRoles 允许访问的角色
+	AllowRoles []string
+	// JournalSource 不为nil时开启GET journal/export, 导出连接记录用于审计
+	JournalSource JournalSource
+}
+
+type ConnectionInfo struct {
+	Id           string            `json:"id"`
+	SessionId    string            `json:"sessionId,omitempty"`
+	Path         string            `json:"path"`
+	BizKey       string            `json:"bizKey"`
+	BizId        string            `json:"bizId"`
+	UserId       int64             `json:"userId"`
+	RemoteIp     string            `json:"remoteIp"`
+	ConnectedAt  time.Time         `json:"connectedAt"`
+	State        string            `json:"state"`
+	PendingBytes int64             `json:"pendingBytes"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+const (
+	defaultAdminPageSize = 20
+	maxAdminPageSize     = 500
+)
+
+// AdminListConnectionsRequest 条件之间为与的关系, 按连接时间排序后分页
+type AdminListConnectionsRequest struct {
+	Path     string `form:"path"`
+	BizKey   string `form:"bizKey"`
+	BizId    string `form:"bizId"`
+	UserId   int64  `form:"userId"`
+	RemoteIp string `form:"remoteIp"`
+	// Label 为"key"时匹配有该标签的连接, 为"key=value"时还需值相等, 见Connection.SetLabel
+	Label string `form:"label"`
+	// ConnectedAfter、ConnectedBefore 连接时间范围, 毫秒时间戳, 0表示不限制
+	ConnectedAfter  int64 `form:"connectedAfter"`
+	ConnectedBefore int64 `form:"connectedBefore"`
+	// PageNo 从1开始, 默认1
+	PageNo int `form:"pageNo" binding:"gte=0"`
+	// PageSize 默认20, 最大500
+	PageSize int `form:"pageSize" binding:"gte=0"`
+}
+
+func (req *AdminListConnectionsRequest) match(c *Connection) bool {
+	return (req.Path == "" || c.Path == req.Path) &&
+		(req.BizKey == "" || c.BizKey == req.BizKey) &&
+		(req.BizId == "" || c.BizId == req.BizId) &&
+		(req.UserId == 0 || c.UserId == req.UserId) &&
+		(req.RemoteIp == "" || c.RemoteIp == req.RemoteIp) &&
+		(req.ConnectedAfter == 0 || c.ConnectedAt.UnixMilli() >= req.ConnectedAfter) &&
+		(req.ConnectedBefore == 0 || c.ConnectedAt.UnixMilli() < req.ConnectedBefore) &&
+		(req.Label == "" || c.matchLabel(req.Label))
+}
+
+type AdminConnectionRequest struct {
+	Id string `form:"id" json:"id" binding:"required"`
+}
+
+type AdminCloseConnectionRequest struct {
+	Id string `json:"id" binding:"required"`
+	// CloseCode 默认1008, 只允许1000-1003、1007-1014和3000-4999
+	CloseCode int    `json:"closeCode"`
+	Reason    string `json:"reason"`
+}
+
+const (
+	AdminBroadcastTargetAll    = "all"
+	AdminBroadcastTargetBizIds = "bizIds"
+)
+
+// AdminBroadcastRequest Target为all时发给所有连接, 为bizIds时发给BizKey下BizIds的连接(按房间推送时即房间id)
+type AdminBroadcastRequest struct {
+	Target string   `json:"target" binding:"required,oneof=all bizIds"`
+	BizKey string   `json:"bizKey"`
+	BizIds []string `json:"bizIds"`
+	// MessageType 只能是数据帧, 默认websocket.TextMessage
+	MessageType int    `json:"messageType" binding:"omitempty,oneof=1 2"`
+	Data        string `json:"data"`
+}
+
+// AdminBroadcastResult Sent为本实例发送成功的连接数, 其他实例的发送结果不计入
+type AdminBroadcastResult struct {
+	Sent int `json:"sent"`
+}
+
+// AdminDiagnosticsRequest Diagnostics为nil时清除Path的设置, 见SetRouteDiagnostics
+type AdminDiagnosticsRequest struct {
+	Path        string            `json:"path"`
+	Diagnostics *RouteDiagnostics `json:"diagnostics"`
+}
+
+// AdminMaintenanceRequest Enabled为false时退出维护状态
+type AdminMaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	// RetryAfter 秒
+	RetryAfter int64 `json:"retryAfter" binding:"gte=0"`
+}
+
+// AdminRouteRequest Path为路由的完整路径, 见RouteStatus
+type AdminRouteRequest struct {
+	Path     string `json:"path" binding:"required"`
+	Disabled bool   `json:"disabled"`
+	Message  string `json:"message"`
+}
+
+const (
+	AdminExportFormatJson   = "json"
+	AdminExportFormatNdjson = "ndjson"
+)
+
+// AdminExportJournalRequest SessionId、ConnectionId、UserId至少指定一个; From、To为毫秒时间戳, 0表示不限制
+type AdminExportJournalRequest struct {
+	SessionId    string `form:"sessionId"`
+	ConnectionId string `form:"connectionId"`
+	UserId       int64  `form:"userId"`
+	From         int64  `form:"from"`
+	To           int64  `form:"to"`
+	// Format json或ndjson, 默认ndjson
+	Format string `form:"format" binding:"omitempty,oneof=json ndjson"`
+}
+
+// AdminQuotaRequest 只调整不为nil的配额, 0表示不限制
+type AdminQuotaRequest struct {
+	ConnLimit *uint `json:"connLimit"`
+	// RoutePath 路由的完整路径, 与RouteConnLimit一起设置
+	RoutePath      string `json:"routePath"`
+	RouteConnLimit *int   `json:"routeConnLimit" binding:"omitempty,gte=0"`
+	UserConnLimit  *int   `json:"userConnLimit" binding:"omitempty,gte=0"`
+	// UserId 与UserIdConnLimit一起设置, UserIdConnLimit小于0时恢复默认上限
+	UserId          int64 `json:"userId"`
+	UserIdConnLimit *int  `json:"userIdConnLimit"`
+}
+
+type AdminUserQuotaRequest struct {
+	UserId int64 `form:"userId"`
+}
+
+const (
+	AdminInjectDirectionIn  = "in"
+	AdminInjectDirectionOut = "out"
+)
+
+// AdminInjectRequest Direction为out时写给客户端, 为in时模拟客户端发来的消息交给BizHandler;
+// Base64为true时Data按base64解码, 用于构造任意二进制帧
+type AdminInjectRequest struct {
+	Id        string `json:"id" binding:"required"`
+	Direction string `json:"direction" binding:"required,oneof=in out"`
+	// MessageType 只能是数据帧, 默认websocket.TextMessage
+	MessageType int    `json:"messageType" binding:"omitempty,oneof=1 2"`
+	Data        string `json:"data"`
+	Base64      bool   `json:"base64"`
+}
+
+type AdminSendRequest struct {
+	Id string `json:"id" binding:"required"`
+	// MessageType 只能是数据帧, 默认websocket.TextMessage
+	MessageType int    `json:"messageType" binding:"omitempty,oneof=1 2"`
+	Data        string `json:"data"`
+}
+
+// RegisterAdminRoutes 注册连接管理接口:
+// GET connections 分页检索本实例的连接, GET connections/detail 查看单个连接,
+// POST connections/close 关闭连接, POST connections/send 向连接发送消息,
+// POST connections/inject 向连接注入构造的消息或模拟客户端发来的消息,
+// POST broadcast 广播消息, 开启InitCluster时同时投递到其他实例,
+// GET diagnostics 查看、POST diagnostics 修改路由的诊断开关(只作用于本实例), GET metrics 查看实时指标,
+// GET maintenance 查看、POST maintenance 进入或退出维护状态(只作用于本实例),
+// GET routes 查看、POST routes 关闭或开启websocket路由(只作用于本实例),
+// GET journal/export 按会话或用户导出JournalSource中的记录,
+// GET quotas 查看、POST quotas 调整连接配额(只作用于本实例).
+// Token与AllowRoles都为空时任何登录用户都能操作所有连接, 此时不注册并返回ErrAdminUnprotected
+func RegisterAdminRoutes(opts *AdminOptions) error {
+	if opts.Token == "" && len(opts.AllowRoles) == 0 {
+		return ErrAdminUnprotected
+	}
+
+	admin := &adminRouter{opts: opts, chain: slices.Clone(opts.PreHandlersChain), nonLogin: opts.NonLogin}
+	if opts.Token != "" {
+		admin.chain = append(gin.HandlersChain{adminTokenHandler(opts.Token)}, admin.chain...)
+		admin.nonLogin = true
+	}
+
+	adminGet(admin, "connections", "列出websocket连接", adminListConnections)
+	adminGet(admin, "connections/detail", "查看websocket连接", adminConnectionDetail)
+	adminPost(admin, "connections/close", "关闭websocket连接", adminCloseConnection)
+	adminPost(admin, "connections/send", "向websocket连接发送消息", adminSend)
+	adminPost(admin, "connections/inject", "向websocket连接注入测试消息", adminInject)
+	adminPost(admin, "broadcast", "广播websocket消息", adminBroadcast)
+	adminGet(admin, "diagnostics", "查看websocket路由诊断开关", adminGetDiagnostics)
+	adminPost(admin, "diagnostics", "修改websocket路由诊断开关", adminSetDiagnostics)
+	adminGet(admin, "metrics", "查看websocket实时指标", adminMetrics)
+	adminGet(admin, "maintenance", "查看websocket维护状态", adminGetMaintenance)
+	adminPost(admin, "maintenance", "设置websocket维护状态", adminSetMaintenance)
+	adminGet(admin, "routes", "查看websocket路由状态", adminGetRoutes)
+	adminPost(admin, "routes", "关闭或开启websocket路由", adminSetRoute)
+	adminGet(admin, "quotas", "查看websocket连接配额", adminGetQuotas)
+	adminPost(admin, "quotas", "调整websocket连接配额", adminSetQuotas)
+	if opts.JournalSource != nil {
+		adminGet(admin, "journal/export", "导出websocket连接记录", adminExportJournal(opts.JournalSource))
+	}
+
+	return nil
+}
+
+type adminRouter struct {
+	opts     *AdminOptions
+	chain    gin.HandlersChain
+	nonLogin bool
+}
+
+func adminHolder[T any, V any](admin *adminRouter, relativePath string, remark string, handler wrapper.HandlerFunc[T, V]) *wrapper.RequestHolder[T, V] {
+	return &wrapper.RequestHolder[T, V]{
+		RouterGroup:      admin.opts.RouterGroup,
+		RelativePath:     relativePath,
+		Remark:           remark,
+		PreHandlersChain: admin.chain,
+		NonLogin:         admin.nonLogin,
+		AllowRoles:       admin.opts.AllowRoles,
+		BizHandler:       handler,
+	}
+}
+
+func adminGet[T any, V any](admin *adminRouter, relativePath string, remark string, handler wrapper.HandlerFunc[T, V]) {
+	wrapper.Get(adminHolder(admin, relativePath, remark, handler))
+}
+
+func adminPost[T any, V any](admin *adminRouter, relativePath string, remark string, handler wrapper.HandlerFunc[T, V]) {
+	wrapper.Post(adminHolder(admin, relativePath, remark, handler))
+}
+
+func adminTokenHandler(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader(AdminTokenHeader)), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[*result.Void](dgerr.NO_PERMISSION))
+			return
+		}
+		c.Next()
+	}
+}
+
+func adminListConnections(_ *gin.Context, _ *dgctx.DgContext, req *AdminListConnectionsRequest) *result.Result[*page.PageList[ConnectionInfo]] {
+	pageNo := max(req.PageNo, 1)
+	pageSize := req.PageSize
+	if pageSize == 0 {
+		pageSize = defaultAdminPageSize
+	}
+	pageSize = min(pageSize, maxAdminPageSize)
+
+	var matched []*Connection
+	RangeConnections(func(c *Connection) bool {
+		if req.match(c) {
+			matched = append(matched, c)
+		}
+		return true
+	})
+	slices.SortFunc(matched, func(a, b *Connection) int {
+		if n := a.ConnectedAt.Compare(b.ConnectedAt); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	// 只为当前页的连接生成详情
+	infos := make([]*ConnectionInfo, 0, pageSize)
+	if start := (pageNo - 1) * pageSize; start < len(matched) {
+		for _, c := range matched[start:min(start+pageSize, len(matched))] {
+			infos = append(infos, connectionInfo(c))
+		}
+	}
+
+	return result.Success(page.ListOf(pageNo, pageSize, len(matched), infos))
+}
+
+func adminConnectionDetail(_ *gin.Context, _ *dgctx.DgContext, req *AdminConnectionRequest) *result.Result[*ConnectionInfo] {
+	c := GetConnectionById(req.Id)
+	if c == nil {
+		return result.FailByDgError[*ConnectionInfo](dgerr.RECORD_NOT_EXISTS)
+	}
+
+	return result.Success(connectionInfo(c))
+}
+
+func adminCloseConnection(_ *gin.Context, ctx *dgctx.DgContext, req *AdminCloseConnectionRequest) *result.Result[*result.Void] {
+	c := GetConnectionById(req.Id)
+	if c == nil {
+		return result.FailByDgError[*result.Void](dgerr.RECORD_NOT_EXISTS)
+	}
+
+	code := req.CloseCode
+	if code == 0 {
+		code = websocket.ClosePolicyViolation
+	}
+	if !sendableCloseCode(code) {
+		return result.FailByDgError[*result.Void](dgerr.ARGUMENT_NOT_VALID)
+	}
+	dglogger.Infof(ctx, "admin close websocket connection %s[%s: %s], code: %d, reason: %s", c.Id, c.BizKey, c.BizId, code, req.Reason)
+	c.closeWith(code, req.Reason)
+
+	return result.SimpleSuccess()
+}
+
+// sendableCloseCode 可以出现在close帧中的状态码, 1004-1006和1015为保留值, 1000以下和5000以上不合法
+func sendableCloseCode(code int) bool {
+	return (code >= websocket.CloseNormalClosure && code <= websocket.CloseUnsupportedData) ||
+		(code >= websocket.CloseInvalidFramePayloadData && code <= 1014) ||
+		(code >= 3000 && code <= 4999)
+}
+
+func adminSend(_ *gin.Context, ctx *dgctx.DgContext, req *AdminSendRequest) *result.Result[*result.Void] {
+	c := GetConnectionById(req.Id)
+	if c == nil {
+		return result.FailByDgError[*result.Void](dgerr.RECORD_NOT_EXISTS)
+	}
+
+	mt := req.MessageType
+	if mt == 0 {
+		mt = websocket.TextMessage
+	}
+	if err := c.WriteMessage(mt, []byte(req.Data)); err != nil {
+		dglogger.Warnf(ctx, "admin send to websocket connection %s error: %v", c.Id, err)
+		return result.FailByError[*result.Void](err)
+	}
+
+	return result.SimpleSuccess()
+}
+
+func adminInject(_ *gin.Context, ctx *dgctx.DgContext, req *AdminInjectRequest) *result.Result[*result.Void] {
+	c := GetConnectionById(req.Id)
+	if c == nil {
+		return result.FailByDgError[*result.Void](dgerr.RECORD_NOT_EXISTS)
+	}
+
+	data := []byte(req.Data)
+	if req.Base64 {
+		decoded, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil {
+			return result.FailByDgError[*result.Void](dgerr.ARGUMENT_NOT_VALID)
+		}
+		data = decoded
+	}
+	mt := req.MessageType
+	if mt == 0 {
+		mt = websocket.TextMessage
+	}
+	dglogger.Infof(ctx, "admin inject websocket message to connection %s[%s: %s], direction: %s, type: %d, size: %d", c.Id, c.BizKey, c.BizId, req.Direction, mt, len(data))
+
+	var err error
+	if req.Direction == AdminInjectDirectionIn {
+		err = c.SimulateInbound(mt, data)
+	} else {
+		err = c.WriteMessage(mt, data)
+	}
+	if err != nil {
+		dglogger.Warnf(ctx, "admin inject websocket message to connection %s error: %v", c.Id, err)
+		return result.FailByError[*result.Void](err)
+	}
+
+	return result.SimpleSuccess()
+}
+
+func adminBroadcast(_ *gin.Context, ctx *dgctx.DgContext, req *AdminBroadcastRequest) *result.Result[*AdminBroadcastResult] {
+	mt := req.MessageType
+	if mt == 0 {
+		mt = websocket.TextMessage
+	}
+
+	var sent int
+	var err error
+	if req.Target == AdminBroadcastTargetAll {
+		sent, err = Broadcast(mt, []byte(req.Data))
+	} else {
+		if req.BizKey == "" || len(req.BizIds) == 0 {
+			return result.FailByDgError[*AdminBroadcastResult](dgerr.ARGUMENT_NOT_VALID)
+		}
+		sent, err = BroadcastToBizIds(req.BizKey, req.BizIds, mt, []byte(req.Data))
+	}
+	dglogger.Infof(ctx, "admin broadcast websocket message, target: %s, bizKey: %s, bizIds: %v, sent: %d", req.Target, req.BizKey, req.BizIds, sent)
+	if err != nil {
+		dglogger.Warnf(ctx, "admin broadcast websocket message error: %v", err)
+		return result.FailByError[*AdminBroadcastResult](err)
+	}
+
+	return result.Success(&AdminBroadcastResult{Sent: sent})
+}
+
+func adminGetDiagnostics(_ *gin.Context, _ *dgctx.DgContext, _ *result.Void) *result.Result[map[string]*RouteDiagnostics] {
+	return result.Success(AllRouteDiagnostics())
+}
+
+func adminSetDiagnostics(_ *gin.Context, ctx *dgctx.DgContext, req *AdminDiagnosticsRequest) *result.Result[*result.Void] {
+	dglogger.Infof(ctx, "admin set websocket route diagnostics, path: %s, diagnostics: %+v", req.Path, req.Diagnostics)
+	SetRouteDiagnostics(req.Path, req.Diagnostics)
+
+	return result.SimpleSuccess()
+}
+
+func adminMetrics(_ *gin.Context, _ *dgctx.DgContext, _ *result.Void) *result.Result[*Metrics] {
+	return result.Success(GetMetrics())
+}
+
+// adminGetMaintenance 未处于维护状态时data为null
+func adminGetMaintenance(_ *gin.Context, _ *dgctx.DgContext, _ *result.Void) *result.Result[*MaintenanceInfo] {
+	var info *MaintenanceInfo
+	if opts := GetMaintenance(); opts != nil {
+		info = opts.info()
+	}
+	return result.Success(info)
+}
+
+func adminSetMaintenance(_ *gin.Context, ctx *dgctx.DgContext, req *AdminMaintenanceRequest) *result.Result[*result.Void] {
+	dglogger.Infof(ctx, "admin set websocket maintenance: %+v", req)
+	if req.Enabled {
+		EnterMaintenance(&MaintenanceOptions{Message: req.Message, RetryAfter: time.Duration(req.RetryAfter) * time.Second})
+	} else {
+		ExitMaintenance()
+	}
+
+	return result.SimpleSuccess()
+}
+
+func adminGetRoutes(_ *gin.Context, _ *dgctx.DgContext, _ *result.Void) *result.Result[[]*RouteStatus] {
+	return result.Success(GetRouteStatuses())
+}
+
+func adminSetRoute(_ *gin.Context, ctx *dgctx.DgContext, req *AdminRouteRequest) *result.Result[*result.Void] {
+	dglogger.Infof(ctx, "admin set websocket route: %+v", req)
+	var err error
+	if req.Disabled {
+		err = DisableRoute(req.Path, req.Message)
+	} else {
+		err = EnableRoute(req.Path)
+	}
+	if err != nil {
+		return result.FailByDgError[*result.Void](dgerr.RECORD_NOT_EXISTS)
+	}
+
+	return result.SimpleSuccess()
+}
+
+// adminExportJournal 以附件流式写出, 写出后wrapper不再输出result
+func adminExportJournal(source JournalSource) wrapper.HandlerFunc[AdminExportJournalRequest, *result.Result[*result.Void]] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, req *AdminExportJournalRequest) *result.Result[*result.Void] {
+		if req.SessionId == "" && req.ConnectionId == "" && req.UserId == 0 {
+			return result.FailByDgError[*result.Void](dgerr.ARGUMENT_NOT_VALID)
+		}
+		q := &JournalQuery{SessionId: req.SessionId, ConnectionId: req.ConnectionId, UserId: req.UserId}
+		if req.From > 0 {
+			q.From = time.UnixMilli(req.From)
+		}
+		if req.To > 0 {
+			q.To = time.UnixMilli(req.To)
+		}
+		format := req.Format
+		if format == "" {
+			format = AdminExportFormatNdjson
+		}
+		dglogger.Infof(ctx, "admin export websocket journal: %+v", req)
+
+		contentType := "application/x-ndjson"
+		if format == AdminExportFormatJson {
+			contentType = "application/json"
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=journal-%d.%s", time.Now().UnixMilli(), format))
+		c.Status(http.StatusOK)
+		// 没有记录时也标记为已写出, 避免wrapper写出null
+		c.Writer.WriteHeaderNow()
+
+		encoder := json.NewEncoder(c.Writer)
+		count := 0
+		if format == AdminExportFormatJson {
+			_, _ = c.Writer.WriteString("[")
+		}
+		err := source.Query(c.Request.Context(), q, func(frame *JournalFrame) error {
+			if format == AdminExportFormatJson && count > 0 {
+				if _, err := c.Writer.WriteString(","); err != nil {
+					return err
+				}
+			}
+			count++
+			return encoder.Encode(frame)
+		})
+		if format == AdminExportFormatJson {
+			_, _ = c.Writer.WriteString("]")
+		}
+		// 已开始写出, 出错时只能截断并记录日志
+		if err != nil {
+			dglogger.Warnf(ctx, "admin export websocket journal error after %d frames: %v", count, err)
+		}
+
+		return nil
+	}
+}
+
+// adminGetQuotas 指定userId时在Users中额外返回该用户的占用
+func adminGetQuotas(_ *gin.Context, _ *dgctx.DgContext, req *AdminUserQuotaRequest) *result.Result[*QuotaUsage] {
+	usage := GetQuotaUsage()
+	if req.UserId > 0 {
+		usage.Users[req.UserId] = GetUserQuota(req.UserId)
+	}
+
+	return result.Success(usage)
+}
+
+func adminSetQuotas(_ *gin.Context, ctx *dgctx.DgContext, req *AdminQuotaRequest) *result.Result[*QuotaUsage] {
+	if (req.RouteConnLimit != nil && req.RoutePath == "") || (req.UserIdConnLimit != nil && req.UserId <= 0) {
+		return result.FailByDgError[*QuotaUsage](dgerr.ARGUMENT_NOT_VALID)
+	}
+
+	if req.ConnLimit != nil {
+		dglogger.Infof(ctx, "admin set websocket conn limit: %d", *req.ConnLimit)
+		SetConnLimit(*req.ConnLimit)
+	}
+	if req.RouteConnLimit != nil {
+		dglogger.Infof(ctx, "admin set websocket route %s conn limit: %d", req.RoutePath, *req.RouteConnLimit)
+		SetRouteConnLimit(req.RoutePath, *req.RouteConnLimit)
+	}
+	if req.UserConnLimit != nil {
+		dglogger.Infof(ctx, "admin set websocket user conn limit: %d", *req.UserConnLimit)
+		SetUserConnLimit(*req.UserConnLimit)
+	}
+	if req.UserIdConnLimit != nil {
+		dglogger.Infof(ctx, "admin set websocket user %d conn limit: %d", req.UserId, *req.UserIdConnLimit)
+		SetUserConnLimitFor(req.UserId, *req.UserIdConnLimit)
+	}
+
+	return result.Success(GetQuotaUsage())
+}
+
+func connectionInfo(c *Connection) *ConnectionInfo {
+	state := GetConnState(c.Ctx)
+	return &ConnectionInfo{
+		Id:           c.Id,
+		SessionId:    c.SessionId,
+		Path:         c.Path,
+		BizKey:       c.BizKey,
+		BizId:        c.BizId,
+		UserId:       c.UserId,
+		RemoteIp:     c.RemoteIp,
+		ConnectedAt:  c.ConnectedAt,
+		State:        state.State().String(),
+		PendingBytes: state.PendingBytes(),
+		Labels:       c.Labels(),
+	}
+}
@@ -0,0 +1,383 @@
+package dgws_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-common/page"
+	"github.com/darwinOrg/go-common/result"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "admin-secret"
+
+func startAdminServer(t *testing.T) string {
+	return startAdminServerWithOptions(t, &dgws.AdminOptions{})
+}
+
+func startAdminServerWithOptions(t *testing.T, opts *dgws.AdminOptions) string {
+	engine := gin.New()
+	opts.RouterGroup = engine.Group("/admin")
+	opts.Token = testAdminToken
+	if err := dgws.RegisterAdminRoutes(opts); err != nil {
+		t.Fatalf("register admin routes: %v", err)
+	}
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	return server.URL + "/admin/"
+}
+
+// adminCall body为nil时发送GET请求, 否则以json发送POST请求
+func adminCall[T any](t *testing.T, url string, token string, body any) *result.Result[T] {
+	method := http.MethodGet
+	var reader *bytes.Reader
+	if body != nil {
+		method = http.MethodPost
+		raw, _ := json.Marshal(body)
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, url, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(dgws.AdminTokenHeader, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin call %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	// 参数校验失败时data为字符串, 成功时才按T解析
+	raw := &result.Result[json.RawMessage]{}
+	if err := json.NewDecoder(resp.Body).Decode(raw); err != nil {
+		t.Fatalf("decode admin response: %v", err)
+	}
+	rt := &result.Result[T]{Success: raw.Success, Code: raw.Code, Message: raw.Message}
+	if raw.Success && len(raw.Data) > 0 {
+		if err := json.Unmarshal(raw.Data, &rt.Data); err != nil {
+			t.Fatalf("decode admin data: %v", err)
+		}
+	}
+	return rt
+}
+
+func TestAdminRoutes(t *testing.T) {
+	admin := startAdminServer(t)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=admin-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "admin-a", 1)
+
+	if rt := adminCall[*page.PageList[dgws.ConnectionInfo]](t, admin+"connections?bizId=admin-a", "wrong", nil); rt.Success {
+		t.Fatal("expected wrong token to be rejected")
+	}
+
+	list := adminCall[*page.PageList[dgws.ConnectionInfo]](t, admin+"connections?bizId=admin-a", testAdminToken, nil)
+	if !list.Success || len(list.Data.List) != 1 || list.Data.List[0].State != "open" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	id := list.Data.List[0].Id
+
+	detail := adminCall[*dgws.ConnectionInfo](t, admin+"connections/detail?id="+id, testAdminToken, nil)
+	if !detail.Success || detail.Data.BizId != "admin-a" {
+		t.Fatalf("unexpected detail: %+v", detail)
+	}
+	if rt := adminCall[*dgws.ConnectionInfo](t, admin+"connections/detail?id=missing", testAdminToken, nil); rt.Success {
+		t.Fatal("expected missing connection to fail")
+	}
+
+	if rt := adminCall[*result.Void](t, admin+"connections/send", testAdminToken, &dgws.AdminSendRequest{Id: id, MessageType: 9, Data: "bad"}); rt.Success {
+		t.Fatal("expected invalid message type to be rejected")
+	}
+	if rt := adminCall[*result.Void](t, admin+"connections/send", testAdminToken, &dgws.AdminSendRequest{Id: id, Data: "from-admin"}); !rt.Success {
+		t.Fatalf("send: %+v", rt)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "from-admin" {
+		t.Fatalf("read: %s, %v", string(data), err)
+	}
+
+	for _, code := range []int{999, 1005, 1006, 1015, 2999, 5000} {
+		if rt := adminCall[*result.Void](t, admin+"connections/close", testAdminToken, &dgws.AdminCloseConnectionRequest{Id: id, CloseCode: code}); rt.Success {
+			t.Fatalf("expected close code %d to be rejected", code)
+		}
+	}
+	waitConnections(t, "admin-a", 1)
+	if rt := adminCall[*result.Void](t, admin+"connections/close", testAdminToken, &dgws.AdminCloseConnectionRequest{Id: id, CloseCode: 4000, Reason: "support"}); !rt.Success {
+		t.Fatalf("close: %+v", rt)
+	}
+	expectClosed(t, conn, 4000)
+	waitConnections(t, "admin-a", 0)
+}
+
+func TestAdminRequiresProtection(t *testing.T) {
+	engine := gin.New()
+	if err := dgws.RegisterAdminRoutes(&dgws.AdminOptions{RouterGroup: engine.Group("/admin"), NonLogin: true}); !errors.Is(err, dgws.ErrAdminUnprotected) {
+		t.Fatalf("expected ErrAdminUnprotected, got %v", err)
+	}
+	if routes := engine.Routes(); len(routes) != 0 {
+		t.Fatalf("expected no admin routes, got %d", len(routes))
+	}
+
+	if err := dgws.RegisterAdminRoutes(&dgws.AdminOptions{RouterGroup: engine.Group("/admin"), AllowRoles: []string{"ops"}}); err != nil {
+		t.Fatalf("register with roles: %v", err)
+	}
+}
+
+func TestAdminBroadcast(t *testing.T) {
+	admin := startAdminServer(t)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	target, _, err := websocket.DefaultDialer.Dial(url+"?bizId=admin-b1", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer target.Close()
+	other, _, err := websocket.DefaultDialer.Dial(url+"?bizId=admin-b2", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+	waitConnections(t, "admin-b1", 1)
+	waitConnections(t, "admin-b2", 1)
+
+	if rt := adminCall[*dgws.AdminBroadcastResult](t, admin+"broadcast", testAdminToken, &dgws.AdminBroadcastRequest{Target: "room"}); rt.Success {
+		t.Fatal("expected unknown target to be rejected")
+	}
+	if rt := adminCall[*dgws.AdminBroadcastResult](t, admin+"broadcast", testAdminToken, &dgws.AdminBroadcastRequest{Target: dgws.AdminBroadcastTargetBizIds}); rt.Success {
+		t.Fatal("expected missing bizIds to be rejected")
+	}
+
+	rt := adminCall[*dgws.AdminBroadcastResult](t, admin+"broadcast", testAdminToken, &dgws.AdminBroadcastRequest{
+		Target: dgws.AdminBroadcastTargetBizIds,
+		BizKey: "bizId",
+		BizIds: []string{"admin-b1"},
+		Data:   "targeted",
+	})
+	if !rt.Success || rt.Data.Sent != 1 {
+		t.Fatalf("unexpected broadcast result: %+v", rt)
+	}
+	_ = target.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := target.ReadMessage(); err != nil || string(data) != "targeted" {
+		t.Fatalf("read: %s, %v", string(data), err)
+	}
+	_ = other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Fatalf("message sent to wrong bizId: %s", string(data))
+	}
+}
+
+func TestAdminSearchConnections(t *testing.T) {
+	admin := startAdminServer(t)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	before := time.Now().Add(-time.Second).UnixMilli()
+	for i := 0; i < 5; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=search-a", nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+	}
+	waitConnections(t, "search-a", 5)
+
+	labeled := 0
+	dgws.RangeConnections(func(c *dgws.Connection) bool {
+		if c.BizId == "search-a" && labeled < 2 {
+			c.SetLabel("version", "2.0")
+			labeled++
+		}
+		return true
+	})
+
+	search := func(query string) *page.PageList[dgws.ConnectionInfo] {
+		rt := adminCall[*page.PageList[dgws.ConnectionInfo]](t, admin+"connections?bizId=search-a&"+query, testAdminToken, nil)
+		if !rt.Success {
+			t.Fatalf("search %s: %+v", query, rt)
+		}
+		return rt.Data
+	}
+
+	first := search("pageNo=1&pageSize=2")
+	if first.TotalCount != 5 || first.TotalPages != 3 || len(first.List) != 2 {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	last := search("pageNo=3&pageSize=2")
+	if len(last.List) != 1 || last.List[0].Id == first.List[0].Id || last.List[0].Id == first.List[1].Id {
+		t.Fatalf("unexpected last page: %+v", last)
+	}
+	if beyond := search("pageNo=4&pageSize=2"); len(beyond.List) != 0 || beyond.TotalCount != 5 {
+		t.Fatalf("unexpected page beyond range: %+v", beyond)
+	}
+
+	if byLabel := search("label=version%3D2.0"); byLabel.TotalCount != 2 || byLabel.List[0].Labels["version"] != "2.0" {
+		t.Fatalf("unexpected label search: %+v", byLabel)
+	}
+	if byKey := search("label=version"); byKey.TotalCount != 2 {
+		t.Fatalf("unexpected label key search: %+v", byKey)
+	}
+	if none := search("label=version%3D1.0"); none.TotalCount != 0 {
+		t.Fatalf("unexpected label value search: %+v", none)
+	}
+	if byIp := search("remoteIp=127.0.0.1"); byIp.TotalCount != 5 {
+		t.Fatalf("unexpected remote ip search: %+v", byIp)
+	}
+	if inRange := search("connectedAfter=" + strconv.FormatInt(before, 10)); inRange.TotalCount != 5 {
+		t.Fatalf("unexpected time range search: %+v", inRange)
+	}
+	if outOfRange := search("connectedBefore=" + strconv.FormatInt(before, 10)); outOfRange.TotalCount != 0 {
+		t.Fatalf("unexpected time range search: %+v", outOfRange)
+	}
+}
+
+func TestAdminExportJournal(t *testing.T) {
+	sink, err := dgws.NewFileJournalSink(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+	defer sink.Close()
+	admin := startAdminServerWithOptions(t, &dgws.AdminOptions{JournalSource: sink})
+
+	handled := make(chan struct{}, 4)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{Journal: &dgws.JournalOptions{Sink: sink}}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		handled <- struct{}{}
+		return nil
+	})
+	var ids []string
+	for _, bizId := range []string{"export-a", "export-b"} {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		waitConnections(t, bizId, 1)
+		dgws.RangeConnections(func(c *dgws.Connection) bool {
+			if c.BizId == bizId {
+				ids = append(ids, c.Id)
+			}
+			return true
+		})
+		for _, msg := range []string{bizId + "-1", bizId + "-2"} {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			select {
+			case <-handled:
+			case <-time.After(3 * time.Second):
+				t.Fatal("message not handled")
+			}
+		}
+	}
+
+	download := func(query string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, admin+"journal/export?"+query, nil)
+		req.Header.Set(dgws.AdminTokenHeader, testAdminToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	if rt := adminCall[*result.Void](t, admin+"journal/export", testAdminToken, nil); rt.Success {
+		t.Fatal("expected export without filter to be rejected")
+	}
+
+	resp, body := download("connectionId=" + ids[0])
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || !strings.Contains(resp.Header.Get("Content-Disposition"), ".ndjson") {
+		t.Fatalf("unexpected headers: %v", resp.Header)
+	}
+	frames, err := dgws.ReadJournal(bytes.NewReader(body))
+	if err != nil || len(frames) != 2 || string(frames[0].Data) != "export-a-1" || frames[1].ConnectionId != ids[0] {
+		t.Fatalf("unexpected ndjson export: %s, %v", body, err)
+	}
+
+	_, body = download("format=json&connectionId=" + ids[1])
+	var exported []*dgws.JournalFrame
+	if err := json.Unmarshal(body, &exported); err != nil || len(exported) != 2 || string(exported[1].Data) != "export-b-2" {
+		t.Fatalf("unexpected json export: %s, %v", body, err)
+	}
+
+	_, body = download("format=json&connectionId=" + ids[1] + "&to=" + strconv.FormatInt(exported[0].At.Add(-time.Millisecond).UnixMilli(), 10))
+	if strings.TrimSpace(string(body)) != "[]" {
+		t.Fatalf("expected empty export outside time range, got %s", body)
+	}
+	resp, body = download("connectionId=export-missing")
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || len(body) != 0 {
+		t.Fatalf("expected empty ndjson export, got %v %q", resp.Header, body)
+	}
+}
+
+func TestAdminInject(t *testing.T) {
+	admin := startAdminServer(t)
+	handled := make(chan string, 2)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{EnableMessagePool: true}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		handled <- string(wsm.MessageData)
+		return dgws.GetConnection(ctx).WriteMessage(websocket.TextMessage, []byte("echo:"+string(wsm.MessageData)))
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=inject-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "inject-a", 1)
+	var id string
+	dgws.RangeConnections(func(c *dgws.Connection) bool {
+		if c.BizId == "inject-a" {
+			id = c.Id
+		}
+		return true
+	})
+
+	// 模拟客户端发来的消息, BizHandler的回复照常写给客户端
+	if rt := adminCall[*result.Void](t, admin+"connections/inject", testAdminToken, &dgws.AdminInjectRequest{Id: id, Direction: dgws.AdminInjectDirectionIn, Data: "simulated"}); !rt.Success {
+		t.Fatalf("inject inbound: %+v", rt)
+	}
+	select {
+	case msg := <-handled:
+		if msg != "simulated" {
+			t.Fatalf("unexpected handled message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("injected message not handled")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "echo:simulated" {
+		t.Fatalf("read: %s, %v", string(data), err)
+	}
+
+	if rt := adminCall[*result.Void](t, admin+"connections/inject", testAdminToken, &dgws.AdminInjectRequest{Id: id, Direction: dgws.AdminInjectDirectionOut, MessageType: websocket.BinaryMessage, Data: "AAEC/w==", Base64: true}); !rt.Success {
+		t.Fatalf("inject outbound: %+v", rt)
+	}
+	if mt, data, err := conn.ReadMessage(); err != nil || mt != websocket.BinaryMessage || !bytes.Equal(data, []byte{0, 1, 2, 255}) {
+		t.Fatalf("read binary: %d, %v, %v", mt, data, err)
+	}
+
+	if rt := adminCall[*result.Void](t, admin+"connections/inject", testAdminToken, &dgws.AdminInjectRequest{Id: id, Direction: dgws.AdminInjectDirectionOut, MessageType: websocket.CloseMessage}); rt.Success {
+		t.Fatal("expected control frame to be rejected")
+	}
+	if rt := adminCall[*result.Void](t, admin+"connections/inject", testAdminToken, &dgws.AdminInjectRequest{Id: id, Direction: dgws.AdminInjectDirectionOut, Data: "%%", Base64: true}); rt.Success {
+		t.Fatal("expected invalid base64 to be rejected")
+	}
+}
@@ -0,0 +1,176 @@
+package amqpbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/kafkabridge"
+	"github.com/gin-gonic/gin"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"strings"
+	"time"
+)
+
+const defaultRoutingKeyPrefix = "ws"
+
+// InboundMessage 与kafkabridge的消息格式一致, 下游可同时对接两种消息系统
+type InboundMessage = kafkabridge.InboundMessage
+
+type OutboundMessage = kafkabridge.OutboundMessage
+
+// Publisher 发布到exchange, *amqp.Channel即实现了该接口
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+}
+
+type Options struct {
+	Publisher Publisher
+	// Exchange 客户端发来的消息发布到该exchange
+	Exchange string
+	// RoutingKey 默认"ws.<bizKey>.<bizId>", topic exchange下可按业务绑定队列
+	RoutingKey func(msg *InboundMessage) string
+	// Deliveries 推送给连接的消息, 由channel.Consume(queue, "", false, ...)得到, 需关闭autoAck;
+	// 每个实例需使用独立的队列, 只投递到本实例的连接
+	Deliveries <-chan amqp.Delivery
+	// Outbound 把消息映射为推送, 默认按json解析body, 见RoutingKeyOutbound
+	Outbound func(d *amqp.Delivery) (*OutboundMessage, error)
+}
+
+// Bridge 将dgws作为AMQP后端的websocket接入层
+type Bridge struct {
+	ctx  *dgctx.DgContext
+	opts *Options
+}
+
+func New(ctx *dgctx.DgContext, opts *Options) *Bridge {
+	return &Bridge{ctx: ctx, opts: opts}
+}
+
+// Inbound 包装BizHandler, 先把消息发布到Exchange再交给next, next为nil时只发布;
+// 发布失败返回错误, 可配合WebSocketHandlerConfig.Retry重试
+func (b *Bridge) Inbound(next wrapper.HandlerFunc[dgws.WebSocketMessage, error]) wrapper.HandlerFunc[dgws.WebSocketMessage, error] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		msg := &InboundMessage{
+			UserId:      ctx.UserId,
+			RemoteIp:    ctx.RemoteIp,
+			TraceId:     ctx.TraceId,
+			MessageType: wsm.MessageType,
+			Data:        wsm.MessageData,
+			ReceivedAt:  time.Now(),
+		}
+		if connection := dgws.GetConnection(ctx); connection != nil {
+			msg.ConnectionId = connection.Id
+			msg.SessionId = connection.SessionId
+			msg.Path = connection.Path
+			msg.BizKey = connection.BizKey
+			msg.BizId = connection.BizId
+		}
+
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		publishing := amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    msg.ReceivedAt,
+			Body:         body,
+		}
+		if err := b.opts.Publisher.PublishWithContext(innerContext(ctx), b.opts.Exchange, b.routingKey(msg), false, false, publishing); err != nil {
+			return err
+		}
+
+		if next != nil {
+			return next(c, ctx, wsm)
+		}
+		return nil
+	}
+}
+
+func (b *Bridge) routingKey(msg *InboundMessage) string {
+	if b.opts.RoutingKey != nil {
+		return b.opts.RoutingKey(msg)
+	}
+	return defaultRoutingKeyPrefix + "." + msg.BizKey + "." + msg.BizId
+}
+
+// Run 持续消费Deliveries并投递到本实例的连接, ctx取消或Deliveries关闭时结束;
+// 投递完成后ack, 无法解析或投递失败时nack且不重新入队, 可配置死信exchange接收
+func (b *Bridge) Run(ctx context.Context) error {
+	if b.opts.Deliveries == nil {
+		return errors.New("amqp bridge deliveries is required")
+	}
+	outbound := b.opts.Outbound
+	if outbound == nil {
+		outbound = JsonOutbound
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-b.opts.Deliveries:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			b.handle(&d, outbound)
+		}
+	}
+}
+
+func (b *Bridge) handle(d *amqp.Delivery, outbound func(d *amqp.Delivery) (*OutboundMessage, error)) {
+	msg, err := outbound(d)
+	if err != nil {
+		dglogger.Warnf(b.ctx, "amqp bridge decode outbound message error: %v", err)
+		b.nack(d)
+		return
+	}
+	if _, err := kafkabridge.Deliver(msg); err != nil {
+		dglogger.Warnf(b.ctx, "amqp bridge deliver message error: %v", err)
+		b.nack(d)
+		return
+	}
+	if err := d.Ack(false); err != nil {
+		dglogger.Warnf(b.ctx, "amqp bridge ack error: %v", err)
+	}
+}
+
+func (b *Bridge) nack(d *amqp.Delivery) {
+	if err := d.Nack(false, false); err != nil {
+		dglogger.Warnf(b.ctx, "amqp bridge nack error: %v", err)
+	}
+}
+
+// JsonOutbound body为OutboundMessage的json
+func JsonOutbound(d *amqp.Delivery) (*OutboundMessage, error) {
+	msg := &OutboundMessage{}
+	if err := json.Unmarshal(d.Body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// RoutingKeyOutbound 按routing key"<prefix>.<bizKey>.<bizId>"推送给对应bizId的连接, body原样作为消息内容, prefix默认ws
+func RoutingKeyOutbound(prefix string) func(d *amqp.Delivery) (*OutboundMessage, error) {
+	if prefix == "" {
+		prefix = defaultRoutingKeyPrefix
+	}
+	return func(d *amqp.Delivery) (*OutboundMessage, error) {
+		parts := strings.SplitN(d.RoutingKey, ".", 3)
+		if len(parts) != 3 || parts[0] != prefix || parts[1] == "" || parts[2] == "" {
+			return nil, errors.New("unexpected routing key: " + d.RoutingKey)
+		}
+		return &OutboundMessage{BizKey: parts[1], BizIds: []string{parts[2]}, Data: d.Body}, nil
+	}
+}
+
+func innerContext(ctx *dgctx.DgContext) context.Context {
+	if ctx.InnerContext() != nil {
+		return ctx.InnerContext()
+	}
+	return context.Background()
+}
@@ -0,0 +1,166 @@
+package amqpbridge_test
+
+import (
+	"context"
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/amqpbridge"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type publishing struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+type fakePublisher struct {
+	lock        sync.Mutex
+	publishings []publishing
+}
+
+func (p *fakePublisher) PublishWithContext(_ context.Context, exchange string, key string, _ bool, _ bool, msg amqp.Publishing) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.publishings = append(p.publishings, publishing{exchange: exchange, key: key, msg: msg})
+	return nil
+}
+
+// fakeAcknowledger 记录每个delivery tag是ack还是nack
+type fakeAcknowledger struct {
+	results chan string
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, _ bool) error {
+	a.results <- "ack"
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, _ bool, requeue bool) error {
+	if requeue {
+		a.results <- "requeue"
+	} else {
+		a.results <- "nack"
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func startServer(t *testing.T, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/edge"),
+		NonLogin:    true,
+		BizHandler:  bizHandler,
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "room",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("room")
+		},
+	})
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/edge"
+}
+
+func TestBridge(t *testing.T) {
+	publisher := &fakePublisher{}
+	deliveries := make(chan amqp.Delivery, 10)
+	acknowledger := &fakeAcknowledger{results: make(chan string, 10)}
+	bridge := amqpbridge.New(&dgctx.DgContext{TraceId: uuid.NewString()}, &amqpbridge.Options{
+		Publisher:  publisher,
+		Exchange:   "ws.inbound",
+		Deliveries: deliveries,
+		Outbound:   amqpbridge.RoutingKeyOutbound(""),
+	})
+	url := startServer(t, bridge.Inbound(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = bridge.Run(ctx)
+	}()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?room=r1", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	other, _, err := websocket.DefaultDialer.Dial(url+"?room=r2", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	var published []publishing
+	for len(published) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message not published")
+		}
+		time.Sleep(10 * time.Millisecond)
+		publisher.lock.Lock()
+		published = publisher.publishings
+		publisher.lock.Unlock()
+	}
+	if published[0].exchange != "ws.inbound" || published[0].key != "ws.room.r1" {
+		t.Fatalf("unexpected publishing: %+v", published[0])
+	}
+	inbound := &amqpbridge.InboundMessage{}
+	if err := json.Unmarshal(published[0].msg.Body, inbound); err != nil {
+		t.Fatalf("decode inbound: %v", err)
+	}
+	if inbound.ConnectionId == "" || inbound.BizId != "r1" || string(inbound.Data) != "hello" {
+		t.Fatalf("unexpected inbound message: %+v", inbound)
+	}
+
+	deliveries <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, RoutingKey: "unknown"}
+	deliveries <- amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: 2, RoutingKey: "ws.room.r1", Body: []byte("pushed")}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "pushed" {
+		t.Fatalf("expected pushed, got %s, %v", string(data), err)
+	}
+	_ = other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Fatalf("message pushed to wrong room: %s", string(data))
+	}
+	for _, expected := range []string{"nack", "ack"} {
+		select {
+		case result := <-acknowledger.results:
+			if result != expected {
+				t.Fatalf("expected %s, got %s", expected, result)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("delivery not %sed", expected)
+		}
+	}
+}
+
+func TestJsonOutbound(t *testing.T) {
+	body, _ := json.Marshal(&amqpbridge.OutboundMessage{BizKey: "room", BizIds: []string{"r1"}, Data: []byte("x")})
+	msg, err := amqpbridge.JsonOutbound(&amqp.Delivery{Body: body})
+	if err != nil || msg.BizKey != "room" || string(msg.Data) != "x" {
+		t.Fatalf("unexpected outbound: %+v, %v", msg, err)
+	}
+	if _, err := amqpbridge.JsonOutbound(&amqp.Delivery{Body: []byte("not json")}); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
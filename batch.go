@@ -0,0 +1,63 @@
+package dgws
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gin-gonic/gin"
+	"sync"
+)
+
+const defaultMaxBatchSize = 64
+
+type BatchHandler func(c *gin.Context, ctx *dgctx.DgContext, messages []*WebSocketMessage) error
+
+// messageBatcher 处理上一批消息期间到达的消息会被合并成一批, 突发消息只产生一次调度
+type messageBatcher struct {
+	lock      sync.Mutex
+	messages  []*WebSocketMessage
+	scheduled bool
+	maxSize   int
+	slots     chan struct{}
+}
+
+func newMessageBatcher(maxSize int, maxPending int) *messageBatcher {
+	if maxSize <= 0 {
+		maxSize = defaultMaxBatchSize
+	}
+	return &messageBatcher{maxSize: maxSize, slots: make(chan struct{}, max(maxPending, maxSize))}
+}
+
+// add 积压达到上限时阻塞读循环, 返回true表示需要提交一次批处理任务
+func (b *messageBatcher) add(wsm *WebSocketMessage) bool {
+	b.slots <- struct{}{}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.messages = append(b.messages, wsm)
+	if b.scheduled {
+		return false
+	}
+	b.scheduled = true
+
+	return true
+}
+
+// take 取出最多maxSize条消息, 返回true表示还有剩余需要再次提交
+func (b *messageBatcher) take() ([]*WebSocketMessage, bool) {
+	b.lock.Lock()
+	n := min(len(b.messages), b.maxSize)
+	batch := make([]*WebSocketMessage, n)
+	copy(batch, b.messages)
+	clear(b.messages[:n])
+	b.messages = b.messages[n:]
+	more := len(b.messages) > 0
+	if !more {
+		b.scheduled = false
+	}
+	b.lock.Unlock()
+
+	for range batch {
+		<-b.slots
+	}
+
+	return batch, more
+}
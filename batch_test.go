@@ -0,0 +1,61 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBatchHandler(t *testing.T) {
+	batches := make(chan []string, 100)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		MaxBatchSize: 10,
+		BatchHandler: func(_ *gin.Context, _ *dgctx.DgContext, messages []*dgws.WebSocketMessage) error {
+			batch := make([]string, 0, len(messages))
+			for _, wsm := range messages {
+				batch = append(batch, string(wsm.MessageData))
+			}
+			batches <- batch
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		},
+	}, nil)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	next, maxSize := 0, 0
+	for next < 50 {
+		select {
+		case batch := <-batches:
+			if len(batch) > 10 {
+				t.Fatalf("batch size %d exceeds max", len(batch))
+			}
+			maxSize = max(maxSize, len(batch))
+			for _, msg := range batch {
+				if msg != strconv.Itoa(next) {
+					t.Fatalf("expected message %d, got %s", next, msg)
+				}
+				next++
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("only %d messages handled", next)
+		}
+	}
+	if maxSize < 2 {
+		t.Fatal("burst messages were not batched")
+	}
+}
@@ -0,0 +1,137 @@
+package dgws
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultBroadcastWriteWait 未设置WriteWait时广播给单个连接的超时, 包括等待写锁和写出
+	defaultBroadcastWriteWait = 5 * time.Second
+	maxBroadcastWorkers       = 64
+)
+
+// errWriteLockTimeout 连接的写锁长时间被其他写入占用, 本次广播跳过该连接
+var errWriteLockTimeout = errors.New("websocket write lock timeout")
+
+// Broadcast 向当前进程内的所有连接发送消息, 返回成功发送的连接数; 开启InitCluster时同时投递到其他实例
+func Broadcast(mt int, data []byte) (int, error) {
+	sent, err := BroadcastFilter(mt, data, nil)
+	if err != nil {
+		return sent, err
+	}
+
+	return sent, publishCluster("", nil, mt, data)
+}
+
+func BroadcastJSON(v any) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return Broadcast(websocket.TextMessage, data)
+}
+
+func BroadcastToBizIds(bizKey string, bizIds []string, mt int, data []byte) (int, error) {
+	sent, err := BroadcastFilter(mt, data, bizIdsFilter(bizKey, bizIds))
+	if err != nil || len(bizIds) == 0 {
+		return sent, err
+	}
+
+	return sent, publishCluster(bizKey, bizIds, mt, data)
+}
+
+func bizIdsFilter(bizKey string, bizIds []string) func(c *Connection) bool {
+	ids := make(map[string]struct{}, len(bizIds))
+	for _, bizId := range bizIds {
+		ids[bizId] = struct{}{}
+	}
+
+	return func(c *Connection) bool {
+		if c.BizKey != bizKey {
+			return false
+		}
+		_, ok := ids[c.BizId]
+		return ok
+	}
+}
+
+// BroadcastFilter 消息只编码分帧一次(PreparedMessage), 再写给filter选中的所有连接;
+// 每个连接最多等待WriteWait(未设置时为5s), 超时的连接不计入返回值
+func BroadcastFilter(mt int, data []byte, filter func(c *Connection) bool) (int, error) {
+	pm, err := websocket.NewPreparedMessage(mt, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return broadcastPrepared(mt, data, pm, filter), nil
+}
+
+// broadcastPrepared 由多个worker并发写出, 个别慢连接只占用一个worker; 全部写完才返回, 连续广播到同一连接的顺序不变
+func broadcastPrepared(mt int, data []byte, pm *websocket.PreparedMessage, filter func(c *Connection) bool) int {
+	var targets []*Connection
+	RangeConnections(func(c *Connection) bool {
+		if filter == nil || filter(c) {
+			targets = append(targets, c)
+		}
+		return true
+	})
+	if len(targets) == 0 {
+		return 0
+	}
+
+	queue := make(chan *Connection, len(targets))
+	for _, c := range targets {
+		queue <- c
+	}
+	close(queue)
+
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for range min(len(targets), maxBroadcastWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range queue {
+				if c.writeBroadcast(mt, data, pm) == nil {
+					sent.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return int(sent.Load())
+}
+
+// writeBroadcast 非websocket连接无法使用pm, 写入原始的mt和data; 等待写锁和写出共用同一个deadline
+func (c *Connection) writeBroadcast(mt int, data []byte, pm *websocket.PreparedMessage) error {
+	if c.writer != nil {
+		return c.writeTo(mt, data)
+	}
+
+	wait := c.writeWait
+	if wait <= 0 {
+		wait = defaultBroadcastWriteWait
+	}
+	deadline := time.Now().Add(wait)
+	timer := time.NewTimer(wait)
+	select {
+	case c.writeSem <- struct{}{}:
+		timer.Stop()
+	case <-timer.C:
+		return errWriteLockTimeout
+	}
+	defer c.unlockWrite()
+
+	return c.writeUntil(deadline, func() error {
+		return c.writeFrame(mt, data, func() error {
+			return c.Conn.WritePreparedMessage(pm)
+		})
+	})
+}
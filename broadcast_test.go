@@ -0,0 +1,183 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroadcastToBizIds(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	bizId := uuid.NewString()
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitConnections(t, bizId, 2)
+
+	sent, err := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.TextMessage, []byte("hello"))
+	if err != nil || sent != 2 {
+		t.Fatalf("broadcast sent %d, error: %v", sent, err)
+	}
+
+	for _, conn := range conns {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil || string(data) != "hello" {
+			t.Fatalf("read broadcast: %s, %v", string(data), err)
+		}
+	}
+}
+
+func TestBroadcastSlowClient(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{WriteWait: time.Second}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	bizId := uuid.NewString()
+	healthy, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer healthy.Close()
+	// 不读取的客户端, 写满缓冲区后写入阻塞
+	stalled, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stalled.Close()
+	waitConnections(t, bizId, 2)
+
+	received := make(chan int, 1)
+	go func() {
+		_ = healthy.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, _ := healthy.ReadMessage()
+		received <- len(data)
+	}()
+
+	payload := []byte(strings.Repeat("x", 16<<20))
+	start := time.Now()
+	sent, err := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.BinaryMessage, payload)
+	if err != nil || sent != 1 {
+		t.Fatalf("broadcast sent %d, error: %v", sent, err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("broadcast blocked by stalled client for %v", elapsed)
+	}
+	if n := <-received; n != len(payload) {
+		t.Fatalf("healthy client received %d bytes", n)
+	}
+}
+
+// 写锁被占用时广播在WriteWait内等待, 超时后跳过该连接
+func TestBroadcastWaitsForWriteLock(t *testing.T) {
+	held := make(chan struct{})
+	release := make(chan struct{})
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{WriteWait: 300 * time.Millisecond}, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		w, err := dgws.GetSafeConn(ctx).NextWriter(websocket.TextMessage)
+		if err != nil {
+			return err
+		}
+		held <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte("held"))
+		return w.Close()
+	})
+
+	bizId := uuid.NewString()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, bizId, 1)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hold")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	<-held
+
+	start := time.Now()
+	if sent, _ := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.TextMessage, []byte("skipped")); sent != 0 {
+		t.Fatalf("broadcast should skip a connection whose write lock is held, sent %d", sent)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("unexpected wait for write lock: %v", elapsed)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	if sent, _ := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.TextMessage, []byte("after")); sent != 1 {
+		t.Fatalf("broadcast should proceed once the write lock is released, sent %d", sent)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for _, want := range []string{"held", "after"} {
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != want {
+			t.Fatalf("expected %s, got %s, %v", want, data, err)
+		}
+	}
+}
+
+func TestBroadcastCompressionStats(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Compression: &dgws.CompressionOptions{Threshold: 64},
+	}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	bizId := uuid.NewString()
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	conn, _, err := dialer.Dial(url+"?bizId="+bizId, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, bizId, 1)
+
+	before := dgws.GetCompressionStats()["/ws"]
+	payload := strings.Repeat("compressible ", 100)
+	if sent, err := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.TextMessage, []byte(payload)); err != nil || sent != 1 {
+		t.Fatalf("broadcast sent %d, error: %v", sent, err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != payload {
+		t.Fatalf("read broadcast: %v", err)
+	}
+
+	after := dgws.GetCompressionStats()["/ws"]
+	if after.Messages-before.Messages != 1 || after.CompressedMessages-before.CompressedMessages != 1 || after.RawBytes-before.RawBytes != int64(len(payload)) {
+		t.Fatalf("broadcast not counted, before: %+v, after: %+v", before, after)
+	}
+}
+
+func waitConnections(t *testing.T, bizId string, expected int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		n := 0
+		dgws.RangeConnections(func(c *dgws.Connection) bool {
+			if c.BizId == bizId {
+				n++
+			}
+			return true
+		})
+		if n == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d connections for %s, got %d", expected, bizId, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,388 @@
+package dgws
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type ClientState int
+
+const (
+	ClientStateIdle ClientState = iota
+	ClientStateConnecting
+	ClientStateConnected
+	ClientStateReconnecting
+	ClientStateClosed
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case ClientStateIdle:
+		return "idle"
+	case ClientStateConnecting:
+		return "connecting"
+	case ClientStateConnected:
+		return "connected"
+	case ClientStateReconnecting:
+		return "reconnecting"
+	case ClientStateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	ErrClientNotConnected = errors.New("websocket client not connected")
+	ErrClientClosed       = errors.New("websocket client closed")
+)
+
+type ClientStartHandler func(ctx *dgctx.DgContext, conn *websocket.Conn) error
+type ClientMessageHandler func(ctx *dgctx.DgContext, mt int, data []byte) error
+type ClientStateChangeHandler func(ctx *dgctx.DgContext, from ClientState, to ClientState)
+
+type ClientConfig struct {
+	Url                  string
+	Header               http.Header
+	DialerOptions        *DialerOptions
+	StartHandler         ClientStartHandler
+	MessageHandler       ClientMessageHandler
+	StateChangeHandler   ClientStateChangeHandler
+	MinReconnectInterval time.Duration
+	MaxReconnectInterval time.Duration
+	// MaxReconnectAttempts 连续重连失败的最大次数, 0表示不限制
+	MaxReconnectAttempts int
+	PingPeriod           time.Duration
+	PongWait             time.Duration
+	WriteWait            time.Duration
+	// MaxMissedPongs 连续未收到pong的次数超过该值即认为连接已断开并触发重连, 默认3
+	MaxMissedPongs int
+	// MissedPongHandler 每次发现上一个ping未收到pong时回调, missed为连续丢失的次数
+	MissedPongHandler MissedPongHandler
+	// OfflineBufferSize 断线期间最多缓存的待发送消息数, 0表示不缓存
+	OfflineBufferSize int
+	OverflowPolicy    OverflowPolicy
+	Codec             Codec
+	SessionHandler    ClientSessionHandler
+	SendInterceptors  []ClientSendInterceptor
+	// GroupHandler 处理服务端以MessageGroup提交的消息组, 为空时逐条交给MessageHandler
+	GroupHandler ClientGroupHandler
+	// ServerStateHandler 服务端连接状态变化时回调, 见ConnectionState
+	ServerStateHandler ClientServerStateHandler
+	// DisableDgHeader 为true时握手请求不携带DgContext中的trace-id、uid等标准头
+	DisableDgHeader bool
+}
+
+const (
+	defaultMinReconnectInterval = 500 * time.Millisecond
+	defaultMaxReconnectInterval = 30 * time.Second
+)
+
+type Client struct {
+	ctx            *dgctx.DgContext
+	conf           *ClientConfig
+	dialer         *websocket.Dialer
+	lock           sync.RWMutex
+	writeSem       chan struct{}
+	conn           *websocket.Conn
+	messageHandler ClientMessageHandler
+	subscriptions  map[string]TopicHandler
+	channels       map[string]*Channel
+	pendingCalls   map[string]chan *Envelope
+	resumeToken    string
+	lastSeq        int64
+	serverState    ConnectionState
+	url            string
+	migrating      bool
+	interceptors   []ClientSendInterceptor
+	pending        []pendingMessage
+	state          ClientState
+	disconnectedAt time.Time
+	startOnce      sync.Once
+	closeOnce      sync.Once
+	markOnce       sync.Once
+	closed         chan struct{}
+	done           chan struct{}
+}
+
+func NewClient(ctx *dgctx.DgContext, conf *ClientConfig) (*Client, error) {
+	if conf.MinReconnectInterval <= 0 {
+		conf.MinReconnectInterval = defaultMinReconnectInterval
+	}
+	if conf.MaxReconnectInterval < conf.MinReconnectInterval {
+		conf.MaxReconnectInterval = max(defaultMaxReconnectInterval, conf.MinReconnectInterval)
+	}
+
+	dialer, err := newDialer(conf.DialerOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		ctx:            ctx,
+		conf:           conf,
+		dialer:         dialer,
+		messageHandler: conf.MessageHandler,
+		interceptors:   conf.SendInterceptors,
+		writeSem:       make(chan struct{}, 1),
+		closed:         make(chan struct{}),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+// Start 启动后台连接, 连接断开后按指数退避自动重连, 直到调用Close
+func (c *Client) Start() {
+	c.startOnce.Do(func() {
+		go c.run()
+	})
+}
+
+func (c *Client) State() ClientState {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.state
+}
+
+// disconnectedSince 开始连接或断开的时间, 已连接时为零值
+func (c *Client) disconnectedSince() time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.disconnectedAt
+}
+
+func (c *Client) Conn() *websocket.Conn {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.conn
+}
+
+// WriteMessage 可并发调用, 写操作按调用顺序串行执行
+func (c *Client) WriteMessage(mt int, data []byte) error {
+	c.lockWrite()
+	defer c.unlockWrite()
+
+	return c.writeOrBuffer(mt, data)
+}
+
+// writeOrBuffer 调用方需持有写锁
+func (c *Client) writeOrBuffer(mt int, data []byte) error {
+	conn := c.Conn()
+	if conn == nil {
+		if c.isClosed() {
+			return ErrClientClosed
+		}
+		if c.conf.OfflineBufferSize > 0 {
+			return c.bufferMessage(mt, data)
+		}
+		return ErrClientNotConnected
+	}
+
+	return c.write(conn, mt, data)
+}
+
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.markClosed()
+		if conn := c.Conn(); conn != nil {
+			err = conn.Close()
+		}
+		c.startOnce.Do(func() {
+			close(c.done)
+		})
+		<-c.done
+		c.setState(ClientStateClosed)
+	})
+
+	return err
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	attempts := 0
+	for !c.isClosed() {
+		if attempts == 0 && c.State() == ClientStateIdle {
+			c.setState(ClientStateConnecting)
+		} else {
+			c.setState(ClientStateReconnecting)
+		}
+
+		conn, err := c.connect()
+		if err != nil {
+			attempts++
+			dglogger.Warnf(c.ctx, "websocket client connect %s error, attempts: %d, error: %v", c.currentUrl(), attempts, err)
+			if c.conf.MaxReconnectAttempts > 0 && attempts >= c.conf.MaxReconnectAttempts {
+				dglogger.Errorf(c.ctx, "websocket client give up connecting %s after %d attempts", c.currentUrl(), attempts)
+				// 与Close一样停止重连, 之后的写入返回ErrClientClosed而不是进入离线缓存
+				c.markClosed()
+				c.setState(ClientStateClosed)
+				return
+			}
+			if !c.sleep(c.backoff(attempts)) {
+				return
+			}
+			continue
+		}
+
+		attempts = 0
+		c.setState(ClientStateConnected)
+		stopHeartbeat := c.startHeartbeat(conn)
+		c.readLoop(conn)
+		close(stopHeartbeat)
+		c.detach(conn)
+		c.failPendingCalls()
+		if c.takeMigrating() {
+			continue
+		}
+		if !c.sleep(c.backoff(1)) {
+			return
+		}
+	}
+}
+
+func (c *Client) connect() (*websocket.Conn, error) {
+	conn, _, err := c.dialer.Dial(c.currentUrl(), c.handshakeHeader())
+	if err != nil {
+		return nil, err
+	}
+
+	if c.conf.StartHandler != nil {
+		if err := c.conf.StartHandler(c.ctx, conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	c.lockWrite()
+	defer c.unlockWrite()
+
+	if err := c.resubscribe(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := c.flushPending(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c.resetChannels()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.isClosed() {
+		_ = conn.Close()
+		return nil, ErrClientClosed
+	}
+	c.conn = conn
+
+	return conn, nil
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) {
+	for {
+		mt, message, err := conn.ReadMessage()
+		if err != nil {
+			if !c.isClosed() {
+				dglogger.Warnf(c.ctx, "websocket client read error: %v", err)
+			}
+			return
+		}
+
+		if c.dispatchSession(mt, message) || c.dispatchServerState(mt, message) || c.dispatchResponse(mt, message) || c.dispatchReliable(conn, mt, message) || c.dispatchGroup(mt, message) || c.dispatchReconnect(conn, mt, message) {
+			continue
+		}
+
+		if c.dispatchChannelMessage(mt, message) {
+			continue
+		}
+
+		if handled, err := c.dispatchTopicMessage(mt, message); handled {
+			if err != nil {
+				dglogger.Errorf(c.ctx, "websocket client handle topic message error: %v", err)
+			}
+			continue
+		}
+
+		if handler := c.getMessageHandler(); handler != nil {
+			if err := handler(c.ctx, mt, message); err != nil {
+				dglogger.Errorf(c.ctx, "websocket client handle message error: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Client) detach(conn *websocket.Conn) {
+	c.lock.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.lock.Unlock()
+	_ = conn.Close()
+	c.setServerState(ConnectionStateClosed)
+}
+
+func (c *Client) setState(state ClientState) {
+	c.lock.Lock()
+	from := c.state
+	if from == state || from == ClientStateClosed {
+		c.lock.Unlock()
+		return
+	}
+	c.state = state
+	if state == ClientStateConnected {
+		c.disconnectedAt = time.Time{}
+	} else if c.disconnectedAt.IsZero() {
+		c.disconnectedAt = time.Now()
+	}
+	c.lock.Unlock()
+
+	if c.conf.StateChangeHandler != nil {
+		c.conf.StateChangeHandler(c.ctx, from, state)
+	}
+}
+
+// backoff 指数退避并加入随机抖动, 结果落在[interval/2, interval]
+func (c *Client) backoff(attempts int) time.Duration {
+	interval := c.conf.MinReconnectInterval
+	for i := 1; i < attempts && interval < c.conf.MaxReconnectInterval; i++ {
+		interval *= 2
+	}
+	interval = min(interval, c.conf.MaxReconnectInterval)
+
+	half := interval / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (c *Client) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// markClosed 标记为关闭以停止重连, 但不关闭当前连接
+func (c *Client) markClosed() {
+	c.markOnce.Do(func() {
+		close(c.closed)
+	})
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,63 @@
+package dgws
+
+import (
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+)
+
+// dispatchReliable 服务端SendReliable发送的message信封, Data交给消息处理器, 处理成功后回复ack;
+// 处理失败不回复, 由服务端超时重发, 因此处理器可能收到重复消息
+func (c *Client) dispatchReliable(conn *websocket.Conn, mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok {
+		return false
+	}
+	if env.Type == EnvelopeTypeReplay {
+		if env.Error != "" {
+			dglogger.Warnf(c.ctx, "websocket client replay from seq %d: %s", env.Seq, env.Error)
+		}
+		return true
+	}
+	if env.Type != EnvelopeTypeMessage {
+		return false
+	}
+
+	if handler := c.getMessageHandler(); handler != nil {
+		if err := handler(c.ctx, websocket.TextMessage, env.Data); err != nil {
+			dglogger.Errorf(c.ctx, "websocket client handle reliable message %s error: %v", env.Id, err)
+			return true
+		}
+	}
+
+	c.lock.Lock()
+	c.lastSeq = max(c.lastSeq, env.Seq)
+	c.lock.Unlock()
+
+	c.lockWrite()
+	defer c.unlockWrite()
+	if err := c.writeEnvelope(conn, &Envelope{Type: EnvelopeTypeAck, Id: env.Id}); err != nil {
+		dglogger.Warnf(c.ctx, "websocket client ack message %s error: %v", env.Id, err)
+	}
+
+	return true
+}
+
+// LastSeq 已处理的服务端可靠消息的最大序号, 发现缺口时可通过RequestReplay补齐
+func (c *Client) LastSeq() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.lastSeq
+}
+
+// RequestReplay 请求服务端重发当前会话中seq及之后仍保留的消息
+func (c *Client) RequestReplay(seq int64) error {
+	if c.Conn() == nil {
+		return ErrClientNotConnected
+	}
+
+	return c.writeEnvelopeIfConnected(&Envelope{Type: EnvelopeTypeReplay, Seq: seq})
+}
@@ -0,0 +1,88 @@
+package dgws
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+)
+
+type OverflowPolicy int
+
+const (
+	OverflowDropOldest OverflowPolicy = iota
+	OverflowDropNewest
+	OverflowReject
+)
+
+var ErrClientBufferFull = errors.New("websocket client offline buffer full")
+
+type pendingMessage struct {
+	messageType int
+	data        []byte
+}
+
+// bufferMessage 断线期间缓存待发送消息, 调用方需持有写锁
+func (c *Client) bufferMessage(mt int, data []byte) error {
+	if len(c.pending) >= c.conf.OfflineBufferSize {
+		switch c.conf.OverflowPolicy {
+		case OverflowDropOldest:
+			c.pending = c.pending[1:]
+		case OverflowDropNewest:
+			return nil
+		default:
+			return ErrClientBufferFull
+		}
+	}
+
+	c.pending = append(c.pending, pendingMessage{messageType: mt, data: append([]byte(nil), data...)})
+	return nil
+}
+
+// flushPending 重连成功后按顺序补发缓存消息, 调用方需持有写锁
+func (c *Client) flushPending(conn *websocket.Conn) error {
+	for len(c.pending) > 0 {
+		pm := c.pending[0]
+		if err := c.write(conn, pm.messageType, pm.data); err != nil {
+			return err
+		}
+		c.pending[0] = pendingMessage{}
+		c.pending = c.pending[1:]
+	}
+	c.pending = nil
+
+	return nil
+}
+
+func (c *Client) PendingCount() int {
+	c.lockWrite()
+	defer c.unlockWrite()
+	return len(c.pending)
+}
+
+// takePending 取出尚未发送的缓存消息, 连接池替换失效成员时转给新成员
+func (c *Client) takePending() []pendingMessage {
+	c.lockWrite()
+	defer c.unlockWrite()
+	pending := c.pending
+	c.pending = nil
+	return pending
+}
+
+// restorePending 在Start之前调用, 转入的消息排在已缓存的消息之前, 返回因缓存已满未能放入的消息数
+func (c *Client) restorePending(pending []pendingMessage) int {
+	if c.conf.OfflineBufferSize <= 0 {
+		return len(pending)
+	}
+
+	c.lockWrite()
+	defer c.unlockWrite()
+	buffered := c.pending
+	c.pending = nil
+	dropped := 0
+	for _, pm := range append(pending, buffered...) {
+		before := len(c.pending)
+		if err := c.bufferMessage(pm.messageType, pm.data); err != nil || len(c.pending) == before {
+			dropped++
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,118 @@
+package dgws
+
+import (
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+var ErrCallTimeout = errors.New("websocket client call timeout")
+
+// Call 发送request并等待相同id的response, 连接断开时所有未完成的调用立即返回错误
+func (c *Client) Call(ctx *dgctx.DgContext, request any, timeout time.Duration) (json.RawMessage, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Envelope{Type: EnvelopeTypeRequest, Id: uuid.NewString(), Data: data}
+	ch := make(chan *Envelope, 1)
+
+	c.lockWrite()
+	conn := c.Conn()
+	if conn == nil {
+		c.unlockWrite()
+		if c.isClosed() {
+			return nil, ErrClientClosed
+		}
+		return nil, ErrClientNotConnected
+	}
+	c.addPendingCall(req.Id, ch)
+	err = c.writeEnvelope(conn, req)
+	c.unlockWrite()
+	if err != nil {
+		c.removePendingCall(req.Id)
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var done <-chan struct{}
+	if inner := ctx.InnerContext(); inner != nil {
+		done = inner.Done()
+	}
+
+	select {
+	case resp := <-ch:
+		if resp == nil {
+			return nil, ErrClientNotConnected
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return resp.Data, nil
+	case <-timer.C:
+		c.removePendingCall(req.Id)
+		return nil, ErrCallTimeout
+	case <-done:
+		c.removePendingCall(req.Id)
+		return nil, ctx.InnerContext().Err()
+	}
+}
+
+func (c *Client) addPendingCall(id string, ch chan *Envelope) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pendingCalls == nil {
+		c.pendingCalls = make(map[string]chan *Envelope)
+	}
+	c.pendingCalls[id] = ch
+}
+
+func (c *Client) removePendingCall(id string) chan *Envelope {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ch := c.pendingCalls[id]
+	delete(c.pendingCalls, id)
+	return ch
+}
+
+func (c *Client) dispatchResponse(mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	c.lock.RLock()
+	calling := len(c.pendingCalls) > 0
+	c.lock.RUnlock()
+	if !calling {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || env.Type != EnvelopeTypeResponse {
+		return false
+	}
+
+	if ch := c.removePendingCall(env.Id); ch != nil {
+		ch <- env
+	}
+
+	return true
+}
+
+// failPendingCalls 连接断开后唤醒所有等待中的调用
+func (c *Client) failPendingCalls() {
+	c.lock.Lock()
+	pendingCalls := c.pendingCalls
+	c.pendingCalls = nil
+	c.lock.Unlock()
+
+	for _, ch := range pendingCalls {
+		close(ch)
+	}
+}
@@ -0,0 +1,208 @@
+package dgws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"sync"
+)
+
+var ErrChannelClosed = errors.New("websocket channel closed")
+
+type ChannelHandler func(ctx *dgctx.DgContext, channel string, data []byte) error
+
+type ChannelOptions struct {
+	// SendWindow 未获得对端credit授权前最多可发送的消息数, 0表示不做流控
+	SendWindow int
+	// ReceiveWindow 每处理ReceiveWindow/2条消息向对端授权一次credit, 0表示不授权
+	ReceiveWindow int
+}
+
+// Channel 在同一物理连接上复用的逻辑通道
+type Channel struct {
+	client   *Client
+	id       string
+	handler  ChannelHandler
+	opts     ChannelOptions
+	lock     sync.Mutex
+	credits  int
+	consumed int
+	closed   bool
+	signal   chan struct{}
+}
+
+func (c *Client) OpenChannel(id string, handler ChannelHandler, opts *ChannelOptions) *Channel {
+	ch := &Channel{
+		client:  c,
+		id:      id,
+		handler: handler,
+		signal:  make(chan struct{}, 1),
+	}
+	if opts != nil {
+		ch.opts = *opts
+	}
+	ch.credits = ch.opts.SendWindow
+
+	c.lock.Lock()
+	if c.channels == nil {
+		c.channels = make(map[string]*Channel)
+	}
+	c.channels[id] = ch
+	c.lock.Unlock()
+
+	return ch
+}
+
+func (ch *Channel) Id() string {
+	return ch.id
+}
+
+// Send 发送消息到该通道, 开启流控时会等待对端授权的credit
+func (ch *Channel) Send(ctx context.Context, data []byte) error {
+	if err := ch.acquireCredit(ctx); err != nil {
+		return err
+	}
+
+	msg, err := json.Marshal(&Envelope{Type: EnvelopeTypeChannel, Channel: ch.id, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return ch.client.SendWithContext(ctx, websocket.TextMessage, msg)
+}
+
+func (ch *Channel) Close() {
+	ch.lock.Lock()
+	ch.closed = true
+	ch.lock.Unlock()
+	ch.notify()
+
+	ch.client.lock.Lock()
+	if ch.client.channels[ch.id] == ch {
+		delete(ch.client.channels, ch.id)
+	}
+	ch.client.lock.Unlock()
+}
+
+func (ch *Channel) acquireCredit(ctx context.Context) error {
+	for {
+		ch.lock.Lock()
+		if ch.closed {
+			ch.lock.Unlock()
+			return ErrChannelClosed
+		}
+		if ch.opts.SendWindow <= 0 || ch.credits > 0 {
+			ch.credits--
+			ch.lock.Unlock()
+			return nil
+		}
+		ch.lock.Unlock()
+
+		select {
+		case <-ch.signal:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (ch *Channel) grant(n int) {
+	ch.lock.Lock()
+	ch.credits = min(ch.credits+n, ch.opts.SendWindow)
+	ch.lock.Unlock()
+	ch.notify()
+}
+
+func (ch *Channel) reset() {
+	ch.lock.Lock()
+	ch.credits = ch.opts.SendWindow
+	ch.consumed = 0
+	ch.lock.Unlock()
+	ch.notify()
+}
+
+func (ch *Channel) notify() {
+	select {
+	case ch.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (ch *Channel) receive(data []byte) {
+	if ch.handler != nil {
+		if err := ch.handler(ch.client.ctx, ch.id, data); err != nil {
+			dglogger.Errorf(ch.client.ctx, "websocket channel %s handle message error: %v", ch.id, err)
+		}
+	}
+
+	if ch.opts.ReceiveWindow <= 0 {
+		return
+	}
+
+	ch.lock.Lock()
+	ch.consumed++
+	consumed := ch.consumed
+	if consumed*2 >= ch.opts.ReceiveWindow {
+		ch.consumed = 0
+	}
+	ch.lock.Unlock()
+
+	if consumed*2 >= ch.opts.ReceiveWindow {
+		env := &Envelope{Type: EnvelopeTypeCredit, Channel: ch.id, Data: json.RawMessage(strconv.Itoa(consumed))}
+		if err := ch.client.writeEnvelopeIfConnected(env); err != nil {
+			dglogger.Warnf(ch.client.ctx, "websocket channel %s grant credit error: %v", ch.id, err)
+		}
+	}
+}
+
+func (c *Client) dispatchChannelMessage(mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	c.lock.RLock()
+	hasChannels := len(c.channels) > 0
+	c.lock.RUnlock()
+	if !hasChannels {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || (env.Type != EnvelopeTypeChannel && env.Type != EnvelopeTypeCredit) {
+		return false
+	}
+
+	c.lock.RLock()
+	ch := c.channels[env.Channel]
+	c.lock.RUnlock()
+	if ch == nil {
+		return false
+	}
+
+	if env.Type == EnvelopeTypeCredit {
+		n, _ := strconv.Atoi(string(env.Data))
+		ch.grant(n)
+	} else {
+		ch.receive(env.Data)
+	}
+
+	return true
+}
+
+// resetChannels 重连后对端的流控状态已丢失, 恢复初始窗口
+func (c *Client) resetChannels() {
+	c.lock.RLock()
+	channels := make([]*Channel, 0, len(c.channels))
+	for _, ch := range c.channels {
+		channels = append(channels, ch)
+	}
+	c.lock.RUnlock()
+
+	for _, ch := range channels {
+		ch.reset()
+	}
+}
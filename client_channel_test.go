@@ -0,0 +1,157 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startChannelServer 原样回显channel消息; 收到"grant:<channel>"时向该通道授权1个credit, 其他协议消息交给credits
+func startChannelServer(t *testing.T, credits chan<- *dgws.Envelope) string {
+	return startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		conn := dgws.GetConnection(ctx)
+		if channel, ok := strings.CutPrefix(string(wsm.MessageData), "grant:"); ok {
+			return dgws.WriteEnvelope(conn, &dgws.Envelope{Type: dgws.EnvelopeTypeCredit, Channel: channel, Data: json.RawMessage("1")})
+		}
+		env, ok := dgws.ParseEnvelope(wsm.MessageData)
+		if !ok {
+			return nil
+		}
+		if env.Type == dgws.EnvelopeTypeChannel {
+			return dgws.WriteEnvelope(conn, env)
+		}
+		credits <- env
+		return nil
+	})
+}
+
+func TestClientChannelMultiplexing(t *testing.T) {
+	url := startChannelServer(t, make(chan *dgws.Envelope, 10))
+	others := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			others <- string(data)
+			return nil
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	received := make(chan string, 10)
+	handler := func(_ *dgctx.DgContext, channel string, data []byte) error {
+		received <- channel + ":" + string(data)
+		return nil
+	}
+	a := client.OpenChannel("a", handler, nil)
+	b := client.OpenChannel("b", handler, nil)
+	if a.Id() != "a" || b.Id() != "b" {
+		t.Fatalf("unexpected channel ids: %s, %s", a.Id(), b.Id())
+	}
+
+	ctx := context.Background()
+	if err := a.Send(ctx, []byte(`"to-a"`)); err != nil {
+		t.Fatalf("send a: %v", err)
+	}
+	if err := b.Send(ctx, []byte(`"to-b"`)); err != nil {
+		t.Fatalf("send b: %v", err)
+	}
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case msg := <-received:
+			got[msg] = true
+		case <-time.After(3 * time.Second):
+			t.Fatalf("channel messages: %v", got)
+		}
+	}
+	if !got[`a:"to-a"`] || !got[`b:"to-b"`] {
+		t.Fatalf("messages routed to wrong channels: %v", got)
+	}
+
+	// 关闭的通道不能再发送, 其回显的消息交给MessageHandler
+	b.Close()
+	if err := b.Send(ctx, []byte(`"late"`)); !errors.Is(err, dgws.ErrChannelClosed) {
+		t.Fatalf("expected ErrChannelClosed, got %v", err)
+	}
+	raw, _ := json.Marshal(&dgws.Envelope{Type: dgws.EnvelopeTypeChannel, Channel: "b", Data: json.RawMessage(`"orphan"`)})
+	if err := client.WriteMessage(websocket.TextMessage, raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case msg := <-others:
+		if env, ok := dgws.ParseEnvelope([]byte(msg)); !ok || env.Channel != "b" {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message for closed channel not passed to MessageHandler")
+	}
+}
+
+func TestClientChannelFlowControl(t *testing.T) {
+	credits := make(chan *dgws.Envelope, 10)
+	url := startChannelServer(t, credits)
+	client := newTestClient(t, &dgws.ClientConfig{Url: url})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	received := make(chan string, 10)
+	ch := client.OpenChannel("flow", func(_ *dgctx.DgContext, _ string, data []byte) error {
+		received <- string(data)
+		return nil
+	}, &dgws.ChannelOptions{SendWindow: 2, ReceiveWindow: 2})
+
+	// 窗口用完后等待对端授权
+	for _, data := range []string{`"1"`, `"2"`} {
+		if err := ch.Send(context.Background(), []byte(data)); err != nil {
+			t.Fatalf("send %s: %v", data, err)
+		}
+	}
+	timeout, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := ch.Send(timeout, []byte(`"3"`)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected send to wait for credit, got %v", err)
+	}
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("grant:flow")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- ch.Send(context.Background(), []byte(`"3"`))
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("send after grant: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("send still blocked after credit granted")
+	}
+
+	// 每处理ReceiveWindow/2条回显消息向服务端授权一次
+	for range 3 {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatal("echo not received")
+		}
+		select {
+		case env := <-credits:
+			if env.Type != dgws.EnvelopeTypeCredit || env.Channel != "flow" || string(env.Data) != "1" {
+				t.Fatalf("unexpected credit: %+v", env)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("credit not granted to server")
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package dgws
+
+import (
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// CloseGracefully 发送close帧并等待服务端回复close帧或超时, 然后释放连接, 之后不再重连
+func (c *Client) CloseGracefully(code int, reason string, timeout time.Duration) error {
+	conn := c.Conn()
+	c.markClosed()
+
+	if conn != nil {
+		err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(timeout))
+		if err == nil {
+			timer := time.NewTimer(timeout)
+			select {
+			case <-c.done:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}
+
+	return c.Close()
+}
@@ -0,0 +1,109 @@
+package dgws_test
+
+import (
+	"errors"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startCloseServer reading为false时服务端从不读取, 因此不会回复close帧
+func startCloseServer(t *testing.T, reading bool) (string, <-chan *websocket.CloseError) {
+	closes := make(chan *websocket.CloseError, 1)
+	release := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if !reading {
+			<-release
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				var ce *websocket.CloseError
+				if errors.As(err, &ce) {
+					closes <- ce
+				}
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), closes
+}
+
+func TestClientCloseGracefully(t *testing.T) {
+	url, closes := startCloseServer(t, true)
+	client := newTestClient(t, &dgws.ClientConfig{Url: url, MinReconnectInterval: 10 * time.Millisecond})
+	client.Start()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	// 服务端回复close帧后立即返回, 不等到超时
+	start := time.Now()
+	if err := client.CloseGracefully(websocket.CloseGoingAway, "bye", 3*time.Second); err != nil {
+		t.Fatalf("close gracefully: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close handshake took %v", elapsed)
+	}
+	select {
+	case ce := <-closes:
+		if ce.Code != websocket.CloseGoingAway || ce.Text != "bye" {
+			t.Fatalf("unexpected close frame: %+v", ce)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not receive close frame")
+	}
+
+	// 之后不再重连
+	time.Sleep(100 * time.Millisecond)
+	if client.State() != dgws.ClientStateClosed {
+		t.Fatalf("expected closed, got %s", client.State())
+	}
+	if err := client.WriteMessage(websocket.TextMessage, []byte("late")); !errors.Is(err, dgws.ErrClientClosed) {
+		t.Fatalf("expected ErrClientClosed, got %v", err)
+	}
+}
+
+func TestClientCloseGracefullyTimeout(t *testing.T) {
+	url, _ := startCloseServer(t, false)
+	client := newTestClient(t, &dgws.ClientConfig{Url: url})
+	client.Start()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	start := time.Now()
+	_ = client.CloseGracefully(websocket.CloseNormalClosure, "", 200*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected to wait for the timeout, took %v", elapsed)
+	}
+	if client.State() != dgws.ClientStateClosed {
+		t.Fatalf("expected closed, got %s", client.State())
+	}
+}
+
+func TestClientCloseGracefullyNotStarted(t *testing.T) {
+	client := newTestClient(t, &dgws.ClientConfig{Url: "ws://127.0.0.1:1"})
+	done := make(chan struct{})
+	go func() {
+		_ = client.CloseGracefully(websocket.CloseNormalClosure, "", time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("CloseGracefully blocked on a client that never started")
+	}
+	if client.State() != dgws.ClientStateClosed {
+		t.Fatalf("expected closed, got %s", client.State())
+	}
+}
@@ -0,0 +1,59 @@
+package dgws
+
+import (
+	"crypto/tls"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type DialerOptions struct {
+	TLSConfig *tls.Config
+	// ProxyUrl 支持http、https、socks5代理, 为空时使用Proxy
+	ProxyUrl string
+	// Proxy 为空且ProxyUrl为空时使用环境变量中的代理配置
+	Proxy             func(*http.Request) (*url.URL, error)
+	DialTimeout       time.Duration
+	HandshakeTimeout  time.Duration
+	EnableCompression bool
+	ReadBufferSize    int
+	WriteBufferSize   int
+	Subprotocols      []string
+}
+
+func newDialer(opts *DialerOptions) (*websocket.Dialer, error) {
+	if opts == nil {
+		return websocket.DefaultDialer, nil
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  websocket.DefaultDialer.HandshakeTimeout,
+		TLSClientConfig:   opts.TLSConfig,
+		EnableCompression: opts.EnableCompression,
+		ReadBufferSize:    opts.ReadBufferSize,
+		WriteBufferSize:   opts.WriteBufferSize,
+		Subprotocols:      opts.Subprotocols,
+	}
+	if opts.HandshakeTimeout > 0 {
+		dialer.HandshakeTimeout = opts.HandshakeTimeout
+	}
+	if opts.DialTimeout > 0 {
+		dialer.NetDialContext = (&net.Dialer{Timeout: opts.DialTimeout}).DialContext
+	}
+
+	switch {
+	case opts.ProxyUrl != "":
+		proxyUrl, err := url.Parse(opts.ProxyUrl)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Proxy = http.ProxyURL(proxyUrl)
+	case opts.Proxy != nil:
+		dialer.Proxy = opts.Proxy
+	}
+
+	return dialer, nil
+}
@@ -0,0 +1,48 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+)
+
+// ClientGroupHandler 一次收到服务端MessageGroup提交的全部消息, 便于业务整体应用
+type ClientGroupHandler func(ctx *dgctx.DgContext, messages []GroupMessage) error
+
+// dispatchGroup 未配置GroupHandler时, 组内消息按顺序交给消息处理器
+func (c *Client) dispatchGroup(mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || env.Type != EnvelopeTypeGroup {
+		return false
+	}
+
+	var messages []GroupMessage
+	if err := json.Unmarshal(env.Data, &messages); err != nil {
+		dglogger.Errorf(c.ctx, "websocket client decode message group error: %v", err)
+		return true
+	}
+
+	if c.conf.GroupHandler != nil {
+		if err := c.conf.GroupHandler(c.ctx, messages); err != nil {
+			dglogger.Errorf(c.ctx, "websocket client handle message group error: %v", err)
+		}
+		return true
+	}
+
+	handler := c.getMessageHandler()
+	if handler == nil {
+		return true
+	}
+	for i, m := range messages {
+		if err := handler(c.ctx, m.MessageType, m.Data); err != nil {
+			dglogger.Errorf(c.ctx, "websocket client handle group message %d/%d error: %v", i+1, len(messages), err)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,66 @@
+package dgws
+
+import (
+	dgcoll "github.com/darwinOrg/go-common/collection"
+	"github.com/darwinOrg/go-common/constants"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"net/http"
+	"strconv"
+)
+
+// BuildDgHeader 将DgContext中的标准字段转换为握手请求头, 服务端通过utils.GetDgContext即可还原
+func BuildDgHeader(ctx *dgctx.DgContext) http.Header {
+	header := http.Header{}
+	if ctx == nil {
+		return header
+	}
+
+	setHeader := func(key string, value string) {
+		if value != "" {
+			header.Set(key, value)
+		}
+	}
+	setInt64Header := func(key string, value int64) {
+		if value != 0 {
+			header.Set(key, strconv.FormatInt(value, 10))
+		}
+	}
+
+	setHeader(constants.TraceId, ctx.TraceId)
+	setInt64Header(constants.UID, ctx.UserId)
+	setInt64Header(constants.OpId, ctx.OpId)
+	setInt64Header(constants.RunAs, ctx.RunAs)
+	setHeader(constants.Roles, ctx.Roles)
+	setInt64Header(constants.BizTypes, int64(ctx.BizTypes))
+	setInt64Header(constants.GroupId, ctx.GroupId)
+	setHeader(constants.Platform, ctx.Platform)
+	setHeader(constants.Lang, ctx.Lang)
+	setHeader(constants.Token, ctx.Token)
+	setHeader(constants.ShareToken, ctx.ShareToken)
+	setHeader(constants.RemoteIp, ctx.RemoteIp)
+	setInt64Header(constants.CompanyId, ctx.CompanyId)
+	setInt64Header(constants.Product, int64(ctx.Product))
+	if len(ctx.Products) > 0 {
+		header.Set(constants.Products, dgcoll.JoinIntsByComma(ctx.Products))
+	}
+	if len(ctx.DepartmentIds) > 0 {
+		header.Set(constants.DepartmentIds, dgcoll.JoinIntsByComma(ctx.DepartmentIds))
+	}
+
+	return header
+}
+
+func (c *Client) handshakeHeader() http.Header {
+	header := http.Header{}
+	if !c.conf.DisableDgHeader {
+		header = BuildDgHeader(c.ctx)
+	}
+	for key, values := range c.conf.Header {
+		header[key] = values
+	}
+	if token := c.ResumeToken(); token != "" {
+		header.Set(ResumeTokenHeader, token)
+	}
+
+	return header
+}
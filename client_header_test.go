@@ -0,0 +1,76 @@
+package dgws_test
+
+import (
+	"github.com/darwinOrg/go-common/constants"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientDgHeaderPropagation(t *testing.T) {
+	received := make(chan *dgctx.DgContext, 10)
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		received <- ctx
+		return nil
+	})
+	serverCtx := func(conf *dgws.ClientConfig, ctx *dgctx.DgContext) *dgctx.DgContext {
+		t.Helper()
+		conf.Url = url
+		client, err := dgws.NewClient(ctx, conf)
+		if err != nil {
+			t.Fatalf("new client: %v", err)
+		}
+		client.Start()
+		defer client.Close()
+		waitState(t, client, dgws.ClientStateConnected)
+		if err := client.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		select {
+		case got := <-received:
+			return got
+		case <-time.After(3 * time.Second):
+			t.Fatal("message not handled")
+			return nil
+		}
+	}
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString(), UserId: 42, Lang: "en", CompanyId: 7, Products: []int{1, 2}}
+	got := serverCtx(&dgws.ClientConfig{}, ctx)
+	if got.TraceId != ctx.TraceId || got.UserId != 42 || got.Lang != "en" || got.CompanyId != 7 || len(got.Products) != 2 {
+		t.Fatalf("dg context not propagated: %+v", got)
+	}
+
+	// Header中的同名头覆盖DgContext
+	header := http.Header{}
+	header.Set(constants.Lang, "zh")
+	got = serverCtx(&dgws.ClientConfig{Header: header}, ctx)
+	if got.TraceId != ctx.TraceId || got.Lang != "zh" {
+		t.Fatalf("custom header not applied: %+v", got)
+	}
+
+	got = serverCtx(&dgws.ClientConfig{DisableDgHeader: true}, ctx)
+	if got.TraceId == ctx.TraceId || got.UserId != 0 {
+		t.Fatalf("dg header sent although disabled: %+v", got)
+	}
+}
+
+func TestBuildDgHeader(t *testing.T) {
+	if header := dgws.BuildDgHeader(nil); len(header) != 0 {
+		t.Fatalf("expected empty header, got %v", header)
+	}
+
+	header := dgws.BuildDgHeader(&dgctx.DgContext{TraceId: "trace-1", UserId: 42, DepartmentIds: []int64{3, 4}})
+	if header.Get(constants.TraceId) != "trace-1" || header.Get(constants.UID) != "42" || header.Get(constants.DepartmentIds) != "3,4" {
+		t.Fatalf("unexpected header: %v", header)
+	}
+	// 零值字段不写入
+	if _, ok := header[http.CanonicalHeaderKey(constants.CompanyId)]; ok {
+		t.Fatalf("zero company id written: %v", header)
+	}
+}
@@ -0,0 +1,66 @@
+package dgws
+
+import (
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"sync/atomic"
+)
+
+const defaultMaxMissedPongs = 3
+
+func (c *Client) startHeartbeat(conn *websocket.Conn) chan struct{} {
+	stop := make(chan struct{})
+	if c.conf.PingPeriod <= 0 {
+		return stop
+	}
+
+	maxMissed := c.conf.MaxMissedPongs
+	if maxMissed <= 0 {
+		maxMissed = defaultMaxMissedPongs
+	}
+
+	var missed atomic.Int32
+	var deadline *readDeadline
+	if c.conf.PongWait > 0 {
+		deadline = newReadDeadline(conn, c.conf.PongWait)
+	}
+	conn.SetPongHandler(func(string) error {
+		missed.Store(0)
+		if deadline != nil {
+			return deadline.extend()
+		}
+		return nil
+	})
+
+	go func() {
+		ticker := getClock().NewTicker(c.conf.PingPeriod)
+		defer ticker.Stop()
+		if deadline != nil {
+			defer deadline.stop()
+		}
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.Chan():
+				n := missed.Add(1)
+				if n > 1 && c.conf.MissedPongHandler != nil {
+					c.conf.MissedPongHandler(c.ctx, int(n-1))
+				}
+				if n > int32(maxMissed) {
+					dglogger.Warnf(c.ctx, "websocket client missed %d pongs, connection considered dead", n-1)
+					_ = conn.Close()
+					return
+				}
+				if err := conn.WriteControl(websocket.PingMessage, nil, writeDeadline(c.conf.WriteWait)); err != nil {
+					dglogger.Warnf(c.ctx, "websocket client write ping error: %v", err)
+					_ = conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	return stop
+}
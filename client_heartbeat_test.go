@@ -0,0 +1,102 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientHeartbeatDetectsDeadConnection(t *testing.T) {
+	// 服务端从不读取, 因此不会回复pong
+	var upgrades atomic.Int32
+	release := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		upgrades.Add(1)
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	missed := make(chan int, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url:                  "ws" + strings.TrimPrefix(server.URL, "http"),
+		PingPeriod:           30 * time.Millisecond,
+		MaxMissedPongs:       2,
+		MinReconnectInterval: 10 * time.Millisecond,
+		MaxReconnectInterval: 20 * time.Millisecond,
+		MissedPongHandler: func(_ *dgctx.DgContext, n int) {
+			select {
+			case missed <- n:
+			default:
+			}
+		},
+	})
+	client.Start()
+	defer client.Close()
+
+	for _, expected := range []int{1, 2} {
+		select {
+		case n := <-missed:
+			if n != expected {
+				t.Fatalf("expected %d missed pongs, got %d", expected, n)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("missed pong %d not reported", expected)
+		}
+	}
+
+	// 超过MaxMissedPongs后断开并重连
+	deadline := time.Now().Add(3 * time.Second)
+	for upgrades.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if upgrades.Load() < 2 {
+		t.Fatal("client did not reconnect after missing pongs")
+	}
+}
+
+func TestClientHeartbeatKeepsLiveConnection(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	var missed atomic.Int32
+	var reconnects atomic.Int32
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url:        url,
+		PingPeriod: 50 * time.Millisecond,
+		PongWait:   time.Second,
+		MissedPongHandler: func(_ *dgctx.DgContext, _ int) {
+			missed.Add(1)
+		},
+		StateChangeHandler: func(_ *dgctx.DgContext, _ dgws.ClientState, to dgws.ClientState) {
+			if to == dgws.ClientStateReconnecting {
+				reconnects.Add(1)
+			}
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	time.Sleep(300 * time.Millisecond)
+	if client.State() != dgws.ClientStateConnected || reconnects.Load() != 0 {
+		t.Fatalf("live connection dropped, state: %s, reconnects: %d", client.State(), reconnects.Load())
+	}
+	if n := missed.Load(); n != 0 {
+		t.Fatalf("expected no missed pongs, got %d", n)
+	}
+}
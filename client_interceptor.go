@@ -0,0 +1,47 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+)
+
+type ClientSendFunc func(ctx *dgctx.DgContext, mt int, data []byte) error
+
+// ClientSendInterceptor 发送链路上的拦截器, 可修改消息后调用next, 或直接返回错误中断发送
+type ClientSendInterceptor func(ctx *dgctx.DgContext, mt int, data []byte, next ClientSendFunc) error
+
+// Use 追加发送拦截器, 按注册顺序执行
+func (c *Client) Use(interceptors ...ClientSendInterceptor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.interceptors = append(c.interceptors[:len(c.interceptors):len(c.interceptors)], interceptors...)
+}
+
+// write 经过拦截器链后写入连接, 调用方需持有写锁
+func (c *Client) write(conn *websocket.Conn, mt int, data []byte) error {
+	c.lock.RLock()
+	interceptors := c.interceptors
+	c.lock.RUnlock()
+
+	send := func(_ *dgctx.DgContext, mt int, data []byte) error {
+		return conn.WriteMessage(mt, data)
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], send
+		send = func(ctx *dgctx.DgContext, mt int, data []byte) error {
+			return interceptor(ctx, mt, data, next)
+		}
+	}
+
+	return send(c.ctx, mt, data)
+}
+
+func (c *Client) writeEnvelope(conn *websocket.Conn, env *Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return c.write(conn, websocket.TextMessage, data)
+}
@@ -0,0 +1,99 @@
+package dgws_test
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func prefixInterceptor(prefix string) dgws.ClientSendInterceptor {
+	return func(ctx *dgctx.DgContext, mt int, data []byte, next dgws.ClientSendFunc) error {
+		return next(ctx, mt, append([]byte(prefix), data...))
+	}
+}
+
+func TestClientSendInterceptors(t *testing.T) {
+	received := make(chan string, 10)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		received <- string(wsm.MessageData)
+		return nil
+	})
+
+	errRejected := errors.New("rejected")
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url:              url,
+		SendInterceptors: []dgws.ClientSendInterceptor{prefixInterceptor("a:")},
+	})
+	// 通过Use追加的拦截器在配置的之后执行, 拒绝包含blocked的消息
+	client.Use(prefixInterceptor("b:"), func(ctx *dgctx.DgContext, mt int, data []byte, next dgws.ClientSendFunc) error {
+		if strings.Contains(string(data), "blocked") {
+			return errRejected
+		}
+		return next(ctx, mt, data)
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("blocked")); !errors.Is(err, errRejected) {
+		t.Fatalf("expected interceptor error, got %v", err)
+	}
+	if err := client.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "b:a:hello" {
+			t.Fatalf("interceptors not applied in order: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not received")
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("rejected message was sent: %s", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClientInterceptorsApplyToProtocolMessages(t *testing.T) {
+	received := make(chan string, 10)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		received <- string(wsm.MessageData)
+		return nil
+	})
+
+	seen := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		SendInterceptors: []dgws.ClientSendInterceptor{func(ctx *dgctx.DgContext, mt int, data []byte, next dgws.ClientSendFunc) error {
+			seen <- string(data)
+			return next(ctx, mt, data)
+		}},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	if err := client.Subscribe("news", func(_ *dgctx.DgContext, _ string, _ []byte) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	for _, ch := range []chan string{seen, received} {
+		select {
+		case msg := <-ch:
+			env, ok := dgws.ParseEnvelope([]byte(msg))
+			if !ok || env.Type != dgws.EnvelopeTypeSubscribe || env.Topic != "news" {
+				t.Fatalf("unexpected message: %s", msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("subscribe message not sent through interceptors")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dgws
+
+import (
+	"encoding/json"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"time"
+)
+
+// dispatchReconnect 收到服务端的reconnect消息后, 在WithinMs内随机等待, 然后以1012关闭当前连接并立即重连到新地址
+func (c *Client) dispatchReconnect(conn *websocket.Conn, mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || env.Type != EnvelopeTypeReconnect {
+		return false
+	}
+
+	info := &ReconnectInfo{}
+	if err := json.Unmarshal(env.Data, info); err != nil {
+		dglogger.Warnf(c.ctx, "websocket client decode reconnect error: %v", err)
+		return true
+	}
+
+	var delay time.Duration
+	if info.WithinMs > 0 {
+		delay = time.Duration(rand.Int63n(info.WithinMs)) * time.Millisecond
+	}
+	time.AfterFunc(delay, func() {
+		c.lock.Lock()
+		if c.conn != conn {
+			c.lock.Unlock()
+			return
+		}
+		if info.Url != "" {
+			c.url = info.Url
+		}
+		c.migrating = true
+		c.lock.Unlock()
+
+		dglogger.Infof(c.ctx, "websocket client migrate to %s", c.currentUrl())
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, "migrating"), writeDeadline(c.conf.WriteWait))
+		_ = conn.Close()
+	})
+
+	return true
+}
+
+// currentUrl 迁移后使用服务端指定的地址, 否则为ClientConfig.Url
+func (c *Client) currentUrl() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.url != "" {
+		return c.url
+	}
+	return c.conf.Url
+}
+
+// takeMigrating 迁移触发的断开不等待退避, 立即重连
+func (c *Client) takeMigrating() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	migrating := c.migrating
+	c.migrating = false
+	return migrating
+}
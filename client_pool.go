@@ -0,0 +1,196 @@
+package dgws
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPoolHealthCheckInterval = 5 * time.Second
+
+var ErrClientPoolClosed = errors.New("websocket client pool closed")
+
+type ClientPoolConfig struct {
+	Size int
+	// ClientConfig 每个成员连接使用该配置的副本
+	ClientConfig        *ClientConfig
+	HealthCheckInterval time.Duration
+	// DeadAfter 成员连续未连接超过该时长即视为失效并替换, 0表示只替换已关闭(如重连次数耗尽)的成员
+	DeadAfter time.Duration
+}
+
+type ClientPool struct {
+	ctx       *dgctx.DgContext
+	conf      *ClientPoolConfig
+	lock      sync.RWMutex
+	clients   []*Client
+	next      atomic.Uint64
+	startOnce sync.Once
+	closeOnce sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+}
+
+func NewClientPool(ctx *dgctx.DgContext, conf *ClientPoolConfig) (*ClientPool, error) {
+	if conf.Size <= 0 {
+		return nil, errors.New("client pool size must be positive")
+	}
+	if conf.HealthCheckInterval <= 0 {
+		conf.HealthCheckInterval = defaultPoolHealthCheckInterval
+	}
+
+	p := &ClientPool{
+		ctx:     ctx,
+		conf:    conf,
+		clients: make([]*Client, conf.Size),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i := range p.clients {
+		client, err := p.newMember()
+		if err != nil {
+			return nil, err
+		}
+		p.clients[i] = client
+	}
+
+	return p, nil
+}
+
+func (p *ClientPool) Start() {
+	p.startOnce.Do(func() {
+		for _, client := range p.members() {
+			client.Start()
+		}
+		go p.healthCheck()
+	})
+}
+
+// WriteMessage 轮询选择已连接的成员发送, 没有已连接成员时交给轮询到的成员处理(可进入其离线缓存)
+func (p *ClientPool) WriteMessage(mt int, data []byte) error {
+	clients := p.members()
+	if len(clients) == 0 {
+		return ErrClientPoolClosed
+	}
+
+	start := int(p.next.Add(1) % uint64(len(clients)))
+	for i := 0; i < len(clients); i++ {
+		j := (start + i) % len(clients)
+		if clients[j].State() == ClientStateConnected {
+			return p.writeMember(j, clients[j], mt, data)
+		}
+	}
+
+	return p.writeMember(start, clients[start], mt, data)
+}
+
+// WriteMessageByKey 相同key的消息总是由同一个成员发送, 以保证顺序
+func (p *ClientPool) WriteMessageByKey(key string, mt int, data []byte) error {
+	clients := p.members()
+	if len(clients) == 0 {
+		return ErrClientPoolClosed
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	i := int(h.Sum32() % uint32(len(clients)))
+	return p.writeMember(i, clients[i], mt, data)
+}
+
+// writeMember 成员在写入前已被替换并关闭时改由新成员发送
+func (p *ClientPool) writeMember(i int, client *Client, mt int, data []byte) error {
+	err := client.WriteMessage(mt, data)
+	if errors.Is(err, ErrClientClosed) {
+		if clients := p.members(); i < len(clients) && clients[i] != client {
+			return clients[i].WriteMessage(mt, data)
+		}
+	}
+	return err
+}
+
+func (p *ClientPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.startOnce.Do(func() {
+			close(p.done)
+		})
+		<-p.done
+
+		p.lock.Lock()
+		clients := p.clients
+		p.clients = nil
+		p.lock.Unlock()
+
+		for _, client := range clients {
+			_ = client.Close()
+		}
+	})
+}
+
+func (p *ClientPool) members() []*Client {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.clients
+}
+
+func (p *ClientPool) newMember() (*Client, error) {
+	conf := *p.conf.ClientConfig
+	return NewClient(p.ctx, &conf)
+}
+
+func (p *ClientPool) healthCheck() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.conf.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			p.replaceDeadMembers()
+		}
+	}
+}
+
+func (p *ClientPool) isDead(client *Client) bool {
+	if client.State() == ClientStateClosed {
+		return true
+	}
+	since := client.disconnectedSince()
+	return p.conf.DeadAfter > 0 && !since.IsZero() && time.Since(since) > p.conf.DeadAfter
+}
+
+// replaceDeadMembers 先换入新成员再关闭失效的成员, 之后的写入不会再选中已关闭的成员;
+// 失效成员离线缓存中尚未发送的消息转给新成员. Close会等待健康检查退出, 因此这里不会与Close并发
+func (p *ClientPool) replaceDeadMembers() {
+	for i, client := range p.members() {
+		if !p.isDead(client) {
+			continue
+		}
+
+		member, err := p.newMember()
+		if err != nil {
+			dglogger.Errorf(p.ctx, "websocket client pool create member error: %v", err)
+			continue
+		}
+		p.lock.Lock()
+		clients := slices.Clone(p.clients)
+		clients[i] = member
+		p.clients = clients
+		p.lock.Unlock()
+
+		state := client.State()
+		_ = client.Close()
+		pending := client.takePending()
+		dropped := member.restorePending(pending)
+		dglogger.Warnf(p.ctx, "websocket client pool replace dead member %d, state: %s, pending messages: %d, dropped: %d", i, state, len(pending), dropped)
+		member.Start()
+	}
+}
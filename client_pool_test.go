@@ -0,0 +1,187 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientPoolReplacesDeadMember(t *testing.T) {
+	// 服务端恢复前成员一直连不上, 超过DeadAfter后被替换
+	var down atomic.Bool
+	down.Store(true)
+	received := make(chan string, 10)
+	engine := gin.New()
+	path := "/pool-" + uuid.NewString()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group(path),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			received <- string(wsm.MessageData)
+			return nil
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	closed := make(chan struct{}, 1)
+	pool, err := dgws.NewClientPool(&dgctx.DgContext{TraceId: uuid.NewString()}, &dgws.ClientPoolConfig{
+		Size: 1,
+		ClientConfig: &dgws.ClientConfig{
+			Url:                  "ws" + strings.TrimPrefix(server.URL, "http") + path,
+			MinReconnectInterval: 50 * time.Millisecond,
+			MaxReconnectInterval: 100 * time.Millisecond,
+			OfflineBufferSize:    10,
+			StateChangeHandler: func(_ *dgctx.DgContext, _ dgws.ClientState, to dgws.ClientState) {
+				if to == dgws.ClientStateClosed {
+					select {
+					case closed <- struct{}{}:
+					default:
+					}
+				}
+			},
+		},
+		HealthCheckInterval: 50 * time.Millisecond,
+		DeadAfter:           200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new pool: %v", err)
+	}
+	pool.Start()
+	defer pool.Close()
+
+	if err := pool.WriteMessage(websocket.TextMessage, []byte("queued")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("dead member not closed")
+	}
+
+	// 被替换成员缓存的消息由新成员连接后发送
+	down.Store(false)
+	select {
+	case msg := <-received:
+		if msg != "queued" {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("pending message not flushed by replacement")
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("message delivered twice: %s", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestClientPoolWritesDuringReplacement(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	received := make(chan string, 10000)
+	engine := gin.New()
+	path := "/pool-" + uuid.NewString()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group(path),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			received <- string(wsm.MessageData)
+			return nil
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		engine.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	replaced := make(chan struct{})
+	var closedOnce sync.Once
+	pool, err := dgws.NewClientPool(&dgctx.DgContext{TraceId: uuid.NewString()}, &dgws.ClientPoolConfig{
+		Size: 1,
+		ClientConfig: &dgws.ClientConfig{
+			Url:                  "ws" + strings.TrimPrefix(server.URL, "http") + path,
+			MinReconnectInterval: 50 * time.Millisecond,
+			MaxReconnectInterval: 100 * time.Millisecond,
+			OfflineBufferSize:    10000,
+			StateChangeHandler: func(_ *dgctx.DgContext, _ dgws.ClientState, to dgws.ClientState) {
+				if to == dgws.ClientStateClosed {
+					closedOnce.Do(func() { close(replaced) })
+				}
+			},
+		},
+		HealthCheckInterval: 50 * time.Millisecond,
+		DeadAfter:           150 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new pool: %v", err)
+	}
+	pool.Start()
+	defer pool.Close()
+
+	// 替换期间持续写入, 不应选中已关闭的成员
+	sent := 0
+	for stop := time.After(3 * time.Second); ; sent++ {
+		if err := pool.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(sent))); err != nil {
+			t.Fatalf("write %d during replacement: %v", sent, err)
+		}
+		select {
+		case <-replaced:
+		case <-stop:
+			t.Fatal("dead member not replaced")
+		default:
+			time.Sleep(200 * time.Microsecond)
+			continue
+		}
+		break
+	}
+	for i := 0; i < 50; i++ {
+		sent++
+		if err := pool.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(sent))); err != nil {
+			t.Fatalf("write %d after replacement: %v", sent, err)
+		}
+	}
+
+	down.Store(false)
+	for i := 0; i <= sent; i++ {
+		select {
+		case msg := <-received:
+			if msg != strconv.Itoa(i) {
+				t.Fatalf("expected message %d, got %s", i, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %d not delivered", i)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package dgws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SendWithContext 与WriteMessage相同, 但在等待写锁和写入期间响应ctx的取消与超时;
+// 写入过程中被取消会使当前连接失效并触发重连
+func (c *Client) SendWithContext(ctx context.Context, mt int, data []byte) error {
+	select {
+	case c.writeSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer c.unlockWrite()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn := c.Conn()
+	if conn == nil {
+		return c.writeOrBuffer(mt, data)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+
+	var lock sync.Mutex
+	finished := false
+	stop := context.AfterFunc(ctx, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if !finished {
+			_ = conn.SetWriteDeadline(time.Now())
+		}
+	})
+
+	err := c.writeOrBuffer(mt, data)
+
+	lock.Lock()
+	finished = true
+	lock.Unlock()
+	stop()
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	if err != nil && ctx.Err() != nil {
+		_ = conn.Close()
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (c *Client) lockWrite() {
+	c.writeSem <- struct{}{}
+}
+
+func (c *Client) unlockWrite() {
+	<-c.writeSem
+}
@@ -0,0 +1,51 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+)
+
+// ClientServerStateHandler 收到服务端的state消息后回调, 需服务端开启NotifyClientState
+type ClientServerStateHandler func(ctx *dgctx.DgContext, state ConnectionState)
+
+// ServerState 服务端最近通知的连接状态, 连接断开后为closed
+func (c *Client) ServerState() ConnectionState {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.serverState
+}
+
+func (c *Client) dispatchServerState(mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || env.Type != EnvelopeTypeState {
+		return false
+	}
+
+	var name string
+	if err := json.Unmarshal(env.Data, &name); err != nil {
+		return true
+	}
+	state, ok := parseConnectionState(name)
+	if !ok {
+		return true
+	}
+	c.setServerState(state)
+
+	return true
+}
+
+func (c *Client) setServerState(state ConnectionState) {
+	c.lock.Lock()
+	changed := c.serverState != state
+	c.serverState = state
+	c.lock.Unlock()
+
+	if changed && c.conf.ServerStateHandler != nil {
+		c.conf.ServerStateHandler(c.ctx, state)
+	}
+}
@@ -0,0 +1,52 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+)
+
+const ResumeTokenHeader = "Ws-Resume-Token"
+
+type SessionInfo struct {
+	Token   string `json:"token"`
+	Resumed bool   `json:"resumed"`
+}
+
+// ClientSessionHandler 收到服务端的session消息后回调, resumed为true表示恢复了之前的会话, 可跳过重新初始化
+type ClientSessionHandler func(ctx *dgctx.DgContext, resumed bool) error
+
+func (c *Client) ResumeToken() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.resumeToken
+}
+
+func (c *Client) dispatchSession(mt int, data []byte) bool {
+	if mt != websocket.TextMessage {
+		return false
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || env.Type != EnvelopeTypeSession {
+		return false
+	}
+
+	info := &SessionInfo{}
+	if err := json.Unmarshal(env.Data, info); err != nil {
+		return true
+	}
+
+	c.lock.Lock()
+	c.resumeToken = info.Token
+	c.lock.Unlock()
+
+	if c.conf.SessionHandler != nil {
+		if err := c.conf.SessionHandler(c.ctx, info.Resumed); err != nil {
+			dglogger.Errorf(c.ctx, "websocket client handle session error: %v", err)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,295 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	return startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{}, bizHandler)
+}
+
+func startTestServerWithConfig(t *testing.T, conf *dgws.WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	return startTestServerAt(t, "/ws", conf, bizHandler)
+}
+
+// startTestServerAt 按路由统计的用例使用唯一的路径, 避免-count大于1时累计之前的结果
+func startTestServerAt(t *testing.T, path string, conf *dgws.WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	engine := gin.New()
+	conf.BizKey = "bizId"
+	conf.GetBizIdHandler = func(c *gin.Context) string {
+		return c.Query("bizId")
+	}
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group(path),
+		NonLogin:    true,
+		BizHandler:  bizHandler,
+	}, conf)
+
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + path
+}
+
+func TestClientReconnect(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "bye" {
+			return wsm.Connection.Close()
+		}
+		return wsm.Connection.WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	var starts atomic.Int32
+	received := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		StartHandler: func(_ *dgctx.DgContext, _ *websocket.Conn) error {
+			starts.Add(1)
+			return nil
+		},
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			received <- string(data)
+			return nil
+		},
+		MinReconnectInterval: 10 * time.Millisecond,
+	})
+	client.Start()
+	defer client.Close()
+
+	waitState(t, client, dgws.ClientStateConnected)
+	if err := client.WriteMessage(websocket.TextMessage, []byte("bye")); err != nil {
+		t.Fatalf("write bye: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for starts.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if starts.Load() < 2 {
+		t.Fatalf("expected client to reconnect, start handler called %d times", starts.Load())
+	}
+
+	waitState(t, client, dgws.ClientStateConnected)
+	if err := client.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "hello" {
+			t.Fatalf("unexpected echo: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no echo after reconnect")
+	}
+
+	_ = client.Close()
+	if client.State() != dgws.ClientStateClosed {
+		t.Fatalf("unexpected state after close: %s", client.State())
+	}
+}
+
+func waitState(t *testing.T, client *dgws.Client, state dgws.ClientState) {
+	deadline := time.Now().Add(3 * time.Second)
+	for client.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("client state %s, expected %s", client.State(), state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientOfflineBuffer(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return wsm.Connection.WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	received := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			received <- string(data)
+			return nil
+		},
+		OfflineBufferSize: 2,
+		OverflowPolicy:    dgws.OverflowDropOldest,
+	})
+	defer client.Close()
+
+	for _, msg := range []string{"1", "2", "3"} {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("buffer message %s: %v", msg, err)
+		}
+	}
+	if client.PendingCount() != 2 {
+		t.Fatalf("expected 2 pending messages, got %d", client.PendingCount())
+	}
+
+	client.Start()
+	for _, expected := range []string{"2", "3"} {
+		select {
+		case msg := <-received:
+			if msg != expected {
+				t.Fatalf("expected %s, got %s", expected, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("buffered message %s not flushed", expected)
+		}
+	}
+}
+
+func TestClientTyped(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return wsm.Connection.WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	received := make(chan *testData, 10)
+	client := newTestClient(t, &dgws.ClientConfig{Url: url})
+	dgws.OnMessage(client, func(_ *dgctx.DgContext, data *testData) error {
+		received <- data
+		return nil
+	})
+	client.Start()
+	defer client.Close()
+
+	waitState(t, client, dgws.ClientStateConnected)
+	for _, content := range []string{"1", "12345"} {
+		if err := dgws.SendJSON(client, &testData{Content: content}); err != nil {
+			t.Fatalf("send json: %v", err)
+		}
+	}
+
+	select {
+	case data := <-received:
+		if data.Content != "12345" {
+			t.Fatalf("invalid message passed validation: %s", data.Content)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no typed message received")
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		req, ok := dgws.ParseEnvelope(wsm.MessageData)
+		if !ok || req.Type != dgws.EnvelopeTypeRequest {
+			return nil
+		}
+		data := &testData{}
+		_ = json.Unmarshal(req.Data, data)
+		return dgws.Reply(dgws.GetConnection(ctx), req, &testData{Content: data.Content + "-reply"}, nil)
+	})
+
+	client := newTestClient(t, &dgws.ClientConfig{Url: url})
+	client.Start()
+	defer client.Close()
+
+	waitState(t, client, dgws.ClientStateConnected)
+	resp, err := client.Call(&dgctx.DgContext{TraceId: uuid.NewString()}, &testData{Content: "ping"}, 3*time.Second)
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	data := &testData{}
+	if err := json.Unmarshal(resp, data); err != nil || data.Content != "ping-reply" {
+		t.Fatalf("unexpected response: %s, %v", string(resp), err)
+	}
+}
+
+func newTestClient(t *testing.T, conf *dgws.ClientConfig) *dgws.Client {
+	client, err := dgws.NewClient(&dgctx.DgContext{TraceId: uuid.NewString()}, conf)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	return client
+}
+
+func TestClientConcurrentSend(t *testing.T) {
+	received := make(chan string, 100)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		received <- string(wsm.MessageData)
+		return nil
+	})
+
+	client := newTestClient(t, &dgws.ClientConfig{Url: url})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			if err := client.SendWithContext(ctx, websocket.TextMessage, []byte("msg")); err != nil {
+				t.Errorf("send with context: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("only %d messages received", i)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.SendWithContext(ctx, websocket.TextMessage, []byte("msg")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
+
+func TestClientGiveUpReconnect(t *testing.T) {
+	// 服务端不可用, 重连次数耗尽后客户端关闭
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	server.Close()
+
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url:                  url,
+		MinReconnectInterval: 10 * time.Millisecond,
+		MaxReconnectInterval: 20 * time.Millisecond,
+		MaxReconnectAttempts: 2,
+		OfflineBufferSize:    10,
+	})
+	client.Start()
+	waitState(t, client, dgws.ClientStateClosed)
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("late")); !errors.Is(err, dgws.ErrClientClosed) {
+		t.Fatalf("expected ErrClientClosed, got %v", err)
+	}
+	if n := client.PendingCount(); n != 0 {
+		t.Fatalf("expected no buffered messages, got %d", n)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close blocked after giving up")
+	}
+}
@@ -0,0 +1,87 @@
+package dgws
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+)
+
+type TopicHandler func(ctx *dgctx.DgContext, topic string, data []byte) error
+
+// Subscribe 订阅topic, 断线重连后会自动重新订阅.
+// 服务端没有内置的订阅协议: subscribe、unsubscribe消息由业务的BizHandler处理, 业务推送Type为publish、
+// Topic为已订阅topic的Envelope时交给handler, 其他消息仍交给MessageHandler
+func (c *Client) Subscribe(topic string, handler TopicHandler) error {
+	c.lock.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]TopicHandler)
+	}
+	c.subscriptions[topic] = handler
+	c.lock.Unlock()
+
+	return c.writeEnvelopeIfConnected(&Envelope{Type: EnvelopeTypeSubscribe, Topic: topic})
+}
+
+func (c *Client) Unsubscribe(topic string) error {
+	c.lock.Lock()
+	delete(c.subscriptions, topic)
+	c.lock.Unlock()
+
+	return c.writeEnvelopeIfConnected(&Envelope{Type: EnvelopeTypeUnsubscribe, Topic: topic})
+}
+
+func (c *Client) writeEnvelopeIfConnected(env *Envelope) error {
+	c.lockWrite()
+	defer c.unlockWrite()
+
+	conn := c.Conn()
+	if conn == nil {
+		return nil
+	}
+
+	return c.writeEnvelope(conn, env)
+}
+
+// resubscribe 重连后重放订阅, 调用方需持有写锁
+func (c *Client) resubscribe(conn *websocket.Conn) error {
+	c.lock.RLock()
+	topics := make([]string, 0, len(c.subscriptions))
+	for topic := range c.subscriptions {
+		topics = append(topics, topic)
+	}
+	c.lock.RUnlock()
+
+	for _, topic := range topics {
+		if err := c.writeEnvelope(conn, &Envelope{Type: EnvelopeTypeSubscribe, Topic: topic}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) dispatchTopicMessage(mt int, data []byte) (bool, error) {
+	if mt != websocket.TextMessage {
+		return false, nil
+	}
+
+	c.lock.RLock()
+	subscribed := len(c.subscriptions) > 0
+	c.lock.RUnlock()
+	if !subscribed {
+		return false, nil
+	}
+
+	env, ok := ParseEnvelope(data)
+	if !ok || env.Type != EnvelopeTypePublish {
+		return false, nil
+	}
+
+	c.lock.RLock()
+	handler := c.subscriptions[env.Topic]
+	c.lock.RUnlock()
+	if handler == nil {
+		return false, nil
+	}
+
+	return true, handler(c.ctx, env.Topic, env.Data)
+}
@@ -0,0 +1,95 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestClientTopicSubscription(t *testing.T) {
+	// 订阅协议由业务定义, 这里的服务端收到subscribe后立即推送一条该topic的消息
+	requests := make(chan *dgws.Envelope, 10)
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "bye" {
+			return wsm.Connection.Close()
+		}
+		env, ok := dgws.ParseEnvelope(wsm.MessageData)
+		if !ok {
+			return nil
+		}
+		requests <- env
+		if env.Type != dgws.EnvelopeTypeSubscribe {
+			return nil
+		}
+		return dgws.WriteEnvelope(dgws.GetConnection(ctx), &dgws.Envelope{Type: dgws.EnvelopeTypePublish, Topic: env.Topic, Data: json.RawMessage(`"welcome"`)})
+	})
+
+	published := make(chan string, 10)
+	others := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url:                  url,
+		MinReconnectInterval: 10 * time.Millisecond,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			others <- string(data)
+			return nil
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	expectRequest := func(typ string, topic string) {
+		t.Helper()
+		select {
+		case env := <-requests:
+			if env.Type != typ || env.Topic != topic {
+				t.Fatalf("expected %s %s, got %+v", typ, topic, env)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s %s not received by server", typ, topic)
+		}
+	}
+	expectPublished := func(expected string) {
+		t.Helper()
+		select {
+		case msg := <-published:
+			if msg != expected {
+				t.Fatalf("expected %s, got %s", expected, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s not published to handler", expected)
+		}
+	}
+
+	if err := client.Subscribe("news", func(_ *dgctx.DgContext, topic string, data []byte) error {
+		published <- topic + ":" + string(data)
+		return nil
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	expectRequest(dgws.EnvelopeTypeSubscribe, "news")
+	expectPublished(`news:"welcome"`)
+
+	// 断线重连后自动重新订阅
+	if err := client.WriteMessage(websocket.TextMessage, []byte("bye")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	expectRequest(dgws.EnvelopeTypeSubscribe, "news")
+	expectPublished(`news:"welcome"`)
+
+	if err := client.Unsubscribe("news"); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	expectRequest(dgws.EnvelopeTypeUnsubscribe, "news")
+
+	// publish消息已交给topic的handler, 不会再交给MessageHandler
+	select {
+	case msg := <-others:
+		t.Fatalf("unexpected message for MessageHandler: %s", msg)
+	default:
+	}
+}
@@ -0,0 +1,52 @@
+package dgws
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+)
+
+func SendJSON[T any](c *Client, v *T) error {
+	codec := c.codec()
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteMessage(codec.MessageType(), data)
+}
+
+// OnMessage 注册类型化的消息处理器, 会覆盖ClientConfig.MessageHandler
+func OnMessage[T any](c *Client, handler func(ctx *dgctx.DgContext, t *T) error) {
+	c.SetMessageHandler(func(ctx *dgctx.DgContext, mt int, data []byte) error {
+		if mt != websocket.TextMessage && mt != websocket.BinaryMessage {
+			return nil
+		}
+
+		t, err := Decode[T](c.codec(), data)
+		if err != nil {
+			return err
+		}
+
+		return handler(ctx, t)
+	})
+}
+
+func (c *Client) SetMessageHandler(handler ClientMessageHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.messageHandler = handler
+}
+
+func (c *Client) getMessageHandler() ClientMessageHandler {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.messageHandler
+}
+
+func (c *Client) codec() Codec {
+	if c.conf.Codec != nil {
+		return c.conf.Codec
+	}
+
+	return DefaultCodec
+}
@@ -0,0 +1,104 @@
+package dgws
+
+import (
+	"github.com/gorilla/websocket"
+	"sync/atomic"
+	"time"
+)
+
+// Clock ping调度、PongWait超时和客户端心跳使用的时钟, 测试中通过SetClock注入以快进时间
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer 语义与time.Timer相同
+type Timer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return realTimer{time.AfterFunc(d, f)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) Chan() <-chan time.Time { return t.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) Chan() <-chan time.Time { return t.C }
+
+type clockHolder struct{ clock Clock }
+
+var currentClock atomic.Pointer[clockHolder]
+
+// SetClock 替换全局时钟, nil恢复为真实时钟; 应在建立连接前调用, 已调度的ping按新时钟重新计时
+func SetClock(c Clock) {
+	if c == nil {
+		currentClock.Store(nil)
+	} else {
+		currentClock.Store(&clockHolder{clock: c})
+	}
+	wakePingSchedulers()
+}
+
+func getClock() Clock {
+	if h := currentClock.Load(); h != nil {
+		return h.clock
+	}
+	return realClock{}
+}
+
+func isRealClock(c Clock) bool {
+	_, ok := c.(realClock)
+	return ok
+}
+
+// readDeadline PongWait超时; 真实时钟下即conn的读超时, 注入的时钟无法作用于网络连接, 改为到期时关闭底层连接
+type readDeadline struct {
+	conn  *websocket.Conn
+	wait  time.Duration
+	timer Timer
+}
+
+func newReadDeadline(conn *websocket.Conn, wait time.Duration) *readDeadline {
+	rd := &readDeadline{conn: conn, wait: wait}
+	if clock := getClock(); !isRealClock(clock) {
+		rd.timer = clock.AfterFunc(wait, func() {
+			_ = conn.NetConn().Close()
+		})
+		return rd
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(wait))
+	return rd
+}
+
+func (rd *readDeadline) extend() error {
+	if rd.timer != nil {
+		rd.timer.Reset(rd.wait)
+		return nil
+	}
+	return rd.conn.SetReadDeadline(time.Now().Add(rd.wait))
+}
+
+func (rd *readDeadline) stop() {
+	if rd.timer != nil {
+		rd.timer.Stop()
+	}
+}
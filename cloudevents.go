@@ -0,0 +1,295 @@
+package dgws
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"strings"
+	"time"
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType 结构化模式下整个事件的媒体类型
+	CloudEventsContentType = "application/cloudevents+json"
+)
+
+// CloudEvent的扩展属性, traceparent和tracestate来自分布式追踪扩展
+const (
+	CloudEventExtTraceParent = "traceparent"
+	CloudEventExtTraceState  = "tracestate"
+	// CloudEventExtTraceId 原样携带DgContext.TraceId, 不是32位十六进制时traceparent无法表达
+	CloudEventExtTraceId = "dgtraceid"
+	CloudEventExtBizKey  = "bizkey"
+	CloudEventExtBizId   = "bizid"
+)
+
+var ErrCloudEvent = errors.New("invalid cloudevent")
+
+// CloudEvent 结构化json模式的CloudEvents 1.0事件, Data为json数据, DataBase64不为nil时以data_base64传输二进制数据
+type CloudEvent struct {
+	Id              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	DataContentType string
+	DataSchema      string
+	Subject         string
+	Time            time.Time
+	Data            json.RawMessage
+	DataBase64      []byte
+	// Extensions 扩展属性, 名称只能是小写字母和数字
+	Extensions map[string]any
+}
+
+var cloudEventAttributes = map[string]bool{
+	"id": true, "source": true, "specversion": true, "type": true, "datacontenttype": true,
+	"dataschema": true, "subject": true, "time": true, "data": true, "data_base64": true,
+}
+
+// NewCloudEvent 以ctx的TraceId生成追踪扩展, ctx对应已注册的连接时带上bizkey和bizid扩展;
+// data为[]byte时作为data_base64, 否则序列化为json
+func NewCloudEvent(ctx *dgctx.DgContext, source string, eventType string, data any) (*CloudEvent, error) {
+	event := &CloudEvent{
+		Id:          uuid.NewString(),
+		Source:      source,
+		SpecVersion: CloudEventsSpecVersion,
+		Type:        eventType,
+		Time:        getClock().Now().UTC(),
+		Extensions:  make(map[string]any),
+	}
+	switch v := data.(type) {
+	case nil:
+	case []byte:
+		event.DataContentType = "application/octet-stream"
+		event.DataBase64 = v
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		event.DataContentType = "application/json"
+		event.Data = raw
+	}
+
+	if ctx != nil {
+		if ctx.TraceId != "" {
+			event.Extensions[CloudEventExtTraceId] = ctx.TraceId
+			if traceParent := buildTraceParent(ctx.TraceId); traceParent != "" {
+				event.Extensions[CloudEventExtTraceParent] = traceParent
+			}
+		}
+		if connection := GetConnection(ctx); connection != nil && connection.BizKey != "" {
+			event.Extensions[CloudEventExtBizKey] = connection.BizKey
+			event.Extensions[CloudEventExtBizId] = connection.BizId
+		}
+	}
+	return event, nil
+}
+
+// buildTraceParent uuid等去掉连字符后为32位十六进制的TraceId才能作为W3C trace-id, parent-id随机生成
+func buildTraceParent(traceId string) string {
+	traceId = strings.ToLower(strings.ReplaceAll(traceId, "-", ""))
+	if len(traceId) != 32 || strings.Trim(traceId, "0") == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(traceId); err != nil {
+		return ""
+	}
+	parentId := make([]byte, 8)
+	_, _ = rand.Read(parentId)
+	return "00-" + traceId + "-" + hex.EncodeToString(parentId) + "-01"
+}
+
+// ParseCloudEvent 解析并校验必需属性
+func ParseCloudEvent(data []byte) (*CloudEvent, error) {
+	event := &CloudEvent{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	if event.SpecVersion != CloudEventsSpecVersion {
+		return nil, fmt.Errorf("%w: unsupported specversion %q", ErrCloudEvent, event.SpecVersion)
+	}
+	if event.Id == "" || event.Source == "" || event.Type == "" {
+		return nil, fmt.Errorf("%w: id, source and type are required", ErrCloudEvent)
+	}
+	return event, nil
+}
+
+func (e *CloudEvent) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(e.Extensions)+10)
+	for name, value := range e.Extensions {
+		if cloudEventAttributes[name] {
+			return nil, fmt.Errorf("%w: extension %s conflicts with context attribute", ErrCloudEvent, name)
+		}
+		m[name] = value
+	}
+	m["id"] = e.Id
+	m["source"] = e.Source
+	m["specversion"] = e.SpecVersion
+	m["type"] = e.Type
+	setIfNotEmpty := func(name string, value string) {
+		if value != "" {
+			m[name] = value
+		}
+	}
+	setIfNotEmpty("datacontenttype", e.DataContentType)
+	setIfNotEmpty("dataschema", e.DataSchema)
+	setIfNotEmpty("subject", e.Subject)
+	if !e.Time.IsZero() {
+		m["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	if e.DataBase64 != nil {
+		m["data_base64"] = base64.StdEncoding.EncodeToString(e.DataBase64)
+	} else if e.Data != nil {
+		m["data"] = e.Data
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON 未知属性作为扩展, 数字解码为json.Number
+func (e *CloudEvent) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*e = CloudEvent{}
+	strs := map[string]*string{
+		"id": &e.Id, "source": &e.Source, "specversion": &e.SpecVersion, "type": &e.Type,
+		"datacontenttype": &e.DataContentType, "dataschema": &e.DataSchema, "subject": &e.Subject,
+	}
+	for name, raw := range m {
+		if p, ok := strs[name]; ok {
+			if err := json.Unmarshal(raw, p); err != nil {
+				return fmt.Errorf("%w: attribute %s must be a string", ErrCloudEvent, name)
+			}
+			continue
+		}
+		switch name {
+		case "time":
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return fmt.Errorf("%w: attribute time must be a string", ErrCloudEvent)
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return fmt.Errorf("%w: attribute time: %v", ErrCloudEvent, err)
+			}
+			e.Time = t
+		case "data":
+			e.Data = raw
+		case "data_base64":
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return fmt.Errorf("%w: attribute data_base64 must be a string", ErrCloudEvent)
+			}
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return fmt.Errorf("%w: attribute data_base64: %v", ErrCloudEvent, err)
+			}
+			e.DataBase64 = decoded
+		default:
+			var value any
+			decoder := json.NewDecoder(bytes.NewReader(raw))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				return err
+			}
+			if e.Extensions == nil {
+				e.Extensions = make(map[string]any)
+			}
+			e.Extensions[name] = value
+		}
+	}
+	return nil
+}
+
+// DecodeData 按json反序列化Data; 事件携带data_base64时v只能是*[]byte
+func (e *CloudEvent) DecodeData(v any) error {
+	if e.DataBase64 != nil {
+		p, ok := v.(*[]byte)
+		if !ok {
+			return fmt.Errorf("%w: binary data can only be decoded into *[]byte", ErrCloudEvent)
+		}
+		*p = e.DataBase64
+		return nil
+	}
+	if e.Data == nil {
+		return nil
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+func (e *CloudEvent) extension(name string) string {
+	s, _ := e.Extensions[name].(string)
+	return s
+}
+
+// TraceId 优先取dgtraceid扩展, 否则取traceparent中的trace-id
+func (e *CloudEvent) TraceId() string {
+	if traceId := e.extension(CloudEventExtTraceId); traceId != "" {
+		return traceId
+	}
+	if parts := strings.Split(e.extension(CloudEventExtTraceParent), "-"); len(parts) == 4 {
+		return parts[1]
+	}
+	return ""
+}
+
+func (e *CloudEvent) BizKey() string {
+	return e.extension(CloudEventExtBizKey)
+}
+
+func (e *CloudEvent) BizId() string {
+	return e.extension(CloudEventExtBizId)
+}
+
+func WriteCloudEvent(conn *websocket.Conn, event *CloudEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// CloudEventsCodec 以CloudEvents结构化json模式收发消息的Codec, 可用于ClientConfig.Codec、ClusterOptions.Codec等:
+// Marshal把v作为data包装成Source和Type指定的事件, v为*CloudEvent时原样序列化; Unmarshal反之
+type CloudEventsCodec struct {
+	Source string
+	Type   string
+}
+
+func (c CloudEventsCodec) Marshal(v any) ([]byte, error) {
+	event, ok := v.(*CloudEvent)
+	if !ok {
+		var err error
+		if event, err = NewCloudEvent(nil, c.Source, c.Type, v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(event)
+}
+
+func (c CloudEventsCodec) Unmarshal(data []byte, v any) error {
+	event, err := ParseCloudEvent(data)
+	if err != nil {
+		return err
+	}
+	if p, ok := v.(*CloudEvent); ok {
+		*p = *event
+		return nil
+	}
+	return event.DecodeData(v)
+}
+
+func (CloudEventsCodec) MessageType() int {
+	return websocket.TextMessage
+}
@@ -0,0 +1,111 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/darwinOrg/go-common/constants"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	data := []byte(`{"specversion":"1.0","id":"e1","source":"/orders","type":"com.example.created","time":"2024-05-01T08:00:00.5Z",` +
+		`"datacontenttype":"application/json","data":{"orderId":7},"partitionkey":"p1","seq":3}`)
+	event, err := dgws.ParseCloudEvent(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if event.Id != "e1" || event.Source != "/orders" || !event.Time.Equal(time.Date(2024, 5, 1, 8, 0, 0, 5e8, time.UTC)) ||
+		event.Extensions["partitionkey"] != "p1" || event.Extensions["seq"] != json.Number("3") {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	var order struct {
+		OrderId int `json:"orderId"`
+	}
+	if err := event.DecodeData(&order); err != nil || order.OrderId != 7 {
+		t.Fatalf("decode data: %v, %+v", err, order)
+	}
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	again, err := dgws.ParseCloudEvent(encoded)
+	if err != nil || again.Type != event.Type || string(again.Data) != `{"orderId":7}` || again.Extensions["seq"] != json.Number("3") {
+		t.Fatalf("round trip: %v, %s", err, encoded)
+	}
+
+	binary, err := dgws.NewCloudEvent(nil, "/files", "com.example.uploaded", []byte{0, 1, 2})
+	if err != nil {
+		t.Fatalf("new binary event: %v", err)
+	}
+	encoded, _ = json.Marshal(binary)
+	if !strings.Contains(string(encoded), `"data_base64":"AAEC"`) {
+		t.Fatalf("unexpected binary event: %s", encoded)
+	}
+	var raw []byte
+	if parsed, err := dgws.ParseCloudEvent(encoded); err != nil || parsed.DecodeData(&raw) != nil || string(raw) != "\x00\x01\x02" {
+		t.Fatalf("unexpected binary data: %v, %v", err, raw)
+	}
+
+	for _, bad := range []string{`{"specversion":"0.3","id":"1","source":"s","type":"t"}`, `{"specversion":"1.0","source":"s","type":"t"}`, `{"specversion":"1.0","id":1,"source":"s","type":"t"}`} {
+		if _, err := dgws.ParseCloudEvent([]byte(bad)); !errors.Is(err, dgws.ErrCloudEvent) {
+			t.Fatalf("expected ErrCloudEvent for %s, got %v", bad, err)
+		}
+	}
+}
+
+func TestCloudEventExtensions(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		event, err := dgws.NewCloudEvent(ctx, "/ws", "com.example.echo", map[string]string{"text": string(wsm.MessageData)})
+		if err != nil {
+			return err
+		}
+		return dgws.WriteCloudEvent(wsm.Connection, event)
+	})
+
+	traceId := "0af7651916cd43dd8448eb211c80319c"
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=ce-1", http.Header{constants.TraceId: {traceId}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	var event dgws.CloudEvent
+	if err := (dgws.CloudEventsCodec{}).Unmarshal(data, &event); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if event.TraceId() != traceId || event.BizKey() != "bizId" || event.BizId() != "ce-1" {
+		t.Fatalf("unexpected extensions: %+v", event.Extensions)
+	}
+	if parent, _ := event.Extensions[dgws.CloudEventExtTraceParent].(string); !strings.HasPrefix(parent, "00-"+traceId+"-") || !strings.HasSuffix(parent, "-01") {
+		t.Fatalf("unexpected traceparent: %q", parent)
+	}
+	payload, err := dgws.Decode[map[string]string](dgws.CloudEventsCodec{}, data)
+	if err != nil || (*payload)["text"] != "hi" {
+		t.Fatalf("decode payload: %v, %v", err, payload)
+	}
+
+	codec := dgws.CloudEventsCodec{Source: "/client", Type: "com.example.ping"}
+	encoded, err := codec.Marshal(map[string]int{"n": 1})
+	if err != nil {
+		t.Fatalf("codec marshal: %v", err)
+	}
+	if parsed, err := dgws.ParseCloudEvent(encoded); err != nil || parsed.Source != "/client" || parsed.Type != "com.example.ping" || parsed.Id == "" {
+		t.Fatalf("unexpected codec event: %v, %s", err, encoded)
+	}
+}
@@ -0,0 +1,162 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+const defaultClusterChannelPrefix = "dgws:"
+
+// ClusterBroker 实例间转发广播的消息通道, 子包redisbroker和natsbroker提供基于Redis pub/sub和NATS的实现
+type ClusterBroker interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe 订阅生效后返回, 之后在后台把收到的消息交给handler, 调用返回的函数取消订阅
+	Subscribe(ctx context.Context, channel string, handler func(payload []byte)) (func() error, error)
+}
+
+// ClusterOptions 开启后Broadcast、BroadcastJSON、BroadcastToBizIds会投递到所有实例的连接; BroadcastFilter仍只作用于本实例
+type ClusterOptions struct {
+	Broker ClusterBroker
+	// ChannelPrefix 默认"dgws:", 同一Redis上的不同集群需使用不同前缀
+	ChannelPrefix string
+	// Codec 序列化ClusterMessage, 默认DefaultCodec
+	Codec Codec
+}
+
+// ClusterMessage 实例间传递的广播, BizIds为空时投递给所有连接; Op不为空时为其他操作, 如kick
+type ClusterMessage struct {
+	InstanceId  string   `json:"instanceId"`
+	Op          string   `json:"op,omitempty"`
+	UserId      int64    `json:"userId,omitempty"`
+	BizKey      string   `json:"bizKey,omitempty"`
+	BizIds      []string `json:"bizIds,omitempty"`
+	MessageType int      `json:"mt,omitempty"`
+	Data        []byte   `json:"data,omitempty"`
+	CloseCode   int      `json:"closeCode,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+	// Region 经RegionRelay跨区域转发时为来源区域, 转发过的消息不再被转发
+	Region string `json:"region,omitempty"`
+}
+
+type clusterState struct {
+	ctx         *dgctx.DgContext
+	instanceId  string
+	channel     string
+	broker      ClusterBroker
+	codec       Codec
+	unsubscribe func() error
+}
+
+var (
+	cluster     *clusterState
+	clusterLock sync.RWMutex
+)
+
+// InitCluster 订阅其他实例的广播, 重复调用会替换之前的配置
+func InitCluster(ctx *dgctx.DgContext, opts *ClusterOptions) error {
+	if opts == nil || opts.Broker == nil {
+		return errors.New("websocket cluster broker is required")
+	}
+
+	state := &clusterState{
+		ctx:        ctx,
+		instanceId: uuid.NewString(),
+		channel:    opts.ChannelPrefix,
+		broker:     opts.Broker,
+		codec:      opts.Codec,
+	}
+	if state.channel == "" {
+		state.channel = defaultClusterChannelPrefix
+	}
+	state.channel += "broadcast"
+	if state.codec == nil {
+		state.codec = DefaultCodec
+	}
+
+	unsubscribe, err := opts.Broker.Subscribe(innerContext(ctx), state.channel, state.receive)
+	if err != nil {
+		return err
+	}
+	state.unsubscribe = unsubscribe
+
+	clusterLock.Lock()
+	previous := cluster
+	cluster = state
+	clusterLock.Unlock()
+	if previous != nil {
+		_ = previous.unsubscribe()
+	}
+
+	return nil
+}
+
+func CloseCluster() error {
+	clusterLock.Lock()
+	state := cluster
+	cluster = nil
+	clusterLock.Unlock()
+	if state == nil {
+		return nil
+	}
+
+	return state.unsubscribe()
+}
+
+func getCluster() *clusterState {
+	clusterLock.RLock()
+	defer clusterLock.RUnlock()
+	return cluster
+}
+
+// publishCluster 未开启集群时不做任何事
+func publishCluster(bizKey string, bizIds []string, mt int, data []byte) error {
+	return publishClusterMessage(&ClusterMessage{BizKey: bizKey, BizIds: bizIds, MessageType: mt, Data: data})
+}
+
+func publishClusterMessage(msg *ClusterMessage) error {
+	state := getCluster()
+	if state == nil {
+		return nil
+	}
+
+	msg.InstanceId = state.instanceId
+	payload, err := state.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return state.broker.Publish(context.Background(), state.channel, payload)
+}
+
+// receive 本实例发出的广播已在本地投递, 忽略
+func (s *clusterState) receive(payload []byte) {
+	msg := &ClusterMessage{}
+	if err := s.codec.Unmarshal(payload, msg); err != nil {
+		dglogger.Warnf(s.ctx, "websocket cluster decode message error: %v", err)
+		return
+	}
+	if msg.InstanceId == s.instanceId {
+		return
+	}
+	if msg.Op == ClusterOpKick {
+		kicked := kickLocal(&KickOptions{UserId: msg.UserId, BizKey: msg.BizKey, BizIds: msg.BizIds, CloseCode: msg.CloseCode, Reason: msg.Reason})
+		dglogger.Infof(s.ctx, "kick websocket connections from instance %s, userId: %d, bizKey: %s, bizIds: %v, kicked: %d", msg.InstanceId, msg.UserId, msg.BizKey, msg.BizIds, kicked)
+		return
+	}
+
+	pm, err := websocket.NewPreparedMessage(msg.MessageType, msg.Data)
+	if err != nil {
+		dglogger.Warnf(s.ctx, "websocket cluster prepare message error: %v", err)
+		return
+	}
+	var filter func(c *Connection) bool
+	if len(msg.BizIds) > 0 {
+		filter = bizIdsFilter(msg.BizKey, msg.BizIds)
+	}
+	broadcastPrepared(msg.MessageType, msg.Data, pm, filter)
+}
@@ -0,0 +1,306 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultClusterLimitTtl = 30 * time.Second
+
+// ClusterLimitStore 集群连接计数的存储, Redis实现见redisstore子包
+type ClusterLimitStore interface {
+	// Reserve 为实例预占n个总数名额, 不超过limit(0表示不限制), 返回实际预占数; n为负数时归还, n为0时只续期.
+	// 超过ttl未续期的实例, 其名额视为已释放
+	Reserve(ctx context.Context, nodeId string, n int64, limit int64, ttl time.Duration) (int64, error)
+	// AcquireUser 用户未过期的连接数小于limit时记录连接id并返回true, 记录ttl后过期
+	AcquireUser(ctx context.Context, user string, id string, limit int64, ttl time.Duration) (bool, error)
+	ReleaseUser(ctx context.Context, user string, id string) error
+	// RefreshUsers 续期仍存在的连接记录, users为连接id到用户的映射
+	RefreshUsers(ctx context.Context, users map[string]string, ttl time.Duration) error
+}
+
+// ClusterConnLimitOptions 通过共享存储协调所有实例的连接数上限, 与InitWsConnLimit的进程内上限同时生效
+type ClusterConnLimitOptions struct {
+	Store ClusterLimitStore
+	// MaxConnections 所有实例的连接总数上限, 0表示不限制
+	MaxConnections int64
+	// MaxPerUser 单个用户在所有实例上的连接数上限, 0表示不限制
+	MaxPerUser int64
+	// UserKey 区分用户的key, 默认有UserId时为UserId, 否则为bizKey:bizId
+	UserKey func(ctx *dgctx.DgContext, bizKey string, bizId string) string
+	// Slack 每次从Store预占的总数名额, 本地用完再预占, 避免每个连接都访问同一个key; 0表示不预占, 每个连接都访问Store.
+	// 各实例最多多占Slack*2个名额, 总数上限需留出余量
+	Slack int64
+	// Ttl 实例预占的名额和用户连接记录的存活时间, 实例异常退出后最多Ttl后释放, 默认30秒, 每Ttl/3续期一次
+	Ttl time.Duration
+}
+
+type clusterLimiter struct {
+	ctx      *dgctx.DgContext
+	opts     ClusterConnLimitOptions
+	nodeId   string
+	lock     sync.Mutex
+	reserved int64
+	used     int64
+	users    map[string]string
+	closed   bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// clusterConnLease 一个连接占用的名额, 连接结束时release
+type clusterConnLease struct {
+	limiter *clusterLimiter
+	id      string
+	// global Store不可用时放行的连接不占用总数名额
+	global bool
+	user   string
+}
+
+var (
+	clusterLimit     *clusterLimiter
+	clusterLimitLock sync.RWMutex
+)
+
+// InitClusterConnLimit 重复调用会替换之前的配置, 之前预占的名额会被归还
+func InitClusterConnLimit(ctx *dgctx.DgContext, opts *ClusterConnLimitOptions) error {
+	if opts == nil || opts.Store == nil {
+		return errors.New("websocket cluster conn limit store is required")
+	}
+
+	l := &clusterLimiter{ctx: ctx, opts: *opts, nodeId: uuid.NewString(), users: make(map[string]string), stop: make(chan struct{}), done: make(chan struct{})}
+	if l.opts.Ttl <= 0 {
+		l.opts.Ttl = defaultClusterLimitTtl
+	}
+
+	clusterLimitLock.Lock()
+	previous := clusterLimit
+	clusterLimit = l
+	clusterLimitLock.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	go l.run()
+
+	return nil
+}
+
+// CloseClusterConnLimit 停止续期并归还本实例预占的名额
+func CloseClusterConnLimit() {
+	clusterLimitLock.Lock()
+	l := clusterLimit
+	clusterLimit = nil
+	clusterLimitLock.Unlock()
+	if l != nil {
+		l.close()
+	}
+}
+
+func getClusterLimit() *clusterLimiter {
+	clusterLimitLock.RLock()
+	defer clusterLimitLock.RUnlock()
+	return clusterLimit
+}
+
+// acquireClusterConn 未开启时返回nil, true; Store不可用时放行
+func acquireClusterConn(ctx *dgctx.DgContext, bizKey string, bizId string) (*clusterConnLease, bool) {
+	l := getClusterLimit()
+	if l == nil {
+		return nil, true
+	}
+
+	return l.acquire(ctx, bizKey, bizId)
+}
+
+func (l *clusterLimiter) acquire(ctx *dgctx.DgContext, bizKey string, bizId string) (*clusterConnLease, bool) {
+	lease := &clusterConnLease{limiter: l, id: uuid.NewString()}
+	if l.opts.MaxConnections > 0 {
+		granted, ok := l.acquireGlobal(ctx)
+		if !ok {
+			return nil, false
+		}
+		lease.global = granted
+	}
+
+	if l.opts.MaxPerUser > 0 {
+		lease.user = l.user(ctx, bizKey, bizId)
+		ok, err := l.opts.Store.AcquireUser(innerContext(ctx), lease.user, lease.id, l.opts.MaxPerUser, l.opts.Ttl)
+		if err != nil {
+			dglogger.Warnf(ctx, "[%s: %s] acquire cluster user conn error: %v", bizKey, bizId, err)
+		} else if !ok {
+			if lease.global {
+				l.releaseGlobal(ctx)
+			}
+			return nil, false
+		}
+		l.lock.Lock()
+		l.users[lease.id] = lease.user
+		l.lock.Unlock()
+	}
+
+	return lease, true
+}
+
+func (l *clusterLimiter) user(ctx *dgctx.DgContext, bizKey string, bizId string) string {
+	if l.opts.UserKey != nil {
+		return l.opts.UserKey(ctx, bizKey, bizId)
+	}
+	if ctx.UserId > 0 {
+		return strconv.FormatInt(ctx.UserId, 10)
+	}
+	return bizKey + ":" + bizId
+}
+
+// acquireGlobal 优先使用本地预占的名额, 用完时再从Store预占Slack个, 返回是否占用了名额和是否放行;
+// 访问Store时不持有锁, 避免一次慢请求阻塞其他连接的准入; Store不可用时放行但不占用名额
+func (l *clusterLimiter) acquireGlobal(ctx *dgctx.DgContext) (bool, bool) {
+	if l.takeReserved() {
+		return true, true
+	}
+
+	granted, err := l.reserve(ctx, max(l.opts.Slack, 1))
+	if err != nil {
+		dglogger.Warnf(ctx, "reserve cluster conn error: %v", err)
+		return false, true
+	}
+	if granted <= 0 {
+		// 并发预占时其他连接可能已经补充了本地名额
+		ok := l.takeReserved()
+		return ok, ok
+	}
+
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		l.returnReserved(ctx, granted)
+		return false, true
+	}
+	l.reserved += granted
+	l.used++
+	l.lock.Unlock()
+
+	return true, true
+}
+
+func (l *clusterLimiter) takeReserved() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.closed && l.used < l.reserved {
+		l.used++
+		return true
+	}
+	return false
+}
+
+// releaseGlobal 本地空闲名额超过2*Slack时归还到只剩Slack个
+func (l *clusterLimiter) releaseGlobal(ctx *dgctx.DgContext) {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return
+	}
+	l.used--
+	var n int64
+	if idle := l.reserved - l.used; idle > 2*l.opts.Slack {
+		n = idle - l.opts.Slack
+		l.reserved -= n
+	}
+	l.lock.Unlock()
+
+	if n > 0 {
+		l.returnReserved(ctx, n)
+	}
+}
+
+// returnReserved 归还失败时名额留在本地, 之后的连接继续使用
+func (l *clusterLimiter) returnReserved(ctx *dgctx.DgContext, n int64) {
+	if _, err := l.reserve(ctx, -n); err != nil {
+		dglogger.Warnf(ctx, "return cluster conn error: %v", err)
+		l.lock.Lock()
+		if !l.closed {
+			l.reserved += n
+		}
+		l.lock.Unlock()
+	}
+}
+
+func (l *clusterLimiter) reserve(ctx *dgctx.DgContext, n int64) (int64, error) {
+	return l.opts.Store.Reserve(innerContext(ctx), l.nodeId, n, l.opts.MaxConnections, l.opts.Ttl)
+}
+
+func (lease *clusterConnLease) release(ctx *dgctx.DgContext) {
+	l := lease.limiter
+	if lease.global {
+		l.releaseGlobal(ctx)
+	}
+	if lease.user == "" {
+		return
+	}
+
+	l.lock.Lock()
+	delete(l.users, lease.id)
+	l.lock.Unlock()
+	if err := l.opts.Store.ReleaseUser(innerContext(ctx), lease.user, lease.id); err != nil {
+		dglogger.Warnf(ctx, "release cluster user conn error: %v", err)
+	}
+}
+
+// refresh 续期本实例预占的名额和存活连接的用户记录
+func (l *clusterLimiter) refresh() {
+	if l.opts.MaxConnections > 0 {
+		if _, err := l.reserve(l.ctx, 0); err != nil {
+			dglogger.Warnf(l.ctx, "refresh cluster conn limit error: %v", err)
+		}
+	}
+
+	l.lock.Lock()
+	users := make(map[string]string, len(l.users))
+	for id, user := range l.users {
+		users[id] = user
+	}
+	l.lock.Unlock()
+	if len(users) == 0 {
+		return
+	}
+
+	if err := l.opts.Store.RefreshUsers(innerContext(l.ctx), users, l.opts.Ttl); err != nil {
+		dglogger.Warnf(l.ctx, "refresh cluster user conn error: %v", err)
+	}
+}
+
+func (l *clusterLimiter) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.Ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.refresh()
+		}
+	}
+}
+
+func (l *clusterLimiter) close() {
+	close(l.stop)
+	<-l.done
+
+	l.lock.Lock()
+	l.closed = true
+	n := l.reserved
+	l.reserved, l.used = 0, 0
+	l.lock.Unlock()
+	if n > 0 {
+		if _, err := l.reserve(l.ctx, -n); err != nil {
+			dglogger.Warnf(l.ctx, "return cluster conn error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package dgws_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/redisstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startClusterLimit(t *testing.T, opts *dgws.ClusterConnLimitOptions) *redis.Client {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = rdb.Close()
+	})
+
+	opts.Store = redisstore.NewClusterLimitStore(rdb, "test:limit:")
+	if err := dgws.InitClusterConnLimit(&dgctx.DgContext{TraceId: uuid.NewString()}, opts); err != nil {
+		t.Fatalf("init cluster conn limit: %v", err)
+	}
+	t.Cleanup(dgws.CloseClusterConnLimit)
+
+	return rdb
+}
+
+func dialEventually(t *testing.T, url string) *websocket.Conn {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClusterConnLimitPerUser(t *testing.T) {
+	startClusterLimit(t, &dgws.ClusterConnLimitOptions{MaxPerUser: 1})
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	first, _, err := websocket.DefaultDialer.Dial(url+"?bizId=limit-a", nil)
+	if err != nil {
+		t.Fatalf("dial first: %v", err)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(url+"?bizId=limit-a", nil); err == nil {
+		t.Fatal("second connection of the same user should be rejected")
+	}
+	other, _, err := websocket.DefaultDialer.Dial(url+"?bizId=limit-b", nil)
+	if err != nil {
+		t.Fatalf("other user rejected: %v", err)
+	}
+	defer other.Close()
+
+	_ = first.Close()
+	dialEventually(t, url+"?bizId=limit-a").Close()
+}
+
+func TestClusterConnLimitGlobal(t *testing.T) {
+	rdb := startClusterLimit(t, &dgws.ClusterConnLimitOptions{MaxConnections: 3, Slack: 2})
+	bg := context.Background()
+	// 模拟其他实例已占用的名额
+	rdb.HSet(bg, "test:limit:{global}", "node-x", 2)
+	rdb.HSet(bg, "test:limit:{global}:expires", "node-x", strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10))
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	first, _, err := websocket.DefaultDialer.Dial(url+"?bizId=global-a", nil)
+	if err != nil {
+		t.Fatalf("dial first: %v", err)
+	}
+	defer first.Close()
+	if _, _, err := websocket.DefaultDialer.Dial(url+"?bizId=global-b", nil); err == nil {
+		t.Fatal("connection over the cluster limit should be rejected")
+	}
+
+	// 其他实例停止续期后, 其名额在过期后释放
+	rdb.HSet(bg, "test:limit:{global}:expires", "node-x", strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10))
+	second, _, err := websocket.DefaultDialer.Dial(url+"?bizId=global-b", nil)
+	if err != nil {
+		t.Fatalf("dial after expiry: %v", err)
+	}
+	defer second.Close()
+	if rdb.HExists(bg, "test:limit:{global}", "node-x").Val() {
+		t.Fatal("expired node reservation not cleaned")
+	}
+}
+
+// SSE与websocket路由共用集群配额
+func TestClusterConnLimitSSE(t *testing.T) {
+	startClusterLimit(t, &dgws.ClusterConnLimitOptions{MaxPerUser: 1})
+	engine := gin.New()
+	conf := &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+	}
+	rh := func(path string) *wrapper.RequestHolder[dgws.WebSocketMessage, error] {
+		return &wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+			RouterGroup: engine.Group(path),
+			NonLogin:    true,
+			BizHandler: func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+				return nil
+			},
+		}
+	}
+	dgws.Get(rh("/ws"), conf)
+	dgws.GetSSE(rh("/sse"), conf)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?bizId=limit-sse", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := http.Get(server.URL + "/sse?bizId=limit-sse")
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") == "text/event-stream" {
+		t.Fatal("sse stream over the cluster limit should be rejected")
+	}
+}
+
+func TestClusterConnLimitRedisUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+	if err := dgws.InitClusterConnLimit(&dgctx.DgContext{TraceId: uuid.NewString()}, &dgws.ClusterConnLimitOptions{
+		Store:          redisstore.NewClusterLimitStore(rdb, "test:unavailable:"),
+		MaxConnections: 4,
+		Slack:          2,
+	}); err != nil {
+		t.Fatalf("init cluster conn limit: %v", err)
+	}
+	defer dgws.CloseClusterConnLimit()
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	// Redis不可用时放行, 但不占用总数名额
+	server.Close()
+	down, _, err := websocket.DefaultDialer.Dial(url+"?bizId=unavailable-down", nil)
+	if err != nil {
+		t.Fatalf("connection should be admitted while redis is down: %v", err)
+	}
+	defer down.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatalf("restart redis: %v", err)
+	}
+
+	for i := range 4 {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=unavailable-"+strconv.Itoa(i), nil)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(url+"?bizId=unavailable-over", nil); err == nil {
+		t.Fatal("connection over the cluster limit should be rejected")
+	}
+}
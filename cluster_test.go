@@ -0,0 +1,88 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/redisbroker"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestClusterBroadcastViaRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitCluster(ctx, &dgws.ClusterOptions{Broker: redisbroker.New(rdb), ChannelPrefix: "test:"}); err != nil {
+		t.Fatalf("init cluster: %v", err)
+	}
+	defer dgws.CloseCluster()
+
+	observer := rdb.Subscribe(context.Background(), "test:broadcast")
+	defer observer.Close()
+	if _, err := observer.Receive(context.Background()); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=cluster-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "cluster-a", 1)
+
+	readText := func() string {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return string(data)
+	}
+
+	sent, err := dgws.BroadcastToBizIds("bizId", []string{"cluster-a"}, websocket.TextMessage, []byte("local"))
+	if err != nil || sent != 1 {
+		t.Fatalf("broadcast: %d, %v", sent, err)
+	}
+	if msg := readText(); msg != "local" {
+		t.Fatalf("expected local, got %s", msg)
+	}
+	select {
+	case published := <-observer.Channel():
+		msg := &dgws.ClusterMessage{}
+		if err := json.Unmarshal([]byte(published.Payload), msg); err != nil {
+			t.Fatalf("decode published: %v", err)
+		}
+		if string(msg.Data) != "local" || msg.BizKey != "bizId" || len(msg.BizIds) != 1 || msg.InstanceId == "" {
+			t.Fatalf("unexpected published message: %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("broadcast not published to redis")
+	}
+
+	// 模拟其他实例发出的广播
+	payload, _ := json.Marshal(&dgws.ClusterMessage{InstanceId: "other", BizKey: "bizId", BizIds: []string{"cluster-a"}, MessageType: websocket.TextMessage, Data: []byte("remote")})
+	if err := rdb.Publish(context.Background(), "test:broadcast", payload).Err(); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if msg := readText(); msg != "remote" {
+		t.Fatalf("expected remote, got %s", msg)
+	}
+
+	// 本实例发出的广播不会被重复投递
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("unexpected duplicate message: %s", string(data))
+	}
+}
@@ -0,0 +1,42 @@
+package dgws
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+)
+
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	MessageType() int
+}
+
+type JsonCodec struct{}
+
+func (JsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (JsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+var DefaultCodec Codec = JsonCodec{}
+
+// Decode 使用codec反序列化并按binding标签校验
+func Decode[T any](codec Codec, data []byte) (*T, error) {
+	t := new(T)
+	if err := codec.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	if err := binding.Validator.ValidateStruct(t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
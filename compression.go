@@ -0,0 +1,99 @@
+package dgws
+
+import (
+	"github.com/gorilla/websocket"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CompressionOptions permessage-deflate配置, gorilla/websocket只协商no_context_takeover,
+// 每条消息独立压缩, 不保留压缩上下文
+type CompressionOptions struct {
+	// Level flate压缩级别, 取值-2~9, 0使用默认级别1; 级别越高带宽越省、CPU越高
+	Level int
+	// Threshold 小于该字节数的消息不压缩, 小消息压缩收益低且浪费CPU
+	Threshold int
+}
+
+// CompressionStats 通过Connection.WriteMessage写出的消息统计, RawBytes/WireBytes为压缩比, WriteTime反映压缩消耗的CPU
+type CompressionStats struct {
+	Messages           int64
+	CompressedMessages int64
+	RawBytes           int64
+	WireBytes          int64
+	WriteTime          time.Duration
+}
+
+type compressionCounter struct {
+	messages           atomic.Int64
+	compressedMessages atomic.Int64
+	rawBytes           atomic.Int64
+	wireBytes          atomic.Int64
+	writeNanos         atomic.Int64
+}
+
+var compressionCounters sync.Map
+
+func getCompressionCounter(path string) *compressionCounter {
+	if v, ok := compressionCounters.Load(path); ok {
+		return v.(*compressionCounter)
+	}
+	v, _ := compressionCounters.LoadOrStore(path, &compressionCounter{})
+	return v.(*compressionCounter)
+}
+
+// GetCompressionStats 按路由返回开启压缩的连接的统计
+func GetCompressionStats() map[string]CompressionStats {
+	stats := make(map[string]CompressionStats)
+	compressionCounters.Range(func(key, value any) bool {
+		cc := value.(*compressionCounter)
+		stats[key.(string)] = CompressionStats{
+			Messages:           cc.messages.Load(),
+			CompressedMessages: cc.compressedMessages.Load(),
+			RawBytes:           cc.rawBytes.Load(),
+			WireBytes:          cc.wireBytes.Load(),
+			WriteTime:          time.Duration(cc.writeNanos.Load()),
+		}
+		return true
+	})
+
+	return stats
+}
+
+// connCompression 单个连接的压缩配置和统计, 未开启压缩时为nil
+type connCompression struct {
+	options *CompressionOptions
+	counter *compressionCounter
+	wire    *countingConn
+}
+
+func (cc *connCompression) write(conn *websocket.Conn, rawBytes int, write func() error) error {
+	compress := rawBytes >= cc.options.Threshold
+	conn.EnableWriteCompression(compress)
+
+	start := time.Now()
+	before := cc.wire.written.Load()
+	err := write()
+	cc.counter.writeNanos.Add(int64(time.Since(start)))
+	cc.counter.wireBytes.Add(cc.wire.written.Load() - before)
+	cc.counter.rawBytes.Add(int64(rawBytes))
+	cc.counter.messages.Add(1)
+	if compress {
+		cc.counter.compressedMessages.Add(1)
+	}
+
+	return err
+}
+
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
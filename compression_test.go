@@ -0,0 +1,49 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressionStats(t *testing.T) {
+	path := "/compression-" + uuid.NewString()
+	url := startTestServerAt(t, path, &dgws.WebSocketHandlerConfig{
+		Compression: &dgws.CompressionOptions{Level: 9, Threshold: 64},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return dgws.GetConnection(ctx).WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = true
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	payload := strings.Repeat("compressible ", 100)
+	for _, msg := range []string{payload, "small"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil || string(data) != msg {
+			t.Fatalf("unexpected echo: %v", err)
+		}
+	}
+
+	stats := dgws.GetCompressionStats()[path]
+	if stats.Messages != 2 || stats.CompressedMessages != 1 {
+		t.Fatalf("unexpected message counts: %+v", stats)
+	}
+	if stats.WireBytes == 0 || stats.WireBytes >= stats.RawBytes {
+		t.Fatalf("expected compressed wire bytes, got %+v", stats)
+	}
+}
@@ -0,0 +1,78 @@
+package dgws_test
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestGetConnContext(t *testing.T) {
+	causes := make(chan error, 2)
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		connCtx := dgws.GetConnContext(ctx)
+		switch string(wsm.MessageData) {
+		case "spawn":
+			go func() {
+				<-connCtx.Done()
+				causes <- context.Cause(connCtx)
+			}()
+			return wsm.Connection.WriteMessage(websocket.TextMessage, []byte("spawned"))
+		case "block":
+			// 阻塞到连接结束, 不能因等待处理中的消息而死锁
+			_ = wsm.Connection.WriteMessage(websocket.TextMessage, []byte("blocking"))
+			<-connCtx.Done()
+			causes <- context.Cause(connCtx)
+		}
+		return nil
+	})
+
+	expectCause := func() {
+		t.Helper()
+		select {
+		case err := <-causes:
+			if !errors.Is(err, dgws.ErrConnectionClosed) {
+				t.Fatalf("unexpected cause: %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("connection context not cancelled")
+		}
+	}
+	dial := func(msg string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=ctx", nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return conn
+	}
+
+	// 异常断开, 读循环出错退出
+	conn := dial("spawn")
+	_ = conn.Close()
+	expectCause()
+
+	// 正常关闭, 进入draining后等待处理中的消息
+	conn = dial("block")
+	defer conn.Close()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	expectCause()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected close reply, got %v", err)
+	}
+
+	if dgws.GetConnContext(dgctx.SimpleDgContext()) != context.Background() {
+		t.Fatal("expected background context without connection")
+	}
+}
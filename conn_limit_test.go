@@ -0,0 +1,42 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestConnLimitAcquireTimeout(t *testing.T) {
+	dgws.InitWsConnLimit(1)
+	t.Cleanup(func() {
+		dgws.InitWsConnLimit(100000)
+	})
+
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{AcquireTimeout: 2 * time.Second}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial first: %v", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() {
+		_ = first.Close()
+	})
+
+	second, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("queued connection rejected: %v", err)
+	}
+	defer second.Close()
+
+	noWait := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	if _, _, err := websocket.DefaultDialer.Dial(noWait, nil); err == nil {
+		t.Fatal("expected immediate rejection without acquire timeout")
+	}
+}
@@ -0,0 +1,138 @@
+package dgws
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+	"sync"
+	"sync/atomic"
+)
+
+const ConnStateKey = "WsConnState"
+
+// ConnState 单个连接的运行时状态, 每个DgContext只创建一次, 避免每条消息都查找字符串key和类型断言
+type ConnState struct {
+	conn atomic.Pointer[websocket.Conn]
+	// state ConnectionState, 取代原先的ended标记
+	state         atomic.Int32
+	onStateChange func(from ConnectionState, to ConnectionState)
+	waitGroup     atomic.Pointer[sync.WaitGroup]
+	connection    atomic.Pointer[Connection]
+	// pendingBytes 已读取但BizHandler尚未处理完的消息字节数
+	pendingBytes atomic.Int64
+	idempotency  atomic.Pointer[idempotencyState]
+	values       sync.Map
+	lock         sync.RWMutex
+	forwards     map[string]*forwardState
+	connCtx      atomic.Pointer[connContext]
+}
+
+type connContext struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+type forwardState struct {
+	conn      atomic.Pointer[websocket.Conn]
+	ended     atomic.Bool
+	timestamp atomic.Int64
+}
+
+var connStateLock sync.Mutex
+
+// GetConnState 获取ctx上的连接状态, 不存在时创建
+func GetConnState(ctx *dgctx.DgContext) *ConnState {
+	if state, ok := ctx.GetExtraValue(ConnStateKey).(*ConnState); ok {
+		return state
+	}
+
+	connStateLock.Lock()
+	defer connStateLock.Unlock()
+	if state, ok := ctx.GetExtraValue(ConnStateKey).(*ConnState); ok {
+		return state
+	}
+	state := &ConnState{}
+	ctx.SetExtraKeyValue(ConnStateKey, state)
+
+	return state
+}
+
+type messageContextKey struct{}
+
+// newMessageContext 复制连接的ctx供单条消息使用, 消息级的extra值写在副本上, ConnState仍与连接共享
+func newMessageContext(ctx *dgctx.DgContext) *dgctx.DgContext {
+	msgCtx := ctx.Clone()
+	// Clone不复制内部context
+	if inner := ctx.InnerContext(); inner != nil {
+		msgCtx.WithValue(inner, messageContextKey{}, true)
+	}
+	return msgCtx
+}
+
+func (s *ConnState) Conn() *websocket.Conn {
+	return s.conn.Load()
+}
+
+// Ended 连接进入draining或closed状态
+func (s *ConnState) Ended() bool {
+	return s.State() >= ConnectionStateDraining
+}
+
+func (s *ConnState) Connection() *Connection {
+	return s.connection.Load()
+}
+
+func (s *ConnState) PendingBytes() int64 {
+	return s.pendingBytes.Load()
+}
+
+// Context 连接结束时取消, 连接建立前为nil
+func (s *ConnState) Context() context.Context {
+	if cc := s.connCtx.Load(); cc != nil {
+		return cc.ctx
+	}
+	return nil
+}
+
+// initContext 返回的函数在请求处理返回时调用, 兜底取消
+func (s *ConnState) initContext(parent context.Context) func() {
+	ctx, cancel := context.WithCancelCause(parent)
+	s.connCtx.Store(&connContext{ctx: ctx, cancel: cancel})
+	return s.cancelContext
+}
+
+func (s *ConnState) cancelContext() {
+	if cc := s.connCtx.Load(); cc != nil {
+		cc.cancel(ErrConnectionClosed)
+	}
+}
+
+// GetConnContext 连接级的context, 连接进入draining状态或读循环退出时取消, context.Cause为ErrConnectionClosed;
+// BizHandler派生的goroutine应以它控制生命周期. ctx不对应连接时返回context.Background()
+func GetConnContext(ctx *dgctx.DgContext) context.Context {
+	if connCtx := GetConnState(ctx).Context(); connCtx != nil {
+		return connCtx
+	}
+	return context.Background()
+}
+
+func (s *ConnState) forward(forwardMark string, create bool) *forwardState {
+	s.lock.RLock()
+	fs := s.forwards[forwardMark]
+	s.lock.RUnlock()
+	if fs != nil || !create {
+		return fs
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if fs = s.forwards[forwardMark]; fs == nil {
+		if s.forwards == nil {
+			s.forwards = make(map[string]*forwardState)
+		}
+		fs = &forwardState{}
+		s.forwards[forwardMark] = fs
+	}
+
+	return fs
+}
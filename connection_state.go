@@ -0,0 +1,98 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+)
+
+// EnvelopeTypeState 开启NotifyClientState时服务端连接状态变化以该类型信封通知客户端, Data为状态名
+const EnvelopeTypeState = "state"
+
+// ConnectionState 服务端连接的生命周期状态, 只能按声明顺序向后迁移
+type ConnectionState int32
+
+const (
+	ConnectionStateConnecting ConnectionState = iota
+	// ConnectionStateResuming 恢复会话后正在重放未确认的消息
+	ConnectionStateResuming
+	ConnectionStateOpen
+	// ConnectionStateDraining 已决定结束连接, 不再读取新消息, 等待已读取的消息处理完
+	ConnectionStateDraining
+	ConnectionStateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionStateConnecting:
+		return "connecting"
+	case ConnectionStateResuming:
+		return "resuming"
+	case ConnectionStateOpen:
+		return "open"
+	case ConnectionStateDraining:
+		return "draining"
+	case ConnectionStateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+func parseConnectionState(name string) (ConnectionState, bool) {
+	for s := ConnectionStateConnecting; s <= ConnectionStateClosed; s++ {
+		if s.String() == name {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+type ConnectionStateChangeHandler func(ctx *dgctx.DgContext, from ConnectionState, to ConnectionState)
+
+// transition 迁移到更靠后的状态, 状态未变化或回退时返回false
+func (s *ConnState) transition(to ConnectionState) bool {
+	for {
+		from := ConnectionState(s.state.Load())
+		if to <= from {
+			return false
+		}
+		if s.state.CompareAndSwap(int32(from), int32(to)) {
+			if to >= ConnectionStateDraining {
+				s.cancelContext()
+			}
+			if s.onStateChange != nil {
+				s.onStateChange(from, to)
+			}
+			return true
+		}
+	}
+}
+
+func (s *ConnState) State() ConnectionState {
+	return ConnectionState(s.state.Load())
+}
+
+func GetConnectionState(ctx *dgctx.DgContext) ConnectionState {
+	return GetConnState(ctx).State()
+}
+
+func (c *Connection) State() ConnectionState {
+	return GetConnState(c.Ctx).State()
+}
+
+// writeState 连接关闭后无法再通知客户端, closed状态不发送
+func writeState(c *Connection, state ConnectionState) error {
+	if state == ConnectionStateClosed {
+		return nil
+	}
+
+	raw, _ := json.Marshal(state.String())
+	data, err := json.Marshal(&Envelope{Type: EnvelopeTypeState, Data: raw})
+	if err != nil {
+		return err
+	}
+
+	return c.WriteMessage(websocket.TextMessage, data)
+}
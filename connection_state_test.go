@@ -0,0 +1,145 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func readState(t *testing.T, conn *websocket.Conn) string {
+	env := readEnvelope(t, conn)
+	if env.Type != dgws.EnvelopeTypeState {
+		t.Fatalf("expected state envelope, got %s", env.Type)
+	}
+	var name string
+	if err := json.Unmarshal(env.Data, &name); err != nil {
+		t.Fatalf("unmarshal state: %v", err)
+	}
+
+	return name
+}
+
+func TestConnectionStateTransitions(t *testing.T) {
+	transitions := make(chan string, 10)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		NotifyClientState: true,
+		StateChangeHandler: func(_ *dgctx.DgContext, from dgws.ConnectionState, to dgws.ConnectionState) {
+			transitions <- from.String() + "->" + to.String()
+		},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "end" {
+			dgws.SetWsEnded(ctx)
+		}
+		if dgws.GetConnection(ctx).State() != dgws.GetConnectionState(ctx) {
+			t.Error("connection state mismatch")
+		}
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if state := readState(t, conn); state != "open" {
+		t.Fatalf("expected open, got %s", state)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("end")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if state := readState(t, conn); state != "draining" {
+		t.Fatalf("expected draining, got %s", state)
+	}
+	// 读循环在下一次读取后发现连接已结束
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("last"))
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("expected connection closed")
+	}
+
+	for _, want := range []string{"connecting->open", "open->draining", "draining->closed"} {
+		select {
+		case got := <-transitions:
+			if got != want {
+				t.Fatalf("expected %s, got %s", want, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("transition %s not observed", want)
+		}
+	}
+}
+
+func TestConnectionStateResuming(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Session:           &dgws.SessionOptions{GraceWindow: time.Second},
+		NotifyClientState: true,
+	}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	info := readSession(t, first)
+	if state := readState(t, first); state != "open" {
+		t.Fatalf("expected open, got %s", state)
+	}
+	_ = first.NetConn().Close()
+
+	header := http.Header{}
+	header.Set(dgws.ResumeTokenHeader, info.Token)
+	second, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	defer second.Close()
+	if !readSession(t, second).Resumed {
+		t.Fatal("session not resumed")
+	}
+	for _, want := range []string{"resuming", "open"} {
+		if state := readState(t, second); state != want {
+			t.Fatalf("expected %s, got %s", want, state)
+		}
+	}
+}
+
+func TestClientServerState(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{NotifyClientState: true}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	states := make(chan dgws.ConnectionState, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		ServerStateHandler: func(_ *dgctx.DgContext, state dgws.ConnectionState) {
+			states <- state
+		},
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			t.Errorf("state envelope leaked to message handler: %s", string(data))
+			return nil
+		},
+	})
+	client.Start()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	select {
+	case state := <-states:
+		if state != dgws.ConnectionStateOpen || client.ServerState() != dgws.ConnectionStateOpen {
+			t.Fatalf("expected open, got %s", state)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server state not received")
+	}
+
+	_ = client.Close()
+	if client.ServerState() != dgws.ConnectionStateClosed {
+		t.Fatalf("expected closed after detach, got %s", client.ServerState())
+	}
+}
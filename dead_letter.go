@@ -0,0 +1,120 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter 重试耗尽仍处理失败的消息
+type DeadLetter struct {
+	ConnectionId string    `json:"connectionId"`
+	BizKey       string    `json:"bizKey"`
+	BizId        string    `json:"bizId"`
+	UserId       int64     `json:"userId"`
+	TraceId      string    `json:"traceId"`
+	MessageType  int       `json:"messageType"`
+	MessageData  []byte    `json:"messageData"`
+	Attempts     int       `json:"attempts"`
+	Error        string    `json:"error"`
+	FailedAt     time.Time `json:"failedAt"`
+}
+
+// DeadLetterSink 死信的去向, 可基于回调、文件或消息队列(如Kafka producer)实现
+type DeadLetterSink interface {
+	Send(ctx *dgctx.DgContext, letter *DeadLetter) error
+}
+
+type DeadLetterFunc func(ctx *dgctx.DgContext, letter *DeadLetter) error
+
+func (f DeadLetterFunc) Send(ctx *dgctx.DgContext, letter *DeadLetter) error {
+	return f(ctx, letter)
+}
+
+// FileDeadLetterSink 以json lines追加写入文件
+type FileDeadLetterSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+func (s *FileDeadLetterSink) Send(_ *dgctx.DgContext, letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *FileDeadLetterSink) Name() string {
+	return s.file.Name()
+}
+
+func (s *FileDeadLetterSink) Close() error {
+	return s.file.Close()
+}
+
+// RetryOptions BizHandler返回错误时的重试策略, 共执行MaxAttempts次, 仍失败则交给DeadLetterSink
+type RetryOptions struct {
+	MaxAttempts    int
+	Backoff        time.Duration
+	DeadLetterSink DeadLetterSink
+}
+
+// withRetry 包装BizHandler, 重试期间同一连接的后续消息会等待, 以保持顺序
+func withRetry(opts *RetryOptions, bizKey string, bizId string, bizHandler wrapper.HandlerFunc[WebSocketMessage, error]) wrapper.HandlerFunc[WebSocketMessage, error] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) error {
+		attempts := max(opts.MaxAttempts, 1)
+		var err error
+		for i := 1; i <= attempts; i++ {
+			if err = bizHandler(c, ctx, wsm); err == nil {
+				return nil
+			}
+			if i < attempts {
+				dglogger.Warnf(ctx, "[%s: %s] biz handle message error, attempt %d/%d: %v", bizKey, bizId, i, attempts, err)
+				if opts.Backoff > 0 {
+					time.Sleep(opts.Backoff * time.Duration(i))
+				}
+			}
+		}
+
+		if opts.DeadLetterSink != nil {
+			letter := &DeadLetter{
+				BizKey:      bizKey,
+				BizId:       bizId,
+				UserId:      ctx.UserId,
+				TraceId:     ctx.TraceId,
+				MessageType: wsm.MessageType,
+				MessageData: wsm.CopyData(),
+				Attempts:    attempts,
+				Error:       err.Error(),
+				FailedAt:    time.Now(),
+			}
+			if connection := GetConnection(ctx); connection != nil {
+				letter.ConnectionId = connection.Id
+			}
+			if serr := opts.DeadLetterSink.Send(ctx, letter); serr != nil {
+				dglogger.Errorf(ctx, "[%s: %s] send dead letter error: %v", bizKey, bizId, serr)
+			}
+		}
+
+		return err
+	}
+}
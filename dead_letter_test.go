@@ -0,0 +1,79 @@
+package dgws_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	sink, err := dgws.NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letter.log"))
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+	defer sink.Close()
+
+	letters := make(chan *dgws.DeadLetter, 1)
+	var attempts atomic.Int32
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Retry: &dgws.RetryOptions{
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+			DeadLetterSink: dgws.DeadLetterFunc(func(ctx *dgctx.DgContext, letter *dgws.DeadLetter) error {
+				letters <- letter
+				return sink.Send(ctx, letter)
+			}),
+		},
+	}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "flaky" && attempts.Add(1) < 2 {
+			return errors.New("temporary error")
+		}
+		if string(wsm.MessageData) == "poison" {
+			return errors.New("permanent error")
+		}
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	for _, msg := range []string{"flaky", "poison"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	select {
+	case letter := <-letters:
+		if string(letter.MessageData) != "poison" || letter.Attempts != 3 || letter.Error != "permanent error" || letter.ConnectionId == "" {
+			t.Fatalf("unexpected dead letter: %+v", letter)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("dead letter not sent")
+	}
+
+	file, err := os.Open(sink.Name())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("dead letter file is empty")
+	}
+	letter := &dgws.DeadLetter{}
+	if err := json.Unmarshal(scanner.Bytes(), letter); err != nil || string(letter.MessageData) != "poison" {
+		t.Fatalf("unexpected file content: %s, %v", scanner.Text(), err)
+	}
+}
@@ -0,0 +1,182 @@
+package dgws
+
+import (
+	"database/sql"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultDedupTtl = 24 * time.Hour
+
+// DedupStore 入站消息去重, Begin在BizHandler执行前调用, 返回false表示该消息已处理或正在处理;
+// BizHandler成功后Complete, 失败后Abort以允许客户端重试
+type DedupStore interface {
+	Begin(ctx *dgctx.DgContext, key string, ttl time.Duration) (bool, error)
+	Complete(ctx *dgctx.DgContext, key string, ttl time.Duration) error
+	Abort(ctx *dgctx.DgContext, key string) error
+}
+
+// DedupOptions 带Id的客户端Envelope消息在Ttl内只会交给BizHandler处理一次, 使用持久化的Store时跨进程重启仍然有效;
+// 不带Id的消息和BatchHandler不受影响
+type DedupOptions struct {
+	Store DedupStore
+	Ttl   time.Duration
+}
+
+func (o *DedupOptions) ttl() time.Duration {
+	if o.Ttl > 0 {
+		return o.Ttl
+	}
+	return defaultDedupTtl
+}
+
+// dedupKey 消息id由客户端生成, 按业务和用户隔离
+func dedupKey(ctx *dgctx.DgContext, bizKey string, wsm *WebSocketMessage) string {
+	if wsm.MessageType != websocket.TextMessage {
+		return ""
+	}
+	env, ok := ParseEnvelope(wsm.MessageData)
+	if !ok || env.Id == "" {
+		return ""
+	}
+
+	return bizKey + ":" + strconv.FormatInt(ctx.UserId, 10) + ":" + env.Id
+}
+
+// MemoryDedupStore 进程内去重, 不能跨进程重启, 仅用于测试或单机场景
+type MemoryDedupStore struct {
+	lock      sync.Mutex
+	entries   map[string]time.Time
+	nextSweep int
+}
+
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{entries: make(map[string]time.Time)}
+}
+
+func (s *MemoryDedupStore) Begin(_ *dgctx.DgContext, key string, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := s.entries[key]; ok && expiresAt.After(now) {
+		return false, nil
+	}
+	s.entries[key] = now.Add(ttl)
+	s.sweep(now)
+
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Complete(_ *dgctx.DgContext, key string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryDedupStore) Abort(_ *dgctx.DgContext, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep 条目数增长到上次清理后的两倍时清理过期条目, 调用方需持有锁
+func (s *MemoryDedupStore) sweep(now time.Time) {
+	if len(s.entries) < max(s.nextSweep, 1024) {
+		return
+	}
+	for key, expiresAt := range s.entries {
+		if !expiresAt.After(now) {
+			delete(s.entries, key)
+		}
+	}
+	s.nextSweep = 2 * len(s.entries)
+}
+
+// SqlDedupStore 基于database/sql的去重存储, 只使用标准SQL, 默认占位符为?(MySQL、SQLite), 表结构:
+//
+//	CREATE TABLE ws_dedup (
+//	  dedup_key VARCHAR(255) NOT NULL PRIMARY KEY,
+//	  expires_at DATETIME(3) NOT NULL
+//	);
+//
+// 过期数据需由业务定期按expires_at清理
+type SqlDedupStore struct {
+	// Placeholder 占位符风格, 使用PostgreSQL时设置为SqlPlaceholderDollar
+	Placeholder SqlPlaceholder
+	db          *sql.DB
+	table       string
+}
+
+func NewSqlDedupStore(db *sql.DB, table string) *SqlDedupStore {
+	return &SqlDedupStore{db: db, table: table}
+}
+
+// Begin 插入失败时再查询一次, 已存在即为重复消息, 不依赖各数据库主键冲突的错误码
+func (s *SqlDedupStore) Begin(ctx *dgctx.DgContext, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	if _, err := s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("DELETE FROM "+s.table+" WHERE dedup_key = ? AND expires_at <= ?"), key, now); err != nil {
+		return false, err
+	}
+	_, err := s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("INSERT INTO "+s.table+" (dedup_key, expires_at) VALUES (?, ?)"), key, now.Add(ttl))
+	if err == nil {
+		return true, nil
+	}
+
+	var n int
+	if qerr := s.db.QueryRowContext(innerContext(ctx), s.Placeholder.rebind("SELECT COUNT(*) FROM "+s.table+" WHERE dedup_key = ?"), key).Scan(&n); qerr != nil || n == 0 {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (s *SqlDedupStore) Complete(ctx *dgctx.DgContext, key string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("UPDATE "+s.table+" SET expires_at = ? WHERE dedup_key = ?"), time.Now().Add(ttl), key)
+	return err
+}
+
+func (s *SqlDedupStore) Abort(ctx *dgctx.DgContext, key string) error {
+	_, err := s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("DELETE FROM "+s.table+" WHERE dedup_key = ?"), key)
+	return err
+}
+
+func handleDedupMessage(c *gin.Context, ctx *dgctx.DgContext, opts *DedupOptions, bizKey string, bizId string, wsm *WebSocketMessage, bizHandler wrapper.HandlerFunc[WebSocketMessage, error]) {
+	key := dedupKey(ctx, bizKey, wsm)
+	if key == "" {
+		if err := bizHandler(c, ctx, wsm); err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] biz handle message error: %v", bizKey, bizId, err)
+		}
+		return
+	}
+
+	// 去重存储不可用时不处理, 宁可让客户端重试也不重复执行
+	first, err := opts.Store.Begin(ctx, key, opts.ttl())
+	if err != nil {
+		dglogger.Errorf(ctx, "[%s: %s] dedup begin %s error: %v", bizKey, bizId, key, err)
+		return
+	}
+	if !first {
+		dglogger.Infof(ctx, "[%s: %s] skip duplicate message %s", bizKey, bizId, key)
+		return
+	}
+
+	if err := bizHandler(c, ctx, wsm); err != nil {
+		dglogger.Errorf(ctx, "[%s: %s] biz handle message error: %v", bizKey, bizId, err)
+		if err := opts.Store.Abort(ctx, key); err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] dedup abort %s error: %v", bizKey, bizId, key, err)
+		}
+		return
+	}
+	if err := opts.Store.Complete(ctx, key, opts.ttl()); err != nil {
+		dglogger.Errorf(ctx, "[%s: %s] dedup complete %s error: %v", bizKey, bizId, key, err)
+	}
+}
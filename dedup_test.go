@@ -0,0 +1,133 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestDedupInbound(t *testing.T) {
+	store := dgws.NewMemoryDedupStore()
+	handled := make(chan string, 10)
+	failOnce := true
+	bizHandler := func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		env, _ := dgws.ParseEnvelope(wsm.MessageData)
+		if env.Id == "fail" && failOnce {
+			failOnce = false
+			handled <- "fail-error"
+			return errors.New("downstream error")
+		}
+		handled <- env.Id
+		return nil
+	}
+
+	// 两个服务共享同一个去重存储, 模拟重启后客户端重发
+	send := func(url string, ids ...string) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		for _, id := range ids {
+			data, _ := json.Marshal(&dgws.Envelope{Type: dgws.EnvelopeTypeRequest, Id: id})
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+	}
+	conf := func() *dgws.WebSocketHandlerConfig {
+		return &dgws.WebSocketHandlerConfig{Dedup: &dgws.DedupOptions{Store: store, Ttl: time.Minute}}
+	}
+
+	var got []string
+	waitHandled := func(n int) {
+		for len(got) < n {
+			select {
+			case id := <-handled:
+				got = append(got, id)
+			case <-time.After(3 * time.Second):
+				t.Fatalf("handled messages: %v", got)
+			}
+		}
+	}
+
+	send(startTestServerWithConfig(t, conf(), bizHandler), "pay-1", "pay-1", "fail")
+	waitHandled(2)
+	// 等待失败消息的Abort完成
+	time.Sleep(50 * time.Millisecond)
+	send(startTestServerWithConfig(t, conf(), bizHandler), "pay-1", "fail")
+	waitHandled(3)
+	select {
+	case id := <-handled:
+		t.Fatalf("duplicate message handled: %s, before: %v", id, got)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if got[0] != "pay-1" || got[1] != "fail-error" || got[2] != "fail" {
+		t.Fatalf("unexpected handled order: %v", got)
+	}
+}
+
+func TestSqlDedupStore(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := dgws.NewSqlDedupStore(db, "ws_dedup")
+	expectBegin := func(insertErr error) {
+		mock.ExpectExec("DELETE FROM ws_dedup WHERE dedup_key = ? AND expires_at <= ?").
+			WithArgs("k1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		insert := mock.ExpectExec("INSERT INTO ws_dedup (dedup_key, expires_at) VALUES (?, ?)").WithArgs("k1", sqlmock.AnyArg())
+		if insertErr == nil {
+			insert.WillReturnResult(sqlmock.NewResult(0, 1))
+		} else {
+			insert.WillReturnError(insertErr)
+		}
+	}
+
+	expectBegin(nil)
+	if first, err := store.Begin(nil, "k1", time.Minute); err != nil || !first {
+		t.Fatalf("first begin: %v, %v", first, err)
+	}
+
+	// 主键冲突的错误因驱动而异, 插入失败后查到已存在即为重复
+	expectBegin(errors.New("duplicate entry"))
+	mock.ExpectQuery("SELECT COUNT(*) FROM ws_dedup WHERE dedup_key = ?").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	if first, err := store.Begin(nil, "k1", time.Minute); err != nil || first {
+		t.Fatalf("duplicate begin: %v, %v", first, err)
+	}
+
+	// 不是冲突导致的插入失败返回错误
+	expectBegin(errors.New("connection reset"))
+	mock.ExpectQuery("SELECT COUNT(*) FROM ws_dedup WHERE dedup_key = ?").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if first, err := store.Begin(nil, "k1", time.Minute); err == nil || first {
+		t.Fatalf("expected begin error, got %v, %v", first, err)
+	}
+
+	mock.ExpectExec("UPDATE ws_dedup SET expires_at = ? WHERE dedup_key = ?").
+		WithArgs(sqlmock.AnyArg(), "k1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Complete(nil, "k1", time.Minute); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	mock.ExpectExec("DELETE FROM ws_dedup WHERE dedup_key = ?").
+		WithArgs("k1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Abort(nil, "k1"); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package dgws
+
+import (
+	"sync"
+	"time"
+)
+
+type Defaults struct {
+	PongWait       time.Duration
+	WriteWait      time.Duration
+	PingPeriod     time.Duration
+	MaxPingPeriod  time.Duration
+	UpgradeTimeout time.Duration
+	MaxMessageSize int64
+	// AcquireTimeout 连接数达到InitWsConnLimit上限时排队等待的最长时间, 0表示立即拒绝
+	AcquireTimeout time.Duration
+	// MaxPendingBytes 单连接已读取但未处理完的消息总字节数上限, 超过后关闭连接, 0表示不限制
+	MaxPendingBytes int64
+}
+
+var (
+	defaults     Defaults
+	defaultsLock sync.RWMutex
+)
+
+// SetDefaults 设置全局默认值, WebSocketHandlerConfig中未设置(零值)的字段使用这里的值
+func SetDefaults(d Defaults) {
+	defaultsLock.Lock()
+	defer defaultsLock.Unlock()
+	defaults = d
+}
+
+func GetDefaults() Defaults {
+	defaultsLock.RLock()
+	defer defaultsLock.RUnlock()
+	return defaults
+}
+
+func (conf *WebSocketHandlerConfig) resolveDefaults() Defaults {
+	d := GetDefaults()
+	if conf.PongWait > 0 {
+		d.PongWait = conf.PongWait
+	}
+	if conf.WriteWait > 0 {
+		d.WriteWait = conf.WriteWait
+	}
+	if conf.PingPeriod > 0 {
+		d.PingPeriod = conf.PingPeriod
+	}
+	if conf.MaxPingPeriod > 0 {
+		d.MaxPingPeriod = conf.MaxPingPeriod
+	}
+	if conf.UpgradeTimeout > 0 {
+		d.UpgradeTimeout = conf.UpgradeTimeout
+	}
+	if conf.MaxMessageSize > 0 {
+		d.MaxMessageSize = conf.MaxMessageSize
+	}
+	if conf.AcquireTimeout > 0 {
+		d.AcquireTimeout = conf.AcquireTimeout
+	}
+	if conf.MaxPendingBytes > 0 {
+		d.MaxPendingBytes = conf.MaxPendingBytes
+	}
+
+	return d
+}
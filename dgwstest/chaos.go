@@ -0,0 +1,175 @@
+package dgwstest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const pongOpcode = 0xa
+
+// ChaosOptions 作用于连接写出的数据, 概率取值[0, 1]; 握手完成前的http报文不受影响, 保证升级本身能够成功
+type ChaosOptions struct {
+	// Seed 随机数种子, 相同的种子和相同的写入序列得到相同的故障, 便于复现
+	Seed int64
+	// DisconnectRate 每次写入前直接断开连接的概率
+	DisconnectRate float64
+	// TruncateRate 每次写入只写出一部分就断开的概率, 对端读到不完整的帧
+	TruncateRate float64
+	// WriteDelay 每次写入前随机延迟[0, WriteDelay)
+	WriteDelay time.Duration
+	// DropPongs 丢弃写出的pong帧, 对端的PongWait和MaxMissedPongs随之触发
+	DropPongs bool
+}
+
+// ChaosConn 按opts向conn注入故障, 可用于websocket.Dialer.NetDialContext等任意位置; opts为nil时原样返回
+func ChaosConn(conn net.Conn, opts *ChaosOptions) net.Conn {
+	if opts == nil {
+		return conn
+	}
+	return &chaosConn{Conn: conn, opts: *opts, rand: rand.New(rand.NewSource(opts.Seed))}
+}
+
+type chaosConn struct {
+	net.Conn
+	opts ChaosOptions
+
+	lock sync.Mutex
+	rand *rand.Rand
+	// upgraded 已写出http报文的结尾, 之后的数据都是websocket帧
+	upgraded bool
+	tail     []byte
+	frames   frameFilter
+}
+
+func (c *chaosConn) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.upgraded {
+		return c.writeHandshake(p)
+	}
+	return c.writeFrames(p)
+}
+
+// writeFrames 需持有lock
+func (c *chaosConn) writeFrames(p []byte) (int, error) {
+	if c.opts.WriteDelay > 0 {
+		time.Sleep(time.Duration(c.rand.Int63n(int64(c.opts.WriteDelay))))
+	}
+	if c.hit(c.opts.DisconnectRate) {
+		_ = c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+
+	out := p
+	if c.opts.DropPongs {
+		out = c.frames.filter(p)
+	}
+	if c.hit(c.opts.TruncateRate) && len(out) > 0 {
+		_, _ = c.Conn.Write(out[:c.rand.Intn(len(out))])
+		_ = c.Conn.Close()
+		return len(p), nil
+	}
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeHandshake 需持有lock, 原样写出, 同时查找http报文的结尾, 其后的部分按websocket帧处理
+func (c *chaosConn) writeHandshake(p []byte) (int, error) {
+	scan := append(c.tail, p...)
+	end := bytes.Index(scan, []byte("\r\n\r\n"))
+	if end < 0 {
+		c.tail = append([]byte(nil), scan[max(0, len(scan)-3):]...)
+		return c.Conn.Write(p)
+	}
+
+	split := end + 4 - len(c.tail)
+	if _, err := c.Conn.Write(p[:split]); err != nil {
+		return 0, err
+	}
+	c.upgraded = true
+	c.tail = nil
+	if split == len(p) {
+		return len(p), nil
+	}
+	if _, err := c.writeFrames(p[split:]); err != nil {
+		return split, err
+	}
+	return len(p), nil
+}
+
+func (c *chaosConn) hit(rate float64) bool {
+	return rate > 0 && c.rand.Float64() < rate
+}
+
+// frameFilter 跟踪写出流中的帧边界, 去掉pong帧; 帧头可能跨多次写入, 凑齐后才决定是否丢弃
+type frameFilter struct {
+	header    []byte
+	remaining uint64
+	dropping  bool
+}
+
+func (f *frameFilter) filter(p []byte) []byte {
+	var out []byte
+	for len(p) > 0 {
+		if f.remaining > 0 {
+			n := min(uint64(len(p)), f.remaining)
+			if !f.dropping {
+				out = append(out, p[:n]...)
+			}
+			p = p[n:]
+			f.remaining -= n
+			continue
+		}
+
+		f.header = append(f.header, p[0])
+		p = p[1:]
+		size, ok := headerSize(f.header)
+		if !ok || len(f.header) < size {
+			continue
+		}
+		f.remaining = payloadLength(f.header)
+		f.dropping = f.header[0]&0x0f == pongOpcode
+		if !f.dropping {
+			out = append(out, f.header...)
+		}
+		f.header = f.header[:0]
+	}
+	return out
+}
+
+// headerSize 至少有两个字节时才能确定帧头长度
+func headerSize(header []byte) (int, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+	size := 2
+	switch header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, true
+}
+
+func payloadLength(header []byte) uint64 {
+	switch n := header[1] & 0x7f; n {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(header[2:10])
+	default:
+		return uint64(n)
+	}
+}
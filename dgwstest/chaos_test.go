@@ -0,0 +1,44 @@
+package dgwstest_test
+
+import (
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestChaosDropPongs(t *testing.T) {
+	clock, route := startPingRoute(t)
+	route.SetChaos(nil, &dgwstest.ChaosOptions{DropPongs: true})
+	conn := route.MustDial(t, "no-pong")
+
+	// 与TestFakeClockKeepsAliveWithPongs相同的步骤, 但客户端回复的pong被丢弃
+	clock.BlockUntil(2)
+	for i := 0; i < 2; i++ {
+		clock.Advance(10 * time.Second)
+		clock.BlockUntil(2)
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("sync"))
+		dgwstest.ExpectText(t, conn, "sync", time.Second)
+	}
+	clock.Advance(10 * time.Second)
+	dgwstest.ExpectClose(t, conn, websocket.CloseAbnormalClosure)
+}
+
+func TestChaosTruncate(t *testing.T) {
+	_, route := startPingRoute(t)
+	route.SetChaos(&dgwstest.ChaosOptions{TruncateRate: 1}, nil)
+	conn := route.MustDial(t, "truncated")
+
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	dgwstest.ExpectClose(t, conn, websocket.CloseAbnormalClosure)
+}
+
+func TestChaosDisconnect(t *testing.T) {
+	_, route := startPingRoute(t)
+	route.SetChaos(nil, &dgwstest.ChaosOptions{DisconnectRate: 1})
+	conn := route.MustDial(t, "disconnected")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err == nil {
+		t.Fatal("expected write error")
+	}
+}
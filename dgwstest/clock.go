@@ -0,0 +1,170 @@
+package dgwstest
+
+import (
+	dgws "github.com/darwinOrg/go-websocket"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock 只有调用Advance时才前进的时钟, 到期的timer和ticker在Advance中按时间顺序触发
+type FakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	notify chan struct{}
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, notify: make(chan struct{})}
+}
+
+// UseFakeClock 以当前时间创建FakeClock并通过dgws.SetClock注入, 测试结束时恢复为真实时钟
+func UseFakeClock(t testing.TB) *FakeClock {
+	c := NewFakeClock(time.Now())
+	dgws.SetClock(c)
+	t.Cleanup(func() {
+		dgws.SetClock(nil)
+	})
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) dgws.Timer {
+	return c.add(d, 0, nil)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) dgws.Ticker {
+	return fakeTicker{c.add(d, d, nil)}
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) dgws.Timer {
+	return c.add(d, 0, f)
+}
+
+// Advance 前进d, 期间到期的AfterFunc在新的goroutine中执行, 与time.AfterFunc一致
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	target := c.now.Add(d)
+	for {
+		next := c.nextDue(target)
+		if next == nil {
+			break
+		}
+		c.now = next.when
+		next.fire()
+	}
+	c.now = target
+}
+
+// BlockUntil 等待至少n个timer或ticker处于等待状态, 用于确认被测代码已开始计时后再Advance
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.lock.Lock()
+		count := len(c.timers)
+		notify := c.notify
+		c.lock.Unlock()
+		if count >= n {
+			return
+		}
+		<-notify
+	}
+}
+
+func (c *FakeClock) add(d time.Duration, period time.Duration, f func()) *fakeTimer {
+	t := &fakeTimer{clock: c, period: period, fn: f}
+	if f == nil {
+		t.c = make(chan time.Time, 1)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t.when = c.now.Add(d)
+	c.schedule(t)
+	return t
+}
+
+// nextDue 需持有lock
+func (c *FakeClock) nextDue(target time.Time) *fakeTimer {
+	var due *fakeTimer
+	for _, t := range c.timers {
+		if !t.when.After(target) && (due == nil || t.when.Before(due.when)) {
+			due = t
+		}
+	}
+	return due
+}
+
+// schedule 需持有lock
+func (c *FakeClock) schedule(t *fakeTimer) {
+	c.timers = append(c.timers, t)
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+// unschedule 需持有lock
+func (c *FakeClock) unschedule(t *fakeTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+	fn     func()
+}
+
+// fire 需持有clock.lock
+func (t *fakeTimer) fire() {
+	t.clock.unschedule(t)
+	if t.fn != nil {
+		go t.fn()
+	} else {
+		select {
+		case t.c <- t.when:
+		default:
+		}
+	}
+	if t.period > 0 {
+		t.when = t.when.Add(t.period)
+		t.clock.schedule(t)
+	}
+}
+
+func (t *fakeTimer) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.clock.unschedule(t)
+	t.when = t.clock.now.Add(d)
+	t.clock.schedule(t)
+	return active
+}
+
+// fakeTicker fakeTimer的Stop有返回值, 包装后满足dgws.Ticker
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
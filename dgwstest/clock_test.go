@@ -0,0 +1,56 @@
+package dgwstest_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func startPingRoute(t *testing.T) (*dgwstest.FakeClock, *dgwstest.PipeRoute) {
+	clock := dgwstest.UseFakeClock(t)
+	route := dgwstest.NewPipeRoute(t, &dgws.WebSocketHandlerConfig{
+		PingPeriod: 10 * time.Second,
+		PongWait:   30 * time.Second,
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return dgws.GetConn(ctx).WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+	return clock, route
+}
+
+func TestFakeClockKeepsAliveWithPongs(t *testing.T) {
+	clock, route := startPingRoute(t)
+	conn := route.MustDial(t, "alive")
+	pings := 0
+	conn.SetPingHandler(func(data string) error {
+		pings++
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	// ping调度的timer和PongWait的timer
+	clock.BlockUntil(2)
+	// 快进一分钟, 每10秒一次ping都及时回复了pong
+	for i := 1; i <= 6; i++ {
+		clock.Advance(10 * time.Second)
+		// ping发出后才会重新计时
+		clock.BlockUntil(2)
+		// 服务端按顺序处理帧, 收到回显时之前的pong已处理; 读取回显的同时处理ping
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("sync"))
+		dgwstest.ExpectText(t, conn, "sync", time.Second)
+		if pings != i {
+			t.Fatalf("expect %d pings, got %d", i, pings)
+		}
+	}
+}
+
+func TestFakeClockClosesWithoutPongs(t *testing.T) {
+	clock, route := startPingRoute(t)
+	conn := route.MustDial(t, "dead")
+
+	clock.BlockUntil(2)
+	clock.Advance(31 * time.Second)
+	dgwstest.ExpectClose(t, conn, websocket.CloseAbnormalClosure)
+}
@@ -0,0 +1,75 @@
+package dgwstest
+
+import (
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+)
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// NewConnPair 返回经过真实握手、相互连接的两个websocket.Conn, 数据只在内存中传递
+func NewConnPair() (server *websocket.Conn, client *websocket.Conn, err error) {
+	listener := NewPipeListener()
+	defer listener.Close()
+
+	servers := make(chan *websocket.Conn, 1)
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			servers <- conn
+		}
+	})}
+	go func() {
+		_ = httpServer.Serve(listener)
+	}()
+
+	dialer := &websocket.Dialer{NetDialContext: listener.DialContext}
+	client, _, err = dialer.Dial("ws://pipe/", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return <-servers, client, nil
+}
+
+// PipeRoute 在内存中运行dgws.Get注册的路由, 不启动端口也不需要sleep等待监听, 测试结束时自动关闭
+type PipeRoute struct {
+	*RouteDialer
+	Engine   *gin.Engine
+	listener *PipeListener
+}
+
+// NewPipeRoute conf未设置BizKey时为bizId, 未设置GetBizIdHandler时从同名url参数中读取
+func NewPipeRoute(t testing.TB, conf *dgws.WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) *PipeRoute {
+	t.Helper()
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	bizKey := registerRoute(engine, conf, bizHandler)
+
+	listener := NewPipeListener()
+	httpServer := &http.Server{Handler: engine}
+	go func() {
+		_ = httpServer.Serve(listener)
+	}()
+	route := &PipeRoute{
+		RouteDialer: newRouteDialer("ws://pipe", bizKey, &websocket.Dialer{NetDialContext: listener.DialContext}),
+		Engine:      engine,
+		listener:    listener,
+	}
+	t.Cleanup(route.Close)
+
+	return route
+}
+
+// SetChaos 见PipeListener.SetChaos
+func (r *PipeRoute) SetChaos(server *ChaosOptions, client *ChaosOptions) {
+	r.listener.SetChaos(server, client)
+}
+
+// Close 只停止接受新连接, 已建立的连接由各自的读循环结束
+func (r *PipeRoute) Close() {
+	_ = r.listener.Close()
+}
@@ -0,0 +1,76 @@
+package dgwstest_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestNewConnPair(t *testing.T) {
+	server, client, err := dgwstest.NewConnPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	defer client.Close()
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	mt, data, err := server.ReadMessage()
+	if err != nil || mt != websocket.TextMessage || string(data) != "ping" {
+		t.Fatalf("server read: %d %q %v", mt, data, err)
+	}
+
+	if err := server.WriteMessage(websocket.BinaryMessage, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	mt, data, err = client.ReadMessage()
+	if err != nil || mt != websocket.BinaryMessage || len(data) != 2 {
+		t.Fatalf("client read: %d %v %v", mt, data, err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Fatal("expected read deadline error")
+	}
+}
+
+func TestPipeRoute(t *testing.T) {
+	ended := make(chan struct{})
+	received := make(chan string, 4)
+	route := dgwstest.NewPipeRoute(t, &dgws.WebSocketHandlerConfig{
+		IsEndedHandler: func(_ *dgctx.DgContext, _ int, data []byte) bool {
+			return string(data) == "bye"
+		},
+		EndCallbackHandler: func(*dgctx.DgContext, *websocket.Conn) error {
+			close(ended)
+			return nil
+		},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		received <- string(wsm.MessageData)
+		return dgws.GetConn(ctx).WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	conn := route.MustDial(t, "pipe-1")
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "hello" {
+		t.Fatalf("biz handler got %q", got)
+	}
+	dgwstest.ExpectText(t, conn, "hello", time.Second)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("end callback not called")
+	}
+}
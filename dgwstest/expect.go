@@ -0,0 +1,98 @@
+package dgwstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"testing"
+	"time"
+)
+
+// DefaultExpectTimeout ExpectClose等待close帧的时间
+const DefaultExpectTimeout = 5 * time.Second
+
+const maxPreviewBytes = 256
+
+// ExpectMessage 在timeout内读取下一条消息, 超时或连接关闭时终止测试; 超时后conn不可再读
+func ExpectMessage(t testing.TB, conn *websocket.Conn, timeout time.Duration) (int, []byte) {
+	t.Helper()
+	mt, data, err := readWithin(conn, timeout)
+	if err != nil {
+		t.Fatalf("expect message: %s", describeReadError(err, timeout))
+	}
+	return mt, data
+}
+
+// ExpectText 读取下一条消息并断言为内容等于want的文本消息
+func ExpectText(t testing.TB, conn *websocket.Conn, want string, timeout time.Duration) {
+	t.Helper()
+	mt, data := ExpectMessage(t, conn, timeout)
+	if mt != websocket.TextMessage || string(data) != want {
+		t.Fatalf("expect text %q, got %s", want, describeMessage(mt, data))
+	}
+}
+
+// ExpectJSON 读取下一条消息并解析为T, 解析失败时输出原始内容
+func ExpectJSON[T any](t testing.TB, conn *websocket.Conn, timeout time.Duration) T {
+	t.Helper()
+	var v T
+	mt, data := ExpectMessage(t, conn, timeout)
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("expect json %T: %v, got %s", v, err, describeMessage(mt, data))
+	}
+	return v
+}
+
+// ExpectClose 在DefaultExpectTimeout内等待对端以code关闭连接, 期间收到的数据消息视为失败
+func ExpectClose(t testing.TB, conn *websocket.Conn, code int) {
+	t.Helper()
+	mt, data, err := readWithin(conn, DefaultExpectTimeout)
+	if err == nil {
+		t.Fatalf("expect close %d, got %s", code, describeMessage(mt, data))
+	}
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expect close %d: %s", code, describeReadError(err, DefaultExpectTimeout))
+	}
+	if ce.Code != code {
+		t.Fatalf("expect close %d, got close %d (%q)", code, ce.Code, ce.Text)
+	}
+}
+
+func readWithin(conn *websocket.Conn, timeout time.Duration) (int, []byte, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	mt, data, err := conn.ReadMessage()
+	if err == nil {
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+	return mt, data, err
+}
+
+func describeReadError(err error, timeout time.Duration) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "no message within " + timeout.String()
+	}
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return "connection closed: " + ce.Error()
+	}
+	return err.Error()
+}
+
+func describeMessage(mt int, data []byte) string {
+	switch mt {
+	case websocket.TextMessage:
+		preview := string(data)
+		if len(preview) > maxPreviewBytes {
+			preview = preview[:maxPreviewBytes] + "..."
+		}
+		return fmt.Sprintf("text message %q", preview)
+	case websocket.BinaryMessage:
+		return fmt.Sprintf("binary message of %d bytes", len(data))
+	default:
+		return fmt.Sprintf("message of type %d", mt)
+	}
+}
@@ -0,0 +1,67 @@
+package dgwstest_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gorilla/websocket"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func TestExpectHelpers(t *testing.T) {
+	server, client, err := dgwstest.NewConnPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	data, _ := json.Marshal(greeting{Name: "dgws"})
+	_ = server.WriteMessage(websocket.TextMessage, data)
+	_ = server.WriteMessage(websocket.TextMessage, []byte("plain"))
+	_ = server.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "bye"))
+
+	if g := dgwstest.ExpectJSON[greeting](t, client, time.Second); g.Name != "dgws" {
+		t.Fatalf("unexpected greeting: %+v", g)
+	}
+	dgwstest.ExpectText(t, client, "plain", time.Second)
+	dgwstest.ExpectClose(t, client, websocket.ClosePolicyViolation)
+}
+
+func TestExpectMessageTimeout(t *testing.T) {
+	server, client, err := dgwstest.NewConnPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	defer client.Close()
+
+	rt := &recordingT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dgwstest.ExpectMessage(rt, client, 20*time.Millisecond)
+	}()
+	<-done
+	if rt.failure != "expect message: no message within 20ms" {
+		t.Fatalf("unexpected failure: %q", rt.failure)
+	}
+}
+
+// recordingT 记录Fatalf的内容而不终止外层测试
+type recordingT struct {
+	testing.TB
+	failure string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Fatalf(format string, args ...any) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
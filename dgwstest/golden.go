@@ -0,0 +1,207 @@
+package dgwstest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gorilla/websocket"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// UpdateGoldenEnv 设置为非空时AssertGolden用本次的输出重写golden文件而不是比较
+const UpdateGoldenEnv = "DGWS_UPDATE_GOLDEN"
+
+const defaultGoldenIdle = 200 * time.Millisecond
+
+// GoldenFrame golden文件中的一行, 只保留与协议有关的字段, 文本消息以原文保存以便diff
+type GoldenFrame struct {
+	Direction   string `json:"dir"`
+	MessageType int    `json:"type"`
+	Text        string `json:"text,omitempty"`
+	Base64      string `json:"base64,omitempty"`
+}
+
+func newGoldenFrame(direction string, mt int, data []byte) *GoldenFrame {
+	f := &GoldenFrame{Direction: direction, MessageType: mt}
+	if mt == websocket.TextMessage {
+		f.Text = string(data)
+	} else {
+		f.Base64 = base64.StdEncoding.EncodeToString(data)
+	}
+	return f
+}
+
+func (f *GoldenFrame) data() ([]byte, error) {
+	if f.MessageType == websocket.TextMessage {
+		return []byte(f.Text), nil
+	}
+	return base64.StdEncoding.DecodeString(f.Base64)
+}
+
+func (f *GoldenFrame) String() string {
+	if f.MessageType == websocket.TextMessage {
+		return fmt.Sprintf("%s text %q", f.Direction, f.Text)
+	}
+	return fmt.Sprintf("%s binary %s", f.Direction, f.Base64)
+}
+
+// GoldenFromJournal 把同一连接的记录转为golden帧, 截断过的帧无法用于比较, 返回错误
+func GoldenFromJournal(frames []*dgws.JournalFrame) ([]*GoldenFrame, error) {
+	golden := make([]*GoldenFrame, 0, len(frames))
+	for _, frame := range frames {
+		if frame.Truncated {
+			return nil, fmt.Errorf("journal frame of %s at %v is truncated", frame.ConnectionId, frame.At)
+		}
+		golden = append(golden, newGoldenFrame(frame.Direction, frame.MessageType, frame.Data))
+	}
+	return golden, nil
+}
+
+// WriteGolden 每行一帧
+func WriteGolden(path string, frames []*GoldenFrame) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for _, frame := range frames {
+		if err := encoder.Encode(frame); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+func ReadGolden(path string) ([]*GoldenFrame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var frames []*GoldenFrame
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		frame := &GoldenFrame{}
+		if err := json.Unmarshal(line, frame); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, scanner.Err()
+}
+
+// GoldenRecorder 作为WebSocketHandlerConfig.Journal的Sink, 录制手工或集成测试中的会话后用Save保存
+type GoldenRecorder struct {
+	lock   sync.Mutex
+	frames []*dgws.JournalFrame
+}
+
+func (r *GoldenRecorder) Record(_ *dgctx.DgContext, frame *dgws.JournalFrame) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.frames = append(r.frames, frame)
+	return nil
+}
+
+// Save 保存最先建立的连接的所有帧
+func (r *GoldenRecorder) Save(path string) error {
+	r.lock.Lock()
+	var frames []*dgws.JournalFrame
+	for _, frame := range r.frames {
+		if frame.ConnectionId == r.frames[0].ConnectionId {
+			frames = append(frames, frame)
+		}
+	}
+	r.lock.Unlock()
+
+	golden, err := GoldenFromJournal(frames)
+	if err != nil {
+		return err
+	}
+	return WriteGolden(path, golden)
+}
+
+// AssertGolden 通过dgws.ReplayJournal把golden文件中客户端发出的帧重放给bizHandler, 断言服务端发出的帧与文件一致;
+// 文件中可以只有in帧, 设置UpdateGoldenEnv后运行一次即生成期望的out帧. idle为0时取200ms
+func AssertGolden(t testing.TB, path string, conf *dgws.WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error], idle time.Duration) {
+	t.Helper()
+	golden, err := ReadGolden(path)
+	if err != nil {
+		t.Fatalf("read golden %s: %v", path, err)
+	}
+	if idle <= 0 {
+		idle = defaultGoldenIdle
+	}
+	if conf == nil {
+		conf = &dgws.WebSocketHandlerConfig{}
+	}
+
+	var inputs []*dgws.JournalFrame
+	var want []*GoldenFrame
+	for i, frame := range golden {
+		if frame.Direction != dgws.JournalDirectionIn {
+			want = append(want, frame)
+			continue
+		}
+		data, err := frame.data()
+		if err != nil {
+			t.Fatalf("golden %s line %d: %v", path, i+1, err)
+		}
+		inputs = append(inputs, &dgws.JournalFrame{Direction: dgws.JournalDirectionIn, MessageType: frame.MessageType, Data: data})
+	}
+
+	outputs, err := dgws.ReplayJournal(inputs, conf, bizHandler, idle)
+	if err != nil {
+		t.Fatalf("replay golden %s: %v", path, err)
+	}
+	got := make([]*GoldenFrame, 0, len(outputs))
+	for _, frame := range outputs {
+		got = append(got, newGoldenFrame(dgws.JournalDirectionOut, frame.MessageType, frame.Data))
+	}
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		// 重放时无法得知输出与输入的交错关系, 按先输入后输出写回
+		updated := make([]*GoldenFrame, 0, len(inputs)+len(got))
+		for _, frame := range golden {
+			if frame.Direction == dgws.JournalDirectionIn {
+				updated = append(updated, frame)
+			}
+		}
+		if err := WriteGolden(path, append(updated, got...)); err != nil {
+			t.Fatalf("update golden %s: %v", path, err)
+		}
+		return
+	}
+
+	if diff := diffGolden(want, got); diff != "" {
+		t.Fatalf("outbound frames differ from golden %s (rerun with %s=1 to update):\n%s", path, UpdateGoldenEnv, diff)
+	}
+}
+
+func diffGolden(want []*GoldenFrame, got []*GoldenFrame) string {
+	var sb strings.Builder
+	for i := 0; i < max(len(want), len(got)); i++ {
+		switch {
+		case i >= len(want):
+			fmt.Fprintf(&sb, "  #%d unexpected: %s\n", i, got[i])
+		case i >= len(got):
+			fmt.Fprintf(&sb, "  #%d missing:    %s\n", i, want[i])
+		case *want[i] != *got[i]:
+			fmt.Fprintf(&sb, "  #%d want: %s\n  #%d got:  %s\n", i, want[i], i, got[i])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package dgwstest_test
+
+import (
+	"bytes"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func upper(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+	data := wsm.MessageData
+	if wsm.MessageType == websocket.TextMessage {
+		data = bytes.ToUpper(data)
+	}
+	return dgws.GetConnection(ctx).WriteMessage(wsm.MessageType, data)
+}
+
+func TestAssertGolden(t *testing.T) {
+	dgwstest.AssertGolden(t, "testdata/upper.golden", nil, upper, 0)
+
+	rt := &recordingT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dgwstest.AssertGolden(rt, "testdata/upper.golden", nil, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			return dgws.GetConnection(ctx).WriteMessage(wsm.MessageType, wsm.MessageData)
+		}, 0)
+	}()
+	<-done
+	if !strings.Contains(rt.failure, `want: out text "HELLO"`) || !strings.Contains(rt.failure, `got:  out text "hello"`) {
+		t.Fatalf("unexpected failure: %s", rt.failure)
+	}
+}
+
+func TestGoldenRecorder(t *testing.T) {
+	recorder := &dgwstest.GoldenRecorder{}
+	route := dgwstest.NewPipeRoute(t, &dgws.WebSocketHandlerConfig{Journal: &dgws.JournalOptions{Sink: recorder}}, upper)
+	conn := route.MustDial(t, "golden")
+	for _, text := range []string{"a", "b"} {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(text))
+		dgwstest.ExpectText(t, conn, strings.ToUpper(text), time.Second)
+	}
+
+	path := filepath.Join(t.TempDir(), "recorded.golden")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := dgwstest.ReadGolden(path)
+	if err != nil || len(frames) != 4 || frames[0].Text != "a" || frames[1].Text != "A" {
+		t.Fatalf("unexpected golden frames: %v, %v", frames, err)
+	}
+	dgwstest.AssertGolden(t, path, nil, upper, 0)
+}
@@ -0,0 +1,211 @@
+package dgwstest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeBuffer 单向的无界缓冲, 写入不会阻塞, 避免测试没有及时读取时服务端写超时
+type pipeBuffer struct {
+	lock sync.Mutex
+	data bytes.Buffer
+	// writerClosed 读完剩余数据后返回io.EOF; readerClosed 与TCP一致, 之后的写入被丢弃而不报错
+	writerClosed bool
+	readerClosed bool
+	deadline     time.Time
+	notify       chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{notify: make(chan struct{})}
+}
+
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		b.lock.Lock()
+		if b.readerClosed {
+			b.lock.Unlock()
+			return 0, net.ErrClosed
+		}
+		if b.data.Len() > 0 {
+			n, _ := b.data.Read(p)
+			b.lock.Unlock()
+			return n, nil
+		}
+		if b.writerClosed {
+			b.lock.Unlock()
+			return 0, io.EOF
+		}
+		deadline := b.deadline
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			b.lock.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		notify := b.notify
+		b.lock.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case <-notify:
+		case <-timeout:
+		}
+	}
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.writerClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if !b.readerClosed {
+		b.data.Write(p)
+		b.wake()
+	}
+	return len(p), nil
+}
+
+func (b *pipeBuffer) closeWriter() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.writerClosed = true
+	b.wake()
+}
+
+func (b *pipeBuffer) closeReader() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.readerClosed = true
+	b.data.Reset()
+	b.wake()
+}
+
+func (b *pipeBuffer) setDeadline(t time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.deadline = t
+	b.wake()
+}
+
+// wake 需持有lock
+func (b *pipeBuffer) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// pipeConn 内存中的net.Conn, 关闭后对端读完剩余数据得到io.EOF, 对端的写入被丢弃
+type pipeConn struct {
+	in  *pipeBuffer
+	out *pipeBuffer
+}
+
+func newPipe() (net.Conn, net.Conn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{in: a, out: b}, &pipeConn{in: b, out: a}
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.in.read(p) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.out.write(p) }
+
+func (c *pipeConn) Close() error {
+	c.in.closeReader()
+	c.out.closeWriter()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline 写入从不阻塞, 无需处理
+func (c *pipeConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// PipeListener 内存中的net.Listener, DialContext返回的连接由Accept的一端接收, 不占用端口
+type PipeListener struct {
+	chaos     atomic.Pointer[[2]*ChaosOptions]
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewPipeListener() *PipeListener {
+	return &PipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// SetChaos 之后建立的连接分别在服务端和客户端的写出方向注入故障, nil表示该方向不注入
+func (l *PipeListener) SetChaos(server *ChaosOptions, client *ChaosOptions) {
+	l.chaos.Store(&[2]*ChaosOptions{server, client})
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// DialContext 签名与websocket.Dialer.NetDialContext一致, network和addr被忽略
+func (l *PipeListener) DialContext(ctx context.Context, _ string, _ string) (net.Conn, error) {
+	client, server := newPipe()
+	if chaos := l.chaos.Load(); chaos != nil {
+		server = ChaosConn(server, chaos[0])
+		client = ChaosConn(client, chaos[1])
+	}
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
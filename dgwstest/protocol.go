@@ -0,0 +1,41 @@
+package dgwstest
+
+import (
+	"bytes"
+	dgws "github.com/darwinOrg/go-websocket"
+	"os"
+	"strings"
+	"testing"
+)
+
+// UpdateProtocolEnv 设置为非空时AssertProtocolCompatible用当前登记的schema重写基线文件
+const UpdateProtocolEnv = "DGWS_UPDATE_PROTOCOL"
+
+// AssertProtocolCompatible 比较dgws.ProtocolSchemas()与path中的基线, 有不兼容的变化时失败;
+// 基线文件不存在或设置UpdateProtocolEnv时写入当前schema. 新增消息类型或字段后也应更新基线, 以保护新增部分
+func AssertProtocolCompatible(t testing.TB, path string) {
+	t.Helper()
+	current := dgws.ProtocolSchemas()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || os.Getenv(UpdateProtocolEnv) != "" {
+		var buf bytes.Buffer
+		if err := dgws.WriteProtocolBaseline(&buf, current); err != nil {
+			t.Fatalf("encode protocol baseline: %v", err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("write protocol baseline %s: %v", path, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("read protocol baseline %s: %v", path, err)
+	}
+
+	baseline, err := dgws.ReadProtocolBaseline(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode protocol baseline %s: %v", path, err)
+	}
+	if breaks := dgws.CheckProtocolCompatibility(baseline, current); len(breaks) > 0 {
+		t.Fatalf("protocol changes are not backward compatible with %s (rerun with %s=1 to accept):\n  %s", path, UpdateProtocolEnv, strings.Join(breaks, "\n  "))
+	}
+}
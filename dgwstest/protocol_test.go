@@ -0,0 +1,38 @@
+package dgwstest_test
+
+import (
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssertProtocolCompatible(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protocol.json")
+	dgws.RegisterMessageSchema("order", struct {
+		Id    string `json:"id"`
+		Price int    `json:"price"`
+	}{})
+	dgwstest.AssertProtocolCompatible(t, path)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("baseline not written: %v", err)
+	}
+	dgwstest.AssertProtocolCompatible(t, path)
+
+	dgws.RegisterMessageSchema("order", struct {
+		Id    string  `json:"id"`
+		Price float64 `json:"price"`
+	}{})
+	rt := &recordingT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dgwstest.AssertProtocolCompatible(rt, path)
+	}()
+	<-done
+	if !strings.Contains(rt.failure, "order.price: type changed from integer to number") {
+		t.Fatalf("unexpected failure: %s", rt.failure)
+	}
+}
@@ -0,0 +1,108 @@
+package dgwstest
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/middleware"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	// RoutePath 测试路由注册的路径
+	RoutePath = "/ws"
+	// DefaultBizKey conf未设置BizKey时使用
+	DefaultBizKey = "bizId"
+)
+
+// registerRoute 以NonLogin注册路由, 返回生效的bizKey; 请求头中的uid仍会写入DgContext
+func registerRoute(engine *gin.Engine, conf *dgws.WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	if conf == nil {
+		conf = &dgws.WebSocketHandlerConfig{}
+	}
+	if conf.BizKey == "" {
+		conf.BizKey = DefaultBizKey
+	}
+	if conf.GetBizIdHandler == nil {
+		bizKey := conf.BizKey
+		conf.GetBizIdHandler = func(c *gin.Context) string {
+			return c.Query(bizKey)
+		}
+	}
+	if bizHandler == nil {
+		bizHandler = func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error { return nil }
+	}
+
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group(RoutePath),
+		NonLogin:    true,
+		BizHandler:  bizHandler,
+	}, conf)
+
+	return conf.BizKey
+}
+
+// RouteDialer 连接测试路由的客户端
+type RouteDialer struct {
+	baseUrl string
+	bizKey  string
+	dialer  *websocket.Dialer
+}
+
+func newRouteDialer(baseUrl string, bizKey string, dialer *websocket.Dialer) *RouteDialer {
+	return &RouteDialer{baseUrl: baseUrl, bizKey: bizKey, dialer: dialer}
+}
+
+// Dialer 可用于dgws.Client等需要自行拨号的场景
+func (d *RouteDialer) Dialer() *websocket.Dialer {
+	return d.dialer
+}
+
+// URL 以bizId连接路由的地址
+func (d *RouteDialer) URL(bizId string) string {
+	return d.baseUrl + RoutePath + "?" + url.Values{d.bizKey: {bizId}}.Encode()
+}
+
+// Dial header可为nil
+func (d *RouteDialer) Dial(ctx context.Context, bizId string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	return d.dialer.DialContext(ctx, d.URL(bizId), header)
+}
+
+// MustDial 连接失败时终止测试, 连接在测试结束时关闭
+func (d *RouteDialer) MustDial(t testing.TB, bizId string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := d.Dial(context.Background(), bizId, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", d.URL(bizId), err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+// StartRoute 用httptest启动带wrapper.DefaultEngine中间件链的路由, 返回时已可连接, 无需sleep等待监听;
+// 返回的cleanup关闭服务端, 已升级的连接不受影响; 可提前调用, 测试结束时也会自动调用
+func StartRoute(t testing.TB, conf *dgws.WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) (*RouteDialer, func()) {
+	t.Helper()
+	// Monitor中间件依赖monitor.Start, 测试中不启用
+	engine := wrapper.NewEngine(middleware.Recover(), middleware.Cors(), middleware.HealthHandler())
+	bizKey := registerRoute(engine, conf, bizHandler)
+
+	server := httptest.NewServer(engine)
+	var once sync.Once
+	cleanup := func() {
+		once.Do(server.Close)
+	}
+	t.Cleanup(cleanup)
+
+	return newRouteDialer("ws"+strings.TrimPrefix(server.URL, "http"), bizKey, &websocket.Dialer{}), cleanup
+}
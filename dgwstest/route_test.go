@@ -0,0 +1,52 @@
+package dgwstest_test
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStartRoute(t *testing.T) {
+	type seen struct {
+		bizId  string
+		userId int64
+	}
+	received := make(chan seen, 1)
+	dialer, cleanup := dgwstest.StartRoute(t, &dgws.WebSocketHandlerConfig{
+		BizKey: "roomId",
+	}, func(c *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		received <- seen{bizId: c.Query("roomId"), userId: ctx.UserId}
+		return nil
+	})
+
+	header := http.Header{}
+	header.Set("uid", strconv.Itoa(42))
+	conn, _, err := dialer.Dial(context.Background(), "room-1", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-received:
+		if s.bizId != "room-1" || s.userId != 42 {
+			t.Fatalf("unexpected context: %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("biz handler not called")
+	}
+
+	cleanup()
+	if _, _, err := dialer.Dial(context.Background(), "room-2", nil); err == nil {
+		t.Fatal("expected dial error after cleanup")
+	}
+}
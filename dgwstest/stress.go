@@ -0,0 +1,193 @@
+package dgwstest
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const stressBizIdPrefix = "stress-"
+
+// StressOptions 零值字段使用默认值
+type StressOptions struct {
+	// Connections 同时保持的客户端连接数, 默认16; 每个连接发送若干消息后断开重连
+	Connections int
+	// Duration 默认1秒
+	Duration time.Duration
+	// Workers 服务端写入、广播、踢出各自的并发goroutine数, 默认4
+	Workers int
+	// MessagesPerConn 每个连接重连前发送的消息数, 默认20
+	MessagesPerConn int
+	// MessageSize 默认64字节
+	MessageSize int
+	// DrainTimeout 结束后等待服务端注销所有连接的时间, 默认5秒
+	DrainTimeout time.Duration
+}
+
+// StressResult 各类操作的次数, Errors为客户端建立连接失败的次数; 被踢出导致的发送失败属于正常情况, 不计入
+type StressResult struct {
+	Connects   int64
+	Sends      int64
+	Writes     int64
+	Broadcasts int64
+	Kicks      int64
+	Errors     int64
+}
+
+// Stress 在Duration内并发地建立和断开连接、客户端发送、服务端通过Connection写入、广播和踢出, 用于在-race下发现
+// 并发写和连接注册表的竞争; 结束后服务端未在DrainTimeout内注销全部连接时测试失败. 路由中的BizHandler可以回写消息以覆盖读写并发
+func Stress(t testing.TB, dialer *RouteDialer, opts *StressOptions) *StressResult {
+	t.Helper()
+	o := StressOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Connections <= 0 {
+		o.Connections = 16
+	}
+	if o.Duration <= 0 {
+		o.Duration = time.Second
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.MessagesPerConn <= 0 {
+		o.MessagesPerConn = 20
+	}
+	if o.MessageSize <= 0 {
+		o.MessageSize = 64
+	}
+	if o.DrainTimeout <= 0 {
+		o.DrainTimeout = 5 * time.Second
+	}
+
+	bizIds := make([]string, o.Connections)
+	for i := range bizIds {
+		bizIds[i] = stressBizIdPrefix + strconv.Itoa(i)
+	}
+	inRoute := func(c *dgws.Connection) bool {
+		return c.BizKey == dialer.bizKey && strings.HasPrefix(c.BizId, stressBizIdPrefix)
+	}
+
+	var (
+		result StressResult
+		wg     sync.WaitGroup
+	)
+	// 不使用WithTimeout, 否则握手会被设置同样的deadline, 结束前的握手超时无法与真实错误区分
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := time.AfterFunc(o.Duration, cancel)
+	defer stop.Stop()
+	defer cancel()
+
+	for _, bizId := range bizIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stressClient(ctx, dialer, bizId, &o, &result)
+		}()
+	}
+
+	payload := make([]byte, o.MessageSize)
+	for i := 0; i < o.Workers; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				dgws.RangeConnections(func(c *dgws.Connection) bool {
+					if inRoute(c) && c.WriteMessage(websocket.TextMessage, payload) == nil {
+						atomic.AddInt64(&result.Writes, 1)
+					}
+					return ctx.Err() == nil
+				})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_, _ = dgws.BroadcastToBizIds(dialer.bizKey, pick(bizIds, 4), websocket.BinaryMessage, payload)
+				atomic.AddInt64(&result.Broadcasts, 1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			kickCtx := dgctx.SimpleDgContext()
+			for ctx.Err() == nil {
+				_, _ = dgws.Kick(kickCtx, &dgws.KickOptions{BizKey: dialer.bizKey, BizIds: pick(bizIds, 1), Reason: "stress"})
+				atomic.AddInt64(&result.Kicks, 1)
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(o.DrainTimeout)
+	for {
+		left := 0
+		dgws.RangeConnections(func(c *dgws.Connection) bool {
+			if inRoute(c) {
+				left++
+			}
+			return true
+		})
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("stress: %d connections still registered after %v", left, o.DrainTimeout)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return &result
+}
+
+// stressClient 反复连接、发送MessagesPerConn条消息后断开, 读取在单独的goroutine中进行, 写入只在本goroutine中
+func stressClient(ctx context.Context, dialer *RouteDialer, bizId string, o *StressOptions, result *StressResult) {
+	payload := make([]byte, o.MessageSize)
+	for ctx.Err() == nil {
+		conn, _, err := dialer.Dial(ctx, bizId, nil)
+		if err != nil {
+			if ctx.Err() == nil {
+				atomic.AddInt64(&result.Errors, 1)
+			}
+			continue
+		}
+		atomic.AddInt64(&result.Connects, 1)
+
+		readDone := make(chan struct{})
+		go func() {
+			defer close(readDone)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < o.MessagesPerConn && ctx.Err() == nil; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				break
+			}
+			atomic.AddInt64(&result.Sends, 1)
+		}
+		_ = conn.Close()
+		<-readDone
+	}
+}
+
+func pick(bizIds []string, n int) []string {
+	picked := make([]string, n)
+	for i := range picked {
+		picked[i] = bizIds[rand.Intn(len(bizIds))]
+	}
+	return picked
+}
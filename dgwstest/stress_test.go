@@ -0,0 +1,28 @@
+package dgwstest_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/gin-gonic/gin"
+	"testing"
+	"time"
+)
+
+func TestStress(t *testing.T) {
+	route := dgwstest.NewPipeRoute(t, &dgws.WebSocketHandlerConfig{}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if c := dgws.GetConnection(ctx); c != nil {
+			_ = c.WriteMessage(wsm.MessageType, wsm.MessageData)
+		}
+		return nil
+	})
+
+	result := dgwstest.Stress(t, route.RouteDialer, &dgwstest.StressOptions{Duration: 300 * time.Millisecond})
+	if result.Connects == 0 || result.Sends == 0 || result.Broadcasts == 0 || result.Kicks == 0 {
+		t.Fatalf("stress did not exercise all operations: %+v", result)
+	}
+	if result.Errors > 0 {
+		t.Fatalf("stress dial errors: %+v", result)
+	}
+	t.Logf("%+v", result)
+}
@@ -0,0 +1,239 @@
+package dgwstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// UpstreamStep 脚本中的一步, 依次执行: 等待Delay, 等待Expect, 发送Send, 关闭
+type UpstreamStep struct {
+	Delay time.Duration
+	// Expect 非nil时等待下一条消息, 内容不一致时记录到Mismatches并断开连接
+	Expect []byte
+	// Send 非nil时发送, MessageType默认为文本消息
+	Send        []byte
+	MessageType int
+	// CloseCode 大于0时发送该close帧后断开, 可以是不合法的关闭码
+	CloseCode int
+	CloseText string
+	// Abort 不发送close帧直接断开底层连接, 模拟上游崩溃
+	Abort bool
+}
+
+// MockUpstreamOptions 零值为接受所有连接并回显消息的上游
+type MockUpstreamOptions struct {
+	// RejectStatus 大于0时以该状态码拒绝握手
+	RejectStatus int
+	// HandshakeDelay 握手前的延迟, 用于测试连接超时
+	HandshakeDelay time.Duration
+	// Script 每个连接依次执行的步骤, 执行完且未关闭时继续读取并回显
+	Script []UpstreamStep
+	// NoEcho 不回显, 只记录收到的消息
+	NoEcho bool
+}
+
+// UpstreamMessage 上游收到的消息
+type UpstreamMessage struct {
+	Conn        int
+	MessageType int
+	Data        []byte
+}
+
+// MockUpstream 可编排的转发上游, 用于测试DialForward的故障转移、重连以及ForwardMessages的关闭传递
+type MockUpstream struct {
+	URL    string
+	server *httptest.Server
+
+	lock       sync.Mutex
+	opts       MockUpstreamOptions
+	handshakes int
+	conns      map[int]*websocket.Conn
+	received   []UpstreamMessage
+	closeCodes []int
+	mismatches []string
+}
+
+// StartMockUpstream opts可为nil, 测试结束时关闭
+func StartMockUpstream(t testing.TB, opts *MockUpstreamOptions) *MockUpstream {
+	t.Helper()
+	u := &MockUpstream{conns: make(map[int]*websocket.Conn)}
+	if opts != nil {
+		u.opts = *opts
+	}
+	u.server = httptest.NewServer(http.HandlerFunc(u.serve))
+	u.URL = "ws" + strings.TrimPrefix(u.server.URL, "http") + "/ws"
+	t.Cleanup(u.Close)
+	return u
+}
+
+// SetOptions 只影响之后的握手, 例如先让上游拒绝连接再恢复, 测试重连
+func (u *MockUpstream) SetOptions(opts *MockUpstreamOptions) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.opts = MockUpstreamOptions{}
+	if opts != nil {
+		u.opts = *opts
+	}
+}
+
+func (u *MockUpstream) serve(w http.ResponseWriter, r *http.Request) {
+	u.lock.Lock()
+	opts := u.opts
+	u.handshakes++
+	id := u.handshakes
+	u.lock.Unlock()
+
+	if opts.HandshakeDelay > 0 {
+		time.Sleep(opts.HandshakeDelay)
+	}
+	if opts.RejectStatus > 0 {
+		w.WriteHeader(opts.RejectStatus)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	u.lock.Lock()
+	u.conns[id] = conn
+	u.lock.Unlock()
+	defer func() {
+		u.lock.Lock()
+		delete(u.conns, id)
+		u.lock.Unlock()
+	}()
+
+	messages := make(chan UpstreamMessage, 16)
+	done := make(chan struct{})
+	defer close(done)
+	go u.read(id, conn, messages, done)
+	if !u.runScript(id, conn, opts.Script, messages) {
+		return
+	}
+	for msg := range messages {
+		if !opts.NoEcho {
+			if err := conn.WriteMessage(msg.MessageType, msg.Data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// read 记录收到的消息和对端的关闭码, 连接结束时关闭messages; done关闭后不再投递
+func (u *MockUpstream) read(id int, conn *websocket.Conn, messages chan<- UpstreamMessage, done <-chan struct{}) {
+	defer close(messages)
+	for {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) {
+				u.lock.Lock()
+				u.closeCodes = append(u.closeCodes, ce.Code)
+				u.lock.Unlock()
+			}
+			return
+		}
+		msg := UpstreamMessage{Conn: id, MessageType: mt, Data: data}
+		u.lock.Lock()
+		u.received = append(u.received, msg)
+		u.lock.Unlock()
+		select {
+		case messages <- msg:
+		case <-done:
+		}
+	}
+}
+
+// runScript 连接已关闭或应关闭时返回false
+func (u *MockUpstream) runScript(id int, conn *websocket.Conn, script []UpstreamStep, messages <-chan UpstreamMessage) bool {
+	for i, step := range script {
+		if step.Delay > 0 {
+			time.Sleep(step.Delay)
+		}
+		if step.Expect != nil {
+			msg, ok := <-messages
+			if !ok {
+				return false
+			}
+			if !bytes.Equal(msg.Data, step.Expect) {
+				u.lock.Lock()
+				u.mismatches = append(u.mismatches, fmt.Sprintf("conn %d step %d: expect %q, got %q", id, i, step.Expect, msg.Data))
+				u.lock.Unlock()
+				return false
+			}
+		}
+		if step.Send != nil {
+			mt := step.MessageType
+			if mt == 0 {
+				mt = websocket.TextMessage
+			}
+			if err := conn.WriteMessage(mt, step.Send); err != nil {
+				return false
+			}
+		}
+		if step.Abort {
+			_ = conn.NetConn().Close()
+			return false
+		}
+		if step.CloseCode > 0 {
+			// FormatCloseMessage会把1005等不可发送的关闭码改写, 这里直接拼帧以便发送不合法的关闭码
+			payload := []byte{byte(step.CloseCode >> 8), byte(step.CloseCode)}
+			_ = conn.WriteControl(websocket.CloseMessage, append(payload, step.CloseText...), time.Now().Add(time.Second))
+			return false
+		}
+	}
+	return true
+}
+
+// Handshakes 收到的握手请求数, 包括被拒绝的
+func (u *MockUpstream) Handshakes() int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.handshakes
+}
+
+// Received 所有连接收到的消息, Conn为连接序号(从1开始, 与握手顺序一致)
+func (u *MockUpstream) Received() []UpstreamMessage {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return append([]UpstreamMessage(nil), u.received...)
+}
+
+// CloseCodes 对端发来的close帧中的关闭码, 用于验证关闭传递
+func (u *MockUpstream) CloseCodes() []int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return append([]int(nil), u.closeCodes...)
+}
+
+// Mismatches 脚本中Expect不一致的记录
+func (u *MockUpstream) Mismatches() []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return append([]string(nil), u.mismatches...)
+}
+
+// AbortConnections 直接断开当前所有连接, 模拟上游崩溃
+func (u *MockUpstream) AbortConnections() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, conn := range u.conns {
+		_ = conn.NetConn().Close()
+	}
+}
+
+// Close 停止接受连接并断开已有连接
+func (u *MockUpstream) Close() {
+	u.AbortConnections()
+	u.server.Close()
+}
@@ -0,0 +1,71 @@
+package dgwstest_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMockUpstreamScript(t *testing.T) {
+	upstream := dgwstest.StartMockUpstream(t, &dgwstest.MockUpstreamOptions{Script: []dgwstest.UpstreamStep{
+		{Expect: []byte("hello"), Send: []byte("world")},
+		{Delay: 10 * time.Millisecond, CloseCode: 4001, CloseText: "bye"},
+	}})
+
+	conn, _, err := websocket.DefaultDialer.Dial(upstream.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	dgwstest.ExpectText(t, conn, "world", time.Second)
+	dgwstest.ExpectClose(t, conn, 4001)
+	if m := upstream.Mismatches(); len(m) > 0 {
+		t.Fatalf("mismatches: %v", m)
+	}
+}
+
+func TestMockUpstreamFailoverAndAbort(t *testing.T) {
+	rejecting := dgwstest.StartMockUpstream(t, &dgwstest.MockUpstreamOptions{RejectStatus: http.StatusServiceUnavailable})
+	healthy := dgwstest.StartMockUpstream(t, nil)
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	upstreams, err := dgws.NewForwardUpstreams(ctx, dgws.StaticResolver{rejecting.URL, healthy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstreams.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := dgws.DialForward(ctx, "backend", upstreams, "", nil)
+		if err != nil {
+			t.Fatalf("dial forward: %v", err)
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+		dgwstest.ExpectText(t, conn, "ping", time.Second)
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		_ = conn.Close()
+	}
+	if rejecting.Handshakes() == 0 {
+		t.Fatal("rejecting upstream was never tried")
+	}
+
+	conn, err := dgws.DialForward(ctx, "backend", upstreams, "", nil)
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	dgwstest.ExpectText(t, conn, "ping", time.Second)
+	healthy.AbortConnections()
+	dgwstest.ExpectClose(t, conn, websocket.CloseAbnormalClosure)
+
+	codes := healthy.CloseCodes()
+	if len(codes) != 2 || codes[0] != websocket.CloseNormalClosure {
+		t.Fatalf("unexpected close codes: %v", codes)
+	}
+}
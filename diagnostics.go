@@ -0,0 +1,103 @@
+package dgws
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"maps"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const defaultMaxTraceBytes = 256
+
+// RouteDiagnostics 路由的运行时诊断开关, 通过SetRouteDiagnostics或管理接口修改后立即对已有连接生效, 无需重启;
+// 接入配置中心时在配置变化的回调中调用SetRouteDiagnostics即可
+type RouteDiagnostics struct {
+	// TraceMessages 记录收到的消息及BizHandler的耗时和结果, 不作用于BatchHandler
+	TraceMessages bool `json:"traceMessages"`
+	// SampleRate 被记录消息的比例, 取值(0, 1], 0表示全部记录
+	SampleRate float64 `json:"sampleRate"`
+	// MaxTraceBytes 日志中消息内容的最大字节数, 默认256, 小于0时不输出内容
+	MaxTraceBytes int `json:"maxTraceBytes"`
+	// Debug 输出连接建立、状态迁移、断开等详细日志
+	Debug bool `json:"debug"`
+}
+
+var (
+	routeDiagnostics     = make(map[string]*RouteDiagnostics)
+	routeDiagnosticsLock sync.RWMutex
+)
+
+// SetRouteDiagnostics path为路由的完整路径(gin的FullPath), 为空时作用于没有单独设置的所有路由; d为nil时清除
+func SetRouteDiagnostics(path string, d *RouteDiagnostics) {
+	routeDiagnosticsLock.Lock()
+	defer routeDiagnosticsLock.Unlock()
+	if d == nil {
+		delete(routeDiagnostics, path)
+		return
+	}
+	copied := *d
+	routeDiagnostics[path] = &copied
+}
+
+// GetRouteDiagnostics 返回路由当前生效的诊断开关, 未开启时返回nil
+func GetRouteDiagnostics(path string) *RouteDiagnostics {
+	routeDiagnosticsLock.RLock()
+	defer routeDiagnosticsLock.RUnlock()
+	if d, ok := routeDiagnostics[path]; ok {
+		return d
+	}
+	return routeDiagnostics[""]
+}
+
+// AllRouteDiagnostics 返回所有单独设置过的路由, key为""的是默认值
+func AllRouteDiagnostics() map[string]*RouteDiagnostics {
+	routeDiagnosticsLock.RLock()
+	defer routeDiagnosticsLock.RUnlock()
+	return maps.Clone(routeDiagnostics)
+}
+
+func debugEnabled(path string) bool {
+	d := GetRouteDiagnostics(path)
+	return d != nil && d.Debug
+}
+
+// withTrace 每条消息都重新读取开关, 关闭时只多一次读锁
+func withTrace(path string, bizKey string, bizId string, bizHandler wrapper.HandlerFunc[WebSocketMessage, error]) wrapper.HandlerFunc[WebSocketMessage, error] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) error {
+		d := GetRouteDiagnostics(path)
+		if d == nil || !d.TraceMessages || (d.SampleRate > 0 && d.SampleRate < 1 && rand.Float64() >= d.SampleRate) {
+			return bizHandler(c, ctx, wsm)
+		}
+
+		// 开启消息池时BizHandler返回后消息可能被复用, 先截取内容
+		preview := tracePreview(d, wsm)
+		size := len(wsm.MessageData)
+		start := time.Now()
+		err := bizHandler(c, ctx, wsm)
+		dglogger.Infof(ctx, "[%s: %s] trace message, type: %d, size: %d, cost: %v, error: %v, data: %s", bizKey, bizId, wsm.MessageType, size, time.Since(start), err, preview)
+
+		return err
+	}
+}
+
+func tracePreview(d *RouteDiagnostics, wsm *WebSocketMessage) string {
+	limit := d.MaxTraceBytes
+	if limit == 0 {
+		limit = defaultMaxTraceBytes
+	}
+	if limit < 0 {
+		return ""
+	}
+	if wsm.MessageType == websocket.BinaryMessage {
+		return "<binary>"
+	}
+	if len(wsm.MessageData) > limit {
+		return string(wsm.MessageData[:limit]) + "..."
+	}
+	return string(wsm.MessageData)
+}
@@ -0,0 +1,74 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-common/result"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestRouteDiagnosticsFallback(t *testing.T) {
+	defer dgws.SetRouteDiagnostics("", nil)
+	defer dgws.SetRouteDiagnostics("/ws", nil)
+
+	if d := dgws.GetRouteDiagnostics("/ws"); d != nil {
+		t.Fatalf("expected no diagnostics, got %+v", d)
+	}
+	dgws.SetRouteDiagnostics("", &dgws.RouteDiagnostics{Debug: true})
+	if d := dgws.GetRouteDiagnostics("/ws"); d == nil || !d.Debug {
+		t.Fatalf("expected default diagnostics, got %+v", d)
+	}
+	dgws.SetRouteDiagnostics("/ws", &dgws.RouteDiagnostics{TraceMessages: true})
+	if d := dgws.GetRouteDiagnostics("/ws"); d == nil || d.Debug || !d.TraceMessages {
+		t.Fatalf("expected route diagnostics, got %+v", d)
+	}
+	if d := dgws.GetRouteDiagnostics("/other"); d == nil || !d.Debug {
+		t.Fatalf("expected default diagnostics for other route, got %+v", d)
+	}
+}
+
+func TestTraceMessagesAtRuntime(t *testing.T) {
+	defer dgws.SetRouteDiagnostics("/ws", nil)
+
+	handled := make(chan string, 4)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{EnableMessagePool: true}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		handled <- string(wsm.MessageData)
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=trace-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// 连接建立后再开启, 已有连接立即生效
+	admin := startAdminServer(t)
+	rt := adminCall[*result.Void](t, admin+"diagnostics", testAdminToken, &dgws.AdminDiagnosticsRequest{
+		Path:        "/ws",
+		Diagnostics: &dgws.RouteDiagnostics{TraceMessages: true, MaxTraceBytes: 4, Debug: true},
+	})
+	if !rt.Success {
+		t.Fatalf("set diagnostics: %+v", rt)
+	}
+	all := adminCall[map[string]*dgws.RouteDiagnostics](t, admin+"diagnostics", testAdminToken, nil)
+	if !all.Success || all.Data["/ws"] == nil || !all.Data["/ws"].TraceMessages {
+		t.Fatalf("unexpected diagnostics: %+v", all)
+	}
+
+	for _, msg := range []string{"traced-message", "short"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		select {
+		case got := <-handled:
+			if got != msg {
+				t.Fatalf("expected %s, got %s", msg, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message not handled")
+		}
+	}
+}
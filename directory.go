@@ -0,0 +1,307 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+const defaultDirectoryTtl = time.Minute
+
+// DirectoryEntry 目录中的一条连接记录, 同一bizId可能同时在多个节点上有连接
+type DirectoryEntry struct {
+	NodeId       string    `json:"nodeId"`
+	NodeAddr     string    `json:"nodeAddr,omitempty"`
+	ConnectionId string    `json:"connectionId"`
+	BizKey       string    `json:"bizKey"`
+	BizId        string    `json:"bizId"`
+	UserId       int64     `json:"userId,omitempty"`
+	ConnectedAt  time.Time `json:"connectedAt"`
+}
+
+// DirectoryStore 共享的连接目录, 内置MemoryDirectoryStore, Redis实现见redisstore子包;
+// Register同时用于首次登记和续期, 超过ttl未续期的记录视为失效
+type DirectoryStore interface {
+	Register(ctx context.Context, entries []*DirectoryEntry, ttl time.Duration) error
+	Unregister(ctx context.Context, entries []*DirectoryEntry) error
+	Lookup(ctx context.Context, bizKey string, bizId string) ([]*DirectoryEntry, error)
+}
+
+// DirectoryOptions 开启后本实例的连接会登记到共享目录, 并按RefreshInterval续期
+type DirectoryOptions struct {
+	Store DirectoryStore
+	// NodeId 默认随机生成
+	NodeId string
+	// NodeAddr 其他实例访问本实例的地址, 目录只负责记录
+	NodeAddr string
+	// Ttl 记录的存活时间, 默认1分钟; 实例异常退出后其记录最多保留Ttl
+	Ttl time.Duration
+	// RefreshInterval 续期间隔, 默认Ttl/3
+	RefreshInterval time.Duration
+}
+
+type directoryState struct {
+	ctx      *dgctx.DgContext
+	nodeId   string
+	nodeAddr string
+	store    DirectoryStore
+	ttl      time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var (
+	directory     *directoryState
+	directoryLock sync.RWMutex
+)
+
+// InitDirectory 登记当前已有的连接并开始定期续期, 重复调用会替换之前的配置
+func InitDirectory(ctx *dgctx.DgContext, opts *DirectoryOptions) error {
+	if opts == nil || opts.Store == nil {
+		return errors.New("websocket directory store is required")
+	}
+
+	state := &directoryState{
+		ctx:      ctx,
+		nodeId:   opts.NodeId,
+		nodeAddr: opts.NodeAddr,
+		store:    opts.Store,
+		ttl:      opts.Ttl,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if state.nodeId == "" {
+		state.nodeId = uuid.NewString()
+	}
+	if state.ttl <= 0 {
+		state.ttl = defaultDirectoryTtl
+	}
+	interval := opts.RefreshInterval
+	if interval <= 0 {
+		interval = state.ttl / 3
+	}
+	if err := state.refresh(); err != nil {
+		return err
+	}
+
+	directoryLock.Lock()
+	previous := directory
+	directory = state
+	directoryLock.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	go state.run(interval)
+
+	return nil
+}
+
+// CloseDirectory 停止续期并从目录中移除本实例的连接
+func CloseDirectory() error {
+	directoryLock.Lock()
+	state := directory
+	directory = nil
+	directoryLock.Unlock()
+	if state == nil {
+		return nil
+	}
+
+	state.close()
+	return state.store.Unregister(innerContext(state.ctx), state.entries())
+}
+
+// Lookup 查询bizId所在的节点, 未开启目录时只返回本实例的连接; 开启InitMembership时不返回失联实例上的连接
+func Lookup(ctx *dgctx.DgContext, bizKey string, bizId string) ([]*DirectoryEntry, error) {
+	state := getDirectory()
+	if state == nil {
+		var entries []*DirectoryEntry
+		RangeConnections(func(c *Connection) bool {
+			if c.BizKey == bizKey && c.BizId == bizId {
+				entries = append(entries, &DirectoryEntry{ConnectionId: c.Id, BizKey: c.BizKey, BizId: c.BizId, UserId: c.UserId, ConnectedAt: c.ConnectedAt})
+			}
+			return true
+		})
+		return entries, nil
+	}
+
+	return state.lookup(ctx, bizKey, bizId)
+}
+
+// lookup 开启InitMembership时过滤掉失联实例上的记录
+func (s *directoryState) lookup(ctx *dgctx.DgContext, bizKey string, bizId string) ([]*DirectoryEntry, error) {
+	entries, err := s.store.Lookup(innerContext(ctx), bizKey, bizId)
+	m := getMembership()
+	if err != nil || m == nil {
+		return entries, err
+	}
+
+	alive := make([]*DirectoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.NodeId == s.nodeId || m.alive(entry) {
+			alive = append(alive, entry)
+		}
+	}
+
+	return alive, nil
+}
+
+// DirectoryNodeId 未开启目录时返回空
+func DirectoryNodeId() string {
+	if state := getDirectory(); state != nil {
+		return state.nodeId
+	}
+	return ""
+}
+
+func getDirectory() *directoryState {
+	directoryLock.RLock()
+	defer directoryLock.RUnlock()
+	return directory
+}
+
+func (s *directoryState) entry(c *Connection) *DirectoryEntry {
+	return &DirectoryEntry{
+		NodeId:       s.nodeId,
+		NodeAddr:     s.nodeAddr,
+		ConnectionId: c.Id,
+		BizKey:       c.BizKey,
+		BizId:        c.BizId,
+		UserId:       c.UserId,
+		ConnectedAt:  c.ConnectedAt,
+	}
+}
+
+func (s *directoryState) entries() []*DirectoryEntry {
+	var entries []*DirectoryEntry
+	RangeConnections(func(c *Connection) bool {
+		entries = append(entries, s.entry(c))
+		return true
+	})
+	return entries
+}
+
+func (s *directoryState) refresh() error {
+	entries := s.entries()
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.store.Register(innerContext(s.ctx), entries, s.ttl)
+}
+
+func (s *directoryState) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.refresh(); err != nil {
+				dglogger.Warnf(s.ctx, "refresh websocket directory error: %v", err)
+			}
+		}
+	}
+}
+
+func (s *directoryState) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// registerDirectory 在连接注册后调用, 未开启目录时不做任何事
+func registerDirectory(c *Connection) {
+	state := getDirectory()
+	if state == nil {
+		return
+	}
+	if err := state.store.Register(innerContext(c.Ctx), []*DirectoryEntry{state.entry(c)}, state.ttl); err != nil {
+		dglogger.Warnf(c.Ctx, "[%s: %s] register websocket directory error: %v", c.BizKey, c.BizId, err)
+	}
+}
+
+func unregisterDirectory(c *Connection) {
+	state := getDirectory()
+	if state == nil {
+		return
+	}
+	if err := state.store.Unregister(innerContext(c.Ctx), []*DirectoryEntry{state.entry(c)}); err != nil {
+		dglogger.Warnf(c.Ctx, "[%s: %s] unregister websocket directory error: %v", c.BizKey, c.BizId, err)
+	}
+}
+
+// MemoryDirectoryStore 进程内存储, 仅用于测试或单机场景
+type MemoryDirectoryStore struct {
+	lock    sync.Mutex
+	entries map[string]map[string]*memoryDirectoryEntry
+}
+
+type memoryDirectoryEntry struct {
+	entry     *DirectoryEntry
+	expiresAt time.Time
+}
+
+func NewMemoryDirectoryStore() *MemoryDirectoryStore {
+	return &MemoryDirectoryStore{entries: make(map[string]map[string]*memoryDirectoryEntry)}
+}
+
+func (s *MemoryDirectoryStore) Register(_ context.Context, entries []*DirectoryEntry, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	for _, entry := range entries {
+		key := directoryKey(entry.BizKey, entry.BizId)
+		conns := s.entries[key]
+		if conns == nil {
+			conns = make(map[string]*memoryDirectoryEntry)
+			s.entries[key] = conns
+		}
+		conns[entry.ConnectionId] = &memoryDirectoryEntry{entry: entry, expiresAt: expiresAt}
+	}
+
+	return nil
+}
+
+func (s *MemoryDirectoryStore) Unregister(_ context.Context, entries []*DirectoryEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, entry := range entries {
+		key := directoryKey(entry.BizKey, entry.BizId)
+		if conns := s.entries[key]; conns != nil {
+			delete(conns, entry.ConnectionId)
+			if len(conns) == 0 {
+				delete(s.entries, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryDirectoryStore) Lookup(_ context.Context, bizKey string, bizId string) ([]*DirectoryEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	var entries []*DirectoryEntry
+	for id, e := range s.entries[directoryKey(bizKey, bizId)] {
+		if e.expiresAt.After(now) {
+			entries = append(entries, e.entry)
+		} else {
+			delete(s.entries[directoryKey(bizKey, bizId)], id)
+		}
+	}
+
+	return entries, nil
+}
+
+func directoryKey(bizKey string, bizId string) string {
+	return bizKey + ":" + bizId
+}
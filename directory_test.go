@@ -0,0 +1,52 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func waitLookup(t *testing.T, ctx *dgctx.DgContext, bizId string, n int) []*dgws.DirectoryEntry {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		entries, err := dgws.Lookup(ctx, "bizId", bizId)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if len(entries) == n {
+			return entries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d entries for %s, got %d", n, bizId, len(entries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDirectoryLifecycle(t *testing.T) {
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	store := dgws.NewMemoryDirectoryStore()
+	if err := dgws.InitDirectory(ctx, &dgws.DirectoryOptions{Store: store, NodeId: "node-a", NodeAddr: "10.0.0.1:8080"}); err != nil {
+		t.Fatalf("init directory: %v", err)
+	}
+	defer dgws.CloseDirectory()
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=dir-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	entries := waitLookup(t, ctx, "dir-a", 1)
+	if entries[0].NodeId != "node-a" || entries[0].NodeAddr != "10.0.0.1:8080" || entries[0].ConnectionId == "" {
+		t.Fatalf("unexpected entry: %+v", entries[0])
+	}
+
+	_ = conn.Close()
+	waitLookup(t, ctx, "dir-a", 0)
+}
@@ -0,0 +1,59 @@
+package dgws
+
+import (
+	"sync"
+)
+
+// messageDispatcher 将BizHandler的执行与读循环解耦, 读循环可持续处理ping/pong/close等控制帧
+type messageDispatcher interface {
+	submit(task func())
+	wait()
+}
+
+// serialDispatcher 每个连接按顺序执行BizHandler, 执行goroutine只在有消息时存在, 队列清空后退出;
+// 因此空闲连接只占用读循环一个goroutine(ping由共享的调度器发送), 处理消息期间为两个;
+// 开启EnableWorkerPool后消息在共享worker中执行, 每个连接始终只有读循环一个goroutine
+type serialDispatcher struct {
+	tasks   chan func()
+	lock    sync.Mutex
+	running bool
+	wg      sync.WaitGroup
+}
+
+func newSerialDispatcher(size int) *serialDispatcher {
+	return &serialDispatcher{tasks: make(chan func(), size)}
+}
+
+func (d *serialDispatcher) run() {
+	for {
+		select {
+		case task := <-d.tasks:
+			task()
+			d.wg.Done()
+		default:
+			d.lock.Lock()
+			if len(d.tasks) == 0 {
+				d.running = false
+				d.lock.Unlock()
+				return
+			}
+			d.lock.Unlock()
+		}
+	}
+}
+
+func (d *serialDispatcher) submit(task func()) {
+	d.wg.Add(1)
+	d.tasks <- task
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+}
+
+func (d *serialDispatcher) wait() {
+	d.wg.Wait()
+}
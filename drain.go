@@ -0,0 +1,30 @@
+package dgws
+
+import (
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+)
+
+// ErrDraining 下线(drain)期间拒绝升级时默认返回的错误码
+var ErrDraining = dgerr.NewDgError(5013, "服务正在下线")
+
+// EnterDrainMode 滚动发布时使用, 即以ErrDraining进入维护状态: 所有路由拒绝新的升级请求, 已建立的连接保持到自然结束,
+// 可轮询ActiveConnections等待其归零后再退出进程; 响应见MaintenanceOptions, opts为nil时返回503, 调用ExitMaintenance退出
+func EnterDrainMode(opts *MaintenanceOptions) {
+	if opts == nil {
+		opts = &MaintenanceOptions{}
+	}
+	copied := *opts
+	copied.drain = true
+	maintenance.Store(&copied)
+}
+
+func IsDraining() bool {
+	opts := maintenance.Load()
+	return opts != nil && opts.drain
+}
+
+// ActiveConnections 通过准入检查、尚未结束处理的连接数, 即本实例连接数配额的占用;
+// 与ConnectionCount不同, 包括升级中和StartHandler执行中尚未注册的连接
+func ActiveConnections() int {
+	return globalQuota.utilization().Used
+}
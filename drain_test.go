@@ -0,0 +1,141 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-common/result"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrainMode(t *testing.T) {
+	t.Cleanup(dgws.ExitMaintenance)
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return dgws.GetSafeConn(ctx).WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	// 等待之前用例的连接结束
+	waitConnectionCount(t, 0)
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=drain", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	waitConnectionCount(t, 1)
+
+	dgws.EnterDrainMode(nil)
+	if !dgws.IsDraining() || dgws.GetMaintenance() == nil {
+		t.Fatal("should be draining on the maintenance switch")
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?bizId=late", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while draining, got %v", err)
+	}
+	rt := &result.Result[*dgws.MaintenanceInfo]{}
+	if err := json.NewDecoder(resp.Body).Decode(rt); err != nil || rt.Code != dgws.ErrDraining.Code || rt.Data == nil || !rt.Data.Drain {
+		t.Fatalf("unexpected drain result: %+v, %v", rt, err)
+	}
+
+	dgws.EnterDrainMode(&dgws.MaintenanceOptions{
+		StatusCode: http.StatusGone,
+		Body:       map[string]string{"redirect": "wss://next.example.com/ws"},
+		RetryAfter: 1500 * time.Millisecond,
+	})
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?bizId=late", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 while draining, got %v", err)
+	}
+	if resp.Header.Get("Retry-After") != "2" {
+		t.Fatalf("unexpected Retry-After: %q", resp.Header.Get("Retry-After"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var m map[string]string
+	if err := json.Unmarshal(body, &m); err != nil || m["redirect"] != "wss://next.example.com/ws" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// 已建立的连接不受影响
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("still alive")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "still alive" {
+		t.Fatalf("read: %s, %v", data, err)
+	}
+
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	_ = conn.Close()
+	waitConnectionCount(t, 0)
+
+	dgws.ExitMaintenance()
+	if dgws.IsDraining() {
+		t.Fatal("ExitMaintenance should exit drain mode")
+	}
+}
+
+func waitConnectionCount(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for dgws.ConnectionCount() != n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dgws.ConnectionCount() != n {
+		t.Fatalf("expected %d connections, got %d", n, dgws.ConnectionCount())
+	}
+}
+
+func TestActiveConnectionsIncludesStarting(t *testing.T) {
+	t.Cleanup(dgws.ExitMaintenance)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		StartHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *websocket.Conn) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		},
+	}, func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+		return nil
+	})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+
+	waitConnectionCount(t, 0)
+	waitActiveConnections(t, 0)
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=starting", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	<-started
+
+	// StartHandler执行中的连接尚未注册, 但drain仍需等待它
+	dgws.EnterDrainMode(nil)
+	if dgws.ConnectionCount() != 0 || dgws.ActiveConnections() != 1 {
+		t.Fatalf("expected 1 active and 0 registered, got %d and %d", dgws.ActiveConnections(), dgws.ConnectionCount())
+	}
+
+	close(release)
+	waitConnectionCount(t, 1)
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	waitActiveConnections(t, 0)
+}
+
+func waitActiveConnections(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for dgws.ActiveConnections() != n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dgws.ActiveConnections() != n {
+		t.Fatalf("expected %d active connections, got %d", n, dgws.ActiveConnections())
+	}
+}
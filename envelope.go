@@ -0,0 +1,72 @@
+package dgws
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	EnvelopeTypeSubscribe   = "subscribe"
+	EnvelopeTypeUnsubscribe = "unsubscribe"
+	EnvelopeTypePublish     = "publish"
+	EnvelopeTypeRequest     = "request"
+	EnvelopeTypeResponse    = "response"
+	EnvelopeTypeSession     = "session"
+	EnvelopeTypeChannel     = "channel"
+	EnvelopeTypeCredit      = "credit"
+)
+
+// Envelope 框架内置协议消息的统一结构, 以json文本帧传输
+type Envelope struct {
+	Type string `json:"type"`
+	Id   string `json:"id,omitempty"`
+	Seq  int64  `json:"seq,omitempty"`
+	// IdempotencyKey 客户端生成的幂等键, 重试同一业务操作时保持不变
+	IdempotencyKey string          `json:"idempotencyKey,omitempty"`
+	Topic          string          `json:"topic,omitempty"`
+	Channel        string          `json:"channel,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+func ParseEnvelope(data []byte) (*Envelope, bool) {
+	if len(data) == 0 || data[0] != '{' {
+		return nil, false
+	}
+
+	env := &Envelope{}
+	if err := json.Unmarshal(data, env); err != nil || env.Type == "" {
+		return nil, false
+	}
+
+	return env, true
+}
+
+// WriteEnvelope 经过Connection的写锁写入, 可与框架内的写入并发; conn为nil(连接尚未注册)时返回ErrConnectionClosed
+func WriteEnvelope(conn *Connection, env *Envelope) error {
+	if conn == nil {
+		return ErrConnectionClosed
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Reply 对request类型的消息回写response, conn一般为GetConnection(ctx)
+func Reply(conn *Connection, req *Envelope, data any, err error) error {
+	resp := &Envelope{Type: EnvelopeTypeResponse, Id: req.Id}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if data != nil {
+		bytes, merr := json.Marshal(data)
+		if merr != nil {
+			return merr
+		}
+		resp.Data = bytes
+	}
+
+	return WriteEnvelope(conn, resp)
+}
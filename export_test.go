@@ -0,0 +1,14 @@
+package dgws
+
+// ResetSessions 清空进程内的会话, 用于模拟进程重启
+func ResetSessions() {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	sessions = make(map[string]*serverSession)
+}
+
+// ResetShutdown 撤销Shutdown及其进入的drain状态, 之后的升级请求不再被拒绝
+func ResetShutdown() {
+	shuttingDown.Store(false)
+	ExitMaintenance()
+}
@@ -0,0 +1,30 @@
+package dgws
+
+import (
+	"github.com/gorilla/websocket"
+	"io"
+)
+
+// ForwardMessages 将src收到的消息逐帧流式转发给dst, 大消息无需整条缓存在内存中, 读写出错(包括收到关闭帧)时返回;
+// 转发期间dst不能有其他并发写入
+func ForwardMessages(dst *websocket.Conn, src *websocket.Conn) error {
+	for {
+		mt, r, err := src.NextReader()
+		if err != nil {
+			return err
+		}
+
+		w, err := dst.NextWriter(mt)
+		if err != nil {
+			return err
+		}
+		// gorilla的消息writer实现了ReaderFrom, 数据直接读入帧缓冲区, 写满即作为分片发出
+		if _, err := io.Copy(w, r); err != nil {
+			_ = w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dgws_test
+
+import (
+	"bytes"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardMessages(t *testing.T) {
+	backendUrl := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return wsm.Connection.WriteMessage(wsm.MessageType, wsm.MessageData)
+	})
+
+	upgrader := websocket.Upgrader{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		backend, _, err := websocket.DefaultDialer.Dial(backendUrl, nil)
+		if err != nil {
+			return
+		}
+		defer backend.Close()
+
+		go func() {
+			_ = dgws.ForwardMessages(client, backend)
+		}()
+		_ = dgws.ForwardMessages(backend, client)
+	}))
+	t.Cleanup(proxy.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	payload := bytes.Repeat([]byte{1, 2, 3, 4}, 256*1024)
+	if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	mt, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if mt != websocket.BinaryMessage || !bytes.Equal(data, payload) {
+		t.Fatalf("forwarded message mismatch, type: %d, size: %d", mt, len(data))
+	}
+}
@@ -0,0 +1,190 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDnsSrvInterval = 30 * time.Second
+
+var ErrNoUpstream = errors.New("websocket forward has no available upstream")
+
+// UpstreamResolver 转发目标的服务发现, 内置StaticResolver和DnsSrvResolver; Nacos、Consul、etcd等可按此接口接入
+type UpstreamResolver interface {
+	// Watch 先同步回调一次当前的地址列表, 之后在后台于列表变化时回调, ctx取消时停止
+	Watch(ctx context.Context, onChange func(addrs []string)) error
+}
+
+// StaticResolver 固定的地址列表
+type StaticResolver []string
+
+func (r StaticResolver) Watch(_ context.Context, onChange func(addrs []string)) error {
+	onChange(slices.Clone(r))
+	return nil
+}
+
+// DnsSrvResolver 按Interval轮询SRV记录, 地址为Scheme://target:port+Path
+type DnsSrvResolver struct {
+	Service string
+	Proto   string
+	Name    string
+	// Scheme 默认ws
+	Scheme string
+	Path   string
+	// Interval 默认30秒
+	Interval time.Duration
+	// Resolver 默认net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+func (r *DnsSrvResolver) Watch(ctx context.Context, onChange func(addrs []string)) error {
+	addrs, err := r.lookup(ctx)
+	if err != nil {
+		return err
+	}
+	onChange(addrs)
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultDnsSrvInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				next, err := r.lookup(ctx)
+				if err != nil {
+					// 查询失败时保留上一次的结果
+					continue
+				}
+				if !slices.Equal(next, addrs) {
+					addrs = next
+					onChange(addrs)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *DnsSrvResolver) lookup(ctx context.Context) ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "ws"
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		addrs = append(addrs, fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port)), r.Path))
+	}
+	slices.Sort(addrs)
+
+	return addrs, nil
+}
+
+// ForwardUpstreams 持续跟踪resolver给出的转发目标, 上游扩缩容或迁移时无需重启网关
+type ForwardUpstreams struct {
+	ctx    *dgctx.DgContext
+	lock   sync.RWMutex
+	addrs  []string
+	next   atomic.Uint64
+	cancel context.CancelFunc
+}
+
+// NewForwardUpstreams 首次解析失败时返回错误, 之后的更新在后台进行, 不再需要时调用Close
+func NewForwardUpstreams(ctx *dgctx.DgContext, resolver UpstreamResolver) (*ForwardUpstreams, error) {
+	watchCtx, cancel := context.WithCancel(innerContext(ctx))
+	u := &ForwardUpstreams{ctx: ctx, cancel: cancel}
+	if err := resolver.Watch(watchCtx, u.update); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (u *ForwardUpstreams) update(addrs []string) {
+	u.lock.Lock()
+	u.addrs = slices.Clone(addrs)
+	u.lock.Unlock()
+	dglogger.Infof(u.ctx, "websocket forward upstreams updated: %v", addrs)
+}
+
+func (u *ForwardUpstreams) Addrs() []string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+	return slices.Clone(u.addrs)
+}
+
+// Pick key为空时轮询, 否则按key哈希, 使同一key尽量落到同一上游
+func (u *ForwardUpstreams) Pick(key string) (string, error) {
+	addrs := u.Addrs()
+	if len(addrs) == 0 {
+		return "", ErrNoUpstream
+	}
+
+	return addrs[u.index(key, len(addrs))], nil
+}
+
+func (u *ForwardUpstreams) index(key string, n int) int {
+	if key == "" {
+		return int(u.next.Add(1) % uint64(n))
+	}
+	return int(crc32.ChecksumIEEE([]byte(key)) % uint32(n))
+}
+
+func (u *ForwardUpstreams) Close() {
+	u.cancel()
+}
+
+// DialForward 从upstreams中选择上游建立转发连接并记录到forwardMark, 失败时依次尝试其他上游
+func DialForward(ctx *dgctx.DgContext, forwardMark string, upstreams *ForwardUpstreams, key string, header http.Header) (*websocket.Conn, error) {
+	addrs := upstreams.Addrs()
+	if len(addrs) == 0 {
+		return nil, ErrNoUpstream
+	}
+
+	start := upstreams.index(key, len(addrs))
+	var lastErr error
+	for i := range addrs {
+		addr := addrs[(start+i)%len(addrs)]
+		conn, _, err := websocket.DefaultDialer.DialContext(innerContext(ctx), addr, header)
+		if err != nil {
+			dglogger.Warnf(ctx, "dial forward upstream %s error: %v", addr, err)
+			lastErr = err
+			continue
+		}
+
+		SetForwardConn(ctx, forwardMark, conn)
+		SetForwardConnTimestamp(ctx, forwardMark, time.Now().UnixMilli())
+		UnsetForwardWsEnded(ctx, forwardMark)
+		return conn, nil
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,110 @@
+package dgws_test
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net"
+	"testing"
+	"time"
+)
+
+// chanResolver 模拟注册中心推送的地址变化
+type chanResolver struct {
+	initial []string
+	updates chan []string
+}
+
+func (r *chanResolver) Watch(ctx context.Context, onChange func(addrs []string)) error {
+	onChange(r.initial)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case addrs := <-r.updates:
+				onChange(addrs)
+			}
+		}
+	}()
+	return nil
+}
+
+func TestForwardUpstreamsWatch(t *testing.T) {
+	resolver := &chanResolver{initial: []string{"ws://a"}, updates: make(chan []string)}
+	upstreams, err := dgws.NewForwardUpstreams(&dgctx.DgContext{TraceId: uuid.NewString()}, resolver)
+	if err != nil {
+		t.Fatalf("new upstreams: %v", err)
+	}
+	defer upstreams.Close()
+
+	if addr, err := upstreams.Pick(""); err != nil || addr != "ws://a" {
+		t.Fatalf("pick: %s, %v", addr, err)
+	}
+	resolver.updates <- []string{"ws://b", "ws://c"}
+	deadline := time.Now().Add(3 * time.Second)
+	for len(upstreams.Addrs()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("upstreams not updated: %v", upstreams.Addrs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	first, _ := upstreams.Pick("user-1")
+	for i := 0; i < 10; i++ {
+		if addr, _ := upstreams.Pick("user-1"); addr != first {
+			t.Fatalf("same key picked different upstreams: %s, %s", first, addr)
+		}
+	}
+
+	resolver.updates <- nil
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		if _, err := upstreams.Pick(""); err == dgws.ErrNoUpstream {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected no upstream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDialForwardFailover(t *testing.T) {
+	live := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return wsm.Connection.WriteMessage(wsm.MessageType, wsm.MessageData)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	dead := "ws://" + listener.Addr().String() + "/ws"
+	_ = listener.Close()
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	upstreams, err := dgws.NewForwardUpstreams(ctx, dgws.StaticResolver{dead, live})
+	if err != nil {
+		t.Fatalf("new upstreams: %v", err)
+	}
+	defer upstreams.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := dgws.DialForward(ctx, "backend", upstreams, "", nil)
+		if err != nil {
+			t.Fatalf("dial forward: %v", err)
+		}
+		if dgws.GetForwardConn(ctx, "backend") != conn || dgws.IsForwardWsEnded(ctx, "backend") {
+			t.Fatal("forward conn not recorded")
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "ping" {
+			t.Fatalf("read: %s, %v", string(data), err)
+		}
+		_ = conn.Close()
+	}
+}
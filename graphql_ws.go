@@ -0,0 +1,329 @@
+package dgws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+// GraphQLTransportWSProtocol graphql-ws库(Apollo等客户端)使用的子协议
+const GraphQLTransportWSProtocol = "graphql-transport-ws"
+
+const (
+	GraphQLMessageConnectionInit = "connection_init"
+	GraphQLMessageConnectionAck  = "connection_ack"
+	GraphQLMessagePing           = "ping"
+	GraphQLMessagePong           = "pong"
+	GraphQLMessageSubscribe      = "subscribe"
+	GraphQLMessageNext           = "next"
+	GraphQLMessageError          = "error"
+	GraphQLMessageComplete       = "complete"
+)
+
+// graphql-transport-ws规定的关闭码
+const (
+	GraphQLCloseBadRequest       = 4400
+	GraphQLCloseUnauthorized     = 4401
+	GraphQLCloseForbidden        = 4403
+	GraphQLCloseInitTimeout      = 4408
+	GraphQLCloseSubscriberExists = 4409
+	GraphQLCloseTooManyInit      = 4429
+)
+
+const (
+	graphqlStateKey           = "WsGraphQLState"
+	defaultGraphQLInitTimeout = 3 * time.Second
+)
+
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+type GraphQLError struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+type GraphQLResult struct {
+	Data       any             `json:"data,omitempty"`
+	Errors     []*GraphQLError `json:"errors,omitempty"`
+	Extensions map[string]any  `json:"extensions,omitempty"`
+}
+
+// GraphQLResolver 执行一次subscribe, ctx在客户端发送complete或连接断开时取消;
+// 返回的channel关闭后向客户端发送complete, query和mutation发送一个结果后关闭即可; 返回错误时以error消息通知客户端
+type GraphQLResolver interface {
+	Subscribe(ctx context.Context, dgCtx *dgctx.DgContext, id string, req *GraphQLRequest) (<-chan *GraphQLResult, error)
+}
+
+type GraphQLResolverFunc func(ctx context.Context, dgCtx *dgctx.DgContext, id string, req *GraphQLRequest) (<-chan *GraphQLResult, error)
+
+func (f GraphQLResolverFunc) Subscribe(ctx context.Context, dgCtx *dgctx.DgContext, id string, req *GraphQLRequest) (<-chan *GraphQLResult, error) {
+	return f(ctx, dgCtx, id, req)
+}
+
+type GraphQLWSOptions struct {
+	Resolver GraphQLResolver
+	// OnInit 校验connection_init的payload(通常为鉴权信息), 返回值随connection_ack发送, 返回错误时以4403关闭连接
+	OnInit func(ctx *dgctx.DgContext, payload json.RawMessage) (any, error)
+	// InitTimeout 连接后未在该时间内完成connection_init时以4408关闭连接, 默认3s
+	InitTimeout time.Duration
+}
+
+type graphqlMessage struct {
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type graphqlSubscription struct {
+	cancel context.CancelFunc
+}
+
+type graphqlState struct {
+	lock         sync.Mutex
+	initReceived bool
+	acked        bool
+	ended        bool
+	subs         map[string]*graphqlSubscription
+}
+
+// GraphQLWS 返回实现graphql-transport-ws子协议的BizHandler, 同时在conf中登记子协议并包装StartHandler和StateChangeHandler
+// (原有的回调仍会执行), 需在Get之前调用:
+//
+//	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{BizHandler: dgws.GraphQLWS(conf, opts), ...}, conf)
+func GraphQLWS(conf *WebSocketHandlerConfig, opts *GraphQLWSOptions) wrapper.HandlerFunc[WebSocketMessage, error] {
+	initTimeout := opts.InitTimeout
+	if initTimeout <= 0 {
+		initTimeout = defaultGraphQLInitTimeout
+	}
+	conf.Subprotocols = append(conf.Subprotocols, GraphQLTransportWSProtocol)
+
+	startHandler := conf.StartHandler
+	conf.StartHandler = func(c *gin.Context, ctx *dgctx.DgContext, conn *websocket.Conn) error {
+		if startHandler != nil {
+			if err := startHandler(c, ctx, conn); err != nil {
+				return err
+			}
+		}
+		state := &graphqlState{subs: make(map[string]*graphqlSubscription)}
+		GetConnState(ctx).values.Store(graphqlStateKey, state)
+		time.AfterFunc(initTimeout, func() {
+			state.lock.Lock()
+			acked := state.acked || state.ended
+			state.lock.Unlock()
+			if !acked {
+				closeGraphQL(ctx, GraphQLCloseInitTimeout, "Connection initialisation timeout")
+			}
+		})
+		return nil
+	}
+
+	stateChangeHandler := conf.StateChangeHandler
+	conf.StateChangeHandler = func(ctx *dgctx.DgContext, from ConnectionState, to ConnectionState) {
+		if to >= ConnectionStateDraining {
+			if state := getGraphQLState(ctx); state != nil {
+				state.end()
+			}
+		}
+		if stateChangeHandler != nil {
+			stateChangeHandler(ctx, from, to)
+		}
+	}
+
+	return func(_ *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) error {
+		state := getGraphQLState(ctx)
+		msg := &graphqlMessage{}
+		if state == nil || wsm.MessageType != websocket.TextMessage || json.Unmarshal(wsm.MessageData, msg) != nil {
+			closeGraphQL(ctx, GraphQLCloseBadRequest, "Invalid message received")
+			return nil
+		}
+
+		switch msg.Type {
+		case GraphQLMessageConnectionInit:
+			return state.init(ctx, opts, msg.Payload)
+		case GraphQLMessagePing:
+			return writeGraphQL(ctx, &graphqlMessage{Type: GraphQLMessagePong, Payload: msg.Payload})
+		case GraphQLMessagePong:
+			return nil
+		case GraphQLMessageSubscribe:
+			state.subscribe(ctx, opts.Resolver, msg)
+			return nil
+		case GraphQLMessageComplete:
+			state.complete(msg.Id)
+			return nil
+		default:
+			closeGraphQL(ctx, GraphQLCloseBadRequest, "Invalid message received")
+			return nil
+		}
+	}
+}
+
+func getGraphQLState(ctx *dgctx.DgContext) *graphqlState {
+	value, _ := GetConnState(ctx).values.Load(graphqlStateKey)
+	state, _ := value.(*graphqlState)
+	return state
+}
+
+func (s *graphqlState) init(ctx *dgctx.DgContext, opts *GraphQLWSOptions, payload json.RawMessage) error {
+	s.lock.Lock()
+	duplicate := s.initReceived
+	s.initReceived = true
+	s.lock.Unlock()
+	if duplicate {
+		closeGraphQL(ctx, GraphQLCloseTooManyInit, "Too many initialisation requests")
+		return nil
+	}
+
+	var ackPayload any
+	if opts.OnInit != nil {
+		var err error
+		if ackPayload, err = opts.OnInit(ctx, payload); err != nil {
+			closeGraphQL(ctx, GraphQLCloseForbidden, "Forbidden")
+			return err
+		}
+	}
+	ack := &graphqlMessage{Type: GraphQLMessageConnectionAck}
+	if ackPayload != nil {
+		data, err := json.Marshal(ackPayload)
+		if err != nil {
+			return err
+		}
+		ack.Payload = data
+	}
+
+	s.lock.Lock()
+	s.acked = true
+	s.lock.Unlock()
+	return writeGraphQL(ctx, ack)
+}
+
+func (s *graphqlState) subscribe(ctx *dgctx.DgContext, resolver GraphQLResolver, msg *graphqlMessage) {
+	req := &GraphQLRequest{}
+	if msg.Id == "" || json.Unmarshal(msg.Payload, req) != nil {
+		closeGraphQL(ctx, GraphQLCloseBadRequest, "Invalid message received")
+		return
+	}
+
+	s.lock.Lock()
+	if !s.acked {
+		s.lock.Unlock()
+		closeGraphQL(ctx, GraphQLCloseUnauthorized, "Unauthorized")
+		return
+	}
+	if _, ok := s.subs[msg.Id]; ok {
+		s.lock.Unlock()
+		closeGraphQL(ctx, GraphQLCloseSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.Id))
+		return
+	}
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	subCtx, cancel := context.WithCancel(context.Background())
+	sub := &graphqlSubscription{cancel: cancel}
+	s.subs[msg.Id] = sub
+	s.lock.Unlock()
+
+	go s.run(subCtx, ctx, resolver, msg.Id, req, sub)
+}
+
+// run 客户端complete或连接断开导致的结束不再发送complete
+func (s *graphqlState) run(subCtx context.Context, ctx *dgctx.DgContext, resolver GraphQLResolver, id string, req *GraphQLRequest, sub *graphqlSubscription) {
+	defer s.remove(id, sub)
+	results, err := resolver.Subscribe(subCtx, ctx, id, req)
+	if err != nil {
+		if subCtx.Err() == nil {
+			payload, _ := json.Marshal([]*GraphQLError{{Message: err.Error()}})
+			if err := writeGraphQL(ctx, &graphqlMessage{Id: id, Type: GraphQLMessageError, Payload: payload}); err != nil {
+				dglogger.Warnf(ctx, "write graphql error of %s error: %v", id, err)
+			}
+		}
+		return
+	}
+
+	for {
+		select {
+		case <-subCtx.Done():
+			return
+		case result, ok := <-results:
+			if !ok {
+				if subCtx.Err() == nil {
+					_ = writeGraphQL(ctx, &graphqlMessage{Id: id, Type: GraphQLMessageComplete})
+				}
+				return
+			}
+			payload, err := json.Marshal(result)
+			if err != nil {
+				dglogger.Errorf(ctx, "marshal graphql result of %s error: %v", id, err)
+				continue
+			}
+			if err := writeGraphQL(ctx, &graphqlMessage{Id: id, Type: GraphQLMessageNext, Payload: payload}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *graphqlState) complete(id string) {
+	s.lock.Lock()
+	sub := s.subs[id]
+	delete(s.subs, id)
+	s.lock.Unlock()
+	if sub != nil {
+		sub.cancel()
+	}
+}
+
+// remove id可能已被complete后重新subscribe, 只删除自己
+func (s *graphqlState) remove(id string, sub *graphqlSubscription) {
+	s.lock.Lock()
+	if s.subs[id] == sub {
+		delete(s.subs, id)
+	}
+	s.lock.Unlock()
+	sub.cancel()
+}
+
+func (s *graphqlState) end() {
+	s.lock.Lock()
+	s.ended = true
+	subs := s.subs
+	s.subs = make(map[string]*graphqlSubscription)
+	s.lock.Unlock()
+	for _, sub := range subs {
+		sub.cancel()
+	}
+}
+
+func writeGraphQL(ctx *dgctx.DgContext, msg *graphqlMessage) error {
+	connection := GetConnection(ctx)
+	if connection == nil {
+		return ErrConnectionClosed
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return connection.WriteMessage(websocket.TextMessage, data)
+}
+
+func closeGraphQL(ctx *dgctx.DgContext, code int, reason string) {
+	if connection := GetConnection(ctx); connection != nil {
+		connection.closeWith(code, reason)
+	} else if conn := GetConn(ctx); conn != nil {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		_ = conn.NetConn().Close()
+	}
+}
@@ -0,0 +1,151 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+type graphqlTestMessage struct {
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func startGraphQLServer(t *testing.T, opts *dgws.GraphQLWSOptions) string {
+	conf := &dgws.WebSocketHandlerConfig{}
+	bizHandler := dgws.GraphQLWS(conf, opts)
+	return startTestServerWithConfig(t, conf, bizHandler)
+}
+
+func dialGraphQL(t *testing.T, url string) *websocket.Conn {
+	dialer := &websocket.Dialer{Subprotocols: []string{dgws.GraphQLTransportWSProtocol}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if conn.Subprotocol() != dgws.GraphQLTransportWSProtocol {
+		t.Fatalf("unexpected subprotocol: %q", conn.Subprotocol())
+	}
+	return conn
+}
+
+func writeGraphQLMessage(t *testing.T, conn *websocket.Conn, msg string) {
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func readGraphQLMessage(t *testing.T, conn *websocket.Conn) *graphqlTestMessage {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	msg := &graphqlTestMessage{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+	return msg
+}
+
+func TestGraphQLWSSubscribe(t *testing.T) {
+	cancelled := make(chan string, 1)
+	url := startGraphQLServer(t, &dgws.GraphQLWSOptions{
+		OnInit: func(_ *dgctx.DgContext, payload json.RawMessage) (any, error) {
+			if string(payload) != `{"token":"ok"}` {
+				return nil, errors.New("bad token")
+			}
+			return map[string]string{"user": "u1"}, nil
+		},
+		Resolver: dgws.GraphQLResolverFunc(func(ctx context.Context, _ *dgctx.DgContext, id string, req *dgws.GraphQLRequest) (<-chan *dgws.GraphQLResult, error) {
+			if req.Query == "bad" {
+				return nil, errors.New("syntax error")
+			}
+			results := make(chan *dgws.GraphQLResult)
+			go func() {
+				defer close(results)
+				for i := 1; req.OperationName != "forever" && i <= 3; i++ {
+					results <- &dgws.GraphQLResult{Data: map[string]int{"count": i}}
+				}
+				if req.OperationName == "forever" {
+					<-ctx.Done()
+					cancelled <- id
+				}
+			}()
+			return results, nil
+		}),
+	})
+	conn := dialGraphQL(t, url)
+
+	writeGraphQLMessage(t, conn, `{"type":"connection_init","payload":{"token":"ok"}}`)
+	if msg := readGraphQLMessage(t, conn); msg.Type != dgws.GraphQLMessageConnectionAck || string(msg.Payload) != `{"user":"u1"}` {
+		t.Fatalf("unexpected ack: %+v", msg)
+	}
+	writeGraphQLMessage(t, conn, `{"type":"ping"}`)
+	if msg := readGraphQLMessage(t, conn); msg.Type != dgws.GraphQLMessagePong {
+		t.Fatalf("unexpected pong: %+v", msg)
+	}
+
+	writeGraphQLMessage(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`)
+	for i := 1; i <= 3; i++ {
+		msg := readGraphQLMessage(t, conn)
+		if msg.Id != "1" || msg.Type != dgws.GraphQLMessageNext || string(msg.Payload) != fmt.Sprintf(`{"data":{"count":%d}}`, i) {
+			t.Fatalf("unexpected next #%d: %+v", i, msg)
+		}
+	}
+	if msg := readGraphQLMessage(t, conn); msg.Id != "1" || msg.Type != dgws.GraphQLMessageComplete {
+		t.Fatalf("unexpected complete: %+v", msg)
+	}
+
+	writeGraphQLMessage(t, conn, `{"id":"2","type":"subscribe","payload":{"query":"bad"}}`)
+	if msg := readGraphQLMessage(t, conn); msg.Id != "2" || msg.Type != dgws.GraphQLMessageError || string(msg.Payload) != `[{"message":"syntax error"}]` {
+		t.Fatalf("unexpected error: %+v", msg)
+	}
+
+	writeGraphQLMessage(t, conn, `{"id":"3","type":"subscribe","payload":{"query":"subscription { tick }","operationName":"forever"}}`)
+	writeGraphQLMessage(t, conn, `{"id":"3","type":"subscribe","payload":{"query":"subscription { tick }","operationName":"forever"}}`)
+	expectClosed(t, conn, dgws.GraphQLCloseSubscriberExists)
+	select {
+	case id := <-cancelled:
+		if id != "3" {
+			t.Fatalf("unexpected cancelled subscription: %s", id)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("subscription not cancelled after connection closed")
+	}
+}
+
+func TestGraphQLWSProtocolErrors(t *testing.T) {
+	url := startGraphQLServer(t, &dgws.GraphQLWSOptions{
+		InitTimeout: 100 * time.Millisecond,
+		Resolver: dgws.GraphQLResolverFunc(func(context.Context, *dgctx.DgContext, string, *dgws.GraphQLRequest) (<-chan *dgws.GraphQLResult, error) {
+			return nil, errors.New("unexpected")
+		}),
+	})
+
+	conn := dialGraphQL(t, url)
+	writeGraphQLMessage(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"{ a }"}}`)
+	expectClosed(t, conn, dgws.GraphQLCloseUnauthorized)
+
+	conn = dialGraphQL(t, url)
+	writeGraphQLMessage(t, conn, `{"type":"connection_init"}`)
+	readGraphQLMessage(t, conn)
+	writeGraphQLMessage(t, conn, `{"type":"connection_init"}`)
+	expectClosed(t, conn, dgws.GraphQLCloseTooManyInit)
+
+	conn = dialGraphQL(t, url)
+	writeGraphQLMessage(t, conn, `not json`)
+	expectClosed(t, conn, dgws.GraphQLCloseBadRequest)
+
+	conn = dialGraphQL(t, url)
+	expectClosed(t, conn, dgws.GraphQLCloseInitTimeout)
+}
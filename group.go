@@ -0,0 +1,106 @@
+package dgws
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// EnvelopeTypeGroup 一组消息合并为一个信封以单帧发送, 客户端要么收到全部, 要么一条都收不到
+const EnvelopeTypeGroup = "group"
+
+var (
+	ErrGroupCommitted  = errors.New("websocket message group already committed or rolled back")
+	ErrGroupRolledBack = errors.New("websocket message group rolled back")
+)
+
+// GroupMessage 消息组中的一条消息, Data在json中为base64编码
+type GroupMessage struct {
+	MessageType int    `json:"mt"`
+	Data        []byte `json:"data"`
+}
+
+// MessageGroup 事务式发送, Add的消息先缓冲, Commit时一次性写出, Rollback或Add出错时全部丢弃
+type MessageGroup struct {
+	conn     *Connection
+	lock     sync.Mutex
+	messages []GroupMessage
+	err      error
+	done     bool
+}
+
+func (c *Connection) BeginGroup() *MessageGroup {
+	return &MessageGroup{conn: c}
+}
+
+func (g *MessageGroup) Add(mt int, data []byte) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.done {
+		return ErrGroupCommitted
+	}
+	if mt != websocket.TextMessage && mt != websocket.BinaryMessage {
+		g.err = errors.New("websocket message group only accepts text or binary messages")
+		return g.err
+	}
+
+	g.messages = append(g.messages, GroupMessage{MessageType: mt, Data: data})
+	return nil
+}
+
+// AddJSON 编码失败时整个组被标记为失败, Commit不会发送任何消息
+func (g *MessageGroup) AddJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		g.lock.Lock()
+		g.err = err
+		g.lock.Unlock()
+		return err
+	}
+
+	return g.Add(websocket.TextMessage, data)
+}
+
+func (g *MessageGroup) Len() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return len(g.messages)
+}
+
+func (g *MessageGroup) Rollback() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.messages = nil
+	g.done = true
+}
+
+// Commit 将缓冲的消息作为一帧group信封写出; 组内此前有Add失败时回滚并返回该错误
+func (g *MessageGroup) Commit() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.done {
+		return ErrGroupCommitted
+	}
+	g.done = true
+	messages := g.messages
+	g.messages = nil
+
+	if g.err != nil {
+		return errors.Join(ErrGroupRolledBack, g.err)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(messages)
+	if err != nil {
+		return errors.Join(ErrGroupRolledBack, err)
+	}
+	data, err := json.Marshal(&Envelope{Type: EnvelopeTypeGroup, Data: raw})
+	if err != nil {
+		return errors.Join(ErrGroupRolledBack, err)
+	}
+
+	return g.conn.WriteMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,115 @@
+package dgws_test
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMessageGroup(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		connection := dgws.GetConnection(ctx)
+
+		failed := connection.BeginGroup()
+		_ = failed.Add(websocket.TextMessage, []byte("partial"))
+		if err := failed.AddJSON(math.NaN()); err == nil {
+			return errors.New("expected json error")
+		}
+		if err := failed.Commit(); !errors.Is(err, dgws.ErrGroupRolledBack) {
+			return errors.New("failed group not rolled back")
+		}
+
+		discarded := connection.BeginGroup()
+		_ = discarded.Add(websocket.TextMessage, []byte("discarded"))
+		discarded.Rollback()
+		if err := discarded.Commit(); !errors.Is(err, dgws.ErrGroupCommitted) {
+			return errors.New("rolled back group committed")
+		}
+
+		group := connection.BeginGroup()
+		_ = group.Add(websocket.TextMessage, []byte("part1"))
+		_ = group.AddJSON(&testData{Content: string(wsm.MessageData)})
+		_ = group.Add(websocket.BinaryMessage, []byte{1, 2})
+		return group.Commit()
+	})
+
+	groups := make(chan []dgws.GroupMessage, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		GroupHandler: func(_ *dgctx.DgContext, messages []dgws.GroupMessage) error {
+			groups <- messages
+			return nil
+		},
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			t.Errorf("unexpected message outside group: %s", string(data))
+			return nil
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case messages := <-groups:
+		if len(messages) != 3 {
+			t.Fatalf("expected 3 messages, got %d", len(messages))
+		}
+		if string(messages[0].Data) != "part1" || string(messages[1].Data) != `{"content":"hi"}` {
+			t.Fatalf("unexpected group: %+v", messages)
+		}
+		if messages[2].MessageType != websocket.BinaryMessage || len(messages[2].Data) != 2 {
+			t.Fatalf("unexpected binary part: %+v", messages[2])
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message group not received")
+	}
+
+	select {
+	case messages := <-groups:
+		t.Fatalf("unexpected extra group: %+v", messages)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMessageGroupFallsBackToMessageHandler(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		group := dgws.GetConnection(ctx).BeginGroup()
+		_ = group.Add(websocket.TextMessage, []byte("a"))
+		_ = group.Add(websocket.TextMessage, []byte("b"))
+		return group.Commit()
+	})
+
+	received := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			received <- string(data)
+			return nil
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, want := range []string{"a", "b"} {
+		select {
+		case msg := <-received:
+			if msg != want {
+				t.Fatalf("expected %s, got %s", want, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("group message %s not received", want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IdempotencyCache 以幂等键缓存已发送的响应, 重复请求直接返回缓存的响应而不再执行BizHandler
+type IdempotencyCache interface {
+	Get(ctx *dgctx.DgContext, key string) ([]byte, bool, error)
+	Set(ctx *dgctx.DgContext, key string, response []byte, ttl time.Duration) error
+}
+
+// IdempotencyOptions 解析Envelope中的IdempotencyKey, BizHandler中通过GetIdempotencyKey获取;
+// Cache不为nil时通过Respond发送的响应会被缓存, 相同幂等键的请求直接返回该响应
+type IdempotencyOptions struct {
+	Cache IdempotencyCache
+	Ttl   time.Duration
+}
+
+// idempotencyState 连接级的幂等配置, 连接建立时设置; 当前消息的幂等键保存在该消息的DgContext上
+type idempotencyState struct {
+	opts   *IdempotencyOptions
+	bizKey string
+}
+
+// idempotencyKeyExtra 消息DgContext上保存幂等键的extra key
+const idempotencyKeyExtra = "WsIdempotencyKey"
+
+func (o *IdempotencyOptions) ttl() time.Duration {
+	if o.Ttl > 0 {
+		return o.Ttl
+	}
+	return defaultDedupTtl
+}
+
+// GetIdempotencyKey 当前正在处理的消息的幂等键, ctx需为BizHandler收到的ctx
+func GetIdempotencyKey(ctx *dgctx.DgContext) string {
+	key, _ := ctx.GetExtraValue(idempotencyKeyExtra).(string)
+	return key
+}
+
+// Respond 与Reply相同, 但通过Connection串行写出, 请求带幂等键时缓存该响应
+func Respond(ctx *dgctx.DgContext, req *Envelope, data any, err error) error {
+	resp := &Envelope{Type: EnvelopeTypeResponse, Id: req.Id}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if data != nil {
+		raw, merr := json.Marshal(data)
+		if merr != nil {
+			return merr
+		}
+		resp.Data = raw
+	}
+	msg, merr := json.Marshal(resp)
+	if merr != nil {
+		return merr
+	}
+
+	state := GetConnState(ctx)
+	if s := state.idempotency.Load(); s != nil && s.opts.Cache != nil && req.IdempotencyKey != "" {
+		if err := s.opts.Cache.Set(ctx, idempotencyCacheKey(ctx, s.bizKey, req.IdempotencyKey), msg, s.opts.ttl()); err != nil {
+			dglogger.Warnf(ctx, "[%s] cache idempotent response error: %v", req.IdempotencyKey, err)
+		}
+	}
+
+	return state.connection.Load().WriteMessage(websocket.TextMessage, msg)
+}
+
+func idempotencyCacheKey(ctx *dgctx.DgContext, bizKey string, key string) string {
+	return bizKey + ":" + strconv.FormatInt(ctx.UserId, 10) + ":" + key
+}
+
+// beginIdempotent 返回当前消息的幂等键, 命中缓存时直接回写缓存的响应并返回true;
+// 同一连接的消息可能并行处理, 幂等键由调用方保存到该消息的DgContext上, 见newMessageContext
+func beginIdempotent(ctx *dgctx.DgContext, opts *IdempotencyOptions, bizKey string, bizId string, wsm *WebSocketMessage) (string, bool) {
+	if wsm.MessageType != websocket.TextMessage {
+		return "", false
+	}
+	env, ok := ParseEnvelope(wsm.MessageData)
+	if !ok || env.IdempotencyKey == "" {
+		return "", false
+	}
+
+	state := GetConnState(ctx)
+	if opts.Cache != nil {
+		resp, hit, err := opts.Cache.Get(ctx, idempotencyCacheKey(ctx, bizKey, env.IdempotencyKey))
+		if err != nil {
+			dglogger.Warnf(ctx, "[%s: %s] get idempotent response %s error: %v", bizKey, bizId, env.IdempotencyKey, err)
+		} else if hit {
+			dglogger.Infof(ctx, "[%s: %s] return cached response for idempotency key %s", bizKey, bizId, env.IdempotencyKey)
+			// 重试的请求可能使用了新的请求id, 响应id需与本次请求一致
+			if cached, ok := ParseEnvelope(resp); ok && cached.Id != env.Id {
+				cached.Id = env.Id
+				if msg, err := json.Marshal(cached); err == nil {
+					resp = msg
+				}
+			}
+			if err := state.connection.Load().WriteMessage(websocket.TextMessage, resp); err != nil {
+				dglogger.Warnf(ctx, "[%s: %s] write cached response error: %v", bizKey, bizId, err)
+			}
+			return env.IdempotencyKey, true
+		}
+	}
+
+	return env.IdempotencyKey, false
+}
+
+// MemoryIdempotencyCache 进程内缓存, 仅用于测试或单机场景
+type MemoryIdempotencyCache struct {
+	lock    sync.Mutex
+	entries map[string]*memoryIdempotencyEntry
+}
+
+type memoryIdempotencyEntry struct {
+	response  []byte
+	expiresAt time.Time
+}
+
+func NewMemoryIdempotencyCache() *MemoryIdempotencyCache {
+	return &MemoryIdempotencyCache{entries: make(map[string]*memoryIdempotencyEntry)}
+}
+
+func (c *MemoryIdempotencyCache) Get(_ *dgctx.DgContext, key string) ([]byte, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := c.entries[key]
+	if entry == nil {
+		return nil, false, nil
+	}
+	if !entry.expiresAt.After(time.Now()) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+
+	return entry.response, true, nil
+}
+
+func (c *MemoryIdempotencyCache) Set(_ *dgctx.DgContext, key string, response []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = &memoryIdempotencyEntry{response: response, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
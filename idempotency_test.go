@@ -0,0 +1,113 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotentResponse(t *testing.T) {
+	var calls atomic.Int32
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Idempotency: &dgws.IdempotencyOptions{Cache: dgws.NewMemoryIdempotencyCache(), Ttl: time.Minute},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		req, _ := dgws.ParseEnvelope(wsm.MessageData)
+		n := calls.Add(1)
+		return dgws.Respond(ctx, req, &testData{Content: dgws.GetIdempotencyKey(ctx) + "-" + string(rune('0'+n))}, nil)
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	call := func(id string, key string) *testData {
+		data, _ := json.Marshal(&dgws.Envelope{Type: dgws.EnvelopeTypeRequest, Id: id, IdempotencyKey: key})
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		resp := readEnvelope(t, conn)
+		if resp.Id != id {
+			t.Fatalf("response id %s, expected %s", resp.Id, id)
+		}
+		result := &testData{}
+		_ = json.Unmarshal(resp.Data, result)
+		return result
+	}
+
+	if r := call("1", "order-1"); r.Content != "order-1-1" {
+		t.Fatalf("unexpected first response: %s", r.Content)
+	}
+	if r := call("2", "order-1"); r.Content != "order-1-1" {
+		t.Fatalf("duplicate should return cached response, got %s", r.Content)
+	}
+	if r := call("3", "order-2"); r.Content != "order-2-2" {
+		t.Fatalf("unexpected response for new key: %s", r.Content)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("biz handler called %d times", calls.Load())
+	}
+}
+
+func TestIdempotentResponseConcurrent(t *testing.T) {
+	dgws.InitWorkerPool(4, 64)
+
+	// 两条消息的BizHandler都开始执行后才返回, 保证它们并行处理
+	var entered sync.WaitGroup
+	entered.Add(2)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		EnableWorkerPool: true,
+		PartitionKey:     dgws.PartitionByEnvelopeField("id"),
+		Idempotency:      &dgws.IdempotencyOptions{Cache: dgws.NewMemoryIdempotencyCache(), Ttl: time.Minute},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		req, _ := dgws.ParseEnvelope(wsm.MessageData)
+		key := dgws.GetIdempotencyKey(ctx)
+		entered.Done()
+		entered.Wait()
+		if got := dgws.GetIdempotencyKey(ctx); got != key {
+			t.Errorf("idempotency key changed from %s to %s", key, got)
+		}
+		return dgws.Respond(ctx, req, &testData{Content: key}, nil)
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	send := func(id string, key string) {
+		data, _ := json.Marshal(&dgws.Envelope{Type: dgws.EnvelopeTypeRequest, Id: id, IdempotencyKey: key})
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// 响应按完成顺序到达, 以请求id对应
+	expect := func(want map[string]string) {
+		t.Helper()
+		for range want {
+			resp := readEnvelope(t, conn)
+			result := &testData{}
+			_ = json.Unmarshal(resp.Data, result)
+			if result.Content != want[resp.Id] {
+				t.Fatalf("response %s: expected %s, got %s", resp.Id, want[resp.Id], result.Content)
+			}
+		}
+	}
+
+	send("1", "order-a")
+	send("2", "order-b")
+	expect(map[string]string{"1": "order-a", "2": "order-b"})
+
+	// 重试命中的缓存需与各自的幂等键对应
+	send("3", "order-b")
+	send("4", "order-a")
+	expect(map[string]string{"3": "order-b", "4": "order-a"})
+}
@@ -0,0 +1,310 @@
+package dgws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	JournalDirectionIn  = "in"
+	JournalDirectionOut = "out"
+)
+
+// JournalFrame 记录的一帧数据消息, Size为脱敏截断前的长度
+type JournalFrame struct {
+	ConnectionId string    `json:"connectionId"`
+	SessionId    string    `json:"sessionId,omitempty"`
+	BizKey       string    `json:"bizKey"`
+	BizId        string    `json:"bizId"`
+	UserId       int64     `json:"userId"`
+	Direction    string    `json:"direction"`
+	MessageType  int       `json:"messageType"`
+	Data         []byte    `json:"data"`
+	Size         int       `json:"size"`
+	Truncated    bool      `json:"truncated,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// JournalSink 帧记录的去向, 在读循环和写入方的goroutine中同步调用, 慢速存储应自行异步化
+type JournalSink interface {
+	Record(ctx *dgctx.DgContext, frame *JournalFrame) error
+}
+
+type JournalSinkFunc func(ctx *dgctx.DgContext, frame *JournalFrame) error
+
+func (f JournalSinkFunc) Record(ctx *dgctx.DgContext, frame *JournalFrame) error {
+	return f(ctx, frame)
+}
+
+// JournalOptions 记录选中连接收发的所有数据帧, 用于通过ReplayJournal复现线上问题;
+// 广播等以PreparedMessage写出的消息不会被记录
+type JournalOptions struct {
+	Sink JournalSink
+	// Select 为nil时所有连接都参与采样
+	Select func(ctx *dgctx.DgContext, bizKey string, bizId string) bool
+	// SampleRate 被选中连接的记录比例, 取值(0, 1], 0表示全部记录
+	SampleRate float64
+	// MaxFrameSize 单帧最多记录的字节数, 超出部分截断, 0表示不限制
+	MaxFrameSize int
+	// MaxFrames 单连接最多记录的帧数, 0表示不限制
+	MaxFrames int
+	// Redact 写入Sink前对数据脱敏, 返回新的切片, 不能修改data
+	Redact func(direction string, mt int, data []byte) []byte
+}
+
+type connJournal struct {
+	opts         *JournalOptions
+	ctx          *dgctx.DgContext
+	connectionId string
+	sessionId    string
+	bizKey       string
+	bizId        string
+	frames       atomic.Int64
+}
+
+// newConnJournal 连接未被选中时返回nil
+func newConnJournal(ctx *dgctx.DgContext, opts *JournalOptions, bizKey string, bizId string) *connJournal {
+	if opts == nil || opts.Sink == nil {
+		return nil
+	}
+	if opts.Select != nil && !opts.Select(ctx, bizKey, bizId) {
+		return nil
+	}
+	if opts.SampleRate > 0 && opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+		return nil
+	}
+
+	return &connJournal{opts: opts, ctx: ctx, bizKey: bizKey, bizId: bizId}
+}
+
+func (j *connJournal) record(direction string, mt int, data []byte) {
+	n := j.frames.Add(1)
+	if j.opts.MaxFrames > 0 && n > int64(j.opts.MaxFrames) {
+		if n == int64(j.opts.MaxFrames)+1 {
+			dglogger.Infof(j.ctx, "[%s: %s] journal reached %d frames, stop recording", j.bizKey, j.bizId, j.opts.MaxFrames)
+		}
+		return
+	}
+
+	frame := &JournalFrame{
+		ConnectionId: j.connectionId,
+		SessionId:    j.sessionId,
+		BizKey:       j.bizKey,
+		BizId:        j.bizId,
+		UserId:       j.ctx.UserId,
+		Direction:    direction,
+		MessageType:  mt,
+		Size:         len(data),
+		At:           time.Now(),
+	}
+	if j.opts.Redact != nil {
+		data = j.opts.Redact(direction, mt, data)
+	}
+	if j.opts.MaxFrameSize > 0 && len(data) > j.opts.MaxFrameSize {
+		data = data[:j.opts.MaxFrameSize]
+		frame.Truncated = true
+	}
+	frame.Data = append([]byte(nil), data...)
+
+	if err := j.opts.Sink.Record(j.ctx, frame); err != nil {
+		dglogger.Warnf(j.ctx, "[%s: %s] journal record error: %v", j.bizKey, j.bizId, err)
+	}
+}
+
+// JournalQuery 条件之间为与的关系, 零值表示不限制; From、To为左闭右开的时间范围
+type JournalQuery struct {
+	ConnectionId string
+	SessionId    string
+	UserId       int64
+	From         time.Time
+	To           time.Time
+}
+
+func (q *JournalQuery) Match(frame *JournalFrame) bool {
+	return (q.ConnectionId == "" || frame.ConnectionId == q.ConnectionId) &&
+		(q.SessionId == "" || frame.SessionId == q.SessionId) &&
+		(q.UserId == 0 || frame.UserId == q.UserId) &&
+		(q.From.IsZero() || !frame.At.Before(q.From)) &&
+		(q.To.IsZero() || frame.At.Before(q.To))
+}
+
+// JournalSource 按条件读取已记录的帧, 用于审计导出; fn返回错误时停止并返回该错误
+type JournalSource interface {
+	Query(ctx context.Context, q *JournalQuery, fn func(frame *JournalFrame) error) error
+}
+
+// FileJournalSink 以json lines追加写入文件, 可用ReadJournal读回
+type FileJournalSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewFileJournalSink(path string) (*FileJournalSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileJournalSink{file: file}, nil
+}
+
+func (s *FileJournalSink) Record(_ *dgctx.DgContext, frame *JournalFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// Query 从头扫描文件, 适合文件较小或排查时临时使用, 长期审计应使用带索引的存储
+func (s *FileJournalSink) Query(ctx context.Context, q *JournalQuery, fn func(frame *JournalFrame) error) error {
+	file, err := os.Open(s.file.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return scanJournal(file, func(frame *JournalFrame) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !q.Match(frame) {
+			return nil
+		}
+		return fn(frame)
+	})
+}
+
+func (s *FileJournalSink) Name() string {
+	return s.file.Name()
+}
+
+func (s *FileJournalSink) Close() error {
+	return s.file.Close()
+}
+
+// ReadJournal 读取FileJournalSink写入的json lines
+func ReadJournal(r io.Reader) ([]*JournalFrame, error) {
+	var frames []*JournalFrame
+	err := scanJournal(r, func(frame *JournalFrame) error {
+		frames = append(frames, frame)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+func scanJournal(r io.Reader, fn func(frame *JournalFrame) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		frame := &JournalFrame{}
+		if err := json.Unmarshal(scanner.Bytes(), frame); err != nil {
+			return err
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ReplayJournal 在本地临时服务上按conf和bizHandler建立连接, 依次发送frames中客户端发出的帧,
+// 最后一次收到消息后idle时间内没有新消息即结束, 返回期间服务端发出的帧; frames应属于同一连接
+func ReplayJournal(frames []*JournalFrame, conf *WebSocketHandlerConfig, bizHandler wrapper.HandlerFunc[WebSocketMessage, error], idle time.Duration) ([]*JournalFrame, error) {
+	replayConf := *conf
+	replayConf.Journal = nil
+	var bizId string
+	if len(frames) > 0 {
+		bizId = frames[0].BizId
+	}
+	if replayConf.GetBizIdHandler == nil {
+		replayConf.GetBizIdHandler = func(*gin.Context) string {
+			return bizId
+		}
+	}
+
+	engine := gin.New()
+	Get(&wrapper.RequestHolder[WebSocketMessage, error]{
+		RouterGroup: engine.Group("/replay"),
+		NonLogin:    true,
+		BizHandler:  bizHandler,
+	}, &replayConf)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/replay", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var outputs []*JournalFrame
+	received := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			outputs = append(outputs, &JournalFrame{BizKey: conf.BizKey, BizId: bizId, Direction: JournalDirectionOut, MessageType: mt, Data: data, Size: len(data), At: time.Now()})
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	for _, frame := range frames {
+		if frame.Direction != JournalDirectionIn {
+			continue
+		}
+		if err := conn.WriteMessage(frame.MessageType, frame.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-received:
+			timer.Reset(idle)
+		case <-timer.C:
+			waiting = false
+		case <-done:
+			waiting = false
+		}
+	}
+	_ = conn.Close()
+	<-done
+
+	return outputs, nil
+}
@@ -0,0 +1,98 @@
+package dgws_test
+
+import (
+	"bytes"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func echoUpper(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+	return dgws.GetConnection(ctx).WriteMessage(websocket.TextMessage, bytes.ToUpper(wsm.MessageData))
+}
+
+func TestJournalRecordAndReplay(t *testing.T) {
+	sink, err := dgws.NewFileJournalSink(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatalf("new sink: %v", err)
+	}
+	conf := &dgws.WebSocketHandlerConfig{
+		Journal: &dgws.JournalOptions{
+			Sink: sink,
+			Select: func(_ *dgctx.DgContext, _ string, bizId string) bool {
+				return bizId == "journal"
+			},
+			MaxFrameSize: 16,
+			Redact: func(_ string, _ int, data []byte) []byte {
+				data = bytes.ReplaceAll(data, []byte("secret"), []byte("******"))
+				return bytes.ReplaceAll(data, []byte("SECRET"), []byte("******"))
+			},
+		},
+	}
+	url := startTestServerWithConfig(t, conf, echoUpper)
+
+	for _, bizId := range []string{"journal", "skipped"} {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		for _, msg := range []string{"hello", "my secret", strings.Repeat("x", 20)} {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			if _, _, err := conn.ReadMessage(); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+		}
+		_ = conn.Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = sink.Close()
+
+	file, err := os.Open(sink.Name())
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	defer file.Close()
+	frames, err := dgws.ReadJournal(file)
+	if err != nil {
+		t.Fatalf("read journal: %v", err)
+	}
+	if len(frames) != 6 {
+		t.Fatalf("expected 6 frames, got %d", len(frames))
+	}
+	for _, frame := range frames {
+		if frame.BizId != "journal" || frame.ConnectionId == "" {
+			t.Fatalf("unexpected frame: %+v", frame)
+		}
+	}
+	if frames[0].Direction != dgws.JournalDirectionIn || string(frames[0].Data) != "hello" || frames[1].Direction != dgws.JournalDirectionOut {
+		t.Fatalf("unexpected first frames: %+v %+v", frames[0], frames[1])
+	}
+	if string(frames[2].Data) != "my ******" || string(frames[3].Data) != "MY ******" {
+		t.Fatalf("frame not redacted: %s / %s", frames[2].Data, frames[3].Data)
+	}
+	if !frames[4].Truncated || len(frames[4].Data) != 16 || frames[4].Size != 20 {
+		t.Fatalf("frame not truncated: %+v", frames[4])
+	}
+
+	outputs, err := dgws.ReplayJournal(frames, &dgws.WebSocketHandlerConfig{}, echoUpper, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(outputs) != 3 {
+		t.Fatalf("expected 3 replayed outputs, got %d", len(outputs))
+	}
+	for i, output := range outputs {
+		if recorded := frames[2*i+1]; !bytes.Equal(output.Data, recorded.Data) {
+			t.Fatalf("replay output %d: expected %s, got %s", i, recorded.Data, output.Data)
+		}
+	}
+}
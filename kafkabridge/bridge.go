@@ -0,0 +1,177 @@
+package kafkabridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// Producer 写入Kafka topic, 可基于kafka-go、sarama、franz-go等任意客户端实现
+type Producer interface {
+	Produce(ctx context.Context, topic string, key []byte, value []byte) error
+}
+
+// Consumer 逐条拉取Kafka消息, 消息投递后调用commit提交位点
+type Consumer interface {
+	Fetch(ctx context.Context) (value []byte, commit func() error, err error)
+}
+
+type Options struct {
+	Producer Producer
+	// InboundTopic 客户端发来的消息写入该topic, 以BizId为key保证同一业务的顺序
+	InboundTopic string
+	// Consumer 消费推送给连接的消息, 每个实例需使用独立的consumer group, 只投递到本实例的连接
+	Consumer Consumer
+}
+
+// InboundMessage 写入InboundTopic的消息, 带上连接和会话信息
+type InboundMessage struct {
+	ConnectionId string    `json:"connectionId"`
+	SessionId    string    `json:"sessionId,omitempty"`
+	Path         string    `json:"path"`
+	BizKey       string    `json:"bizKey"`
+	BizId        string    `json:"bizId"`
+	UserId       int64     `json:"userId"`
+	RemoteIp     string    `json:"remoteIp"`
+	TraceId      string    `json:"traceId"`
+	MessageType  int       `json:"messageType"`
+	Data         []byte    `json:"data"`
+	ReceivedAt   time.Time `json:"receivedAt"`
+}
+
+// OutboundMessage 从Consumer消费的推送, 优先按ConnectionId投递, 其次按BizKey和BizIds过滤, 都为空时投递给所有连接
+type OutboundMessage struct {
+	ConnectionId string   `json:"connectionId,omitempty"`
+	BizKey       string   `json:"bizKey,omitempty"`
+	BizIds       []string `json:"bizIds,omitempty"`
+	// MessageType 默认文本消息
+	MessageType int    `json:"messageType,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// Bridge 将dgws作为事件驱动后端的websocket接入层
+type Bridge struct {
+	ctx  *dgctx.DgContext
+	opts *Options
+}
+
+func New(ctx *dgctx.DgContext, opts *Options) *Bridge {
+	return &Bridge{ctx: ctx, opts: opts}
+}
+
+// Inbound 包装BizHandler, 先把消息写入InboundTopic再交给next, next为nil时只写入Kafka;
+// 写入失败返回错误, 可配合WebSocketHandlerConfig.Retry重试
+func (b *Bridge) Inbound(next wrapper.HandlerFunc[dgws.WebSocketMessage, error]) wrapper.HandlerFunc[dgws.WebSocketMessage, error] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		msg := &InboundMessage{
+			UserId:      ctx.UserId,
+			RemoteIp:    ctx.RemoteIp,
+			TraceId:     ctx.TraceId,
+			MessageType: wsm.MessageType,
+			Data:        wsm.MessageData,
+			ReceivedAt:  time.Now(),
+		}
+		if connection := dgws.GetConnection(ctx); connection != nil {
+			msg.ConnectionId = connection.Id
+			msg.SessionId = connection.SessionId
+			msg.Path = connection.Path
+			msg.BizKey = connection.BizKey
+			msg.BizId = connection.BizId
+		}
+
+		value, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := b.opts.Producer.Produce(innerContext(ctx), b.opts.InboundTopic, []byte(msg.BizId), value); err != nil {
+			return err
+		}
+
+		if next != nil {
+			return next(c, ctx, wsm)
+		}
+		return nil
+	}
+}
+
+// Run 持续消费Consumer并投递到本实例的连接, ctx取消或Fetch返回错误时结束; 无法解析的消息记录日志后跳过
+func (b *Bridge) Run(ctx context.Context) error {
+	if b.opts.Consumer == nil {
+		return errors.New("kafka bridge consumer is required")
+	}
+
+	for {
+		value, commit, err := b.opts.Consumer.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		msg := &OutboundMessage{}
+		if err := json.Unmarshal(value, msg); err != nil {
+			dglogger.Warnf(b.ctx, "kafka bridge decode outbound message error: %v", err)
+		} else if _, err := Deliver(msg); err != nil {
+			dglogger.Warnf(b.ctx, "kafka bridge deliver message error: %v", err)
+		}
+
+		if commit != nil {
+			if err := commit(); err != nil {
+				dglogger.Warnf(b.ctx, "kafka bridge commit error: %v", err)
+			}
+		}
+	}
+}
+
+// Deliver 投递到本实例的连接, 返回成功发送的连接数; 不经过集群广播, 避免多个实例重复投递
+func Deliver(msg *OutboundMessage) (int, error) {
+	mt := msg.MessageType
+	if mt == 0 {
+		mt = websocket.TextMessage
+	}
+
+	if msg.ConnectionId != "" {
+		connection := dgws.GetConnectionById(msg.ConnectionId)
+		if connection == nil {
+			return 0, nil
+		}
+		if err := connection.WriteMessage(mt, msg.Data); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	if msg.BizKey == "" && len(msg.BizIds) == 0 {
+		return dgws.BroadcastFilter(mt, msg.Data, nil)
+	}
+
+	ids := make(map[string]struct{}, len(msg.BizIds))
+	for _, bizId := range msg.BizIds {
+		ids[bizId] = struct{}{}
+	}
+	return dgws.BroadcastFilter(mt, msg.Data, func(c *dgws.Connection) bool {
+		if msg.BizKey != "" && c.BizKey != msg.BizKey {
+			return false
+		}
+		if len(ids) == 0 {
+			return true
+		}
+		_, ok := ids[c.BizId]
+		return ok
+	})
+}
+
+func innerContext(ctx *dgctx.DgContext) context.Context {
+	if ctx.InnerContext() != nil {
+		return ctx.InnerContext()
+	}
+	return context.Background()
+}
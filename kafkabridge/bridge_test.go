@@ -0,0 +1,148 @@
+package kafkabridge_test
+
+import (
+	"context"
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/kafkabridge"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type record struct {
+	topic string
+	key   string
+	value []byte
+}
+
+type fakeProducer struct {
+	lock    sync.Mutex
+	records []record
+}
+
+func (p *fakeProducer) Produce(_ context.Context, topic string, key []byte, value []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.records = append(p.records, record{topic: topic, key: string(key), value: value})
+	return nil
+}
+
+type fakeConsumer struct {
+	values    chan []byte
+	committed chan struct{}
+}
+
+func (c *fakeConsumer) Fetch(ctx context.Context) ([]byte, func() error, error) {
+	select {
+	case value := <-c.values:
+		return value, func() error {
+			c.committed <- struct{}{}
+			return nil
+		}, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func startServer(t *testing.T, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/edge"),
+		NonLogin:    true,
+		BizHandler:  bizHandler,
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "room",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("room")
+		},
+	})
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/edge"
+}
+
+func TestBridge(t *testing.T) {
+	producer := &fakeProducer{}
+	consumer := &fakeConsumer{values: make(chan []byte, 10), committed: make(chan struct{}, 10)}
+	bridge := kafkabridge.New(&dgctx.DgContext{TraceId: uuid.NewString()}, &kafkabridge.Options{
+		Producer:     producer,
+		InboundTopic: "ws-inbound",
+		Consumer:     consumer,
+	})
+	handled := make(chan string, 10)
+	url := startServer(t, bridge.Inbound(func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		handled <- string(wsm.MessageData)
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = bridge.Run(ctx)
+	}()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?room=r1", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	other, _, err := websocket.DefaultDialer.Dial(url+"?room=r2", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case msg := <-handled:
+		if msg != "hello" {
+			t.Fatalf("unexpected handled message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not handled")
+	}
+	producer.lock.Lock()
+	records := producer.records
+	producer.lock.Unlock()
+	if len(records) != 1 || records[0].topic != "ws-inbound" || records[0].key != "r1" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	inbound := &kafkabridge.InboundMessage{}
+	if err := json.Unmarshal(records[0].value, inbound); err != nil {
+		t.Fatalf("decode inbound: %v", err)
+	}
+	if inbound.ConnectionId == "" || inbound.BizKey != "room" || string(inbound.Data) != "hello" {
+		t.Fatalf("unexpected inbound message: %+v", inbound)
+	}
+
+	outbound, _ := json.Marshal(&kafkabridge.OutboundMessage{BizKey: "room", BizIds: []string{"r1"}, Data: []byte("pushed")})
+	consumer.values <- []byte("not json")
+	consumer.values <- outbound
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "pushed" {
+		t.Fatalf("expected pushed, got %s, %v", string(data), err)
+	}
+	_ = other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Fatalf("message pushed to wrong room: %s", string(data))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-consumer.committed:
+		case <-time.After(3 * time.Second):
+			t.Fatal("outbound message not committed")
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package dgws
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+)
+
+// ClusterOpKick 通知其他实例关闭选中的连接, 见Kick
+const ClusterOpKick = "kick"
+
+// KickOptions 选中UserId的所有连接, 或BizKey下BizIds的所有连接, 两者可同时设置
+type KickOptions struct {
+	UserId int64
+	BizKey string
+	BizIds []string
+	// CloseCode 默认1008(Policy Violation)
+	CloseCode int
+	Reason    string
+}
+
+// Kick 关闭选中的连接并立即结束其会话(不再等待GraceWindow, 恢复token失效), 返回本实例关闭的连接数;
+// 开启InitCluster时同时通知其他实例
+func Kick(ctx *dgctx.DgContext, opts *KickOptions) (int, error) {
+	if opts == nil || (opts.UserId <= 0 && len(opts.BizIds) == 0) {
+		return 0, errors.New("websocket kick requires UserId or BizIds")
+	}
+
+	kicked := kickLocal(opts)
+	dglogger.Infof(ctx, "kick websocket connections, userId: %d, bizKey: %s, bizIds: %v, kicked: %d", opts.UserId, opts.BizKey, opts.BizIds, kicked)
+
+	return kicked, publishClusterMessage(&ClusterMessage{
+		Op:        ClusterOpKick,
+		UserId:    opts.UserId,
+		BizKey:    opts.BizKey,
+		BizIds:    opts.BizIds,
+		CloseCode: opts.CloseCode,
+		Reason:    opts.Reason,
+	})
+}
+
+func kickLocal(opts *KickOptions) int {
+	match := kickFilter(opts)
+	revokeSessions(func(s *serverSession) bool {
+		return match(s.userId, s.bizKey, s.bizId)
+	})
+
+	code := opts.CloseCode
+	if code == 0 {
+		code = websocket.ClosePolicyViolation
+	}
+	kicked := 0
+	RangeConnections(func(c *Connection) bool {
+		if !match(c.UserId, c.BizKey, c.BizId) {
+			return true
+		}
+		c.closeWith(code, opts.Reason)
+		kicked++
+		return true
+	})
+
+	return kicked
+}
+
+func kickFilter(opts *KickOptions) func(userId int64, bizKey string, bizId string) bool {
+	ids := make(map[string]struct{}, len(opts.BizIds))
+	for _, bizId := range opts.BizIds {
+		ids[bizId] = struct{}{}
+	}
+
+	return func(userId int64, bizKey string, bizId string) bool {
+		if opts.UserId > 0 && userId == opts.UserId {
+			return true
+		}
+		if bizKey != opts.BizKey {
+			return false
+		}
+		_, ok := ids[bizId]
+		return ok
+	}
+}
@@ -0,0 +1,104 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/redisbroker"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func expectClosed(t *testing.T, conn *websocket.Conn, code int) {
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, code) {
+			t.Fatalf("expected close %d, got %v", code, err)
+		}
+		return
+	}
+}
+
+func TestKickRevokesSession(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Session: &dgws.SessionOptions{GraceWindow: time.Minute},
+	}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=kick-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	info := readSession(t, conn)
+	waitConnections(t, "kick-a", 1)
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	kicked, err := dgws.Kick(ctx, &dgws.KickOptions{BizKey: "bizId", BizIds: []string{"kick-a"}, Reason: "revoked"})
+	if err != nil || kicked != 1 {
+		t.Fatalf("kick: %d, %v", kicked, err)
+	}
+	expectClosed(t, conn, websocket.ClosePolicyViolation)
+
+	header := http.Header{}
+	header.Set(dgws.ResumeTokenHeader, info.Token)
+	again, _, err := websocket.DefaultDialer.Dial(url+"?bizId=kick-a", header)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	defer again.Close()
+	if resumed := readSession(t, again); resumed.Resumed {
+		t.Fatal("kicked session should not be resumed")
+	}
+
+	if _, err := dgws.Kick(ctx, &dgws.KickOptions{}); err == nil {
+		t.Fatal("kick without target should fail")
+	}
+}
+
+func TestKickFromOtherInstance(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitCluster(ctx, &dgws.ClusterOptions{Broker: redisbroker.New(rdb), ChannelPrefix: "kick:"}); err != nil {
+		t.Fatalf("init cluster: %v", err)
+	}
+	defer dgws.CloseCluster()
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=kick-b", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	other, _, err := websocket.DefaultDialer.Dial(url+"?bizId=kick-c", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+	waitConnections(t, "kick-b", 1)
+	waitConnections(t, "kick-c", 1)
+
+	payload, _ := json.Marshal(&dgws.ClusterMessage{InstanceId: "other", Op: dgws.ClusterOpKick, BizKey: "bizId", BizIds: []string{"kick-b"}, CloseCode: 4001})
+	if err := rdb.Publish(context.Background(), "kick:broadcast", payload).Err(); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	expectClosed(t, conn, 4001)
+	waitConnections(t, "kick-c", 1)
+}
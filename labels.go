@@ -0,0 +1,46 @@
+package dgws
+
+import (
+	"maps"
+	"strings"
+)
+
+// SetLabel 给连接打标签, 如客户端版本、灰度分组, 可在管理接口中按标签检索连接
+func (c *Connection) SetLabel(key string, value string) {
+	c.labelLock.Lock()
+	defer c.labelLock.Unlock()
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+	c.labels[key] = value
+}
+
+func (c *Connection) RemoveLabel(key string) {
+	c.labelLock.Lock()
+	defer c.labelLock.Unlock()
+	delete(c.labels, key)
+}
+
+func (c *Connection) Label(key string) (string, bool) {
+	c.labelLock.RLock()
+	defer c.labelLock.RUnlock()
+	value, ok := c.labels[key]
+	return value, ok
+}
+
+// Labels 返回标签的副本
+func (c *Connection) Labels() map[string]string {
+	c.labelLock.RLock()
+	defer c.labelLock.RUnlock()
+	return maps.Clone(c.labels)
+}
+
+// matchLabel selector为"key"时匹配有该标签的连接, 为"key=value"时还需值相等
+func (c *Connection) matchLabel(selector string) bool {
+	key, value, hasValue := strings.Cut(selector, "=")
+	actual, ok := c.Label(key)
+	if !ok {
+		return false
+	}
+	return !hasValue || actual == value
+}
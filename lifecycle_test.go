@@ -0,0 +1,115 @@
+package dgws_test
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+type disconnectEvent struct {
+	bizId  string
+	code   int
+	reason string
+}
+
+func startLifecycleServer(t *testing.T) (string, chan string, chan disconnectEvent) {
+	connected := make(chan string, 10)
+	disconnected := make(chan disconnectEvent, 10)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		StartHandler: func(c *gin.Context, _ *dgctx.DgContext, _ *websocket.Conn) error {
+			if c.Query("bizId") == "rejected" {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+		// 读取出错时不视为结束, EndCallbackHandler不会被调用, OnDisconnect仍然回调
+		IsEndedHandler: func(_ *dgctx.DgContext, mt int, _ []byte) bool {
+			return mt == websocket.CloseMessage
+		},
+		OnConnect: func(ctx *dgctx.DgContext, conn *websocket.Conn) {
+			if conn == nil || dgws.GetConnection(ctx) == nil {
+				t.Error("connection should be registered before OnConnect")
+			}
+			connected <- dgws.GetConnection(ctx).BizId
+		},
+		OnDisconnect: func(ctx *dgctx.DgContext, _ *websocket.Conn, closeCode int, reason string) {
+			disconnected <- disconnectEvent{bizId: dgws.GetConnection(ctx).BizId, code: closeCode, reason: reason}
+		},
+	}, func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+		return nil
+	})
+	return url, connected, disconnected
+}
+
+func expectDisconnect(t *testing.T, disconnected chan disconnectEvent, bizId string, code int, reason string) {
+	t.Helper()
+	select {
+	case ev := <-disconnected:
+		if ev.bizId != bizId || ev.code != code || !strings.Contains(ev.reason, reason) {
+			t.Fatalf("unexpected disconnect: %+v", ev)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("OnDisconnect not called for %s", bizId)
+	}
+	select {
+	case ev := <-disconnected:
+		t.Fatalf("OnDisconnect called twice: %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestOnConnectOnDisconnect(t *testing.T) {
+	url, connected, disconnected := startLifecycleServer(t)
+	dial := func(bizId string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		if bizId == "rejected" {
+			return conn
+		}
+		select {
+		case id := <-connected:
+			if id != bizId {
+				t.Fatalf("unexpected OnConnect for %s", id)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("OnConnect not called for %s", bizId)
+		}
+		return conn
+	}
+
+	normal := dial("normal")
+	_ = normal.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	expectDisconnect(t, disconnected, "normal", websocket.CloseNormalClosure, "bye")
+
+	dropped := dial("dropped")
+	_ = dropped.NetConn().Close()
+	expectDisconnect(t, disconnected, "dropped", websocket.CloseAbnormalClosure, "")
+
+	kicked := dial("kicked")
+	if n, err := dgws.Kick(&dgctx.DgContext{TraceId: uuid.NewString()}, &dgws.KickOptions{BizKey: "bizId", BizIds: []string{"kicked"}, Reason: "banned"}); err != nil || n != 1 {
+		t.Fatalf("kicked %d, error: %v", n, err)
+	}
+	expectClosed(t, kicked, websocket.ClosePolicyViolation)
+	expectDisconnect(t, disconnected, "kicked", websocket.ClosePolicyViolation, "banned")
+
+	rejected := dial("rejected")
+	_ = rejected.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, _ = rejected.ReadMessage()
+	_ = rejected.Close()
+	select {
+	case id := <-connected:
+		t.Fatalf("OnConnect called for %s", id)
+	case ev := <-disconnected:
+		t.Fatalf("OnDisconnect called for rejected connection: %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
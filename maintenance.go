@@ -0,0 +1,103 @@
+package dgws
+
+import (
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/result"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrMaintenance 维护期间拒绝升级时返回的错误码
+var ErrMaintenance = dgerr.NewDgError(5010, "系统维护中")
+
+// MaintenanceOptions 维护期间所有路由拒绝新的升级请求, 已建立的连接不受影响
+type MaintenanceOptions struct {
+	// Message 返回给客户端的提示, 默认"系统维护中", drain时默认"服务正在下线"
+	Message string
+	// RetryAfter 建议客户端重试的间隔, 大于0时写入Retry-After响应头
+	RetryAfter time.Duration
+	// StatusCode 拒绝升级的http状态码, 默认503
+	StatusCode int
+	// Body 不为nil时序列化为json作为响应体, 代替默认的result
+	Body any
+	// drain 由EnterDrainMode进入, 见drain.go
+	drain bool
+}
+
+// MaintenanceInfo 拒绝升级时result的data, 也是管理接口查看维护状态的结果
+type MaintenanceInfo struct {
+	Message string `json:"message"`
+	// RetryAfter 秒
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+	// Drain 是否为EnterDrainMode进入的下线状态
+	Drain bool `json:"drain,omitempty"`
+}
+
+var maintenance atomic.Pointer[MaintenanceOptions]
+
+// EnterMaintenance 重复调用会更新提示和重试间隔
+func EnterMaintenance(opts *MaintenanceOptions) {
+	if opts == nil {
+		opts = &MaintenanceOptions{}
+	}
+	copied := *opts
+	copied.drain = false
+	maintenance.Store(&copied)
+}
+
+// ExitMaintenance 同时退出drain状态
+func ExitMaintenance() {
+	maintenance.Store(nil)
+}
+
+// GetMaintenance 未处于维护状态时返回nil
+func GetMaintenance() *MaintenanceOptions {
+	return maintenance.Load()
+}
+
+func (opts *MaintenanceOptions) err() *dgerr.DgError {
+	if opts.drain {
+		return ErrDraining
+	}
+	return ErrMaintenance
+}
+
+func (opts *MaintenanceOptions) info() *MaintenanceInfo {
+	info := &MaintenanceInfo{Message: opts.Message, Drain: opts.drain}
+	if info.Message == "" {
+		info.Message = opts.err().Message
+	}
+	if opts.RetryAfter > 0 {
+		info.RetryAfter = int64((opts.RetryAfter + time.Second - 1) / time.Second)
+	}
+	return info
+}
+
+// rejectMaintenance 处于维护或drain状态时以StatusCode(默认503)和Retry-After拒绝升级
+func rejectMaintenance(c *gin.Context) bool {
+	opts := maintenance.Load()
+	if opts == nil {
+		return false
+	}
+
+	info := opts.info()
+	if info.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.FormatInt(info.RetryAfter, 10))
+	}
+	status := opts.StatusCode
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if opts.Body != nil {
+		c.AbortWithStatusJSON(status, opts.Body)
+		return true
+	}
+	rt := result.Fail[*MaintenanceInfo](opts.err().Code, info.Message)
+	rt.Data = info
+	c.AbortWithStatusJSON(status, rt)
+
+	return true
+}
@@ -0,0 +1,75 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-common/result"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	defer dgws.ExitMaintenance()
+
+	handled := make(chan string, 1)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		handled <- string(wsm.MessageData)
+		return nil
+	})
+	existing, _, err := websocket.DefaultDialer.Dial(url+"?bizId=maintenance-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer existing.Close()
+	waitConnections(t, "maintenance-a", 1)
+
+	admin := startAdminServer(t)
+	if rt := adminCall[*result.Void](t, admin+"maintenance", testAdminToken, &dgws.AdminMaintenanceRequest{Enabled: true, Message: "upgrading", RetryAfter: 30}); !rt.Success {
+		t.Fatalf("enter maintenance: %+v", rt)
+	}
+	if rt := adminCall[*dgws.MaintenanceInfo](t, admin+"maintenance", testAdminToken, nil); !rt.Success || rt.Data == nil || rt.Data.RetryAfter != 30 {
+		t.Fatalf("unexpected maintenance state: %+v", rt)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?bizId=maintenance-b", nil)
+	if err == nil {
+		t.Fatal("expected upgrade to be rejected during maintenance")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "30" {
+		t.Fatalf("unexpected response: %d, %v", resp.StatusCode, resp.Header)
+	}
+	rt := &result.Result[*dgws.MaintenanceInfo]{}
+	if err := json.NewDecoder(resp.Body).Decode(rt); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rt.Code != dgws.ErrMaintenance.Code || rt.Data == nil || rt.Data.Message != "upgrading" {
+		t.Fatalf("unexpected result: %+v", rt)
+	}
+
+	// 已建立的连接不受影响
+	if err := existing.WriteMessage(websocket.TextMessage, []byte("still-here")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case msg := <-handled:
+		if msg != "still-here" {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("existing connection stopped handling messages")
+	}
+
+	if rt := adminCall[*result.Void](t, admin+"maintenance", testAdminToken, &dgws.AdminMaintenanceRequest{}); !rt.Success {
+		t.Fatalf("exit maintenance: %+v", rt)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=maintenance-b", nil)
+	if err != nil {
+		t.Fatalf("dial after maintenance: %v", err)
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,280 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval    = 5 * time.Second
+	defaultProbeTimeout     = time.Second
+	defaultProbeMaxFailures = 3
+)
+
+// Member 集群中的一个实例, Addr与DirectoryOptions.NodeAddr一致, 即RelayHandler的地址
+type Member struct {
+	NodeId string
+	Addr   string
+}
+
+// Membership 实例发现, 内置StaticMembership和ResolverMembership(可配合DnsSrvResolver), gossip等可按此接口接入
+type Membership interface {
+	// Watch 先同步回调一次当前成员, 之后在后台于成员变化时回调, ctx取消时停止
+	Watch(ctx context.Context, onChange func(members []Member)) error
+}
+
+// StaticMembership 固定的成员列表
+type StaticMembership []Member
+
+func (m StaticMembership) Watch(_ context.Context, onChange func(members []Member)) error {
+	onChange(slices.Clone(m))
+	return nil
+}
+
+// ResolverMembership 由UpstreamResolver给出的地址作为成员, NodeId即地址
+type ResolverMembership struct {
+	Resolver UpstreamResolver
+}
+
+func (m *ResolverMembership) Watch(ctx context.Context, onChange func(members []Member)) error {
+	return m.Resolver.Watch(ctx, func(addrs []string) {
+		members := make([]Member, 0, len(addrs))
+		for _, addr := range addrs {
+			members = append(members, Member{NodeId: addr, Addr: addr})
+		}
+		onChange(members)
+	})
+}
+
+// MembershipOptions 开启后PushToBizId不再中继到失联的实例, Lookup也不返回失联实例上的连接
+type MembershipOptions struct {
+	Membership Membership
+	// ProbeInterval 健康检查间隔, 默认5秒
+	ProbeInterval time.Duration
+	// MaxFailures 连续失败该次数后剔除, 恢复后重新加入, 默认3
+	MaxFailures int
+	// Probe 健康检查, 默认对Addr的host建立TCP连接
+	Probe func(ctx context.Context, member Member) error
+}
+
+type memberHealth struct {
+	member   Member
+	failures int
+}
+
+type membershipState struct {
+	ctx     *dgctx.DgContext
+	opts    MembershipOptions
+	lock    sync.RWMutex
+	members map[string]*memberHealth
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+var (
+	membership     *membershipState
+	membershipLock sync.RWMutex
+)
+
+// InitMembership 重复调用会替换之前的配置
+func InitMembership(ctx *dgctx.DgContext, opts *MembershipOptions) error {
+	if opts == nil || opts.Membership == nil {
+		return errors.New("websocket membership is required")
+	}
+
+	state := &membershipState{ctx: ctx, opts: *opts, members: make(map[string]*memberHealth), done: make(chan struct{})}
+	if state.opts.ProbeInterval <= 0 {
+		state.opts.ProbeInterval = defaultProbeInterval
+	}
+	if state.opts.MaxFailures <= 0 {
+		state.opts.MaxFailures = defaultProbeMaxFailures
+	}
+	if state.opts.Probe == nil {
+		state.opts.Probe = probeTcp
+	}
+	watchCtx, cancel := context.WithCancel(innerContext(ctx))
+	state.cancel = cancel
+	if err := opts.Membership.Watch(watchCtx, state.update); err != nil {
+		cancel()
+		return err
+	}
+
+	membershipLock.Lock()
+	previous := membership
+	membership = state
+	membershipLock.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	go state.run(watchCtx)
+
+	return nil
+}
+
+func CloseMembership() {
+	membershipLock.Lock()
+	state := membership
+	membership = nil
+	membershipLock.Unlock()
+	if state != nil {
+		state.close()
+	}
+}
+
+func getMembership() *membershipState {
+	membershipLock.RLock()
+	defer membershipLock.RUnlock()
+	return membership
+}
+
+// Members 返回健康的成员, 未开启时返回nil
+func Members() []Member {
+	state := getMembership()
+	if state == nil {
+		return nil
+	}
+
+	state.lock.RLock()
+	defer state.lock.RUnlock()
+	var members []Member
+	for _, h := range state.members {
+		if h.failures < state.opts.MaxFailures {
+			members = append(members, h.member)
+		}
+	}
+	slices.SortFunc(members, func(a, b Member) int {
+		return strings.Compare(a.NodeId, b.NodeId)
+	})
+
+	return members
+}
+
+// update 保留仍在列表中的成员的健康状态
+func (s *membershipState) update(members []Member) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	next := make(map[string]*memberHealth, len(members))
+	for _, m := range members {
+		h := s.members[m.NodeId]
+		if h == nil {
+			h = &memberHealth{}
+		}
+		h.member = m
+		next[m.NodeId] = h
+	}
+	s.members = next
+}
+
+// alive 本实例、健康成员(按NodeId或Addr匹配)上的目录记录视为存活
+func (s *membershipState) alive(entry *DirectoryEntry) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if h := s.members[entry.NodeId]; h != nil {
+		return h.failures < s.opts.MaxFailures
+	}
+	if entry.NodeAddr == "" {
+		return false
+	}
+	for _, h := range s.members {
+		if h.member.Addr == entry.NodeAddr {
+			return h.failures < s.opts.MaxFailures
+		}
+	}
+	return false
+}
+
+// addr 目录记录没有NodeAddr时使用成员的地址
+func (s *membershipState) addr(nodeId string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if h := s.members[nodeId]; h != nil {
+		return h.member.Addr
+	}
+	return ""
+}
+
+func (s *membershipState) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.probe(ctx)
+		}
+	}
+}
+
+func (s *membershipState) probe(ctx context.Context) {
+	s.lock.RLock()
+	healths := make([]*memberHealth, 0, len(s.members))
+	members := make([]Member, 0, len(s.members))
+	for _, h := range s.members {
+		healths = append(healths, h)
+		members = append(members, h.member)
+	}
+	s.lock.RUnlock()
+
+	var wg sync.WaitGroup
+	for i, h := range healths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, defaultProbeTimeout)
+			err := s.opts.Probe(probeCtx, members[i])
+			cancel()
+
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if err == nil {
+				if h.failures >= s.opts.MaxFailures {
+					dglogger.Infof(s.ctx, "websocket cluster member %s recovered", h.member.NodeId)
+				}
+				h.failures = 0
+				return
+			}
+			h.failures++
+			if h.failures == s.opts.MaxFailures {
+				dglogger.Warnf(s.ctx, "websocket cluster member %s evicted: %v", h.member.NodeId, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *membershipState) close() {
+	s.cancel()
+	<-s.done
+}
+
+func probeTcp(ctx context.Context, member Member) error {
+	host := member.Addr
+	if u, err := url.Parse(member.Addr); err == nil && u.Host != "" {
+		host = u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "wss" || u.Scheme == "https" {
+				port = "443"
+			}
+			host = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,80 @@
+package dgws_test
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestMembershipEvictsDeadPeers(t *testing.T) {
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitRelay(ctx, &dgws.RelayOptions{Token: "secret", RequestTimeout: time.Second}); err != nil {
+		t.Fatalf("init relay: %v", err)
+	}
+	defer dgws.CloseRelay()
+	relayUrl := startRelayServer(t)
+
+	if err := dgws.InitMembership(ctx, &dgws.MembershipOptions{
+		Membership: dgws.StaticMembership{
+			{NodeId: "node-remote", Addr: relayUrl},
+			{NodeId: "node-dead", Addr: "ws://node-dead/relay"},
+		},
+		ProbeInterval: 20 * time.Millisecond,
+		MaxFailures:   2,
+		Probe: func(_ context.Context, member dgws.Member) error {
+			if member.NodeId == "node-dead" {
+				return errors.New("unreachable")
+			}
+			return nil
+		},
+	}); err != nil {
+		t.Fatalf("init membership: %v", err)
+	}
+	defer dgws.CloseMembership()
+
+	store := &staticDirectoryStore{entries: map[string][]*dgws.DirectoryEntry{
+		"member-a": {
+			{NodeId: "node-remote", BizKey: "bizId", BizId: "member-a"},
+			{NodeId: "node-dead", NodeAddr: "ws://node-dead/relay", BizKey: "bizId", BizId: "member-a"},
+			{NodeId: "node-unknown", NodeAddr: "ws://node-unknown/relay", BizKey: "bizId", BizId: "member-a"},
+		},
+	}}
+	if err := dgws.InitDirectory(ctx, &dgws.DirectoryOptions{Store: store, NodeId: "node-local"}); err != nil {
+		t.Fatalf("init directory: %v", err)
+	}
+	defer dgws.CloseDirectory()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(dgws.Members()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("dead member not evicted: %v", dgws.Members())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	entries, err := dgws.Lookup(ctx, "bizId", "member-a")
+	if err != nil || len(entries) != 1 || entries[0].NodeId != "node-remote" {
+		t.Fatalf("unexpected lookup: %v, %v", entries, err)
+	}
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=member-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "member-a", 1)
+
+	// 目录记录没有地址时使用成员地址中继, 失联的实例不再尝试
+	sent, err := dgws.PushToBizId(ctx, "bizId", "member-a", websocket.TextMessage, []byte("hi"))
+	if err != nil || sent != 2 {
+		t.Fatalf("push: %d, %v", sent, err)
+	}
+}
@@ -0,0 +1,43 @@
+package dgws_test
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxPendingBytes(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		close(release)
+	})
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{MaxPendingBytes: 1000}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		<-release
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	payload := []byte(strings.Repeat("x", 400))
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Fatalf("expected close for exceeded budget, got %v", err)
+	}
+}
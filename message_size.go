@@ -0,0 +1,57 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/result"
+	"github.com/gorilla/websocket"
+	"io"
+	"time"
+)
+
+// ErrMessageTooBig 消息超过MaxMessageSize时DefaultMessageTooBigHandler返回的错误码
+var ErrMessageTooBig = dgerr.NewDgError(5014, "消息过大")
+
+const messageTooBigReason = "message too big"
+
+// MessageTooBigHandler 返回的内容在1009 close帧之前作为文本消息发送给客户端, 为nil时不发送
+type MessageTooBigHandler func(ctx *dgctx.DgContext, limit int64) []byte
+
+// DefaultMessageTooBigHandler 返回ErrMessageTooBig对应的result, data为limit
+func DefaultMessageTooBigHandler(_ *dgctx.DgContext, limit int64) []byte {
+	rt := result.Fail[int64](ErrMessageTooBig.Code, ErrMessageTooBig.Message)
+	rt.Data = limit
+	data, _ := json.Marshal(rt)
+	return data
+}
+
+// readLimitedMessage 设置OnMessageTooBig时代替SetReadLimit, gorilla超限时会先自行发送close帧;
+// 最多读取limit+1字节, 超过时返回websocket.ErrReadLimit, 不再读取剩余数据
+func readLimitedMessage(conn *websocket.Conn, limit int64) (int, []byte, error) {
+	mt, r, err := conn.NextReader()
+	if err != nil {
+		return mt, nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return -1, nil, err
+	}
+	if int64(len(data)) > limit {
+		return -1, nil, websocket.ErrReadLimit
+	}
+
+	return mt, data, nil
+}
+
+// closeMessageTooBig 发送payload后以1009关闭, 读循环随后退出
+func (c *Connection) closeMessageTooBig(payload []byte) {
+	c.closeFrame.CompareAndSwap(nil, &closeFrame{code: websocket.CloseMessageTooBig, reason: messageTooBigReason})
+	if payload != nil {
+		_ = c.WriteMessage(websocket.TextMessage, payload)
+	}
+	_ = c.write(func() error {
+		return c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, messageTooBigReason), time.Now().Add(time.Second))
+	})
+}
@@ -0,0 +1,172 @@
+package dgws_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startMessageSizeServer(t *testing.T, conf *dgws.WebSocketHandlerConfig) (string, chan int) {
+	disconnected := make(chan int, 1)
+	conf.MaxMessageSize = 16
+	conf.OnDisconnect = func(_ *dgctx.DgContext, _ *websocket.Conn, closeCode int, _ string) {
+		disconnected <- closeCode
+	}
+	url := startTestServerWithConfig(t, conf, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return dgws.GetSafeConn(ctx).WriteMessage(websocket.TextMessage, wsm.CopyData())
+	})
+	return url, disconnected
+}
+
+func sendTooBig(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=size", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("small")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "small" {
+		t.Fatalf("read: %s, %v", data, err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 1024))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return conn
+}
+
+func expectTooBigClose(t *testing.T, conn *websocket.Conn, disconnected chan int) {
+	t.Helper()
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("expected 1009, got %v", err)
+	}
+	select {
+	case code := <-disconnected:
+		if code != websocket.CloseMessageTooBig {
+			t.Fatalf("unexpected disconnect code: %d", code)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	url, disconnected := startMessageSizeServer(t, &dgws.WebSocketHandlerConfig{})
+	conn := sendTooBig(t, url)
+	expectTooBigClose(t, conn, disconnected)
+}
+
+func TestOnMessageTooBig(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		url, disconnected := startMessageSizeServer(t, &dgws.WebSocketHandlerConfig{
+			EnableMessagePool: pooled,
+			OnMessageTooBig:   dgws.DefaultMessageTooBigHandler,
+		})
+		conn := sendTooBig(t, url)
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read error payload: %v", err)
+		}
+		var rt struct {
+			Code int   `json:"code"`
+			Data int64 `json:"data"`
+		}
+		if err := json.Unmarshal(data, &rt); err != nil || rt.Code != dgws.ErrMessageTooBig.Code || rt.Data != 16 {
+			t.Fatalf("unexpected payload: %s", data)
+		}
+		expectTooBigClose(t, conn, disconnected)
+	}
+}
+
+// recordingConn 记录服务端发来的原始字节
+type recordingConn struct {
+	net.Conn
+	mu   sync.Mutex
+	read bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// closeFrames 统计握手响应之后的close帧, 服务端的帧不带掩码
+func (c *recordingConn) closeFrames() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw := c.read.Bytes()
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		raw = raw[i+4:]
+	}
+	n := 0
+	for len(raw) >= 2 {
+		size, header := int(raw[1]&0x7f), 2
+		switch size {
+		case 126:
+			size, header = int(binary.BigEndian.Uint16(raw[2:4])), 4
+		case 127:
+			size, header = int(binary.BigEndian.Uint64(raw[2:10])), 10
+		}
+		if raw[0]&0x0f == websocket.CloseMessage {
+			n++
+		}
+		if len(raw) < header+size {
+			break
+		}
+		raw = raw[header+size:]
+	}
+	return n
+}
+
+func TestMessageTooBigSendsSingleCloseFrame(t *testing.T) {
+	url, disconnected := startMessageSizeServer(t, &dgws.WebSocketHandlerConfig{
+		OnMessageTooBig: dgws.DefaultMessageTooBigHandler,
+	})
+	var recorded *recordingConn
+	dialer := &websocket.Dialer{NetDialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		recorded = &recordingConn{Conn: conn}
+		return recorded, nil
+	}}
+	conn, _, err := dialer.Dial(url+"?bizId=size", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 1024))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("read error payload: %v", err)
+	}
+	expectTooBigClose(t, conn, disconnected)
+
+	// 读到服务端关闭TCP为止, 确保之后写出的帧都已记录
+	_ = recorded.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _ = io.Copy(io.Discard, recorded)
+	if n := recorded.closeFrames(); n != 1 {
+		t.Fatalf("expected a single close frame, got %d", n)
+	}
+}
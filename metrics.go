@@ -0,0 +1,147 @@
+package dgws
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const metricsWindowSeconds = 60
+
+// Metrics 当前实例的实时指标, 供无法抓取Prometheus的看板和健康检查使用, 见MetricsHandler
+type Metrics struct {
+	Timestamp   int64 `json:"timestamp"`
+	Connections int   `json:"connections"`
+	// ConnLimit InitWsConnLimit或SetConnLimit设置的连接数上限, 0表示未限制
+	ConnLimit uint                     `json:"connLimit,omitempty"`
+	Routes    map[string]*RouteMetrics `json:"routes"`
+	// WorkerPool 未调用InitWorkerPool时为nil
+	WorkerPool *WorkerPoolMetrics `json:"workerPool,omitempty"`
+}
+
+type RouteMetrics struct {
+	Connections int `json:"connections"`
+	// PendingBytes 已读取但BizHandler尚未处理完的消息字节数之和, 持续增长说明处理跟不上
+	PendingBytes int64 `json:"pendingBytes"`
+	// Messages、Errors 最近一分钟BizHandler(或BatchHandler)处理的消息数和失败数
+	Messages  int64   `json:"messages"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+type WorkerPoolMetrics struct {
+	Workers int `json:"workers"`
+	// ReadyQueues 有消息等待worker的连接数, 长期大于Workers说明worker不足
+	ReadyQueues int `json:"readyQueues"`
+}
+
+// GetMetrics 连接相关的指标只统计本实例
+func GetMetrics() *Metrics {
+	now := time.Now()
+	m := &Metrics{Timestamp: now.UnixMilli(), ConnLimit: uint(globalQuota.utilization().Limit), Routes: make(map[string]*RouteMetrics)}
+	route := func(path string) *RouteMetrics {
+		rm := m.Routes[path]
+		if rm == nil {
+			rm = &RouteMetrics{}
+			m.Routes[path] = rm
+		}
+		return rm
+	}
+
+	RangeConnections(func(c *Connection) bool {
+		m.Connections++
+		rm := route(c.Path)
+		rm.Connections++
+		rm.PendingBytes += GetConnState(c.Ctx).PendingBytes()
+		return true
+	})
+	routeWindows.Range(func(key, value any) bool {
+		messages, errors := value.(*minuteWindow).sum(now)
+		if messages == 0 {
+			return true
+		}
+		rm := route(key.(string))
+		rm.Messages = messages
+		rm.Errors = errors
+		rm.ErrorRate = float64(errors) / float64(messages)
+		return true
+	})
+	if pool := workerPool; pool != nil {
+		m.WorkerPool = pool.metrics()
+	}
+
+	return m
+}
+
+// MetricsHandler 以json输出GetMetrics, 不做鉴权, 需要时在路由上加中间件
+func MetricsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetMetrics())
+}
+
+func (p *WorkerPool) metrics() *WorkerPoolMetrics {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return &WorkerPoolMetrics{Workers: p.size, ReadyQueues: len(p.ready)}
+}
+
+// minuteWindow 按秒分桶的一分钟滑动窗口
+type minuteWindow struct {
+	lock    sync.Mutex
+	buckets [metricsWindowSeconds]windowBucket
+}
+
+type windowBucket struct {
+	second   int64
+	messages int64
+	errors   int64
+}
+
+var routeWindows sync.Map
+
+func getRouteWindow(path string) *minuteWindow {
+	if v, ok := routeWindows.Load(path); ok {
+		return v.(*minuteWindow)
+	}
+	v, _ := routeWindows.LoadOrStore(path, &minuteWindow{})
+	return v.(*minuteWindow)
+}
+
+func (w *minuteWindow) record(now time.Time, messages int, failed bool) {
+	second := now.Unix()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	b := &w.buckets[second%metricsWindowSeconds]
+	if b.second != second {
+		*b = windowBucket{second: second}
+	}
+	b.messages += int64(messages)
+	if failed {
+		b.errors += int64(messages)
+	}
+}
+
+func (w *minuteWindow) sum(now time.Time) (int64, int64) {
+	second := now.Unix()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var messages, errors int64
+	for _, b := range w.buckets {
+		if second-b.second < metricsWindowSeconds {
+			messages += b.messages
+			errors += b.errors
+		}
+	}
+	return messages, errors
+}
+
+func withMetrics(path string, bizHandler wrapper.HandlerFunc[WebSocketMessage, error]) wrapper.HandlerFunc[WebSocketMessage, error] {
+	window := getRouteWindow(path)
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) error {
+		err := bizHandler(c, ctx, wsm)
+		window.record(time.Now(), 1, err != nil)
+		return err
+	}
+}
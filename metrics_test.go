@@ -0,0 +1,79 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	path := "/metrics-" + uuid.NewString()
+	engine := gin.New()
+	handled := make(chan struct{}, 4)
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group(path),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			defer func() {
+				handled <- struct{}{}
+			}()
+			if string(wsm.MessageData) == "fail" {
+				return errors.New("fail")
+			}
+			return nil
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+	})
+	engine.GET("/metrics", dgws.MetricsHandler)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path+"?bizId=metrics-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "metrics-a", 1)
+
+	for _, msg := range []string{"ok", "fail", "ok", "fail"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		select {
+		case <-handled:
+		case <-time.After(3 * time.Second):
+			t.Fatal("message not handled")
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	metrics := &dgws.Metrics{}
+	if err := json.NewDecoder(resp.Body).Decode(metrics); err != nil {
+		t.Fatalf("decode metrics: %v", err)
+	}
+	route := metrics.Routes[path]
+	if route == nil || route.Connections != 1 || route.Messages != 4 || route.Errors != 2 || route.ErrorRate != 0.5 {
+		t.Fatalf("unexpected route metrics: %+v", route)
+	}
+	if metrics.Connections < 1 || metrics.Timestamp == 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
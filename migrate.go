@@ -0,0 +1,97 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// EnvelopeTypeReconnect 通知客户端在WithinMs内重连到Url, 用于滚动发布时逐步迁移连接
+const EnvelopeTypeReconnect = "reconnect"
+
+const defaultMigrateWithin = 5 * time.Second
+
+// ReconnectInfo reconnect消息的Data, Url为空时客户端重连原地址(由负载均衡分配到其他节点)
+type ReconnectInfo struct {
+	Url      string `json:"url,omitempty"`
+	WithinMs int64  `json:"withinMs"`
+}
+
+// MigrateOptions 发布前迁移当前节点的连接
+type MigrateOptions struct {
+	Url string
+	// Within 客户端在该时间内随机选择时刻重连, 避免同时涌向新节点, 默认5s
+	Within time.Duration
+	// Rate 每秒最多通知的连接数, 0表示不限制
+	Rate int
+	// ForceCloseAfter 通知后超过该时间仍未断开的连接由服务端以1012(Service Restart)关闭, 0表示不关闭
+	ForceCloseAfter time.Duration
+	Filter          func(c *Connection) bool
+}
+
+// MigrateConnections 按Rate逐个向连接发送reconnect消息, 阻塞到全部通知完成或ctx取消, 返回已通知的连接数;
+// 开启会话时客户端以1012关闭连接, 会话在GraceWindow内保留, 可在新节点恢复
+func MigrateConnections(ctx *dgctx.DgContext, opts *MigrateOptions) (int, error) {
+	within := opts.Within
+	if within <= 0 {
+		within = defaultMigrateWithin
+	}
+	raw, err := json.Marshal(&ReconnectInfo{Url: opts.Url, WithinMs: within.Milliseconds()})
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(&Envelope{Type: EnvelopeTypeReconnect, Data: raw})
+	if err != nil {
+		return 0, err
+	}
+
+	var conns []*Connection
+	RangeConnections(func(c *Connection) bool {
+		if opts.Filter == nil || opts.Filter(c) {
+			conns = append(conns, c)
+		}
+		return true
+	})
+
+	var ticker *time.Ticker
+	if opts.Rate > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(opts.Rate))
+		defer ticker.Stop()
+	}
+	done := innerContext(ctx).Done()
+	notified := 0
+	for i, c := range conns {
+		if ticker != nil && i > 0 {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return notified, innerContext(ctx).Err()
+			}
+		}
+		if GetConnectionById(c.Id) == nil {
+			continue
+		}
+		if err := c.WriteMessage(websocket.TextMessage, data); err != nil {
+			dglogger.Warnf(c.Ctx, "[%s: %s] write reconnect error: %v", c.BizKey, c.BizId, err)
+			continue
+		}
+		notified++
+		if opts.ForceCloseAfter > 0 {
+			forceCloseAfter(c, opts.ForceCloseAfter)
+		}
+	}
+
+	return notified, nil
+}
+
+func forceCloseAfter(c *Connection, d time.Duration) {
+	time.AfterFunc(d, func() {
+		if GetConnectionById(c.Id) != c {
+			return
+		}
+		dglogger.Infof(c.Ctx, "[%s: %s] connection not migrated in time, close it", c.BizKey, c.BizId)
+		c.closeWith(websocket.CloseServiceRestart, "migrating")
+	})
+}
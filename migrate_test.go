@@ -0,0 +1,82 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestMigrateConnections(t *testing.T) {
+	handler := func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	}
+	oldNode := startTestServer(t, handler)
+	newNode := startTestServer(t, handler)
+
+	const clients = 5
+	for i := 0; i < clients; i++ {
+		client := newTestClient(t, &dgws.ClientConfig{Url: oldNode + "?bizId=migrate-old"})
+		client.Start()
+		defer client.Close()
+		waitState(t, client, dgws.ClientStateConnected)
+	}
+	waitConnections(t, "migrate-old", clients)
+
+	start := time.Now()
+	notified, err := dgws.MigrateConnections(dgctx.SimpleDgContext(), &dgws.MigrateOptions{
+		Url:    newNode + "?bizId=migrate-new",
+		Within: 50 * time.Millisecond,
+		Rate:   50,
+		Filter: func(c *dgws.Connection) bool {
+			return c.BizId == "migrate-old"
+		},
+	})
+	if err != nil || notified != clients {
+		t.Fatalf("expected %d notified, got %d, %v", clients, notified, err)
+	}
+	// Rate为50时5个连接至少间隔4个周期
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Fatalf("migration not paced: %v", elapsed)
+	}
+
+	waitConnections(t, "migrate-new", clients)
+	waitConnections(t, "migrate-old", 0)
+}
+
+func TestMigrateForceClose(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	// 不理会reconnect消息的客户端
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=migrate-stuck", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "migrate-stuck", 1)
+
+	notified, err := dgws.MigrateConnections(dgctx.SimpleDgContext(), &dgws.MigrateOptions{
+		Within:          10 * time.Millisecond,
+		ForceCloseAfter: 100 * time.Millisecond,
+		Filter: func(c *dgws.Connection) bool {
+			return c.BizId == "migrate-stuck"
+		},
+	})
+	if err != nil || notified != 1 {
+		t.Fatalf("expected 1 notified, got %d, %v", notified, err)
+	}
+
+	env := readEnvelope(t, conn)
+	if env.Type != dgws.EnvelopeTypeReconnect {
+		t.Fatalf("expected reconnect envelope, got %s", env.Type)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Fatalf("expected service restart close, got %v", err)
+	}
+	waitConnections(t, "migrate-stuck", 0)
+}
@@ -0,0 +1,49 @@
+package natsbroker
+
+import (
+	"context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/nats-io/nats.go"
+)
+
+// Broker 基于NATS subject的dgws.ClusterBroker, 延迟低于Redis pub/sub; subject为ChannelPrefix+"broadcast", 前缀建议以"."结尾
+type Broker struct {
+	conn *nats.Conn
+	// QueueGroup 为空时每个实例都收到广播; 设置后同组订阅者中只有一个收到,
+	// 仅适用于多个订阅者代表同一批连接的部署(如同一实例的多个订阅)
+	QueueGroup string
+}
+
+var _ dgws.ClusterBroker = (*Broker)(nil)
+
+func New(conn *nats.Conn) *Broker {
+	return &Broker{conn: conn}
+}
+
+func (b *Broker) Publish(_ context.Context, subject string, payload []byte) error {
+	return b.conn.Publish(subject, payload)
+}
+
+func (b *Broker) Subscribe(ctx context.Context, subject string, handler func(payload []byte)) (func() error, error) {
+	cb := func(msg *nats.Msg) {
+		handler(msg.Data)
+	}
+
+	var sub *nats.Subscription
+	var err error
+	if b.QueueGroup != "" {
+		sub, err = b.conn.QueueSubscribe(subject, b.QueueGroup, cb)
+	} else {
+		sub, err = b.conn.Subscribe(subject, cb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 确保服务端已登记订阅, 之后发布的消息不会丢失
+	if err := b.conn.FlushWithContext(ctx); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+
+	return sub.Unsubscribe, nil
+}
@@ -0,0 +1,106 @@
+package natsbroker_test
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/natsbroker"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connect 需要可用的NATS服务, 通过NATS_URL指定
+func connect(t *testing.T) *nats.Conn {
+	natsUrl := os.Getenv("NATS_URL")
+	if natsUrl == "" {
+		t.Skip("NATS_URL not set")
+	}
+	nc, err := nats.Connect(natsUrl)
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestBrokerPublishSubscribe(t *testing.T) {
+	broker := natsbroker.New(connect(t))
+	subject := "dgws.test." + uuid.NewString()
+	received := make(chan string, 1)
+	unsubscribe, err := broker.Subscribe(context.Background(), subject, func(payload []byte) {
+		received <- string(payload)
+	})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if err := broker.Publish(context.Background(), subject, []byte("hello")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "hello" {
+			t.Fatalf("unexpected payload: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("payload not received")
+	}
+
+	if err := unsubscribe(); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+}
+
+func TestClusterBroadcastViaNats(t *testing.T) {
+	nc := connect(t)
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	prefix := "dgws.test." + uuid.NewString() + "."
+	if err := dgws.InitCluster(ctx, &dgws.ClusterOptions{Broker: natsbroker.New(nc), ChannelPrefix: prefix}); err != nil {
+		t.Fatalf("init cluster: %v", err)
+	}
+	defer dgws.CloseCluster()
+
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/ws"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+			return nil
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "room",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("room")
+		},
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?room=nats-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for dgws.ConnectionCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	payload := []byte(`{"instanceId":"other","bizKey":"room","bizIds":["nats-a"],"mt":1,"data":"cmVtb3Rl"}`)
+	if err := nc.Publish(prefix+"broadcast", payload); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "remote" {
+		t.Fatalf("expected remote, got %s, %v", string(data), err)
+	}
+}
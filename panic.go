@@ -0,0 +1,47 @@
+package dgws
+
+import (
+	"errors"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"runtime/debug"
+)
+
+const panicCloseReason = "internal error"
+
+// ErrHandlerPanic BizHandler或BatchHandler panic时作为处理结果返回, 连接随后以1011关闭
+var ErrHandlerPanic = errors.New("biz handler panic")
+
+// OnPanicHandler recovered为recover()的返回值, stack为panic所在goroutine的堆栈
+type OnPanicHandler func(ctx *dgctx.DgContext, recovered any, stack []byte)
+
+// withRecover 在重试之外, panic不会被重试
+func withRecover(onPanic OnPanicHandler, bizKey string, bizId string, bizHandler wrapper.HandlerFunc[WebSocketMessage, error]) wrapper.HandlerFunc[WebSocketMessage, error] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) (err error) {
+		defer recoverPanic(ctx, onPanic, bizKey, bizId, &err)
+		return bizHandler(c, ctx, wsm)
+	}
+}
+
+// recoverPanic 必须直接defer调用: 以trace-id记录堆栈, 回调onPanic, 把连接迁移到draining并以1011关闭
+func recoverPanic(ctx *dgctx.DgContext, onPanic OnPanicHandler, bizKey string, bizId string, err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	dglogger.Errorf(ctx, "[%s: %s] biz handler panic: %v\n%s", bizKey, bizId, recovered, stack)
+	*err = fmt.Errorf("%w: %v", ErrHandlerPanic, recovered)
+	if onPanic != nil {
+		onPanic(ctx, recovered, stack)
+	}
+	GetConnState(ctx).transition(ConnectionStateDraining)
+	if connection := GetConnection(ctx); connection != nil {
+		connection.closeWith(websocket.CloseInternalServerErr, panicCloseReason)
+	}
+}
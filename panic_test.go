@@ -0,0 +1,75 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBizHandlerPanic(t *testing.T) {
+	panics := make(chan any, 1)
+	disconnected := make(chan int, 1)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		OnPanic: func(_ *dgctx.DgContext, recovered any, stack []byte) {
+			if !strings.Contains(string(stack), "panic_test.go") {
+				t.Errorf("stack should contain the panicking handler: %s", stack)
+			}
+			panics <- recovered
+		},
+		OnDisconnect: func(_ *dgctx.DgContext, _ *websocket.Conn, closeCode int, _ string) {
+			disconnected <- closeCode
+		},
+	}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "boom" {
+			panic("boom")
+		}
+		return wsm.Connection.WriteMessage(websocket.TextMessage, wsm.MessageData)
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=panic", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("boom")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case recovered := <-panics:
+		if recovered != "boom" {
+			t.Fatalf("unexpected recovered value: %v", recovered)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnPanic not called")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseInternalServerErr) {
+		t.Fatalf("expected 1011, got %v", err)
+	}
+	select {
+	case code := <-disconnected:
+		if code != websocket.CloseInternalServerErr {
+			t.Fatalf("unexpected disconnect code: %d", code)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+
+	// 服务仍可接受新连接
+	conn2, _, err := websocket.DefaultDialer.Dial(url+"?bizId=after", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn2.Close()
+	if err := conn2.WriteMessage(websocket.TextMessage, []byte("ok")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, data, err := conn2.ReadMessage(); err != nil || string(data) != "ok" {
+		t.Fatalf("read: %s, %v", data, err)
+	}
+}
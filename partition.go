@@ -0,0 +1,93 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"sync"
+)
+
+// PartitionKeyFunc 返回消息的分区key, 相同key的消息按到达顺序处理, 返回空串时使用连接默认分区
+type PartitionKeyFunc func(ctx *dgctx.DgContext, wsm *WebSocketMessage) string
+
+// PartitionByEnvelopeField 取json消息顶层字段作为分区key, 字段不存在或消息不是json对象时使用连接默认分区
+func PartitionByEnvelopeField(field string) PartitionKeyFunc {
+	return func(_ *dgctx.DgContext, wsm *WebSocketMessage) string {
+		data := wsm.MessageData
+		if len(data) == 0 || data[0] != '{' {
+			return ""
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return ""
+		}
+		raw, ok := fields[field]
+		if !ok {
+			return ""
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+
+		return string(raw)
+	}
+}
+
+// keyedDispatcher 同一分区key的消息按顺序执行, 不同key的消息在worker池中并行执行;
+// 排队消息总数仍受maxPendingPerConn限制, 分区队列清空后即释放
+type keyedDispatcher struct {
+	pool   *WorkerPool
+	slots  chan struct{}
+	lock   sync.Mutex
+	queues map[string]*keyedQueue
+	wg     sync.WaitGroup
+}
+
+type keyedQueue struct {
+	queue   *connQueue
+	pending int
+}
+
+func (p *WorkerPool) newKeyedDispatcher() *keyedDispatcher {
+	return &keyedDispatcher{pool: p, slots: make(chan struct{}, p.pending), queues: make(map[string]*keyedQueue)}
+}
+
+func (d *keyedDispatcher) submit(task func()) {
+	d.submitKey("", task)
+}
+
+func (d *keyedDispatcher) submitKey(key string, task func()) {
+	d.slots <- struct{}{}
+	d.wg.Add(1)
+
+	d.lock.Lock()
+	kq := d.queues[key]
+	if kq == nil {
+		kq = &keyedQueue{queue: d.pool.newQueue()}
+		d.queues[key] = kq
+	}
+	kq.pending++
+	d.lock.Unlock()
+
+	kq.queue.submit(func() {
+		defer d.done(key, kq)
+		task()
+	})
+}
+
+func (d *keyedDispatcher) done(key string, kq *keyedQueue) {
+	d.lock.Lock()
+	kq.pending--
+	if kq.pending == 0 && d.queues[key] == kq {
+		delete(d.queues, key)
+	}
+	d.lock.Unlock()
+
+	<-d.slots
+	d.wg.Done()
+}
+
+func (d *keyedDispatcher) wait() {
+	d.wg.Wait()
+}
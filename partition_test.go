@@ -0,0 +1,139 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"sync"
+	"testing"
+	"time"
+)
+
+type partitionedMessage struct {
+	ConversationId string `json:"conversationId"`
+	Index          int    `json:"index"`
+}
+
+func TestPartitionKeyOrdering(t *testing.T) {
+	dgws.InitWorkerPool(4, 64)
+
+	release := make(chan struct{})
+	received := make(chan *partitionedMessage, 100)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		EnableWorkerPool: true,
+		PartitionKey:     dgws.PartitionByEnvelopeField("conversationId"),
+	}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		msg := &partitionedMessage{}
+		if err := json.Unmarshal(wsm.MessageData, msg); err != nil {
+			return err
+		}
+		// 会话a的第一条消息阻塞到会话b全部处理完, 按连接排序时会一直等待
+		if msg.ConversationId == "a" && msg.Index == 0 {
+			<-release
+		}
+		received <- msg
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	const perKey = 10
+	for i := 0; i < perKey; i++ {
+		for _, key := range []string{"a", "b"} {
+			if err := conn.WriteJSON(&partitionedMessage{ConversationId: key, Index: i}); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+	}
+
+	next := map[string]int{}
+	for i := 0; i < 2*perKey; i++ {
+		select {
+		case msg := <-received:
+			if msg.Index != next[msg.ConversationId] {
+				t.Fatalf("conversation %s: expected %d, got %d", msg.ConversationId, next[msg.ConversationId], msg.Index)
+			}
+			next[msg.ConversationId]++
+			if next["b"] == perKey && next["a"] == 0 {
+				close(release)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("messages not handled, progress: %v", next)
+		}
+	}
+}
+
+func TestPartitionByEnvelopeField(t *testing.T) {
+	key := dgws.PartitionByEnvelopeField("conversationId")
+	cases := map[string]string{
+		`{"conversationId":"c1","index":1}`: "c1",
+		`{"conversationId":42}`:             "42",
+		`{"index":1}`:                       "",
+		`plain text`:                        "",
+	}
+	for data, want := range cases {
+		if got := key(nil, &dgws.WebSocketMessage{MessageData: []byte(data)}); got != want {
+			t.Fatalf("%s: expected %q, got %q", data, want, got)
+		}
+	}
+}
+
+func TestPartitionKeyIsolatesContext(t *testing.T) {
+	dgws.InitWorkerPool(4, 64)
+
+	// 两个分区的BizHandler同时修改ctx, 都写完后再检查
+	var written sync.WaitGroup
+	written.Add(2)
+	done := make(chan error, 2)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		EnableWorkerPool: true,
+		PartitionKey:     dgws.PartitionByEnvelopeField("conversationId"),
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		msg := &partitionedMessage{}
+		if err := json.Unmarshal(wsm.MessageData, msg); err != nil {
+			return err
+		}
+		ctx.Platform = msg.ConversationId
+		ctx.SetExtraKeyValue("conversationId", msg.ConversationId)
+		written.Done()
+		written.Wait()
+		if ctx.Platform != msg.ConversationId || ctx.GetExtraValue("conversationId") != msg.ConversationId {
+			done <- fmt.Errorf("conversation %s sees ctx of %s", msg.ConversationId, ctx.Platform)
+			return nil
+		}
+		if dgws.GetConnection(ctx) == nil {
+			done <- fmt.Errorf("conversation %s lost the connection state", msg.ConversationId)
+			return nil
+		}
+		done <- nil
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	for _, key := range []string{"a", "b"} {
+		if err := conn.WriteJSON(&partitionedMessage{ConversationId: key}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for range 2 {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("messages not handled in parallel")
+		}
+	}
+}
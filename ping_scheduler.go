@@ -0,0 +1,275 @@
+package dgws
+
+import (
+	"container/heap"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gorilla/websocket"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultPingWriteWait 未设置WriteWait时ping的写超时, gorilla的WriteControl在没有deadline时会长时间等待写锁
+	defaultPingWriteWait = 5 * time.Second
+	// pingSenders 每个分片写ping的goroutine数, 个别连接写阻塞时由其余sender继续发送
+	pingSenders = 4
+	// pingQueueSize 每个分片到期待发送的ping数, 队列满时调度goroutine等待sender
+	pingQueueSize = 256
+)
+
+// pingScheduler 用最小堆统一调度多个连接的ping, 每个分片占用一个调度goroutine和固定数量的sender
+type pingScheduler struct {
+	lock  sync.Mutex
+	tasks pingTaskHeap
+	wake  chan struct{}
+	due   chan *pingTask
+}
+
+type pingTask struct {
+	scheduler *pingScheduler
+	ctx       *dgctx.DgContext
+	conn      *websocket.Conn
+	period    time.Duration
+	writeWait time.Duration
+	next      time.Time
+	index     int
+	canceled  bool
+	// basePeriod/maxPeriod 自适应ping的区间, maxPeriod为0时固定使用period
+	basePeriod time.Duration
+	maxPeriod  time.Duration
+	sentAt     time.Time
+	pongAt     atomic.Int64
+	// maxMissed 大于0时连续maxMissed次以上未收到pong才关闭连接
+	maxMissed int
+	missed    int
+	onMissed  MissedPongHandler
+}
+
+// MissedPongHandler 每次发现上一个ping未收到pong时回调, missed为连续丢失的次数; 在ping调度goroutine中执行, 不应阻塞
+type MissedPongHandler func(ctx *dgctx.DgContext, missed int)
+
+var (
+	pingSchedulers     []*pingScheduler
+	pingSchedulersOnce sync.Once
+	pingSchedulerSeq   atomic.Uint64
+)
+
+// schedulePing 注册连接的定时ping, 连接结束时需调用返回任务的cancel;
+// maxPeriod大于period时开启自适应, 链路稳定时逐步拉长间隔, pong延迟或丢失时恢复为period
+func schedulePing(ctx *dgctx.DgContext, conn *websocket.Conn, period time.Duration, maxPeriod time.Duration, writeWait time.Duration, maxMissed int, onMissed MissedPongHandler) *pingTask {
+	initPingSchedulers()
+	s := pingSchedulers[pingSchedulerSeq.Add(1)%uint64(len(pingSchedulers))]
+	task := &pingTask{scheduler: s, ctx: ctx, conn: conn, period: period, writeWait: writeWait, next: getClock().Now().Add(period), basePeriod: period, maxMissed: maxMissed, onMissed: onMissed}
+	if maxPeriod > period {
+		task.maxPeriod = maxPeriod
+	}
+	s.add(task)
+
+	return task
+}
+
+func initPingSchedulers() {
+	pingSchedulersOnce.Do(func() {
+		pingSchedulers = make([]*pingScheduler, runtime.GOMAXPROCS(0))
+		for i := range pingSchedulers {
+			s := &pingScheduler{wake: make(chan struct{}, 1), due: make(chan *pingTask, pingQueueSize)}
+			pingSchedulers[i] = s
+			go s.run()
+			for range pingSenders {
+				go s.send()
+			}
+		}
+	})
+}
+
+func (task *pingTask) cancel() {
+	task.scheduler.remove(task)
+}
+
+// pong 在连接的pong handler中调用, 用于计算RTT
+func (task *pingTask) pong() {
+	task.pongAt.Store(getClock().Now().UnixNano())
+}
+
+// adapt 根据上一次ping的结果调整间隔: 按时收到pong且RTT较小则增加basePeriod, 否则恢复为basePeriod
+func (task *pingTask) adapt() {
+	if task.maxPeriod == 0 || task.sentAt.IsZero() {
+		return
+	}
+
+	pongAt := task.pongAt.Load()
+	if pongAt < task.sentAt.UnixNano() {
+		task.period = task.basePeriod
+		return
+	}
+	if rtt := time.Duration(pongAt - task.sentAt.UnixNano()); rtt > task.basePeriod/10 {
+		task.period = task.basePeriod
+		return
+	}
+	task.period = min(task.period+task.basePeriod, task.maxPeriod)
+}
+
+// checkMissed 上一个ping未收到pong时累加丢失次数, 超过maxMissed返回false
+func (task *pingTask) checkMissed() bool {
+	if task.maxMissed <= 0 || task.sentAt.IsZero() {
+		return true
+	}
+	if task.pongAt.Load() >= task.sentAt.UnixNano() {
+		task.missed = 0
+		return true
+	}
+
+	task.missed++
+	if task.onMissed != nil {
+		task.onMissed(task.ctx, task.missed)
+	}
+
+	return task.missed <= task.maxMissed
+}
+
+func (s *pingScheduler) add(task *pingTask) {
+	s.lock.Lock()
+	heap.Push(&s.tasks, task)
+	first := task.index == 0
+	s.lock.Unlock()
+
+	if first {
+		s.notify()
+	}
+}
+
+func (s *pingScheduler) remove(task *pingTask) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	task.canceled = true
+	if task.index >= 0 {
+		heap.Remove(&s.tasks, task.index)
+	}
+}
+
+func wakePingSchedulers() {
+	initPingSchedulers()
+	for _, s := range pingSchedulers {
+		s.notify()
+	}
+}
+
+func (s *pingScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 每次等待都从当前时钟创建timer, SetClock后唤醒即可切换到新时钟
+func (s *pingScheduler) run() {
+	for {
+		clock := getClock()
+		s.lock.Lock()
+		var wait time.Duration
+		var due *pingTask
+		if len(s.tasks) > 0 {
+			if wait = s.tasks[0].next.Sub(clock.Now()); wait <= 0 {
+				due = heap.Pop(&s.tasks).(*pingTask)
+			}
+		}
+		idle := len(s.tasks) == 0 && due == nil
+		s.lock.Unlock()
+
+		// 写ping交给sender, 个别连接写阻塞时不影响同一分片的其他连接; 发送期间任务不在堆中, 不会重复发送
+		if due != nil {
+			s.due <- due
+			continue
+		}
+		if idle {
+			<-s.wake
+			continue
+		}
+
+		timer := clock.NewTimer(wait)
+		select {
+		case <-timer.Chan():
+		case <-s.wake:
+		}
+		timer.Stop()
+	}
+}
+
+func (s *pingScheduler) send() {
+	for task := range s.due {
+		s.ping(task)
+	}
+}
+
+func (s *pingScheduler) ping(task *pingTask) {
+	if IsWsEnded(task.ctx) {
+		return
+	}
+
+	if !task.checkMissed() {
+		dglogger.Warnf(task.ctx, "missed %d pongs, close connection", task.missed)
+		_ = task.conn.NetConn().Close()
+		return
+	}
+	task.adapt()
+	sentAt := getClock().Now()
+	writeWait := task.writeWait
+	if writeWait <= 0 {
+		writeWait = defaultPingWriteWait
+	}
+	if err := task.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+		dglogger.Warnf(task.ctx, "write ping error: %v", err)
+		return
+	}
+
+	s.lock.Lock()
+	task.sentAt = sentAt
+	// 发送ping期间连接可能已注销, 注销后不再重新调度
+	if task.canceled {
+		s.lock.Unlock()
+		return
+	}
+	task.next = task.next.Add(task.period)
+	if now := getClock().Now(); task.next.Before(now) {
+		task.next = now.Add(task.period)
+	}
+	heap.Push(&s.tasks, task)
+	first := task.index == 0
+	s.lock.Unlock()
+
+	// 调度goroutine可能已因堆为空而休眠
+	if first {
+		s.notify()
+	}
+}
+
+type pingTaskHeap []*pingTask
+
+func (h pingTaskHeap) Len() int { return len(h) }
+
+func (h pingTaskHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+
+func (h pingTaskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pingTaskHeap) Push(x any) {
+	task := x.(*pingTask)
+	task.index = len(*h)
+	*h = append(*h, task)
+}
+
+func (h *pingTaskHeap) Pop() any {
+	old := *h
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	task.index = -1
+	*h = old[:n-1]
+	return task
+}
@@ -0,0 +1,267 @@
+package dgws_test
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerPing(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{PingPeriod: 20 * time.Millisecond}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	var pings [3]atomic.Int32
+	for i := range pings {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+
+		conn.SetPingHandler(func(string) error {
+			pings[i].Add(1)
+			return nil
+		})
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	for i := range pings {
+		if n := pings[i].Load(); n < 3 {
+			t.Fatalf("connection %d received %d pings", i, n)
+		}
+	}
+}
+
+func TestSlowHandlerDoesNotMissPong(t *testing.T) {
+	handled := make(chan string, 2)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		PingPeriod: 20 * time.Millisecond,
+		PongWait:   100 * time.Millisecond,
+	}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		handled <- string(wsm.MessageData)
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("slow"))
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("fast"))
+	for _, expected := range []string{"slow", "fast"} {
+		select {
+		case msg := <-handled:
+			if msg != expected {
+				t.Fatalf("expected %s, got %s", expected, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %s not handled, connection dropped", expected)
+		}
+	}
+}
+
+func TestAdaptivePing(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		PingPeriod:    20 * time.Millisecond,
+		MaxPingPeriod: 100 * time.Millisecond,
+	}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	dial := func(replyPong bool) *atomic.Int32 {
+		var pings atomic.Int32
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		conn.SetPingHandler(func(data string) error {
+			pings.Add(1)
+			if replyPong {
+				return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+			}
+			return nil
+		})
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		return &pings
+	}
+
+	healthy := dial(true)
+	silent := dial(false)
+	time.Sleep(600 * time.Millisecond)
+
+	if healthy.Load()*2 >= silent.Load() {
+		t.Fatalf("expected fewer pings for healthy connection, healthy: %d, silent: %d", healthy.Load(), silent.Load())
+	}
+}
+
+func TestMissedPongsTolerated(t *testing.T) {
+	var drop atomic.Bool
+	drop.Store(true)
+	missed := make(chan int, 100)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		PingPeriod:     30 * time.Millisecond,
+		PongWait:       40 * time.Millisecond,
+		MaxMissedPongs: 3,
+		MissedPongHandler: func(_ *dgctx.DgContext, n int) {
+			if n == 2 {
+				drop.Store(false)
+			}
+			missed <- n
+		},
+	}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetPingHandler(func(data string) error {
+		if drop.Load() {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	// 超过PongWait未收到pong, 但未超过MaxMissedPongs, 连接保持
+	select {
+	case err := <-closed:
+		t.Fatalf("connection closed after delayed pongs: %v", err)
+	case <-time.After(400 * time.Millisecond):
+	}
+	for len(missed) > 0 {
+		if n := <-missed; n > 2 {
+			t.Fatalf("missed count %d after pongs resumed", n)
+		}
+	}
+}
+
+func TestMissedPongsCloseConnection(t *testing.T) {
+	missed := make(chan int, 100)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		PingPeriod:     30 * time.Millisecond,
+		MaxMissedPongs: 2,
+		MissedPongHandler: func(_ *dgctx.DgContext, n int) {
+			missed <- n
+		},
+	}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetPingHandler(func(string) error {
+		return nil
+	})
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				t.Fatal("connection not closed after missed pongs")
+			}
+			break
+		}
+	}
+	if n := len(missed); n != 3 {
+		t.Fatalf("expected 3 missed pong callbacks, got %d", n)
+	}
+}
+
+func TestPingNotBlockedByStalledConnection(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{PingPeriod: 30 * time.Millisecond}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "flood" {
+			// 客户端不读取, 写入一直阻塞并占用gorilla的写锁, 直到客户端断开
+			return dgws.GetSafeConn(ctx).WriteMessage(websocket.BinaryMessage, make([]byte, 32<<20))
+		}
+		return nil
+	})
+
+	// 连接按顺序分配到ping分片, 让每个分片上都有一个写阻塞的连接
+	for range runtime.GOMAXPROCS(0) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("flood"))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	var pings atomic.Int32
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetPingHandler(func(string) error {
+		pings.Add(1)
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	if n := pings.Load(); n < 3 {
+		t.Fatalf("pings blocked by stalled connections, got %d", n)
+	}
+}
@@ -0,0 +1,77 @@
+package dgws
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"io"
+	"sync"
+)
+
+// 超过该容量的缓冲区不回收, 避免偶发的大消息长期占用内存
+const maxPooledBufferSize = 64 * 1024
+
+var (
+	bufferPool = sync.Pool{
+		New: func() any {
+			return new(bytes.Buffer)
+		},
+	}
+	messagePool = sync.Pool{
+		New: func() any {
+			return new(WebSocketMessage)
+		},
+	}
+)
+
+// readPooledMessage 与conn.ReadMessage语义一致, 但消息体读入池化的缓冲区
+func readPooledMessage(conn *websocket.Conn, limit int64) (int, []byte, *bytes.Buffer, error) {
+	mt, r, err := conn.NextReader()
+	if err != nil {
+		return mt, nil, nil, err
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if _, err = buf.ReadFrom(r); err != nil {
+		putBuffer(buf)
+		return -1, nil, nil, err
+	}
+	if limit > 0 && int64(buf.Len()) > limit {
+		putBuffer(buf)
+		return -1, nil, nil, websocket.ErrReadLimit
+	}
+
+	return mt, buf.Bytes(), buf, nil
+}
+
+func acquireMessage(conn *websocket.Conn, mt int, data []byte, buf *bytes.Buffer) *WebSocketMessage {
+	wsm := messagePool.Get().(*WebSocketMessage)
+	wsm.Connection = conn
+	wsm.MessageType = mt
+	wsm.MessageData = data
+	wsm.buf = buf
+	return wsm
+}
+
+// releaseMessage 已Retain的消息由调用方持有, 不再回收
+func releaseMessage(wsm *WebSocketMessage) {
+	if wsm.retained {
+		return
+	}
+
+	buf := wsm.buf
+	*wsm = WebSocketMessage{}
+	messagePool.Put(wsm)
+	if buf != nil {
+		putBuffer(buf)
+	}
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
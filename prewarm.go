@@ -0,0 +1,61 @@
+package dgws
+
+import (
+	"bytes"
+)
+
+// CapacityHints 预估的容量, 用于启动时预先分配, 避免流量爬坡阶段扩容带来的延迟抖动;
+// worker池的大小由InitWorkerPool指定
+type CapacityHints struct {
+	// ExpectedConnections 单进程预计的最大连接数, 用于预分配连接注册表和ping调度堆
+	ExpectedConnections int
+	// MessageSize 典型消息字节数, 预热的读缓冲区按该大小分配, 超过64KB时按64KB
+	MessageSize int
+	// PooledMessages 预热的读缓冲区和消息对象数量, 仅在开启EnableMessagePool时有意义
+	PooledMessages int
+}
+
+// Prewarm 按容量预估预先分配, 应在服务启动、接收连接之前调用
+func Prewarm(hints CapacityHints) {
+	if hints.ExpectedConnections > 0 {
+		registry.grow(hints.ExpectedConnections)
+		growPingSchedulers(hints.ExpectedConnections)
+	}
+
+	size := min(hints.MessageSize, maxPooledBufferSize)
+	for i := 0; i < hints.PooledMessages; i++ {
+		buf := new(bytes.Buffer)
+		if size > 0 {
+			buf.Grow(size)
+		}
+		bufferPool.Put(buf)
+		messagePool.Put(new(WebSocketMessage))
+	}
+}
+
+func (r *connRegistry) grow(expected int) {
+	perShard := expected/registryShardCount + 1
+	for _, s := range r.shards {
+		s.lock.Lock()
+		conns := make(map[string]*Connection, max(perShard, len(s.conns)))
+		for id, c := range s.conns {
+			conns[id] = c
+		}
+		s.conns = conns
+		s.lock.Unlock()
+	}
+}
+
+func growPingSchedulers(expected int) {
+	initPingSchedulers()
+	perScheduler := expected/len(pingSchedulers) + 1
+	for _, s := range pingSchedulers {
+		s.lock.Lock()
+		if cap(s.tasks) < perScheduler {
+			tasks := make(pingTaskHeap, len(s.tasks), perScheduler)
+			copy(tasks, s.tasks)
+			s.tasks = tasks
+		}
+		s.lock.Unlock()
+	}
+}
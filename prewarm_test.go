@@ -0,0 +1,33 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestPrewarm(t *testing.T) {
+	dgws.Prewarm(dgws.CapacityHints{ExpectedConnections: 10000, MessageSize: 4096, PooledMessages: 64})
+
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{EnableMessagePool: true, PingPeriod: time.Second}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return wsm.Connection.WriteMessage(wsm.MessageType, wsm.MessageData)
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=prewarm", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected echo: %s, %v", string(data), err)
+	}
+	waitConnections(t, "prewarm", 1)
+}
@@ -0,0 +1,197 @@
+package dgws
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemaString  = "string"
+	SchemaInteger = "integer"
+	SchemaNumber  = "number"
+	SchemaBoolean = "boolean"
+	SchemaObject  = "object"
+	SchemaArray   = "array"
+	// SchemaAny 自定义序列化或interface等无法推断的类型, 比较时与任何类型兼容
+	SchemaAny = "any"
+)
+
+// EnvelopeSchemaType 内置Envelope登记的消息类型
+const EnvelopeSchemaType = "envelope"
+
+// Schema 消息的json结构, Fields为对象的字段, Items为数组元素或map的值
+type Schema struct {
+	Type   string             `json:"type"`
+	Fields map[string]*Schema `json:"fields,omitempty"`
+	Items  *Schema            `json:"items,omitempty"`
+}
+
+var (
+	protocolSchemas     = map[string]*Schema{EnvelopeSchemaType: SchemaOf(Envelope{})}
+	protocolSchemasLock sync.RWMutex
+)
+
+// RegisterMessageSchema 登记消息类型对应的Go结构, 用于CheckProtocolCompatibility; 重复登记时覆盖
+func RegisterMessageSchema(messageType string, v any) {
+	protocolSchemasLock.Lock()
+	defer protocolSchemasLock.Unlock()
+	protocolSchemas[messageType] = SchemaOf(v)
+}
+
+// ProtocolSchemas 当前登记的所有消息类型, 包括内置的envelope
+func ProtocolSchemas() map[string]*Schema {
+	protocolSchemasLock.RLock()
+	defer protocolSchemasLock.RUnlock()
+	return maps.Clone(protocolSchemas)
+}
+
+// SchemaOf 按encoding/json的规则推断v序列化后的结构
+func SchemaOf(v any) *Schema {
+	return schemaOf(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf visiting用于截断自引用的结构
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	if t == nil {
+		return &Schema{Type: SchemaAny}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: SchemaString}
+	case t == rawMessageType || t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return &Schema{Type: SchemaAny}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: SchemaString}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: SchemaString}
+	case reflect.Bool:
+		return &Schema{Type: SchemaBoolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: SchemaInteger}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaNumber}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte序列化为base64字符串
+			return &Schema{Type: SchemaString}
+		}
+		return &Schema{Type: SchemaArray, Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return &Schema{Type: SchemaObject, Items: schemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return &Schema{Type: SchemaObject}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		s := &Schema{Type: SchemaObject, Fields: make(map[string]*Schema)}
+		addStructFields(s, t, visiting)
+		return s
+	default:
+		return &Schema{Type: SchemaAny}
+	}
+}
+
+// addStructFields 匿名嵌入且没有json名称的结构体字段展开到外层, 与encoding/json一致
+func addStructFields(s *Schema, t reflect.Type, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(s, ft, visiting)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if slices.Contains(strings.Split(opts, ","), "string") {
+			s.Fields[name] = &Schema{Type: SchemaString}
+			continue
+		}
+		s.Fields[name] = schemaOf(f.Type, visiting)
+	}
+}
+
+// WriteProtocolBaseline 以json保存schemas, 通常保存ProtocolSchemas()并随代码提交
+func WriteProtocolBaseline(w io.Writer, schemas map[string]*Schema) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schemas)
+}
+
+func ReadProtocolBaseline(r io.Reader) (map[string]*Schema, error) {
+	schemas := make(map[string]*Schema)
+	if err := json.NewDecoder(r).Decode(&schemas); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// CheckProtocolCompatibility 返回current相对baseline不向后兼容的变化: 删除消息类型、删除字段、字段类型变化;
+// 新增的消息类型和字段视为兼容. 结果按路径排序, 为空表示兼容
+func CheckProtocolCompatibility(baseline map[string]*Schema, current map[string]*Schema) []string {
+	var breaks []string
+	for messageType, old := range baseline {
+		s, ok := current[messageType]
+		if !ok {
+			breaks = append(breaks, fmt.Sprintf("%s: message type removed", messageType))
+			continue
+		}
+		breaks = compareSchema(messageType, old, s, breaks)
+	}
+	slices.Sort(breaks)
+	return breaks
+}
+
+func compareSchema(path string, old *Schema, s *Schema, breaks []string) []string {
+	if old == nil || s == nil || old.Type == SchemaAny || s.Type == SchemaAny {
+		return breaks
+	}
+	if old.Type != s.Type {
+		return append(breaks, fmt.Sprintf("%s: type changed from %s to %s", path, old.Type, s.Type))
+	}
+	for name, field := range old.Fields {
+		current, ok := s.Fields[name]
+		if !ok {
+			breaks = append(breaks, fmt.Sprintf("%s.%s: field removed", path, name))
+			continue
+		}
+		breaks = compareSchema(path+"."+name, field, current, breaks)
+	}
+	return compareSchema(path+"[]", old.Items, s.Items, breaks)
+}
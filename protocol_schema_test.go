@@ -0,0 +1,74 @@
+package dgws_test
+
+import (
+	"bytes"
+	dgws "github.com/darwinOrg/go-websocket"
+	"slices"
+	"testing"
+	"time"
+)
+
+type chatMessageV1 struct {
+	Room    string    `json:"room"`
+	Content string    `json:"content"`
+	Mention []string  `json:"mention,omitempty"`
+	SentAt  time.Time `json:"sentAt"`
+	Ignored string    `json:"-"`
+}
+
+type chatMessageV2 struct {
+	Room    int64             `json:"room"`
+	Mention []int64           `json:"mention,omitempty"`
+	SentAt  time.Time         `json:"sentAt"`
+	Extra   map[string]string `json:"extra"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := dgws.SchemaOf(&chatMessageV1{})
+	if s.Type != dgws.SchemaObject || len(s.Fields) != 4 {
+		t.Fatalf("unexpected schema: %+v", s)
+	}
+	if s.Fields["mention"].Type != dgws.SchemaArray || s.Fields["mention"].Items.Type != dgws.SchemaString {
+		t.Fatalf("unexpected mention schema: %+v", s.Fields["mention"])
+	}
+	if s.Fields["sentAt"].Type != dgws.SchemaString {
+		t.Fatalf("time should be a string: %+v", s.Fields["sentAt"])
+	}
+
+	env := dgws.ProtocolSchemas()[dgws.EnvelopeSchemaType]
+	if env == nil || env.Fields["type"].Type != dgws.SchemaString || env.Fields["data"].Type != dgws.SchemaAny {
+		t.Fatalf("unexpected envelope schema: %+v", env)
+	}
+}
+
+func TestCheckProtocolCompatibility(t *testing.T) {
+	dgws.RegisterMessageSchema("chat", chatMessageV1{})
+	dgws.RegisterMessageSchema("typing", struct {
+		Room string `json:"room"`
+	}{})
+	var buf bytes.Buffer
+	if err := dgws.WriteProtocolBaseline(&buf, dgws.ProtocolSchemas()); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := dgws.ReadProtocolBaseline(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breaks := dgws.CheckProtocolCompatibility(baseline, dgws.ProtocolSchemas()); len(breaks) > 0 {
+		t.Fatalf("unchanged schemas should be compatible: %v", breaks)
+	}
+
+	current := dgws.ProtocolSchemas()
+	current["chat"] = dgws.SchemaOf(chatMessageV2{})
+	delete(current, "typing")
+	current["presence"] = dgws.SchemaOf(struct{}{})
+	want := []string{
+		"chat.content: field removed",
+		"chat.mention[]: type changed from string to integer",
+		"chat.room: type changed from string to integer",
+		"typing: message type removed",
+	}
+	if breaks := dgws.CheckProtocolCompatibility(baseline, current); !slices.Equal(breaks, want) {
+		t.Fatalf("unexpected breaks: %v", breaks)
+	}
+}
@@ -0,0 +1,199 @@
+package dgws
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaUtilization Limit为0表示不限制
+type QuotaUtilization struct {
+	Limit int `json:"limit"`
+	Used  int `json:"used"`
+}
+
+// QuotaUsage 本实例的连接配额及当前占用
+type QuotaUsage struct {
+	Global QuotaUtilization            `json:"global"`
+	Routes map[string]QuotaUtilization `json:"routes,omitempty"`
+	// UserLimit 每个用户默认的连接数上限
+	UserLimit int `json:"userLimit"`
+	// Users 单独设置过上限的用户
+	Users map[int64]QuotaUtilization `json:"users,omitempty"`
+}
+
+// connQuota 可在运行时调整大小的信号量, 调小后已建立的连接不受影响, 占用降到上限以下才接受新连接
+type connQuota struct {
+	lock   sync.Mutex
+	limit  int
+	used   int
+	notify chan struct{}
+}
+
+func newConnQuota(limit int) *connQuota {
+	return &connQuota{limit: limit, notify: make(chan struct{})}
+}
+
+// acquire timeout为0时不等待
+func (q *connQuota) acquire(timeout time.Duration) bool {
+	var timer *time.Timer
+	for {
+		q.lock.Lock()
+		if q.limit <= 0 || q.used < q.limit {
+			q.used++
+			q.lock.Unlock()
+			return true
+		}
+		notify := q.notify
+		q.lock.Unlock()
+
+		if timeout <= 0 {
+			return false
+		}
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+func (q *connQuota) release() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.used--
+	q.wake()
+}
+
+func (q *connQuota) setLimit(limit int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.limit = limit
+	q.wake()
+}
+
+// wake 唤醒所有等待者重新竞争, 需持有lock
+func (q *connQuota) wake() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+func (q *connQuota) utilization() QuotaUtilization {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return QuotaUtilization{Limit: q.limit, Used: q.used}
+}
+
+var (
+	globalQuota = newConnQuota(0)
+	routeQuotas sync.Map
+)
+
+// SetConnLimit 调整本实例的连接数上限, 0表示不限制, 等同于InitWsConnLimit
+func SetConnLimit(limit uint) {
+	globalQuota.setLimit(int(limit))
+}
+
+// SetRouteConnLimit 调整单个路由的连接数上限, path为路由的完整路径, 0表示不限制
+func SetRouteConnLimit(path string, limit int) {
+	getRouteQuota(path, true).setLimit(limit)
+}
+
+func getRouteQuota(path string, create bool) *connQuota {
+	if v, ok := routeQuotas.Load(path); ok {
+		return v.(*connQuota)
+	}
+	if !create {
+		return nil
+	}
+	v, _ := routeQuotas.LoadOrStore(path, newConnQuota(0))
+	return v.(*connQuota)
+}
+
+// userQuota 只统计登录用户(UserId大于0)的连接
+type userQuota struct {
+	lock      sync.Mutex
+	limit     int
+	overrides map[int64]int
+	used      map[int64]int
+}
+
+var userQuotas = &userQuota{overrides: make(map[int64]int), used: make(map[int64]int)}
+
+// SetUserConnLimit 调整每个用户默认的连接数上限, 0表示不限制
+func SetUserConnLimit(limit int) {
+	userQuotas.lock.Lock()
+	defer userQuotas.lock.Unlock()
+	userQuotas.limit = limit
+}
+
+// SetUserConnLimitFor 单独调整某个用户的连接数上限, 0表示不限制, 小于0时恢复默认上限
+func SetUserConnLimitFor(userId int64, limit int) {
+	userQuotas.lock.Lock()
+	defer userQuotas.lock.Unlock()
+	if limit < 0 {
+		delete(userQuotas.overrides, userId)
+	} else {
+		userQuotas.overrides[userId] = limit
+	}
+}
+
+func (q *userQuota) limitOf(userId int64) int {
+	if limit, ok := q.overrides[userId]; ok {
+		return limit
+	}
+	return q.limit
+}
+
+func (q *userQuota) acquire(userId int64) bool {
+	if userId <= 0 {
+		return true
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if limit := q.limitOf(userId); limit > 0 && q.used[userId] >= limit {
+		return false
+	}
+	q.used[userId]++
+	return true
+}
+
+func (q *userQuota) release(userId int64) {
+	if userId <= 0 {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.used[userId] <= 1 {
+		delete(q.used, userId)
+	} else {
+		q.used[userId]--
+	}
+}
+
+// GetUserQuota 返回某个用户的连接数上限及占用
+func GetUserQuota(userId int64) QuotaUtilization {
+	userQuotas.lock.Lock()
+	defer userQuotas.lock.Unlock()
+	return QuotaUtilization{Limit: userQuotas.limitOf(userId), Used: userQuotas.used[userId]}
+}
+
+func GetQuotaUsage() *QuotaUsage {
+	usage := &QuotaUsage{Global: globalQuota.utilization(), Routes: make(map[string]QuotaUtilization), Users: make(map[int64]QuotaUtilization)}
+	routeQuotas.Range(func(key, value any) bool {
+		usage.Routes[key.(string)] = value.(*connQuota).utilization()
+		return true
+	})
+
+	userQuotas.lock.Lock()
+	defer userQuotas.lock.Unlock()
+	usage.UserLimit = userQuotas.limit
+	for userId, limit := range userQuotas.overrides {
+		usage.Users[userId] = QuotaUtilization{Limit: limit, Used: userQuotas.used[userId]}
+	}
+
+	return usage
+}
@@ -0,0 +1,110 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestQuotaAdjustAtRuntime(t *testing.T) {
+	t.Cleanup(func() {
+		dgws.SetRouteConnLimit("/ws", 0)
+		dgws.SetUserConnLimit(0)
+		dgws.SetUserConnLimitFor(42, -1)
+	})
+	admin := startAdminServer(t)
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	dial := func(bizId string, userId string) (*websocket.Conn, error) {
+		header := http.Header{}
+		if userId != "" {
+			header.Set("uid", userId)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId="+bizId, header)
+		if err == nil {
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+		return conn, err
+	}
+	one, two := 1, 2
+
+	if rt := adminCall[*dgws.QuotaUsage](t, admin+"quotas", testAdminToken, &dgws.AdminQuotaRequest{RouteConnLimit: &one}); rt.Success {
+		t.Fatal("expected route limit without path to be rejected")
+	}
+	if rt := adminCall[*dgws.QuotaUsage](t, admin+"quotas", testAdminToken, &dgws.AdminQuotaRequest{RoutePath: "/ws", RouteConnLimit: &one}); !rt.Success || rt.Data.Routes["/ws"].Limit != 1 {
+		t.Fatalf("set route limit: %+v", rt)
+	}
+	if _, err := dial("quota-a", ""); err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	waitConnections(t, "quota-a", 1)
+	if _, err := dial("quota-b", ""); err == nil {
+		t.Fatal("expected route limit to reject second connection")
+	}
+	usage := adminCall[*dgws.QuotaUsage](t, admin+"quotas", testAdminToken, nil)
+	if route := usage.Data.Routes["/ws"]; route.Limit != 1 || route.Used != 1 {
+		t.Fatalf("unexpected route utilization: %+v", route)
+	}
+
+	// 调大后立即接受新连接
+	adminCall[*dgws.QuotaUsage](t, admin+"quotas", testAdminToken, &dgws.AdminQuotaRequest{RoutePath: "/ws", RouteConnLimit: &two})
+	if _, err := dial("quota-b", ""); err != nil {
+		t.Fatalf("dial after raising route limit: %v", err)
+	}
+	dgws.SetRouteConnLimit("/ws", 0)
+
+	adminCall[*dgws.QuotaUsage](t, admin+"quotas", testAdminToken, &dgws.AdminQuotaRequest{UserConnLimit: &one, UserId: 42, UserIdConnLimit: &two})
+	if _, err := dial("quota-u1", "7"); err != nil {
+		t.Fatalf("dial user 7: %v", err)
+	}
+	waitConnections(t, "quota-u1", 1)
+	if _, err := dial("quota-u2", "7"); err == nil {
+		t.Fatal("expected default user limit to reject second connection")
+	}
+	for _, bizId := range []string{"quota-u3", "quota-u4"} {
+		if _, err := dial(bizId, "42"); err != nil {
+			t.Fatalf("dial user 42: %v", err)
+		}
+		waitConnections(t, bizId, 1)
+	}
+	if _, err := dial("quota-u5", "42"); err == nil {
+		t.Fatal("expected user override to reject third connection")
+	}
+	user := adminCall[*dgws.QuotaUsage](t, admin+"quotas?userId=7", testAdminToken, nil)
+	if user.Data.UserLimit != 1 || user.Data.Users[7] != (dgws.QuotaUtilization{Limit: 1, Used: 1}) || user.Data.Users[42] != (dgws.QuotaUtilization{Limit: 2, Used: 2}) {
+		t.Fatalf("unexpected user utilization: %+v", user.Data)
+	}
+}
+
+func TestConnLimitLoweredBelowUsage(t *testing.T) {
+	t.Cleanup(func() {
+		dgws.SetConnLimit(0)
+	})
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{AcquireTimeout: 2 * time.Second}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	first, _, err := websocket.DefaultDialer.Dial(url+"?bizId=lowered-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	waitConnections(t, "lowered-a", 1)
+
+	// 调到当前占用以下, 已有连接保留, 新连接等到占用降下来才被接受
+	used := dgws.GetQuotaUsage().Global.Used
+	dgws.SetConnLimit(uint(used))
+	time.AfterFunc(100*time.Millisecond, func() {
+		_ = first.Close()
+	})
+	second, _, err := websocket.DefaultDialer.Dial(url+"?bizId=lowered-b", nil)
+	if err != nil {
+		t.Fatalf("queued connection rejected: %v", err)
+	}
+	_ = second.Close()
+}
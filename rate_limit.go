@@ -0,0 +1,141 @@
+package dgws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// UpgradeRateLimit 握手升级限速, Rate为每秒允许的升级数, 为0表示该维度不限速
+type UpgradeRateLimit struct {
+	Rate       float64
+	Burst      int
+	PerIpRate  float64
+	PerIpBurst int
+	// MaxWait 无可用令牌时最多排队等待的时间, 超过则返回SYSTEM_BUSY; 重连风暴会被平滑到这段时间内
+	MaxWait time.Duration
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := &tokenBucket{rate: rate, burst: float64(max(burst, 1)), last: now}
+	b.tokens = b.burst
+	return b
+}
+
+// reserve 预占一个令牌, 返回需要等待的时间; 等待超过maxWait时不预占并返回false
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	if wait > maxWait {
+		b.tokens++
+		return 0, false
+	}
+
+	return wait, true
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+const ipBucketSweepInterval = time.Minute
+
+type upgradeLimiter struct {
+	conf      UpgradeRateLimit
+	lock      sync.Mutex
+	global    *tokenBucket
+	ips       map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+var rateLimiter *upgradeLimiter
+
+// InitUpgradeRateLimit 开启握手升级限速, 对所有dgws路由生效
+func InitUpgradeRateLimit(conf UpgradeRateLimit) {
+	now := time.Now()
+	l := &upgradeLimiter{conf: conf, ips: make(map[string]*tokenBucket), lastSweep: now}
+	if conf.Rate > 0 {
+		l.global = newTokenBucket(conf.Rate, conf.Burst, now)
+	}
+	rateLimiter = l
+}
+
+// wait 按全局和单IP限速排队, 需要等待过久或请求已取消时返回false
+func (l *upgradeLimiter) wait(ctx context.Context, ip string) bool {
+	d, ok := l.reserve(ip)
+	if !ok {
+		return false
+	}
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *upgradeLimiter) reserve(ip string) (time.Duration, bool) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+
+	var ipWait time.Duration
+	var ipBucket *tokenBucket
+	if l.conf.PerIpRate > 0 {
+		ipBucket = l.ips[ip]
+		if ipBucket == nil {
+			ipBucket = newTokenBucket(l.conf.PerIpRate, l.conf.PerIpBurst, now)
+			l.ips[ip] = ipBucket
+		}
+		var ok bool
+		if ipWait, ok = ipBucket.reserve(now, l.conf.MaxWait); !ok {
+			return 0, false
+		}
+	}
+
+	var globalWait time.Duration
+	if l.global != nil {
+		var ok bool
+		if globalWait, ok = l.global.reserve(now, l.conf.MaxWait); !ok {
+			if ipBucket != nil {
+				ipBucket.tokens++
+			}
+			return 0, false
+		}
+	}
+
+	return max(ipWait, globalWait), true
+}
+
+// sweep 定期清理令牌已回满的IP, 避免map无限增长
+func (l *upgradeLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < ipBucketSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for ip, b := range l.ips {
+		if b.full(now) {
+			delete(l.ips, ip)
+		}
+	}
+}
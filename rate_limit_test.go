@@ -0,0 +1,42 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestUpgradeRateLimit(t *testing.T) {
+	t.Cleanup(func() {
+		dgws.InitUpgradeRateLimit(dgws.UpgradeRateLimit{})
+	})
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	dgws.InitUpgradeRateLimit(dgws.UpgradeRateLimit{Rate: 10, Burst: 1, MaxWait: time.Second})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		_ = conn.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("upgrades were not throttled, elapsed: %v", elapsed)
+	}
+
+	dgws.InitUpgradeRateLimit(dgws.UpgradeRateLimit{PerIpRate: 1, PerIpBurst: 1})
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+	if _, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+		t.Fatal("expected per-ip rejection")
+	}
+}
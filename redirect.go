@@ -0,0 +1,164 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"hash/crc32"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHashRingReplicas = 160
+	defaultRedirectWait     = 5 * time.Second
+)
+
+// HashRing 一致性哈希环, 每个节点放置replicas个虚拟节点, 节点增减时只有少量key改变归属
+type HashRing struct {
+	replicas int
+	lock     sync.RWMutex
+	hashes   []uint32
+	owners   map[uint32]string
+	nodes    map[string]string
+}
+
+// NewHashRing replicas默认160
+func NewHashRing(replicas int) *HashRing {
+	if replicas <= 0 {
+		replicas = defaultHashRingReplicas
+	}
+	return &HashRing{replicas: replicas, owners: make(map[uint32]string), nodes: make(map[string]string)}
+}
+
+// Set 用nodes(nodeId -> 节点地址)替换环上的所有节点
+func (r *HashRing) Set(nodes map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nodes = make(map[string]string, len(nodes))
+	for nodeId, addr := range nodes {
+		r.nodes[nodeId] = addr
+	}
+	r.rebuild()
+}
+
+func (r *HashRing) Add(nodeId string, addr string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nodes[nodeId] = addr
+	r.rebuild()
+}
+
+func (r *HashRing) Remove(nodeId string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.nodes, nodeId)
+	r.rebuild()
+}
+
+// Locate 返回key所属的节点, 环为空时ok为false
+func (r *HashRing) Locate(key string) (nodeId string, addr string, ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.hashes) == 0 {
+		return "", "", false
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i, _ := slices.BinarySearch(r.hashes, hash)
+	if i == len(r.hashes) {
+		i = 0
+	}
+	nodeId = r.owners[r.hashes[i]]
+
+	return nodeId, r.nodes[nodeId], true
+}
+
+func (r *HashRing) rebuild() {
+	r.hashes = r.hashes[:0]
+	r.owners = make(map[uint32]string, len(r.nodes)*r.replicas)
+	for nodeId := range r.nodes {
+		for i := 0; i < r.replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(nodeId + "#" + strconv.Itoa(i)))
+			// 哈希冲突时取nodeId较小的节点, 保证各实例计算结果一致
+			if owner, ok := r.owners[hash]; !ok {
+				r.hashes = append(r.hashes, hash)
+			} else if owner < nodeId {
+				continue
+			}
+			r.owners[hash] = nodeId
+		}
+	}
+	slices.Sort(r.hashes)
+}
+
+// RedirectOptions 升级后按一致性哈希检查bizId归属, 不属于本节点时通知客户端重连到归属节点, 使同一用户的状态只在一个节点上
+type RedirectOptions struct {
+	Ring *HashRing
+	// NodeId 本节点在Ring中的id
+	NodeId string
+	// Key 参与哈希的key, 默认bizKey:bizId
+	Key func(bizKey string, bizId string) string
+	// Wait 发出reconnect后等待客户端主动断开的时间, 默认5秒
+	Wait time.Duration
+}
+
+// target 返回重定向地址, bizId属于本节点或环为空时返回false;
+// 节点地址形如wss://host:port, 与原请求的path和query拼接
+func (o *RedirectOptions) target(bizKey string, bizId string, requestUri string) (string, bool) {
+	key := bizKey + ":" + bizId
+	if o.Key != nil {
+		key = o.Key(bizKey, bizId)
+	}
+	nodeId, addr, ok := o.Ring.Locate(key)
+	if !ok || nodeId == o.NodeId || addr == "" {
+		return "", false
+	}
+
+	return strings.TrimSuffix(addr, "/") + requestUri, true
+}
+
+// redirectConnection 发送reconnect并等待客户端断开, 超时后由服务端关闭
+func redirectConnection(ctx *dgctx.DgContext, conn *websocket.Conn, url string, opts *RedirectOptions, writeWait time.Duration) {
+	raw, err := json.Marshal(&ReconnectInfo{Url: url})
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(&Envelope{Type: EnvelopeTypeReconnect, Data: raw})
+	if err != nil {
+		return
+	}
+	_ = conn.SetWriteDeadline(writeDeadline(writeWait))
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		dglogger.Warnf(ctx, "write redirect error: %v", err)
+		return
+	}
+
+	wait := opts.Wait
+	if wait <= 0 {
+		wait = defaultRedirectWait
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// redirectHTTP 非websocket连接(SSE、Transport)无法发送reconnect, 以307让客户端向归属节点重新发起请求, ws/wss地址转换为http/https
+func redirectHTTP(c *gin.Context, url string) {
+	if rest, ok := strings.CutPrefix(url, "ws://"); ok {
+		url = "http://" + rest
+	} else if rest, ok := strings.CutPrefix(url, "wss://"); ok {
+		url = "https://" + rest
+	}
+	c.Redirect(http.StatusTemporaryRedirect, url)
+	c.Abort()
+}
@@ -0,0 +1,107 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashRingLocate(t *testing.T) {
+	ring := dgws.NewHashRing(0)
+	if _, _, ok := ring.Locate("k"); ok {
+		t.Fatal("empty ring should not locate")
+	}
+	ring.Set(map[string]string{"a": "ws://a", "b": "ws://b", "c": "ws://c"})
+
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := "user:" + strconv.Itoa(i)
+		nodeId, addr, ok := ring.Locate(key)
+		if !ok || addr != "ws://"+nodeId {
+			t.Fatalf("unexpected owner of %s: %s %s", key, nodeId, addr)
+		}
+		before[key] = nodeId
+	}
+
+	ring.Add("d", "ws://d")
+	moved := 0
+	for key, nodeId := range before {
+		owner, _, _ := ring.Locate(key)
+		if owner != nodeId {
+			if owner != "d" {
+				t.Fatalf("%s moved from %s to %s instead of the new node", key, nodeId, owner)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 400 {
+		t.Fatalf("unexpected number of moved keys: %d", moved)
+	}
+
+	ring.Remove("d")
+	for key, nodeId := range before {
+		if owner, _, _ := ring.Locate(key); owner != nodeId {
+			t.Fatalf("%s not restored to %s after removing node", key, nodeId)
+		}
+	}
+}
+
+func TestRedirectToOwnerNode(t *testing.T) {
+	ring := dgws.NewHashRing(0)
+	startNode := func(nodeId string) string {
+		return startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+			Redirect: &dgws.RedirectOptions{Ring: ring, NodeId: nodeId, Wait: time.Second},
+		}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			return wsm.Connection.WriteMessage(websocket.TextMessage, []byte(nodeId))
+		})
+	}
+	urlA := startNode("node-a")
+	urlB := startNode("node-b")
+	ring.Set(map[string]string{
+		"node-a": strings.TrimSuffix(urlA, "/ws"),
+		"node-b": strings.TrimSuffix(urlB, "/ws"),
+	})
+
+	var bizId string
+	for i := 0; ; i++ {
+		bizId = "redirect-" + strconv.Itoa(i)
+		if owner, _, _ := ring.Locate("bizId:" + bizId); owner == "node-b" {
+			break
+		}
+	}
+
+	received := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: urlA + "?bizId=" + bizId,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			received <- string(data)
+			return nil
+		},
+		MinReconnectInterval: 10 * time.Millisecond,
+	})
+	client.Start()
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		waitState(t, client, dgws.ClientStateConnected)
+		if err := client.WriteMessage(websocket.TextMessage, []byte("where")); err == nil {
+			select {
+			case node := <-received:
+				if node != "node-b" {
+					t.Fatalf("served by %s", node)
+				}
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client not redirected to owner node")
+		}
+	}
+}
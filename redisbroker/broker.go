@@ -0,0 +1,38 @@
+package redisbroker
+
+import (
+	"context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker 基于Redis pub/sub的dgws.ClusterBroker
+type Broker struct {
+	client redis.UniversalClient
+}
+
+var _ dgws.ClusterBroker = (*Broker)(nil)
+
+func New(client redis.UniversalClient) *Broker {
+	return &Broker{client: client}
+}
+
+func (b *Broker) Publish(ctx context.Context, channel string, payload []byte) error {
+	return b.client.Publish(ctx, channel, payload).Err()
+}
+
+func (b *Broker) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) (func() error, error) {
+	ps := b.client.Subscribe(ctx, channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range ps.Channel() {
+			handler([]byte(msg.Payload))
+		}
+	}()
+
+	return ps.Close, nil
+}
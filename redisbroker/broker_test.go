@@ -0,0 +1,49 @@
+package redisbroker_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/darwinOrg/go-websocket/redisbroker"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestBrokerPublishSubscribe(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	broker := redisbroker.New(client)
+
+	received := make(chan string, 1)
+	unsubscribe, err := broker.Subscribe(context.Background(), "test:broadcast", func(payload []byte) {
+		received <- string(payload)
+	})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if err := broker.Publish(context.Background(), "test:broadcast", []byte("hello")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "hello" {
+			t.Fatalf("unexpected payload: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("payload not received")
+	}
+
+	if err := unsubscribe(); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	if err := broker.Publish(context.Background(), "test:broadcast", []byte("late")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("received after unsubscribe: %s", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,104 @@
+package redisstore
+
+import (
+	"context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const defaultClusterLimitKeyPrefix = "dgws:limit:"
+
+// reserveScript 清理过期实例的名额后按上限预占n个, 返回实际预占数; n为负数时归还, n为0时只续期
+var reserveScript = redis.NewScript(`
+local now = tonumber(ARGV[4])
+local expires = redis.call('HGETALL', KEYS[2])
+for i = 1, #expires, 2 do
+	if tonumber(expires[i + 1]) < now then
+		redis.call('HDEL', KEYS[1], expires[i])
+		redis.call('HDEL', KEYS[2], expires[i])
+	end
+end
+local n = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+if n > 0 and limit > 0 then
+	local total = 0
+	for _, v in ipairs(redis.call('HVALS', KEYS[1])) do
+		total = total + tonumber(v)
+	end
+	if total + n > limit then
+		n = limit - total
+	end
+	if n <= 0 then
+		return 0
+	end
+end
+if redis.call('HINCRBY', KEYS[1], ARGV[1], n) <= 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[5])
+return n
+`)
+
+// acquireUserScript 用户的连接记录为有序集合, 分数为过期时间, 未超过上限时加入并返回1
+var acquireUserScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[2])
+if tonumber(ARGV[4]) > 0 and redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// ClusterLimitStore 各实例预占的总数名额保存在哈希prefix+"{global}"中, 续期时间保存在prefix+"{global}:expires"中,
+// 两个key使用相同的hash tag, 可用于Redis Cluster; 每个用户的连接记录为有序集合prefix+"user:"+user
+type ClusterLimitStore struct {
+	client    redis.UniversalClient
+	prefix    string
+	globalKey string
+	expireKey string
+}
+
+var _ dgws.ClusterLimitStore = (*ClusterLimitStore)(nil)
+
+// NewClusterLimitStore prefix默认"dgws:limit:"
+func NewClusterLimitStore(client redis.UniversalClient, prefix string) *ClusterLimitStore {
+	if prefix == "" {
+		prefix = defaultClusterLimitKeyPrefix
+	}
+	return &ClusterLimitStore{client: client, prefix: prefix, globalKey: prefix + "{global}", expireKey: prefix + "{global}:expires"}
+}
+
+func (s *ClusterLimitStore) Reserve(ctx context.Context, nodeId string, n int64, limit int64, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	return reserveScript.Run(ctx, s.client, []string{s.globalKey, s.expireKey},
+		nodeId, n, limit, now.UnixMilli(), now.Add(ttl).UnixMilli()).Int64()
+}
+
+func (s *ClusterLimitStore) AcquireUser(ctx context.Context, user string, id string, limit int64, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	ok, err := acquireUserScript.Run(ctx, s.client, []string{s.userKey(user)},
+		id, now.UnixMilli(), now.Add(ttl).UnixMilli(), limit, ttl.Milliseconds()).Int()
+	return ok == 1, err
+}
+
+func (s *ClusterLimitStore) ReleaseUser(ctx context.Context, user string, id string) error {
+	return s.client.ZRem(ctx, s.userKey(user), id).Err()
+}
+
+func (s *ClusterLimitStore) RefreshUsers(ctx context.Context, users map[string]string, ttl time.Duration) error {
+	expiresAt := float64(time.Now().Add(ttl).UnixMilli())
+	pipe := s.client.Pipeline()
+	for id, user := range users {
+		key := s.userKey(user)
+		pipe.ZAddXX(ctx, key, redis.Z{Score: expiresAt, Member: id})
+		pipe.PExpire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *ClusterLimitStore) userKey(user string) string {
+	return s.prefix + "user:" + user
+}
@@ -0,0 +1,72 @@
+package redisstore_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/darwinOrg/go-websocket/redisstore"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestClusterLimitStoreReserve(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := redisstore.NewClusterLimitStore(client, "test:limit:")
+	ctx := context.Background()
+
+	if n, err := store.Reserve(ctx, "node-a", 2, 3, 100*time.Millisecond); err != nil || n != 2 {
+		t.Fatalf("reserve node-a: %d, %v", n, err)
+	}
+	if n, err := store.Reserve(ctx, "node-b", 2, 3, time.Minute); err != nil || n != 1 {
+		t.Fatalf("reserve node-b should be capped at the limit: %d, %v", n, err)
+	}
+	if !server.Exists("test:limit:{global}") || !server.Exists("test:limit:{global}:expires") {
+		t.Fatalf("unexpected keys: %v", server.Keys())
+	}
+
+	// node-a未续期, 名额过期后由其他实例预占
+	time.Sleep(150 * time.Millisecond)
+	if n, err := store.Reserve(ctx, "node-b", 2, 3, time.Minute); err != nil || n != 2 {
+		t.Fatalf("reserve after expiry: %d, %v", n, err)
+	}
+	if n, err := store.Reserve(ctx, "node-b", -3, 3, time.Minute); err != nil || n != -3 {
+		t.Fatalf("return: %d, %v", n, err)
+	}
+	if server.Exists("test:limit:{global}") {
+		t.Fatal("returned reservation left in redis")
+	}
+}
+
+func TestClusterLimitStoreUser(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := redisstore.NewClusterLimitStore(client, "")
+	ctx := context.Background()
+
+	acquire := func(id string) bool {
+		t.Helper()
+		ok, err := store.AcquireUser(ctx, "7", id, 1, time.Minute)
+		if err != nil {
+			t.Fatalf("acquire user: %v", err)
+		}
+		return ok
+	}
+	if !acquire("c1") || acquire("c2") {
+		t.Fatal("user limit not enforced")
+	}
+	if !server.Exists("dgws:limit:user:7") {
+		t.Fatalf("unexpected keys: %v", server.Keys())
+	}
+	if err := store.RefreshUsers(ctx, map[string]string{"c1": "7"}, time.Minute); err != nil {
+		t.Fatalf("refresh users: %v", err)
+	}
+	if err := store.ReleaseUser(ctx, "7", "c1"); err != nil {
+		t.Fatalf("release user: %v", err)
+	}
+	if !acquire("c2") {
+		t.Fatal("released connection still counted")
+	}
+}
@@ -0,0 +1,86 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+const defaultDirectoryKeyPrefix = "dgws:dir:"
+
+// DirectoryStore 每个bizId对应一个有序集合, 成员为序列化的DirectoryEntry, 分数为过期时间(毫秒)
+type DirectoryStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ dgws.DirectoryStore = (*DirectoryStore)(nil)
+
+// NewDirectoryStore prefix默认"dgws:dir:"
+func NewDirectoryStore(client redis.UniversalClient, prefix string) *DirectoryStore {
+	if prefix == "" {
+		prefix = defaultDirectoryKeyPrefix
+	}
+	return &DirectoryStore{client: client, prefix: prefix}
+}
+
+func (s *DirectoryStore) Register(ctx context.Context, entries []*dgws.DirectoryEntry, ttl time.Duration) error {
+	expiresAt := float64(time.Now().Add(ttl).UnixMilli())
+	pipe := s.client.Pipeline()
+	for _, entry := range entries {
+		member, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		key := s.key(entry.BizKey, entry.BizId)
+		pipe.ZAdd(ctx, key, redis.Z{Score: expiresAt, Member: member})
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *DirectoryStore) Unregister(ctx context.Context, entries []*dgws.DirectoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	pipe := s.client.Pipeline()
+	for _, entry := range entries {
+		member, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		pipe.ZRem(ctx, s.key(entry.BizKey, entry.BizId), member)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *DirectoryStore) Lookup(ctx context.Context, bizKey string, bizId string) ([]*dgws.DirectoryEntry, error) {
+	key := s.key(bizKey, bizId)
+	pipe := s.client.Pipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(time.Now().UnixMilli(), 10))
+	members := pipe.ZRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*dgws.DirectoryEntry, 0, len(members.Val()))
+	for _, member := range members.Val() {
+		entry := &dgws.DirectoryEntry{}
+		if err := json.Unmarshal([]byte(member), entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (s *DirectoryStore) key(bizKey string, bizId string) string {
+	return s.prefix + bizKey + ":" + bizId
+}
@@ -0,0 +1,70 @@
+package redisstore_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/darwinOrg/go-websocket/redisstore"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newClient(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func waitLookup(t *testing.T, ctx *dgctx.DgContext, bizId string, n int) []*dgws.DirectoryEntry {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		entries, err := dgws.Lookup(ctx, dgwstest.DefaultBizKey, bizId)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if len(entries) == n {
+			return entries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d entries for %s, got %d", n, bizId, len(entries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDirectoryRefreshAndExpire(t *testing.T) {
+	store := redisstore.NewDirectoryStore(newClient(t), "test:dir:")
+	dialer, _ := dgwstest.StartRoute(t, nil, nil)
+	dialer.MustDial(t, "dir-b")
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitDirectory(ctx, &dgws.DirectoryOptions{Store: store, NodeId: "node-b", Ttl: 200 * time.Millisecond, RefreshInterval: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("init directory: %v", err)
+	}
+	waitLookup(t, ctx, "dir-b", 1)
+	time.Sleep(500 * time.Millisecond)
+	if entries := waitLookup(t, ctx, "dir-b", 1); entries[0].NodeId != "node-b" {
+		t.Fatalf("unexpected entry: %+v", entries[0])
+	}
+
+	stale := &dgws.DirectoryEntry{NodeId: "node-gone", ConnectionId: uuid.NewString(), BizKey: dgwstest.DefaultBizKey, BizId: "dir-b"}
+	if err := store.Register(context.Background(), []*dgws.DirectoryEntry{stale}, 100*time.Millisecond); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	waitLookup(t, ctx, "dir-b", 2)
+	time.Sleep(200 * time.Millisecond)
+	waitLookup(t, ctx, "dir-b", 1)
+
+	if err := dgws.CloseDirectory(); err != nil {
+		t.Fatalf("close directory: %v", err)
+	}
+	if entries, err := store.Lookup(context.Background(), dgwstest.DefaultBizKey, "dir-b"); err != nil || len(entries) != 0 {
+		t.Fatalf("entries left after close: %d, %v", len(entries), err)
+	}
+}
@@ -0,0 +1,55 @@
+package redisstore
+
+import (
+	"context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const defaultLeaderKeyPrefix = "dgws:leader:"
+
+var acquireLeaderScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder == false then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// LeaderElector 基于Redis的租约锁
+type LeaderElector struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ dgws.LeaderElector = (*LeaderElector)(nil)
+
+// NewLeaderElector prefix默认"dgws:leader:"
+func NewLeaderElector(client redis.UniversalClient, prefix string) *LeaderElector {
+	if prefix == "" {
+		prefix = defaultLeaderKeyPrefix
+	}
+	return &LeaderElector{client: client, prefix: prefix}
+}
+
+func (e *LeaderElector) TryAcquire(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	ok, err := acquireLeaderScript.Run(ctx, e.client, []string{e.prefix + key}, holder, ttl.Milliseconds()).Int()
+	return ok == 1, err
+}
+
+func (e *LeaderElector) Release(ctx context.Context, key string, holder string) error {
+	return releaseLeaderScript.Run(ctx, e.client, []string{e.prefix + key}, holder).Err()
+}
@@ -0,0 +1,50 @@
+package redisstore_test
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/darwinOrg/go-websocket/redisstore"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestLeaderElector(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	elector := redisstore.NewLeaderElector(client, "test:leader:")
+	ctx := context.Background()
+
+	acquire := func(holder string) bool {
+		t.Helper()
+		ok, err := elector.TryAcquire(ctx, "tick", holder, time.Second)
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		return ok
+	}
+	if !acquire("a") || !acquire("a") {
+		t.Fatal("holder should acquire and renew")
+	}
+	if acquire("b") {
+		t.Fatal("lease held by a should not be taken over")
+	}
+	if server.TTL("test:leader:tick") <= 0 {
+		t.Fatal("lease should expire")
+	}
+
+	// 非持有者释放无效
+	if err := elector.Release(ctx, "tick", "b"); err != nil || acquire("b") {
+		t.Fatalf("release by non-holder took effect: %v", err)
+	}
+	if err := elector.Release(ctx, "tick", "a"); err != nil || !acquire("b") {
+		t.Fatalf("lease not released: %v", err)
+	}
+
+	// 租约到期后由其他实例接管
+	server.FastForward(2 * time.Second)
+	if !acquire("a") {
+		t.Fatal("expired lease should be taken over")
+	}
+}
@@ -0,0 +1,144 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const defaultReplayKeyPrefix = "dgws:replay:"
+
+var appendReplayScript = redis.NewScript(`
+redis.call('SET', KEYS[3], ARGV[1], 'NX')
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[4])
+local limit = tonumber(ARGV[5])
+if limit > 0 then
+	local trimmed = redis.call('ZRANGE', KEYS[1], 0, -(limit + 1))
+	if #trimmed > 0 then
+		redis.call('ZREM', KEYS[1], unpack(trimmed))
+		redis.call('HDEL', KEYS[2], unpack(trimmed))
+	end
+end
+local ttl = tonumber(ARGV[6])
+if ttl > 0 then
+	for i = 1, 3 do
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return 1
+`)
+
+// ReplayStore 每个会话对应一个有序集合(成员为消息id, 分数为Seq)、一个保存消息的哈希和一个保存归属的字符串,
+// 三个key使用相同的hash tag, 可用于Redis Cluster; 写入时刷新key的过期时间
+type ReplayStore struct {
+	client        redis.UniversalClient
+	prefix        string
+	ttl           time.Duration
+	maxPerSession int
+}
+
+var _ dgws.ReplayStore = (*ReplayStore)(nil)
+
+type replayMessage struct {
+	Env *dgws.Envelope `json:"env"`
+	// ExpiresAt 毫秒时间戳, 0表示不过期
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+// NewReplayStore prefix默认"dgws:replay:", ttl为0表示不过期, maxPerSession为0表示不限制
+func NewReplayStore(client redis.UniversalClient, prefix string, ttl time.Duration, maxPerSession int) *ReplayStore {
+	if prefix == "" {
+		prefix = defaultReplayKeyPrefix
+	}
+	return &ReplayStore{client: client, prefix: prefix, ttl: ttl, maxPerSession: maxPerSession}
+}
+
+func (s *ReplayStore) keys(sessionId string) []string {
+	key := s.prefix + "{" + sessionId + "}"
+	return []string{key, key + ":data", key + ":owner"}
+}
+
+func (s *ReplayStore) Append(ctx *dgctx.DgContext, sessionId string, owner *dgws.ReplayOwner, env *dgws.Envelope) error {
+	ownerData, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	msg := &replayMessage{Env: env}
+	if s.ttl > 0 {
+		msg.ExpiresAt = time.Now().Add(s.ttl).UnixMilli()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return appendReplayScript.Run(innerContext(ctx), s.client, s.keys(sessionId),
+		ownerData, env.Id, env.Seq, data, s.maxPerSession, s.ttl.Milliseconds()).Err()
+}
+
+func (s *ReplayStore) Remove(ctx *dgctx.DgContext, sessionId string, id string) error {
+	keys := s.keys(sessionId)
+	pipe := s.client.Pipeline()
+	pipe.ZRem(innerContext(ctx), keys[0], id)
+	pipe.HDel(innerContext(ctx), keys[1], id)
+	_, err := pipe.Exec(innerContext(ctx))
+	return err
+}
+
+func (s *ReplayStore) Load(ctx *dgctx.DgContext, sessionId string) (*dgws.ReplayOwner, []*dgws.Envelope, error) {
+	keys := s.keys(sessionId)
+	ownerData, err := s.client.Get(innerContext(ctx), keys[2]).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	owner := &dgws.ReplayOwner{}
+	if err := json.Unmarshal(ownerData, owner); err != nil {
+		return nil, nil, err
+	}
+
+	ids, err := s.client.ZRange(innerContext(ctx), keys[0], 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return owner, nil, err
+	}
+	values, err := s.client.HMGet(innerContext(ctx), keys[1], ids...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	envs := make([]*dgws.Envelope, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		msg := &replayMessage{}
+		if err := json.Unmarshal([]byte(data), msg); err != nil {
+			return nil, nil, err
+		}
+		if msg.ExpiresAt == 0 || msg.ExpiresAt > now {
+			envs = append(envs, msg.Env)
+		}
+	}
+
+	return owner, envs, nil
+}
+
+func (s *ReplayStore) Delete(ctx *dgctx.DgContext, sessionId string) error {
+	return s.client.Del(innerContext(ctx), s.keys(sessionId)...).Err()
+}
+
+func innerContext(ctx *dgctx.DgContext) context.Context {
+	if ctx != nil && ctx.InnerContext() != nil {
+		return ctx.InnerContext()
+	}
+	return context.Background()
+}
@@ -0,0 +1,79 @@
+package redisstore_test
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/redisstore"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func replayEnvelopes(n int) []*dgws.Envelope {
+	envs := make([]*dgws.Envelope, 0, n)
+	for i := 1; i <= n; i++ {
+		envs = append(envs, &dgws.Envelope{Type: dgws.EnvelopeTypeMessage, Id: "m" + strconv.Itoa(i), Seq: int64(i)})
+	}
+	return envs
+}
+
+func TestReplayStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := redisstore.NewReplayStore(client, "", time.Minute, 2)
+	owner := &dgws.ReplayOwner{UserId: 7, BizKey: "room", BizId: "r1"}
+
+	if got, envs, err := store.Load(nil, "s1"); err != nil || got != nil || envs != nil {
+		t.Fatalf("expected empty session, got %+v %v %v", got, envs, err)
+	}
+
+	for _, env := range replayEnvelopes(3) {
+		if err := store.Append(nil, "s1", owner, env); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	got, envs, err := store.Load(nil, "s1")
+	if err != nil || *got != *owner {
+		t.Fatalf("load owner: %+v, %v", got, err)
+	}
+	if len(envs) != 2 || envs[0].Seq != 2 || envs[1].Seq != 3 {
+		t.Fatalf("expected the latest 2 messages in seq order, got %+v", envs)
+	}
+
+	if err := store.Remove(nil, "s1", "m2"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, envs, _ := store.Load(nil, "s1"); len(envs) != 1 || envs[0].Id != "m3" {
+		t.Fatalf("unexpected messages after remove: %+v", envs)
+	}
+
+	if err := store.Delete(nil, "s1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if got, envs, _ := store.Load(nil, "s1"); got != nil || envs != nil {
+		t.Fatalf("expected deleted session, got %+v %v", got, envs)
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("expected no keys left, got %v", keys)
+	}
+}
+
+func TestReplayStoreTtl(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := redisstore.NewReplayStore(client, "test:", time.Second, 0)
+
+	if err := store.Append(nil, "s1", &dgws.ReplayOwner{UserId: 1}, replayEnvelopes(1)[0]); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if ttl := server.TTL("test:{s1}"); ttl <= 0 || ttl > time.Second {
+		t.Fatalf("unexpected key ttl: %v", ttl)
+	}
+	server.FastForward(2 * time.Second)
+	if got, envs, _ := store.Load(nil, "s1"); got != nil || envs != nil {
+		t.Fatalf("expected expired session, got %+v %v", got, envs)
+	}
+}
@@ -0,0 +1,157 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"sync/atomic"
+	"time"
+)
+
+// RegionRelayOptions 各区域的实例只连接本区域的broker, 每个区域运行RegionRelay把本区域产生的集群消息向每个其他区域转发一次,
+// 跨区域流量与区域数成正比, 与连接数和实例数无关
+type RegionRelayOptions struct {
+	// Region 本区域名称
+	Region string
+	// Local 本区域实例InitCluster使用的broker
+	Local ClusterBroker
+	// Remotes 其他区域的broker, key为区域名称
+	Remotes map[string]ClusterBroker
+	// ChannelPrefix 与ClusterOptions.ChannelPrefix一致
+	ChannelPrefix string
+	// Codec 与ClusterOptions.Codec一致
+	Codec Codec
+	// Elector 同一区域运行多个RegionRelay做高可用时设置, 只有leader转发; 为nil时需保证每个区域只运行一个
+	Elector LeaderElector
+	// LeaseTtl 默认15秒
+	LeaseTtl time.Duration
+}
+
+type RegionRelay struct {
+	ctx         *dgctx.DgContext
+	opts        RegionRelayOptions
+	channel     string
+	holder      string
+	leader      atomic.Bool
+	unsubscribe func() error
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// StartRegionRelay 订阅本区域的集群消息并开始转发, 不再需要时调用Close
+func StartRegionRelay(ctx *dgctx.DgContext, opts *RegionRelayOptions) (*RegionRelay, error) {
+	if opts == nil || opts.Region == "" || opts.Local == nil {
+		return nil, errors.New("websocket region relay requires region and local broker")
+	}
+
+	r := &RegionRelay{ctx: ctx, opts: *opts, holder: uuid.NewString(), stop: make(chan struct{}), done: make(chan struct{})}
+	r.channel = r.opts.ChannelPrefix
+	if r.channel == "" {
+		r.channel = defaultClusterChannelPrefix
+	}
+	r.channel += "broadcast"
+	if r.opts.Codec == nil {
+		r.opts.Codec = DefaultCodec
+	}
+	if r.opts.LeaseTtl <= 0 {
+		r.opts.LeaseTtl = defaultLeaderLeaseTtl
+	}
+
+	if r.opts.Elector == nil {
+		r.leader.Store(true)
+		close(r.done)
+	} else {
+		r.elect()
+		go r.run()
+	}
+
+	unsubscribe, err := r.opts.Local.Subscribe(innerContext(ctx), r.channel, r.forward)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.unsubscribe = unsubscribe
+
+	return r, nil
+}
+
+// forward 只转发本区域产生的消息, 带Region的消息是其他区域转发过来的
+func (r *RegionRelay) forward(payload []byte) {
+	if !r.leader.Load() {
+		return
+	}
+
+	msg := &ClusterMessage{}
+	if err := r.opts.Codec.Unmarshal(payload, msg); err != nil {
+		dglogger.Warnf(r.ctx, "region relay decode message error: %v", err)
+		return
+	}
+	if msg.Region != "" {
+		return
+	}
+	msg.Region = r.opts.Region
+	forwarded, err := r.opts.Codec.Marshal(msg)
+	if err != nil {
+		dglogger.Warnf(r.ctx, "region relay encode message error: %v", err)
+		return
+	}
+
+	for region, broker := range r.opts.Remotes {
+		if err := broker.Publish(context.Background(), r.channel, forwarded); err != nil {
+			dglogger.Warnf(r.ctx, "region relay publish to %s error: %v", region, err)
+		}
+	}
+}
+
+func (r *RegionRelay) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.LeaseTtl / leaderRenewDivisor)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			if r.leader.Load() {
+				ctx, cancel := context.WithTimeout(context.Background(), schedulerStopTimeout)
+				_ = r.opts.Elector.Release(ctx, r.leaseKey(), r.holder)
+				cancel()
+			}
+			return
+		case <-ticker.C:
+			r.elect()
+		}
+	}
+}
+
+func (r *RegionRelay) elect() {
+	ok, err := r.opts.Elector.TryAcquire(innerContext(r.ctx), r.leaseKey(), r.holder, r.opts.LeaseTtl)
+	if err != nil {
+		dglogger.Warnf(r.ctx, "region relay elect leader error: %v", err)
+	}
+	r.leader.Store(ok && err == nil)
+}
+
+func (r *RegionRelay) leaseKey() string {
+	return "region-relay:" + r.opts.Region
+}
+
+// IsLeader 本RegionRelay当前是否负责转发
+func (r *RegionRelay) IsLeader() bool {
+	return r.leader.Load()
+}
+
+func (r *RegionRelay) Close() error {
+	select {
+	case <-r.stop:
+		return nil
+	default:
+		close(r.stop)
+	}
+	<-r.done
+	if r.unsubscribe != nil {
+		return r.unsubscribe()
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/redisbroker"
+	"github.com/darwinOrg/go-websocket/redisstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestRegionRelayForwardsOncePerRegion(t *testing.T) {
+	newRedis := func() *redis.Client {
+		rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() {
+			_ = rdb.Close()
+		})
+		return rdb
+	}
+	regionA, regionB := newRedis(), newRedis()
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitCluster(ctx, &dgws.ClusterOptions{Broker: redisbroker.New(regionA), ChannelPrefix: "region:"}); err != nil {
+		t.Fatalf("init cluster: %v", err)
+	}
+	defer dgws.CloseCluster()
+	relay, err := dgws.StartRegionRelay(ctx, &dgws.RegionRelayOptions{
+		Region:        "a",
+		Local:         redisbroker.New(regionA),
+		Remotes:       map[string]dgws.ClusterBroker{"b": redisbroker.New(regionB)},
+		ChannelPrefix: "region:",
+		Elector:       redisstore.NewLeaderElector(regionA, ""),
+	})
+	if err != nil {
+		t.Fatalf("start region relay: %v", err)
+	}
+	defer relay.Close()
+	if !relay.IsLeader() {
+		t.Fatal("single relay should be leader")
+	}
+
+	observer := regionB.Subscribe(context.Background(), "region:broadcast")
+	defer observer.Close()
+	if _, err := observer.Receive(context.Background()); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if _, err := dgws.Broadcast(websocket.TextMessage, []byte("from-a")); err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+	select {
+	case published := <-observer.Channel():
+		msg := &dgws.ClusterMessage{}
+		if err := json.Unmarshal([]byte(published.Payload), msg); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if msg.Region != "a" || string(msg.Data) != "from-a" {
+			t.Fatalf("unexpected forwarded message: %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("broadcast not forwarded to region b")
+	}
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=region-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "region-a", 1)
+
+	// 区域b转发来的消息在本区域投递, 但不会再被转发回去
+	payload, _ := json.Marshal(&dgws.ClusterMessage{InstanceId: "b-1", Region: "b", MessageType: websocket.TextMessage, Data: []byte("from-b")})
+	if err := regionA.Publish(context.Background(), "region:broadcast", payload).Err(); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "from-b" {
+		t.Fatalf("read: %s, %v", string(data), err)
+	}
+	select {
+	case published := <-observer.Channel():
+		t.Fatalf("relayed message bounced back: %s", published.Payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,300 @@
+package dgws
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ConnectionKey      = "WsConnection"
+	registryShardCount = 32
+)
+
+// Connection 已建立的websocket连接, 通过其写方法发送的消息会串行化, 可在多个goroutine中安全使用
+type Connection struct {
+	Id string
+	// SessionId 开启会话恢复时的会话token, 恢复后的连接与断开前的连接SessionId相同
+	SessionId   string
+	Path        string
+	BizKey      string
+	BizId       string
+	UserId      int64
+	RemoteIp    string
+	ConnectedAt time.Time
+	Ctx         *dgctx.DgContext
+	// Conn 通过GetSSE、HandleTransport建立的连接为nil
+	Conn *websocket.Conn
+	// writer 不为nil时写入和关闭都交给它, 见messageWriter
+	writer      messageWriter
+	writeSem    chan struct{}
+	writeWait   time.Duration
+	compression *connCompression
+	acks        *ackTracker
+	journal     *connJournal
+	labelLock   sync.RWMutex
+	labels      map[string]string
+	// inbound 把消息交给与读循环相同的处理流程, 见SimulateInbound
+	inbound atomic.Pointer[func(mt int, data []byte)]
+	// closeFrame 服务端主动关闭时发出的第一个close帧, 见OnDisconnect
+	closeFrame atomic.Pointer[closeFrame]
+}
+
+type closeFrame struct {
+	code   int
+	reason string
+}
+
+func (c *Connection) WriteMessage(mt int, data []byte) error {
+	if c.writer != nil {
+		return c.writeTo(mt, data)
+	}
+	return c.write(func() error {
+		return c.writeFrame(mt, data, func() error {
+			return c.Conn.WriteMessage(mt, data)
+		})
+	})
+}
+
+// WritePreparedMessage 非websocket连接不支持, 返回ErrPreparedMessageUnsupported;
+// PreparedMessage无法取回原始数据, 不计入压缩统计和journal, 需要时使用WriteMessage或Broadcast
+func (c *Connection) WritePreparedMessage(pm *websocket.PreparedMessage) error {
+	if c.writer != nil {
+		return ErrPreparedMessageUnsupported
+	}
+	return c.write(func() error {
+		return c.Conn.WritePreparedMessage(pm)
+	})
+}
+
+// writeFrame 需持有写锁; 写出一条数据帧, 并与WriteMessage一样计入压缩统计和journal
+func (c *Connection) writeFrame(mt int, data []byte, write func() error) error {
+	var err error
+	if c.compression != nil {
+		err = c.compression.write(c.Conn, len(data), write)
+	} else {
+		err = write()
+	}
+	// 在写锁内记录, 保证与实际写出的顺序一致
+	if err == nil && c.journal != nil {
+		c.journal.record(JournalDirectionOut, mt, data)
+	}
+	return err
+}
+
+// SimulateInbound 模拟收到客户端发来的消息, 与真实消息一样经过重试、去重、BizHandler等处理, 用于复现线上问题
+func (c *Connection) SimulateInbound(mt int, data []byte) error {
+	inbound := c.inbound.Load()
+	if inbound == nil || GetConnState(c.Ctx).Ended() {
+		return ErrConnectionClosed
+	}
+	(*inbound)(mt, data)
+	return nil
+}
+
+// closeWith 发送close帧后直接关闭底层连接, 读循环随之退出并完成清理
+func (c *Connection) closeWith(code int, reason string) {
+	c.closeFrame.CompareAndSwap(nil, &closeFrame{code: code, reason: reason})
+	if c.writer != nil {
+		_ = c.writer.Close(code, reason)
+		return
+	}
+	_ = c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	_ = c.Conn.NetConn().Close()
+}
+
+// closeGracefully 只发送close帧, 对端回应后读循环正常结束; 非websocket连接直接关闭
+func (c *Connection) closeGracefully(code int, reason string) {
+	if c.writer != nil {
+		c.closeWith(code, reason)
+		return
+	}
+	c.closeFrame.CompareAndSwap(nil, &closeFrame{code: code, reason: reason})
+	_ = c.write(func() error {
+		return c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	})
+}
+
+func (c *Connection) lockWrite() {
+	c.writeSem <- struct{}{}
+}
+
+func (c *Connection) unlockWrite() {
+	<-c.writeSem
+}
+
+// write 写失败后websocket.Conn不可再用, 关闭底层连接让读循环尽快结束, 错误原样返回给调用方
+func (c *Connection) write(fn func() error) error {
+	c.lockWrite()
+	defer c.unlockWrite()
+	var deadline time.Time
+	if c.writeWait > 0 {
+		deadline = time.Now().Add(c.writeWait)
+	}
+	return c.writeUntil(deadline, fn)
+}
+
+// writeUntil 需持有写锁, deadline为零值时不设置写超时
+func (c *Connection) writeUntil(deadline time.Time, fn func() error) error {
+	if !deadline.IsZero() {
+		// 只作用于本次写入, 避免过期的deadline影响之后直接通过Conn的写入
+		_ = c.Conn.SetWriteDeadline(deadline)
+		defer c.Conn.SetWriteDeadline(time.Time{})
+	}
+
+	err := fn()
+	if err != nil {
+		dglogger.Warnf(c.Ctx, "[%s: %s] write message error, close connection: %v", c.BizKey, c.BizId, err)
+		_ = c.Conn.NetConn().Close()
+	}
+
+	return err
+}
+
+type registryShard struct {
+	lock  sync.RWMutex
+	conns map[string]*Connection
+}
+
+type connRegistry struct {
+	shards [registryShardCount]*registryShard
+}
+
+var registry = newConnRegistry()
+
+func newConnRegistry() *connRegistry {
+	r := &connRegistry{}
+	for i := range r.shards {
+		r.shards[i] = &registryShard{conns: make(map[string]*Connection)}
+	}
+	return r
+}
+
+func (r *connRegistry) shard(id string) *registryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return r.shards[h.Sum32()%registryShardCount]
+}
+
+func (r *connRegistry) add(c *Connection) {
+	s := r.shard(c.Id)
+	s.lock.Lock()
+	s.conns[c.Id] = c
+	s.lock.Unlock()
+}
+
+func (r *connRegistry) remove(c *Connection) {
+	s := r.shard(c.Id)
+	s.lock.Lock()
+	delete(s.conns, c.Id)
+	s.lock.Unlock()
+}
+
+func (r *connRegistry) get(id string) *Connection {
+	s := r.shard(id)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.conns[id]
+}
+
+func (r *connRegistry) rangeConns(fn func(c *Connection) bool) {
+	for _, s := range r.shards {
+		s.lock.RLock()
+		conns := make([]*Connection, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.lock.RUnlock()
+
+		for _, c := range conns {
+			if !fn(c) {
+				return
+			}
+		}
+	}
+}
+
+func (r *connRegistry) count() int {
+	n := 0
+	for _, s := range r.shards {
+		s.lock.RLock()
+		n += len(s.conns)
+		s.lock.RUnlock()
+	}
+	return n
+}
+
+func registerConnection(ctx *dgctx.DgContext, conn *websocket.Conn, writer messageWriter, path string, bizKey string, bizId string, writeWait time.Duration, compression *connCompression, acks *ackTracker, journal *connJournal) *Connection {
+	c := &Connection{
+		Id:          uuid.NewString(),
+		Path:        path,
+		BizKey:      bizKey,
+		BizId:       bizId,
+		UserId:      ctx.UserId,
+		RemoteIp:    ctx.RemoteIp,
+		ConnectedAt: time.Now(),
+		Ctx:         ctx,
+		Conn:        conn,
+		writer:      writer,
+		writeSem:    make(chan struct{}, 1),
+		writeWait:   writeWait,
+		compression: compression,
+	}
+	// 没有经过网关时ctx中没有客户端ip, 使用对端地址
+	if c.RemoteIp == "" && conn != nil {
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			c.RemoteIp = host
+		}
+	}
+	if acks != nil {
+		c.acks = acks
+		if acks.session != nil {
+			c.SessionId = acks.session.token
+		}
+	}
+	if journal != nil {
+		journal.connectionId = c.Id
+		journal.sessionId = c.SessionId
+		c.journal = journal
+	}
+	registry.add(c)
+	GetConnState(ctx).connection.Store(c)
+	registerDirectory(c)
+	// Shutdown时仍在升级或执行StartHandler的连接之后才注册, 同样以1001关闭
+	if shuttingDown.Load() {
+		c.closeGracefully(websocket.CloseGoingAway, shutdownReason)
+	}
+
+	return c
+}
+
+func unregisterConnection(c *Connection) {
+	registry.remove(c)
+	unregisterDirectory(c)
+	if c.acks != nil {
+		c.acks.detach(c)
+	}
+}
+
+func GetConnection(ctx *dgctx.DgContext) *Connection {
+	return GetConnState(ctx).connection.Load()
+}
+
+func GetConnectionById(id string) *Connection {
+	return registry.get(id)
+}
+
+// RangeConnections 遍历当前进程内的所有连接, fn返回false时停止
+func RangeConnections(fn func(c *Connection) bool) {
+	registry.rangeConns(fn)
+}
+
+func ConnectionCount() int {
+	return registry.count()
+}
@@ -0,0 +1,389 @@
+package dgws
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RelayTokenHeader 实例间中继连接携带的共享token
+const RelayTokenHeader = "X-Dgws-Relay-Token"
+
+const (
+	defaultRelayDialTimeout    = 3 * time.Second
+	defaultRelayRequestTimeout = 5 * time.Second
+	defaultRelayWorkers        = 8
+)
+
+var (
+	ErrRelayClosed      = errors.New("websocket relay closed")
+	ErrRelayUnprotected = errors.New("websocket relay requires Token")
+)
+
+// RelayOptions 开启后PushToBizId按连接目录把消息直接转发给持有连接的实例;
+// 每个实例需挂载RelayHandler, 并把其websocket地址配置为DirectoryOptions.NodeAddr
+type RelayOptions struct {
+	// Token 实例间共享的token, 必填
+	Token string
+	// DialTimeout 默认3秒
+	DialTimeout time.Duration
+	// RequestTimeout 等待对端投递结果的时间, 默认5秒
+	RequestTimeout time.Duration
+	// Workers 每个中继连接并行投递的消息数, 默认8; 都在投递时不再读取新的消息
+	Workers int
+}
+
+// RelayMessage 实例间转发的一条定向推送
+type RelayMessage struct {
+	Id          string `json:"id"`
+	BizKey      string `json:"bizKey"`
+	BizId       string `json:"bizId"`
+	MessageType int    `json:"mt"`
+	Data        []byte `json:"data"`
+}
+
+// RelayResult 对端的投递结果, Sent为对端成功写入的连接数
+type RelayResult struct {
+	Id    string `json:"id"`
+	Sent  int    `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+type relayState struct {
+	ctx            *dgctx.DgContext
+	token          string
+	dialer         *websocket.Dialer
+	requestTimeout time.Duration
+	workers        int
+	lock           sync.Mutex
+	peers          map[string]*relayPeer
+	closed         bool
+}
+
+type relayPeer struct {
+	state     *relayState
+	addr      string
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	pending   map[string]chan *RelayResult
+	err       error
+}
+
+var (
+	relay     *relayState
+	relayLock sync.RWMutex
+)
+
+// InitRelay 重复调用会替换之前的配置并关闭已建立的中继连接;
+// 未设置Token时任何能访问RelayHandler的人都能向任意bizId推送消息, 此时不开启并返回ErrRelayUnprotected
+func InitRelay(ctx *dgctx.DgContext, opts *RelayOptions) error {
+	if opts == nil || opts.Token == "" {
+		return ErrRelayUnprotected
+	}
+	state := &relayState{
+		ctx:            ctx,
+		token:          opts.Token,
+		dialer:         &websocket.Dialer{HandshakeTimeout: opts.DialTimeout},
+		requestTimeout: opts.RequestTimeout,
+		workers:        opts.Workers,
+		peers:          make(map[string]*relayPeer),
+	}
+	if state.dialer.HandshakeTimeout <= 0 {
+		state.dialer.HandshakeTimeout = defaultRelayDialTimeout
+	}
+	if state.requestTimeout <= 0 {
+		state.requestTimeout = defaultRelayRequestTimeout
+	}
+	if state.workers <= 0 {
+		state.workers = defaultRelayWorkers
+	}
+
+	relayLock.Lock()
+	previous := relay
+	relay = state
+	relayLock.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
+	return nil
+}
+
+func CloseRelay() {
+	relayLock.Lock()
+	state := relay
+	relay = nil
+	relayLock.Unlock()
+	if state != nil {
+		state.close()
+	}
+}
+
+func getRelay() *relayState {
+	relayLock.RLock()
+	defer relayLock.RUnlock()
+	return relay
+}
+
+// RelayHandler 接收其他实例转发的消息并投递给本实例的连接, 未调用InitRelay时返回404
+func RelayHandler(c *gin.Context) {
+	state := getRelay()
+	if state == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader(RelayTokenHeader)), []byte(state.token)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		dglogger.Warnf(state.ctx, "upgrade websocket relay error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 固定数量的worker投递消息, 都在忙时读循环阻塞, 背压传递给发送方
+	var writeLock sync.Mutex
+	var workers sync.WaitGroup
+	messages := make(chan *RelayMessage, state.workers)
+	for range state.workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range messages {
+				result := state.deliver(msg)
+				writeLock.Lock()
+				_ = conn.SetWriteDeadline(time.Now().Add(state.requestTimeout))
+				_ = conn.WriteJSON(result)
+				writeLock.Unlock()
+			}
+		}()
+	}
+	defer func() {
+		close(messages)
+		workers.Wait()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg := &RelayMessage{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			dglogger.Warnf(state.ctx, "websocket relay decode message error: %v", err)
+			continue
+		}
+		messages <- msg
+	}
+}
+
+// deliver 把中继消息投递给本实例的连接
+func (s *relayState) deliver(msg *RelayMessage) *RelayResult {
+	result := &RelayResult{Id: msg.Id}
+	sent, err := BroadcastFilter(msg.MessageType, msg.Data, bizIdsFilter(msg.BizKey, []string{msg.BizId}))
+	result.Sent = sent
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// PushToBizId 向bizId的所有连接发送消息, 不论连接在哪个实例上, 返回成功发送的连接数;
+// 本实例的连接直接写入, 目录中其他实例的连接经中继转发; 目录中查不到、对端没有地址或中继失败时退化为集群广播, 此时对端发送数不计入返回值;
+// 开启InitMembership时跳过失联的实例, 对端没有地址时使用成员的地址
+func PushToBizId(ctx *dgctx.DgContext, bizKey string, bizId string, mt int, data []byte) (int, error) {
+	dir := getDirectory()
+	state := getRelay()
+	if dir == nil || state == nil {
+		return BroadcastToBizIds(bizKey, []string{bizId}, mt, data)
+	}
+
+	entries, err := dir.lookup(ctx, bizKey, bizId)
+	if err != nil {
+		dglogger.Warnf(ctx, "[%s: %s] lookup websocket directory error: %v", bizKey, bizId, err)
+	}
+
+	sent, err := BroadcastFilter(mt, data, bizIdsFilter(bizKey, []string{bizId}))
+	if err != nil {
+		return sent, err
+	}
+
+	fallback := len(entries) == 0
+	nodes := make(map[string]string)
+	m := getMembership()
+	for _, entry := range entries {
+		if entry.NodeId == dir.nodeId {
+			continue
+		}
+		addr := entry.NodeAddr
+		if addr == "" && m != nil {
+			addr = m.addr(entry.NodeId)
+		}
+		if addr == "" {
+			fallback = true
+			continue
+		}
+		nodes[entry.NodeId] = addr
+	}
+	for _, addr := range nodes {
+		n, err := state.send(ctx, addr, &RelayMessage{Id: uuid.NewString(), BizKey: bizKey, BizId: bizId, MessageType: mt, Data: data})
+		if err != nil {
+			dglogger.Warnf(ctx, "[%s: %s] relay to %s error: %v", bizKey, bizId, addr, err)
+			fallback = true
+			continue
+		}
+		sent += n
+	}
+
+	if fallback {
+		return sent, publishCluster(bizKey, []string{bizId}, mt, data)
+	}
+
+	return sent, nil
+}
+
+func PushJSONToBizId(ctx *dgctx.DgContext, bizKey string, bizId string, v any) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return PushToBizId(ctx, bizKey, bizId, websocket.TextMessage, data)
+}
+
+func (s *relayState) send(ctx *dgctx.DgContext, addr string, msg *RelayMessage) (int, error) {
+	peer, err := s.peer(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+
+	return peer.send(msg, s.requestTimeout)
+}
+
+// peer 复用到同一地址的中继连接, 连接断开后下次发送时重新建立
+func (s *relayState) peer(ctx *dgctx.DgContext, addr string) (*relayPeer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, ErrRelayClosed
+	}
+	if peer := s.peers[addr]; peer != nil {
+		return peer, nil
+	}
+
+	header := http.Header{}
+	header.Set(RelayTokenHeader, s.token)
+	dialCtx, cancel := context.WithTimeout(innerContext(ctx), s.dialer.HandshakeTimeout)
+	defer cancel()
+	conn, _, err := s.dialer.DialContext(dialCtx, addr, header)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := &relayPeer{state: s, addr: addr, conn: conn, pending: make(map[string]chan *RelayResult)}
+	s.peers[addr] = peer
+	go peer.read()
+
+	return peer, nil
+}
+
+func (s *relayState) close() {
+	s.lock.Lock()
+	s.closed = true
+	peers := s.peers
+	s.peers = make(map[string]*relayPeer)
+	s.lock.Unlock()
+
+	for _, peer := range peers {
+		_ = peer.conn.Close()
+	}
+}
+
+func (s *relayState) removePeer(peer *relayPeer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.peers[peer.addr] == peer {
+		delete(s.peers, peer.addr)
+	}
+}
+
+func (p *relayPeer) send(msg *RelayMessage, timeout time.Duration) (int, error) {
+	ch := make(chan *RelayResult, 1)
+	p.lock.Lock()
+	if p.err != nil {
+		p.lock.Unlock()
+		return 0, p.err
+	}
+	p.pending[msg.Id] = ch
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, msg.Id)
+		p.lock.Unlock()
+	}()
+
+	p.writeLock.Lock()
+	_ = p.conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := p.conn.WriteJSON(msg)
+	p.writeLock.Unlock()
+	if err != nil {
+		_ = p.conn.Close()
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result, ok := <-ch:
+		if !ok {
+			return 0, ErrRelayClosed
+		}
+		if result.Error != "" {
+			return result.Sent, errors.New(result.Error)
+		}
+		return result.Sent, nil
+	case <-timer.C:
+		return 0, context.DeadlineExceeded
+	}
+}
+
+// read 读取对端返回的投递结果, 连接断开时让所有等待中的发送失败
+func (p *relayPeer) read() {
+	defer func() {
+		p.state.removePeer(p)
+		p.lock.Lock()
+		p.err = ErrRelayClosed
+		for id, ch := range p.pending {
+			close(ch)
+			delete(p.pending, id)
+		}
+		p.lock.Unlock()
+		_ = p.conn.Close()
+	}()
+
+	for {
+		result := &RelayResult{}
+		if err := p.conn.ReadJSON(result); err != nil {
+			return
+		}
+		p.lock.Lock()
+		if ch := p.pending[result.Id]; ch != nil {
+			ch <- result
+		}
+		p.lock.Unlock()
+	}
+}
@@ -0,0 +1,201 @@
+package dgws_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/redisbroker"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticDirectoryStore 模拟其他实例登记的连接, 本实例的登记被忽略
+type staticDirectoryStore struct {
+	entries map[string][]*dgws.DirectoryEntry
+}
+
+func (s *staticDirectoryStore) Register(context.Context, []*dgws.DirectoryEntry, time.Duration) error {
+	return nil
+}
+
+func (s *staticDirectoryStore) Unregister(context.Context, []*dgws.DirectoryEntry) error {
+	return nil
+}
+
+func (s *staticDirectoryStore) Lookup(_ context.Context, _ string, bizId string) ([]*dgws.DirectoryEntry, error) {
+	return s.entries[bizId], nil
+}
+
+func startRelayServer(t *testing.T) string {
+	engine := gin.New()
+	engine.GET("/relay", dgws.RelayHandler)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/relay"
+}
+
+func TestPushToBizIdViaRelay(t *testing.T) {
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitRelay(ctx, &dgws.RelayOptions{Token: "secret", RequestTimeout: time.Second}); err != nil {
+		t.Fatalf("init relay: %v", err)
+	}
+	defer dgws.CloseRelay()
+	relayUrl := startRelayServer(t)
+
+	store := &staticDirectoryStore{entries: map[string][]*dgws.DirectoryEntry{
+		"relay-a": {{NodeId: "node-remote", NodeAddr: relayUrl, BizKey: "bizId", BizId: "relay-a"}},
+	}}
+	if err := dgws.InitDirectory(ctx, &dgws.DirectoryOptions{Store: store, NodeId: "node-local"}); err != nil {
+		t.Fatalf("init directory: %v", err)
+	}
+	defer dgws.CloseDirectory()
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=relay-a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "relay-a", 1)
+
+	// 两个"实例"在同一进程内, 本地直写一次, 经中继再投递一次
+	sent, err := dgws.PushToBizId(ctx, "bizId", "relay-a", websocket.TextMessage, []byte("hello"))
+	if err != nil || sent != 2 {
+		t.Fatalf("push: %d, %v", sent, err)
+	}
+	for i := 0; i < 2; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hello" {
+			t.Fatalf("read: %s, %v", string(data), err)
+		}
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(relayUrl, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("relay without token should be rejected: %v", err)
+	}
+}
+
+func TestPushToBizIdFallbackBroadcast(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitCluster(ctx, &dgws.ClusterOptions{Broker: redisbroker.New(rdb), ChannelPrefix: "relay:"}); err != nil {
+		t.Fatalf("init cluster: %v", err)
+	}
+	defer dgws.CloseCluster()
+	if err := dgws.InitRelay(ctx, &dgws.RelayOptions{Token: "secret", DialTimeout: 200 * time.Millisecond}); err != nil {
+		t.Fatalf("init relay: %v", err)
+	}
+	defer dgws.CloseRelay()
+
+	store := &staticDirectoryStore{entries: map[string][]*dgws.DirectoryEntry{
+		"no-addr":     {{NodeId: "node-remote", BizKey: "bizId", BizId: "no-addr"}},
+		"unreachable": {{NodeId: "node-remote", NodeAddr: "ws://127.0.0.1:1/relay", BizKey: "bizId", BizId: "unreachable"}},
+	}}
+	if err := dgws.InitDirectory(ctx, &dgws.DirectoryOptions{Store: store, NodeId: "node-local"}); err != nil {
+		t.Fatalf("init directory: %v", err)
+	}
+	defer dgws.CloseDirectory()
+
+	observer := rdb.Subscribe(context.Background(), "relay:broadcast")
+	defer observer.Close()
+	if _, err := observer.Receive(context.Background()); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	for _, bizId := range []string{"unknown", "no-addr", "unreachable"} {
+		if _, err := dgws.PushToBizId(ctx, "bizId", bizId, websocket.TextMessage, []byte(bizId)); err != nil {
+			t.Fatalf("push %s: %v", bizId, err)
+		}
+		select {
+		case msg := <-observer.Channel():
+			if !strings.Contains(msg.Payload, bizId) {
+				t.Fatalf("unexpected cluster message: %s", msg.Payload)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("push %s did not fall back to broadcast", bizId)
+		}
+	}
+}
+
+func TestRelayRequiresToken(t *testing.T) {
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	for _, opts := range []*dgws.RelayOptions{nil, {RequestTimeout: time.Second}} {
+		if err := dgws.InitRelay(ctx, opts); !errors.Is(err, dgws.ErrRelayUnprotected) {
+			t.Fatalf("expected ErrRelayUnprotected, got %v", err)
+		}
+	}
+
+	// 未开启时RelayHandler不接收消息
+	relayUrl := startRelayServer(t)
+	if _, resp, err := websocket.DefaultDialer.Dial(relayUrl, nil); err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("relay without InitRelay should be rejected: %v", err)
+	}
+}
+
+func TestRelayConcurrentDelivery(t *testing.T) {
+	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
+	if err := dgws.InitRelay(ctx, &dgws.RelayOptions{Token: "secret", RequestTimeout: 3 * time.Second, Workers: 2}); err != nil {
+		t.Fatalf("init relay: %v", err)
+	}
+	defer dgws.CloseRelay()
+	relayUrl := startRelayServer(t)
+
+	store := &staticDirectoryStore{entries: map[string][]*dgws.DirectoryEntry{
+		"relay-many": {{NodeId: "node-remote", NodeAddr: relayUrl, BizKey: "bizId", BizId: "relay-many"}},
+	}}
+	if err := dgws.InitDirectory(ctx, &dgws.DirectoryOptions{Store: store, NodeId: "node-local"}); err != nil {
+		t.Fatalf("init directory: %v", err)
+	}
+	defer dgws.CloseDirectory()
+
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=relay-many", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "relay-many", 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 并发推送的消息多于worker数, 全部经中继投递
+	const pushes = 20
+	results := make(chan error, pushes)
+	for range pushes {
+		go func() {
+			sent, err := dgws.PushToBizId(ctx, "bizId", "relay-many", websocket.TextMessage, []byte("hello"))
+			if err == nil && sent != 2 {
+				err = fmt.Errorf("sent to %d connections", sent)
+			}
+			results <- err
+		}()
+	}
+	for range pushes {
+		if err := <-results; err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package dgws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	EnvelopeTypeMessage = "message"
+	EnvelopeTypeAck     = "ack"
+	// EnvelopeTypeReplay 客户端请求重发Seq及之后的消息, 服务端无法满足时以同类型消息返回最早可重发的Seq和Error
+	EnvelopeTypeReplay = "replay"
+)
+
+const (
+	defaultAckTimeout  = 5 * time.Second
+	defaultMaxAttempts = 3
+)
+
+var (
+	ErrAckDisabled       = errors.New("websocket ack is not enabled for this route")
+	ErrDeliveryFailed    = errors.New("websocket message not acknowledged")
+	ErrConnectionClosed  = errors.New("websocket connection closed")
+	ErrReplayBufferFull  = errors.New("websocket replay buffer full")
+	ackEnvelopePrefix    = []byte(`{"type":"ack"`)
+	replayEnvelopePrefix = []byte(`{"type":"replay"`)
+)
+
+// DeliveryFailedHandler 消息在重试次数内未被确认, 或确认前连接已断开(会话过期)时回调
+type DeliveryFailedHandler func(ctx *dgctx.DgContext, env *Envelope, err error)
+
+// AckOptions 至少一次投递, 通过SendReliable发送的消息在AckTimeout内未收到ack会重发, 最多发送MaxAttempts次
+type AckOptions struct {
+	AckTimeout            time.Duration
+	MaxAttempts           int
+	DeliveryFailedHandler DeliveryFailedHandler
+}
+
+type pendingDelivery struct {
+	env      *Envelope
+	data     []byte
+	attempts int
+	timer    *time.Timer
+}
+
+// ackTracker 未确认消息的跟踪, 开启会话时属于会话, 连接断开后保留到会话过期, 恢复时重放给新连接
+type ackTracker struct {
+	opts       *AckOptions
+	maxPending int
+	lock       sync.Mutex
+	conn       *Connection
+	attached   bool
+	session    *serverSession
+	store      ReplayStore
+	retain     int
+	history    []*pendingDelivery
+	seq        int64
+	pending    map[string]*pendingDelivery
+	closed     bool
+}
+
+func newAckTracker(opts *AckOptions, maxPending int) *ackTracker {
+	return &ackTracker{opts: opts, maxPending: maxPending, pending: make(map[string]*pendingDelivery)}
+}
+
+// SendReliable 以message信封发送data并等待客户端ack, 返回消息id; 未确认的消息由DeliveryFailedHandler通知
+func (c *Connection) SendReliable(data any) (string, error) {
+	if c.acks == nil {
+		return "", ErrAckDisabled
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	env := &Envelope{Type: EnvelopeTypeMessage, Id: uuid.NewString(), Data: raw}
+	msg, err := c.acks.track(env)
+	if err != nil {
+		return "", err
+	}
+	if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+		// 写失败的消息保留在待确认列表中, 由超时重发、会话恢复重放或最终的失败回调处理
+		dglogger.Warnf(c.Ctx, "[%s: %s] send reliable message %s error: %v", c.BizKey, c.BizId, env.Id, err)
+	}
+
+	return env.Id, nil
+}
+
+// PendingAcks 尚未确认的消息数
+func (c *Connection) PendingAcks() int {
+	if c.acks == nil {
+		return 0
+	}
+
+	c.acks.lock.Lock()
+	defer c.acks.lock.Unlock()
+	return len(c.acks.pending)
+}
+
+// track 分配序号并编码消息, 加入待确认列表
+func (t *ackTracker) track(env *Envelope) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return nil, ErrConnectionClosed
+	}
+	if t.maxPending > 0 && len(t.pending) >= t.maxPending {
+		return nil, ErrReplayBufferFull
+	}
+
+	t.seq++
+	env.Seq = t.seq
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.store != nil {
+		if err := t.store.Append(t.conn.Ctx, t.session.token, t.session.owner(), env); err != nil {
+			return nil, err
+		}
+	}
+	t.add(env, data)
+
+	return data, nil
+}
+
+// add 调用方需持有锁
+func (t *ackTracker) add(env *Envelope, data []byte) {
+	p := &pendingDelivery{env: env, data: data, attempts: 1}
+	p.timer = time.AfterFunc(t.ackTimeout(), func() {
+		t.timeout(env.Id)
+	})
+	t.pending[env.Id] = p
+	if t.retain > 0 {
+		t.history = append(t.history, p)
+		if len(t.history) > t.retain {
+			t.history[0] = nil
+			t.history = t.history[1:]
+		}
+	}
+}
+
+// replayFrom 响应客户端的replay请求, 重发已保留的seq及之后的消息(包括已确认的)
+func (t *ackTracker) replayFrom(seq int64) {
+	t.lock.Lock()
+	conn := t.conn
+	replay := make(map[string]*pendingDelivery)
+	oldest := t.seq + 1
+	for _, p := range t.history {
+		oldest = min(oldest, p.env.Seq)
+		if p.env.Seq >= seq {
+			replay[p.env.Id] = p
+		}
+	}
+	for id, p := range t.pending {
+		oldest = min(oldest, p.env.Seq)
+		if p.env.Seq >= seq {
+			replay[id] = p
+		}
+	}
+	t.lock.Unlock()
+
+	if seq < oldest {
+		msg, _ := json.Marshal(&Envelope{Type: EnvelopeTypeReplay, Seq: oldest, Error: "messages before seq are no longer retained"})
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return
+		}
+	}
+	for _, p := range sortBySeq(replay) {
+		if err := conn.WriteMessage(websocket.TextMessage, p.data); err != nil {
+			dglogger.Warnf(conn.Ctx, "[%s: %s] replay message %s error: %v", conn.BizKey, conn.BizId, p.env.Id, err)
+			return
+		}
+	}
+}
+
+func sortBySeq(deliveries map[string]*pendingDelivery) []*pendingDelivery {
+	sorted := make([]*pendingDelivery, 0, len(deliveries))
+	for _, p := range deliveries {
+		sorted = append(sorted, p)
+	}
+	slices.SortFunc(sorted, func(a, b *pendingDelivery) int {
+		return int(a.env.Seq - b.env.Seq)
+	})
+
+	return sorted
+}
+
+// restore 从ReplayStore恢复未确认的消息, 在attach之前调用
+func (t *ackTracker) restore(envs []*Envelope) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, env := range envs {
+		data, err := json.Marshal(env)
+		if err != nil {
+			return err
+		}
+		t.add(env, data)
+		t.seq = max(t.seq, env.Seq)
+	}
+
+	return nil
+}
+
+func (t *ackTracker) removeStored(conn *Connection, id string) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.Remove(conn.Ctx, t.session.token, id); err != nil {
+		dglogger.Warnf(conn.Ctx, "[%s: %s] remove stored message %s error: %v", conn.BizKey, conn.BizId, id, err)
+	}
+}
+
+func (t *ackTracker) ack(id string) {
+	t.lock.Lock()
+	p := t.pending[id]
+	delete(t.pending, id)
+	conn := t.conn
+	t.lock.Unlock()
+
+	if p != nil {
+		p.timer.Stop()
+		t.removeStored(conn, id)
+	}
+}
+
+func (t *ackTracker) timeout(id string) {
+	t.lock.Lock()
+	p := t.pending[id]
+	// 会话断开期间不计重试次数, 恢复后重放
+	if p == nil || t.closed || !t.attached {
+		t.lock.Unlock()
+		return
+	}
+	conn := t.conn
+	if p.attempts >= t.maxAttempts() {
+		delete(t.pending, id)
+		t.lock.Unlock()
+		t.removeStored(conn, id)
+		t.fail(conn, p, ErrDeliveryFailed)
+		return
+	}
+	p.attempts++
+	p.timer.Reset(t.ackTimeout())
+	t.lock.Unlock()
+
+	if err := conn.WriteMessage(websocket.TextMessage, p.data); err != nil {
+		dglogger.Warnf(conn.Ctx, "[%s: %s] resend message %s error: %v", conn.BizKey, conn.BizId, id, err)
+	}
+}
+
+// attach 绑定新连接, 按序号重放所有未确认的消息
+func (t *ackTracker) attach(c *Connection) {
+	t.lock.Lock()
+	t.conn = c
+	t.attached = true
+	for _, p := range t.pending {
+		p.attempts = 1
+		p.timer.Reset(t.ackTimeout())
+	}
+	replay := sortBySeq(t.pending)
+	t.lock.Unlock()
+
+	for _, p := range replay {
+		if err := c.WriteMessage(websocket.TextMessage, p.data); err != nil {
+			dglogger.Warnf(c.Ctx, "[%s: %s] replay message %s error: %v", c.BizKey, c.BizId, p.env.Id, err)
+			return
+		}
+	}
+}
+
+// detach 连接结束时调用, 没有会话时未确认的消息全部回调失败, 有会话时保留等待恢复
+func (t *ackTracker) detach(c *Connection) {
+	t.lock.Lock()
+	if t.conn != c || !t.attached {
+		t.lock.Unlock()
+		return
+	}
+	t.attached = false
+	for _, p := range t.pending {
+		p.timer.Stop()
+	}
+	session := t.session
+	t.lock.Unlock()
+
+	if session != nil {
+		session.detached()
+	} else {
+		t.close()
+	}
+}
+
+func (t *ackTracker) close() {
+	t.lock.Lock()
+	t.closed = true
+	pending := t.pending
+	t.pending = make(map[string]*pendingDelivery)
+	conn := t.conn
+	t.lock.Unlock()
+
+	for _, p := range pending {
+		p.timer.Stop()
+		t.fail(conn, p, ErrConnectionClosed)
+	}
+}
+
+func (t *ackTracker) fail(conn *Connection, p *pendingDelivery, err error) {
+	dglogger.Warnf(conn.Ctx, "[%s: %s] message %s delivery failed after %d attempts: %v", conn.BizKey, conn.BizId, p.env.Id, p.attempts, err)
+	if t.opts.DeliveryFailedHandler != nil {
+		t.opts.DeliveryFailedHandler(conn.Ctx, p.env, err)
+	}
+}
+
+func (t *ackTracker) lastConn() *Connection {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.conn
+}
+
+func (t *ackTracker) ackTimeout() time.Duration {
+	if t.opts.AckTimeout > 0 {
+		return t.opts.AckTimeout
+	}
+	return defaultAckTimeout
+}
+
+func (t *ackTracker) maxAttempts() int {
+	if t.opts.MaxAttempts > 0 {
+		return t.opts.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// isAckEnvelope 快速判断是否为客户端的ack或replay消息, 避免对每条业务消息做json解析
+func isAckEnvelope(mt int, data []byte) bool {
+	return mt == websocket.TextMessage && (bytes.HasPrefix(data, ackEnvelopePrefix) || bytes.HasPrefix(data, replayEnvelopePrefix))
+}
+
+func (t *ackTracker) handleEnvelope(env *Envelope) {
+	switch env.Type {
+	case EnvelopeTypeAck:
+		t.ack(env.Id)
+	case EnvelopeTypeReplay:
+		t.replayFrom(env.Seq)
+	}
+}
@@ -0,0 +1,104 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func startReliableServer(t *testing.T, failed chan error) string {
+	return startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Ack: &dgws.AckOptions{
+			AckTimeout:  50 * time.Millisecond,
+			MaxAttempts: 3,
+			DeliveryFailedHandler: func(_ *dgctx.DgContext, _ *dgws.Envelope, err error) {
+				failed <- err
+			},
+		},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		_, err := dgws.GetConnection(ctx).SendReliable(&testData{Content: "notify"})
+		return err
+	})
+}
+
+func TestSendReliableAcked(t *testing.T) {
+	failed := make(chan error, 10)
+	url := startReliableServer(t, failed)
+
+	received := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			received <- string(data)
+			return nil
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != `{"content":"notify"}` {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("reliable message not received")
+	}
+
+	select {
+	case msg := <-received:
+		t.Fatalf("acked message resent: %s", msg)
+	case err := <-failed:
+		t.Fatalf("acked message failed: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestSendReliableResendAndFail(t *testing.T) {
+	failed := make(chan error, 10)
+	url := startReliableServer(t, failed)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	ids := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read attempt %d: %v", i, err)
+		}
+		env := &dgws.Envelope{}
+		if err := json.Unmarshal(data, env); err != nil || env.Type != dgws.EnvelopeTypeMessage {
+			t.Fatalf("unexpected message: %s", string(data))
+		}
+		ids[env.Id]++
+	}
+	if len(ids) != 1 {
+		t.Fatalf("resends should keep the message id, got %v", ids)
+	}
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, dgws.ErrDeliveryFailed) {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("delivery failure not reported")
+	}
+}
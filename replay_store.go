@@ -0,0 +1,256 @@
+package dgws
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayOwner 会话所属的用户和业务, 从存储恢复会话时校验
+type ReplayOwner struct {
+	UserId int64
+	BizKey string
+	BizId  string
+}
+
+// ReplayStore 会话未确认消息的持久化, 进程重启后客户端带着恢复token重连仍能收到重放;
+// Load返回的消息需按Seq升序, Redis实现见redisstore子包
+type ReplayStore interface {
+	Append(ctx *dgctx.DgContext, sessionId string, owner *ReplayOwner, env *Envelope) error
+	Remove(ctx *dgctx.DgContext, sessionId string, id string) error
+	Load(ctx *dgctx.DgContext, sessionId string) (*ReplayOwner, []*Envelope, error)
+	Delete(ctx *dgctx.DgContext, sessionId string) error
+}
+
+// MemoryReplayStore 进程内存储, 仅用于测试或单机场景, 不能跨进程重启
+type MemoryReplayStore struct {
+	// Ttl 消息的保留时间, 0表示不过期
+	Ttl time.Duration
+	// MaxPerSession 单个会话最多保留的消息数, 超过时丢弃最旧的消息, 0表示不限制
+	MaxPerSession int
+	lock          sync.Mutex
+	sessions      map[string]*memoryReplaySession
+}
+
+type memoryReplaySession struct {
+	owner    *ReplayOwner
+	messages []*memoryReplayMessage
+}
+
+type memoryReplayMessage struct {
+	env       *Envelope
+	expiresAt time.Time
+}
+
+func NewMemoryReplayStore(ttl time.Duration, maxPerSession int) *MemoryReplayStore {
+	return &MemoryReplayStore{Ttl: ttl, MaxPerSession: maxPerSession, sessions: make(map[string]*memoryReplaySession)}
+}
+
+func (s *MemoryReplayStore) Append(_ *dgctx.DgContext, sessionId string, owner *ReplayOwner, env *Envelope) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session := s.sessions[sessionId]
+	if session == nil {
+		session = &memoryReplaySession{owner: owner}
+		s.sessions[sessionId] = session
+	}
+	msg := &memoryReplayMessage{env: env}
+	if s.Ttl > 0 {
+		msg.expiresAt = time.Now().Add(s.Ttl)
+	}
+	session.messages = append(session.messages, msg)
+	if s.MaxPerSession > 0 && len(session.messages) > s.MaxPerSession {
+		session.messages = slices.Delete(session.messages, 0, len(session.messages)-s.MaxPerSession)
+	}
+
+	return nil
+}
+
+func (s *MemoryReplayStore) Remove(_ *dgctx.DgContext, sessionId string, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if session := s.sessions[sessionId]; session != nil {
+		session.messages = slices.DeleteFunc(session.messages, func(msg *memoryReplayMessage) bool {
+			return msg.env.Id == id
+		})
+	}
+
+	return nil
+}
+
+func (s *MemoryReplayStore) Load(_ *dgctx.DgContext, sessionId string) (*ReplayOwner, []*Envelope, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session := s.sessions[sessionId]
+	if session == nil {
+		return nil, nil, nil
+	}
+
+	now := time.Now()
+	envs := make([]*Envelope, 0, len(session.messages))
+	for _, msg := range session.messages {
+		if msg.expiresAt.IsZero() || msg.expiresAt.After(now) {
+			envs = append(envs, msg.env)
+		}
+	}
+
+	return session.owner, envs, nil
+}
+
+func (s *MemoryReplayStore) Delete(_ *dgctx.DgContext, sessionId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, sessionId)
+	return nil
+}
+
+// SqlReplayStore 基于database/sql的存储, 默认占位符为?(MySQL、SQLite), 表结构(PostgreSQL中data为BYTEA, expires_at为TIMESTAMP):
+//
+//	CREATE TABLE ws_replay_message (
+//	  session_id VARCHAR(64) NOT NULL,
+//	  message_id VARCHAR(64) NOT NULL,
+//	  seq BIGINT NOT NULL,
+//	  user_id BIGINT NOT NULL,
+//	  biz_key VARCHAR(64) NOT NULL,
+//	  biz_id VARCHAR(128) NOT NULL,
+//	  data BLOB NOT NULL,
+//	  expires_at DATETIME(3) NULL,
+//	  PRIMARY KEY (session_id, message_id),
+//	  KEY idx_session_seq (session_id, seq)
+//	);
+type SqlReplayStore struct {
+	// Placeholder 占位符风格, 使用PostgreSQL时设置为SqlPlaceholderDollar
+	Placeholder   SqlPlaceholder
+	db            *sql.DB
+	table         string
+	ttl           time.Duration
+	maxPerSession int
+}
+
+// NewSqlReplayStore ttl为0表示不过期, 过期数据需由业务定期按expires_at清理;
+// maxPerSession为单个会话最多保留的消息数, 写入时删除更旧的消息, 0表示不限制
+func NewSqlReplayStore(db *sql.DB, table string, ttl time.Duration, maxPerSession int) *SqlReplayStore {
+	return &SqlReplayStore{db: db, table: table, ttl: ttl, maxPerSession: maxPerSession}
+}
+
+func (s *SqlReplayStore) Append(ctx *dgctx.DgContext, sessionId string, owner *ReplayOwner, env *Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	var expiresAt any
+	if s.ttl > 0 {
+		expiresAt = time.Now().Add(s.ttl)
+	}
+
+	_, err = s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("INSERT INTO "+s.table+" (session_id, message_id, seq, user_id, biz_key, biz_id, data, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		sessionId, env.Id, env.Seq, owner.UserId, owner.BizKey, owner.BizId, data, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return s.trim(ctx, sessionId)
+}
+
+// trim 删除超过maxPerSession的旧消息, 先查出保留范围外最新的seq再删除, 避免MySQL不支持在DELETE中查询同一张表
+func (s *SqlReplayStore) trim(ctx *dgctx.DgContext, sessionId string) error {
+	if s.maxPerSession <= 0 {
+		return nil
+	}
+
+	var seq int64
+	err := s.db.QueryRowContext(innerContext(ctx), s.Placeholder.rebind("SELECT seq FROM "+s.table+" WHERE session_id = ? ORDER BY seq DESC LIMIT 1 OFFSET ?"),
+		sessionId, s.maxPerSession).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("DELETE FROM "+s.table+" WHERE session_id = ? AND seq <= ?"), sessionId, seq)
+	return err
+}
+
+func (s *SqlReplayStore) Remove(ctx *dgctx.DgContext, sessionId string, id string) error {
+	_, err := s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("DELETE FROM "+s.table+" WHERE session_id = ? AND message_id = ?"), sessionId, id)
+	return err
+}
+
+func (s *SqlReplayStore) Load(ctx *dgctx.DgContext, sessionId string) (*ReplayOwner, []*Envelope, error) {
+	rows, err := s.db.QueryContext(innerContext(ctx), s.Placeholder.rebind("SELECT user_id, biz_key, biz_id, data FROM "+s.table+" WHERE session_id = ? AND (expires_at IS NULL OR expires_at > ?) ORDER BY seq"),
+		sessionId, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var owner *ReplayOwner
+	var envs []*Envelope
+	for rows.Next() {
+		o := &ReplayOwner{}
+		var data []byte
+		if err := rows.Scan(&o.UserId, &o.BizKey, &o.BizId, &data); err != nil {
+			return nil, nil, err
+		}
+		env := &Envelope{}
+		if err := json.Unmarshal(data, env); err != nil {
+			return nil, nil, err
+		}
+		owner = o
+		envs = append(envs, env)
+	}
+
+	return owner, envs, rows.Err()
+}
+
+func (s *SqlReplayStore) Delete(ctx *dgctx.DgContext, sessionId string) error {
+	_, err := s.db.ExecContext(innerContext(ctx), s.Placeholder.rebind("DELETE FROM "+s.table+" WHERE session_id = ?"), sessionId)
+	return err
+}
+
+// SqlPlaceholder SQL存储的占位符风格
+type SqlPlaceholder int
+
+const (
+	// SqlPlaceholderQuestion ?, 用于MySQL、SQLite
+	SqlPlaceholderQuestion SqlPlaceholder = iota
+	// SqlPlaceholderDollar $1、$2, 用于PostgreSQL
+	SqlPlaceholderDollar
+)
+
+// rebind 语句中除占位符外不含?
+func (p SqlPlaceholder) rebind(query string) string {
+	if p != SqlPlaceholderDollar {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func innerContext(ctx *dgctx.DgContext) context.Context {
+	if ctx != nil && ctx.InnerContext() != nil {
+		return ctx.InnerContext()
+	}
+	return context.Background()
+}
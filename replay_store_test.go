@@ -0,0 +1,76 @@
+package dgws_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	dgws "github.com/darwinOrg/go-websocket"
+	"strconv"
+	"testing"
+)
+
+func replayEnvelopes(n int) []*dgws.Envelope {
+	envs := make([]*dgws.Envelope, 0, n)
+	for i := 1; i <= n; i++ {
+		envs = append(envs, &dgws.Envelope{Type: dgws.EnvelopeTypeMessage, Id: "m" + strconv.Itoa(i), Seq: int64(i)})
+	}
+	return envs
+}
+
+func TestSqlReplayStoreTrim(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := dgws.NewSqlReplayStore(db, "ws_replay_message", 0, 2)
+	env := replayEnvelopes(3)[2]
+
+	mock.ExpectExec("INSERT INTO ws_replay_message (session_id, message_id, seq, user_id, biz_key, biz_id, data, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").
+		WithArgs("s1", "m3", int64(3), int64(7), "room", "r1", sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT seq FROM ws_replay_message WHERE session_id = ? ORDER BY seq DESC LIMIT 1 OFFSET ?").
+		WithArgs("s1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"seq"}).AddRow(1))
+	mock.ExpectExec("DELETE FROM ws_replay_message WHERE session_id = ? AND seq <= ?").
+		WithArgs("s1", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.Append(nil, "s1", &dgws.ReplayOwner{UserId: 7, BizKey: "room", BizId: "r1"}, env); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未超过上限时不删除
+	mock.ExpectExec("INSERT INTO ws_replay_message (session_id, message_id, seq, user_id, biz_key, biz_id, data, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT seq FROM ws_replay_message WHERE session_id = ? ORDER BY seq DESC LIMIT 1 OFFSET ?").
+		WithArgs("s2", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"seq"}))
+	if err := store.Append(nil, "s2", &dgws.ReplayOwner{UserId: 7, BizKey: "room", BizId: "r1"}, env); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSqlReplayStoreDollarPlaceholder(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := dgws.NewSqlReplayStore(db, "ws_replay_message", 0, 0)
+	store.Placeholder = dgws.SqlPlaceholderDollar
+
+	mock.ExpectExec("DELETE FROM ws_replay_message WHERE session_id = $1 AND message_id = $2").
+		WithArgs("s1", "m1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Remove(nil, "s1", "m1"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
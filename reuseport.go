@@ -0,0 +1,54 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ReusePortListeners 以SO_REUSEPORT在同一地址上创建n个监听, 由内核在各监听间分配新连接,
+// 适用于单个accept循环成为建连瓶颈的场景
+func ReusePortListeners(ctx context.Context, network string, addr string, n int) ([]net.Listener, error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		ln, err := lc.Listen(ctx, network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+		// addr端口为0时, 后续监听复用第一个监听分配到的端口
+		addr = ln.Addr().String()
+	}
+
+	return listeners, nil
+}
+
+// ServeReusePort 在n个SO_REUSEPORT监听上运行accept循环, 共用同一个handler(如注册了dgws路由的gin.Engine);
+// 任一监听出错即关闭全部监听并返回该错误
+func ServeReusePort(addr string, n int, handler http.Handler) error {
+	listeners, err := ReusePortListeners(context.Background(), "tcp", addr, n)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler}
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errs <- server.Serve(ln)
+		}(ln)
+	}
+
+	err = <-errs
+	_ = server.Close()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,59 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package dgws_test
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+)
+
+func TestReusePortListeners(t *testing.T) {
+	listeners, err := dgws.ReusePortListeners(context.Background(), "tcp", "127.0.0.1:0", 2)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if listeners[0].Addr().String() != listeners[1].Addr().String() {
+		t.Fatalf("listeners bound to different addresses: %s, %s", listeners[0].Addr(), listeners[1].Addr())
+	}
+
+	engine := gin.New()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/ws"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			return wsm.Connection.WriteMessage(wsm.MessageType, wsm.MessageData)
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey:          "bizId",
+		GetBizIdHandler: func(c *gin.Context) string { return c.Query("bizId") },
+	})
+	server := &http.Server{Handler: engine}
+	for _, ln := range listeners {
+		go func() {
+			_ = server.Serve(ln)
+		}()
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	for i := 0; i < 4; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+listeners[0].Addr().String()+"/ws", nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hi" {
+			t.Fatalf("unexpected echo: %s, %v", string(data), err)
+		}
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,144 @@
+package dgws
+
+import (
+	"errors"
+	"fmt"
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/result"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Route struct {
+	RelativePath string
+	Remark       string
+	BizHandler   wrapper.HandlerFunc[WebSocketMessage, error]
+	Config       *WebSocketHandlerConfig
+}
+
+// ErrRouteConfigRequired Route缺少Config或GetBizIdHandler
+var ErrRouteConfigRequired = errors.New("websocket route config with GetBizIdHandler is required")
+
+// RegisterRoutes 批量注册websocket路由, rh中的RouterGroup、PreHandlersChain、登录及权限配置由所有路由共享;
+// 任一路由的配置无效时返回ErrRouteConfigRequired, 不注册任何路由
+func RegisterRoutes(rh *wrapper.RequestHolder[WebSocketMessage, error], routes []*Route) error {
+	for _, route := range routes {
+		if route.Config == nil || route.Config.GetBizIdHandler == nil {
+			return fmt.Errorf("%w: %s", ErrRouteConfigRequired, route.RelativePath)
+		}
+	}
+
+	for _, route := range routes {
+		holder := *rh
+		holder.RelativePath = route.RelativePath
+		if route.Remark != "" {
+			holder.Remark = route.Remark
+		}
+		if route.BizHandler != nil {
+			holder.BizHandler = route.BizHandler
+		}
+		Get(&holder, route.Config)
+	}
+
+	return nil
+}
+
+// ErrRouteDisabled 路由被DisableRoute关闭时拒绝升级返回的错误码
+var ErrRouteDisabled = dgerr.NewDgError(5011, "该功能暂不可用")
+
+var ErrRouteNotFound = errors.New("websocket route not found")
+
+// RouteStatus 通过Get注册的websocket路由及其开关状态
+type RouteStatus struct {
+	Path     string `json:"path"`
+	Remark   string `json:"remark,omitempty"`
+	Disabled bool   `json:"disabled"`
+	Message  string `json:"message,omitempty"`
+}
+
+type routeState struct {
+	remark   string
+	disabled atomic.Pointer[string]
+}
+
+var wsRoutes sync.Map
+
+// routePath 与gin拼接完整路径的方式一致(保留末尾的/), 结果与c.FullPath()相同
+func routePath(basePath string, relativePath string) string {
+	if relativePath == "" {
+		return basePath
+	}
+	fullPath := path.Join(basePath, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(fullPath, "/") {
+		return fullPath + "/"
+	}
+	return fullPath
+}
+
+// registerRouteState path需与c.FullPath()一致, 见routePath
+func registerRouteState(path string, remark string) {
+	wsRoutes.LoadOrStore(path, &routeState{remark: remark})
+}
+
+// DisableRoute 关闭路由, 之后的升级请求被拒绝, 已建立的连接不受影响; path为路由的完整路径, message为空时使用默认提示
+func DisableRoute(path string, message string) error {
+	v, ok := wsRoutes.Load(path)
+	if !ok {
+		return ErrRouteNotFound
+	}
+	if message == "" {
+		message = ErrRouteDisabled.Message
+	}
+	v.(*routeState).disabled.Store(&message)
+	return nil
+}
+
+func EnableRoute(path string) error {
+	v, ok := wsRoutes.Load(path)
+	if !ok {
+		return ErrRouteNotFound
+	}
+	v.(*routeState).disabled.Store(nil)
+	return nil
+}
+
+// GetRouteStatuses 按路径排序
+func GetRouteStatuses() []*RouteStatus {
+	var statuses []*RouteStatus
+	wsRoutes.Range(func(key, value any) bool {
+		rs := value.(*routeState)
+		status := &RouteStatus{Path: key.(string), Remark: rs.remark}
+		if message := rs.disabled.Load(); message != nil {
+			status.Disabled = true
+			status.Message = *message
+		}
+		statuses = append(statuses, status)
+		return true
+	})
+	slices.SortFunc(statuses, func(a, b *RouteStatus) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return statuses
+}
+
+// rejectDisabledRoute 路由被关闭时以503拒绝升级
+func rejectDisabledRoute(c *gin.Context, path string) bool {
+	v, ok := wsRoutes.Load(path)
+	if !ok {
+		return false
+	}
+	message := v.(*routeState).disabled.Load()
+	if message == nil {
+		return false
+	}
+
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, result.Fail[*result.Void](ErrRouteDisabled.Code, *message))
+	return true
+}
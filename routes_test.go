@@ -0,0 +1,170 @@
+package dgws_test
+
+import (
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-common/result"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDisableRouteAtRuntime(t *testing.T) {
+	engine := gin.New()
+	err := dgws.RegisterRoutes(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/toggle"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+			return nil
+		},
+	}, []*dgws.Route{
+		{RelativePath: "a", Remark: "route a", Config: &dgws.WebSocketHandlerConfig{GetBizIdHandler: func(c *gin.Context) string { return "toggle-a" }}},
+		{RelativePath: "b", Config: &dgws.WebSocketHandlerConfig{GetBizIdHandler: func(c *gin.Context) string { return "toggle-b" }}},
+	})
+	if err != nil {
+		t.Fatalf("register routes: %v", err)
+	}
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	base := "ws" + strings.TrimPrefix(server.URL, "http") + "/toggle/"
+	defer dgws.EnableRoute("/toggle/a")
+
+	existing, _, err := websocket.DefaultDialer.Dial(base+"a", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer existing.Close()
+	waitConnections(t, "toggle-a", 1)
+
+	admin := startAdminServer(t)
+	if rt := adminCall[*result.Void](t, admin+"routes", testAdminToken, &dgws.AdminRouteRequest{Path: "/toggle/missing", Disabled: true}); rt.Success {
+		t.Fatal("expected unknown route to fail")
+	}
+	if rt := adminCall[*result.Void](t, admin+"routes", testAdminToken, &dgws.AdminRouteRequest{Path: "/toggle/a", Disabled: true, Message: "under repair"}); !rt.Success {
+		t.Fatalf("disable route: %+v", rt)
+	}
+	statuses := adminCall[[]*dgws.RouteStatus](t, admin+"routes", testAdminToken, nil)
+	found := false
+	for _, status := range statuses.Data {
+		if status.Path == "/toggle/a" {
+			found = status.Disabled && status.Remark == "route a" && status.Message == "under repair"
+		}
+		if status.Path == "/toggle/b" && status.Disabled {
+			t.Fatalf("route b should stay enabled: %+v", status)
+		}
+	}
+	if !found {
+		t.Fatalf("unexpected route statuses: %+v", statuses.Data)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(base+"a", nil)
+	if err == nil {
+		t.Fatal("expected disabled route to reject upgrade")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	other, _, err := websocket.DefaultDialer.Dial(base+"b", nil)
+	if err != nil {
+		t.Fatalf("dial other route: %v", err)
+	}
+	_ = other.Close()
+	waitConnections(t, "toggle-a", 1)
+
+	if err := dgws.EnableRoute("/toggle/a"); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(base+"a", nil)
+	if err != nil {
+		t.Fatalf("dial after enable: %v", err)
+	}
+	_ = conn.Close()
+	if err := dgws.DisableRoute("/toggle/missing", ""); !errors.Is(err, dgws.ErrRouteNotFound) {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+}
+
+func TestRegisterRoutesRequiresConfig(t *testing.T) {
+	engine := gin.New()
+	err := dgws.RegisterRoutes(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/invalid"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+			return nil
+		},
+	}, []*dgws.Route{
+		{RelativePath: "valid", Config: &dgws.WebSocketHandlerConfig{GetBizIdHandler: func(c *gin.Context) string { return "valid" }}},
+		{RelativePath: "missing"},
+	})
+	if !errors.Is(err, dgws.ErrRouteConfigRequired) {
+		t.Fatalf("expected ErrRouteConfigRequired, got %v", err)
+	}
+	if routes := engine.Routes(); len(routes) != 0 {
+		t.Fatalf("no route should be registered, got %+v", routes)
+	}
+}
+
+func TestDisableRouteTrailingSlash(t *testing.T) {
+	engine := gin.New()
+	group := "/exact-" + uuid.NewString()
+	bizId := func(c *gin.Context) string { return c.Query("bizId") }
+	err := dgws.RegisterRoutes(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group(group),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+			return nil
+		},
+	}, []*dgws.Route{
+		{RelativePath: "plain", Config: &dgws.WebSocketHandlerConfig{GetBizIdHandler: bizId}},
+		{RelativePath: "slash/", Config: &dgws.WebSocketHandlerConfig{GetBizIdHandler: bizId}},
+		{RelativePath: "other/", Config: &dgws.WebSocketHandlerConfig{GetBizIdHandler: bizId}},
+	})
+	if err != nil {
+		t.Fatalf("register routes: %v", err)
+	}
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	base := "ws" + strings.TrimPrefix(server.URL, "http") + group + "/"
+
+	// 路由按gin的完整路径登记, 保留末尾的/
+	if err := dgws.DisableRoute(group+"/slash", ""); !errors.Is(err, dgws.ErrRouteNotFound) {
+		t.Fatalf("expected ErrRouteNotFound without trailing slash, got %v", err)
+	}
+	if err := dgws.DisableRoute(group+"/slash/", ""); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	defer dgws.EnableRoute(group + "/slash/")
+
+	_, resp, err := websocket.DefaultDialer.Dial(base+"slash/", nil)
+	if err == nil {
+		t.Fatal("expected disabled route to reject upgrade")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	for _, route := range []string{"plain", "other/"} {
+		conn, _, err := websocket.DefaultDialer.Dial(base+route+"?bizId=exact", nil)
+		if err != nil {
+			t.Fatalf("dial %s: %v", route, err)
+		}
+		_ = conn.Close()
+	}
+
+	disabled := map[string]bool{}
+	for _, status := range dgws.GetRouteStatuses() {
+		if strings.HasPrefix(status.Path, group) {
+			disabled[status.Path] = status.Disabled
+		}
+	}
+	if len(disabled) != 3 || !disabled[group+"/slash/"] || disabled[group+"/plain"] || disabled[group+"/other/"] {
+		t.Fatalf("unexpected route statuses: %v", disabled)
+	}
+}
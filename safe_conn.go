@@ -0,0 +1,76 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/gorilla/websocket"
+	"io"
+	"time"
+)
+
+// SafeConn 与Connection共用写锁的websocket.Conn, 读取等其他方法直接使用内嵌的Conn.
+// 直接通过GetConn或WebSocketMessage.Connection写入会与广播、推送、ack等框架内的写入并发, gorilla不允许这种用法,
+// 在BizHandler或其派生的goroutine中回写消息时应使用SafeConn
+type SafeConn struct {
+	*websocket.Conn
+	connection *Connection
+}
+
+// GetSafeConn 连接尚未注册(如StartHandler中)或不是websocket连接(SSE、Transport)时返回nil
+func GetSafeConn(ctx *dgctx.DgContext) *SafeConn {
+	connection := GetConnection(ctx)
+	if connection == nil || connection.Conn == nil {
+		return nil
+	}
+	return &SafeConn{Conn: connection.Conn, connection: connection}
+}
+
+// WriteMessage 与Connection.WriteMessage相同, 经过压缩统计、WriteWait和journal
+func (c *SafeConn) WriteMessage(mt int, data []byte) error {
+	return c.connection.WriteMessage(mt, data)
+}
+
+func (c *SafeConn) WritePreparedMessage(pm *websocket.PreparedMessage) error {
+	return c.connection.WritePreparedMessage(pm)
+}
+
+func (c *SafeConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.connection.WriteMessage(websocket.TextMessage, data)
+}
+
+// WriteControl gorilla允许控制帧与数据帧并发写出, 这里仍然加锁, 保证与其他写入的先后顺序
+func (c *SafeConn) WriteControl(mt int, data []byte, deadline time.Time) error {
+	c.connection.lockWrite()
+	defer c.connection.unlockWrite()
+	return c.Conn.WriteControl(mt, data, deadline)
+}
+
+// NextWriter 返回的writer关闭前一直持有写锁, 其他写入会等待
+func (c *SafeConn) NextWriter(mt int) (io.WriteCloser, error) {
+	c.connection.lockWrite()
+	w, err := c.Conn.NextWriter(mt)
+	if err != nil {
+		c.connection.unlockWrite()
+		return nil, err
+	}
+	return &lockedWriter{WriteCloser: w, unlock: c.connection.unlockWrite}, nil
+}
+
+type lockedWriter struct {
+	io.WriteCloser
+	unlock func()
+	closed bool
+}
+
+func (w *lockedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.unlock()
+	return w.WriteCloser.Close()
+}
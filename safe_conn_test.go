@@ -0,0 +1,61 @@
+package dgws_test
+
+import (
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSafeConnConcurrentWrites 在-race下运行, BizHandler派生的goroutine与广播并发写同一连接
+func TestSafeConnConcurrentWrites(t *testing.T) {
+	const writers, perWriter = 4, 20
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{}, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		conn := dgws.GetSafeConn(ctx)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < perWriter; j++ {
+					if j%2 == 0 {
+						_ = conn.WriteJSON(map[string]int{"writer": i, "seq": j})
+						continue
+					}
+					w, err := conn.NextWriter(websocket.TextMessage)
+					if err != nil {
+						return
+					}
+					_, _ = fmt.Fprintf(w, `{"writer":%d,"seq":%d}`, i, j)
+					_ = w.Close()
+				}
+			}(i)
+		}
+		for j := 0; j < perWriter; j++ {
+			_, _ = dgws.BroadcastToBizIds("bizId", []string{"safe-1"}, websocket.TextMessage, []byte(`{"broadcast":true}`))
+		}
+		wg.Wait()
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=safe-1", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("go")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < writers*perWriter+perWriter; i++ {
+		var msg map[string]any
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read message %d: %v", i, err)
+		}
+	}
+}
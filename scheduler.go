@@ -0,0 +1,211 @@
+package dgws
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaderLeaseTtl   = 15 * time.Second
+	leaderRenewDivisor      = 3
+	schedulerStopTimeout    = 5 * time.Second
+	scheduledJobMinInterval = 10 * time.Millisecond
+)
+
+// LeaderElector 按key选主, TryAcquire同时用于抢占和续期, 持有者在ttl内未续期则由其他实例接管; Redis实现见redisstore子包
+type LeaderElector interface {
+	TryAcquire(ctx context.Context, key string, holder string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key string, holder string) error
+}
+
+// MemoryLeaderElector 进程内的租约锁, 仅用于测试或单机场景
+type MemoryLeaderElector struct {
+	lock   sync.Mutex
+	leases map[string]*memoryLeaderLease
+}
+
+type memoryLeaderLease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+func NewMemoryLeaderElector() *MemoryLeaderElector {
+	return &MemoryLeaderElector{leases: make(map[string]*memoryLeaderLease)}
+}
+
+func (e *MemoryLeaderElector) TryAcquire(_ context.Context, key string, holder string, ttl time.Duration) (bool, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	now := time.Now()
+	if lease := e.leases[key]; lease != nil && lease.holder != holder && lease.expiresAt.After(now) {
+		return false, nil
+	}
+	e.leases[key] = &memoryLeaderLease{holder: holder, expiresAt: now.Add(ttl)}
+
+	return true, nil
+}
+
+func (e *MemoryLeaderElector) Release(_ context.Context, key string, holder string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if lease := e.leases[key]; lease != nil && lease.holder == holder {
+		delete(e.leases, key)
+	}
+	return nil
+}
+
+// ScheduledJob 每Interval执行一次, 整个集群中只有该任务的leader实例执行
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx *dgctx.DgContext) error
+}
+
+// BroadcastJob 定时构造消息并通过Broadcast发送, 开启InitCluster时由leader实例投递到所有实例的连接
+func BroadcastJob(name string, interval time.Duration, build func(ctx *dgctx.DgContext) ([]byte, error)) *ScheduledJob {
+	return &ScheduledJob{
+		Name:     name,
+		Interval: interval,
+		Run: func(ctx *dgctx.DgContext) error {
+			data, err := build(ctx)
+			if err != nil || data == nil {
+				return err
+			}
+			_, err = Broadcast(websocket.TextMessage, data)
+			return err
+		},
+	}
+}
+
+// Scheduler 每个任务独立选主, 不同任务可由不同实例执行; leader切换期间可能少执行或多执行一次
+type Scheduler struct {
+	ctx      *dgctx.DgContext
+	elector  LeaderElector
+	holder   string
+	leaseTtl time.Duration
+	lock     sync.Mutex
+	jobs     map[string]*scheduledJobState
+	wg       sync.WaitGroup
+	stopped  bool
+}
+
+type scheduledJobState struct {
+	job    *ScheduledJob
+	leader bool
+	stop   chan struct{}
+}
+
+// NewScheduler leaseTtl默认15秒, 每leaseTtl/3续期一次
+func NewScheduler(ctx *dgctx.DgContext, elector LeaderElector, leaseTtl time.Duration) *Scheduler {
+	if leaseTtl <= 0 {
+		leaseTtl = defaultLeaderLeaseTtl
+	}
+	return &Scheduler{ctx: ctx, elector: elector, holder: uuid.NewString(), leaseTtl: leaseTtl, jobs: make(map[string]*scheduledJobState)}
+}
+
+// Add 添加后立即开始参与选主, 同名任务只能添加一次
+func (s *Scheduler) Add(job *ScheduledJob) error {
+	if job == nil || job.Name == "" || job.Run == nil || job.Interval < scheduledJobMinInterval {
+		return errors.New("websocket scheduled job requires name, run and interval")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return errors.New("websocket scheduler stopped")
+	}
+	if s.jobs[job.Name] != nil {
+		return errors.New("websocket scheduled job already exists: " + job.Name)
+	}
+	state := &scheduledJobState{job: job, stop: make(chan struct{})}
+	s.jobs[job.Name] = state
+	s.wg.Add(1)
+	go s.run(state)
+
+	return nil
+}
+
+// IsLeader 本实例当前是否负责执行该任务
+func (s *Scheduler) IsLeader(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state := s.jobs[name]
+	return state != nil && state.leader
+}
+
+// Stop 停止所有任务并释放持有的leader, 其他实例随即可以接管
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		return
+	}
+	s.stopped = true
+	for _, state := range s.jobs {
+		close(state.stop)
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(state *scheduledJobState) {
+	defer s.wg.Done()
+	renew := time.NewTicker(s.leaseTtl / leaderRenewDivisor)
+	defer renew.Stop()
+	tick := time.NewTicker(state.job.Interval)
+	defer tick.Stop()
+
+	s.elect(state)
+	for {
+		select {
+		case <-state.stop:
+			if s.isLeader(state) {
+				ctx, cancel := context.WithTimeout(context.Background(), schedulerStopTimeout)
+				if err := s.elector.Release(ctx, state.job.Name, s.holder); err != nil {
+					dglogger.Warnf(s.ctx, "release scheduled job %s leader error: %v", state.job.Name, err)
+				}
+				cancel()
+			}
+			return
+		case <-renew.C:
+			s.elect(state)
+		case <-tick.C:
+			if !s.isLeader(state) {
+				continue
+			}
+			if err := state.job.Run(s.ctx); err != nil {
+				dglogger.Warnf(s.ctx, "run scheduled job %s error: %v", state.job.Name, err)
+			}
+		}
+	}
+}
+
+// elect 抢占或续期, Redis不可用时放弃leader, 避免网络分区时多个实例同时执行
+func (s *Scheduler) elect(state *scheduledJobState) {
+	ok, err := s.elector.TryAcquire(innerContext(s.ctx), state.job.Name, s.holder, s.leaseTtl)
+	if err != nil {
+		dglogger.Warnf(s.ctx, "elect scheduled job %s leader error: %v", state.job.Name, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	leader := ok && err == nil
+	if leader != state.leader {
+		dglogger.Infof(s.ctx, "scheduled job %s leader changed: %v", state.job.Name, leader)
+	}
+	state.leader = leader
+}
+
+func (s *Scheduler) isLeader(state *scheduledJobState) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return state.leader
+}
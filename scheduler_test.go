@@ -0,0 +1,81 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerSingleLeader(t *testing.T) {
+	elector := dgws.NewMemoryLeaderElector()
+
+	var runs [2]atomic.Int32
+	schedulers := make([]*dgws.Scheduler, 2)
+	for i := range schedulers {
+		schedulers[i] = dgws.NewScheduler(&dgctx.DgContext{TraceId: uuid.NewString()}, elector, 150*time.Millisecond)
+		counter := &runs[i]
+		if err := schedulers[i].Add(&dgws.ScheduledJob{Name: "tick", Interval: 20 * time.Millisecond, Run: func(_ *dgctx.DgContext) error {
+			counter.Add(1)
+			return nil
+		}}); err != nil {
+			t.Fatalf("add job: %v", err)
+		}
+	}
+	defer schedulers[1].Stop()
+
+	time.Sleep(300 * time.Millisecond)
+	if (runs[0].Load() > 0) == (runs[1].Load() > 0) {
+		t.Fatalf("expected exactly one instance to run the job: %d, %d", runs[0].Load(), runs[1].Load())
+	}
+	if schedulers[0].IsLeader("tick") == schedulers[1].IsLeader("tick") {
+		t.Fatal("expected exactly one leader")
+	}
+
+	leader, follower := 0, 1
+	if schedulers[1].IsLeader("tick") {
+		leader, follower = 1, 0
+	}
+	schedulers[leader].Stop()
+	before := runs[follower].Load()
+	deadline := time.Now().Add(3 * time.Second)
+	for runs[follower].Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("follower did not take over after leader stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	schedulers[0].Stop()
+}
+
+func TestBroadcastJob(t *testing.T) {
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=scheduled", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitConnections(t, "scheduled", 1)
+
+	scheduler := dgws.NewScheduler(&dgctx.DgContext{TraceId: uuid.NewString()}, dgws.NewMemoryLeaderElector(), 0)
+	defer scheduler.Stop()
+	if err := scheduler.Add(dgws.BroadcastJob("announce", 20*time.Millisecond, func(_ *dgctx.DgContext) ([]byte, error) {
+		return []byte("announcement"), nil
+	})); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	if err := scheduler.Add(&dgws.ScheduledJob{Name: "announce", Interval: time.Second, Run: func(*dgctx.DgContext) error { return nil }}); err == nil {
+		t.Fatal("duplicate job name should be rejected")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "announcement" {
+		t.Fatalf("read: %s, %v", string(data), err)
+	}
+}
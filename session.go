@@ -0,0 +1,245 @@
+package dgws
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
+const defaultSessionGraceWindow = 30 * time.Second
+
+// SessionOptions 会话恢复, 连接建立时下发session消息携带恢复token, 客户端在GraceWindow内带上ResumeTokenHeader重连即恢复会话,
+// 断开期间未确认的SendReliable消息会按序号重放给新连接, SetSessionValue保存的状态继续可用;
+// 异常断开时EndCallbackHandler推迟到GraceWindow结束仍未恢复时才回调, 正常关闭时会话立即结束
+type SessionOptions struct {
+	GraceWindow time.Duration
+	// Store 不为nil时未确认的消息同时写入该存储, 进程重启后按恢复token从中重建会话
+	Store ReplayStore
+	// RetainMessages 每个会话保留最近发送的消息数(包括已确认的), 用于响应客户端的replay请求
+	RetainMessages int
+	// ReplayBufferSize 会话最多保留的未确认消息数, 超过后SendReliable返回ErrReplayBufferFull, 0表示不限制
+	ReplayBufferSize int
+}
+
+type serverSession struct {
+	token       string
+	userId      int64
+	bizKey      string
+	bizId       string
+	grace       time.Duration
+	acks        *ackTracker
+	expireTimer *time.Timer
+	generation  int
+	values      sync.Map
+	// endCallback 异常断开后推迟执行的EndCallbackHandler, 恢复后丢弃; endNow为true时断开后立即结束会话
+	endCallback func()
+	endNow      bool
+	// revoked 被Kick的会话不能再恢复
+	revoked bool
+}
+
+var (
+	sessions     = make(map[string]*serverSession)
+	sessionsLock sync.Mutex
+)
+
+// resumeSession token对应的会话存在且属于同一用户和业务时恢复, 否则创建新会话
+func resumeSession(ctx *dgctx.DgContext, token string, bizKey string, bizId string, conf *WebSocketHandlerConfig) (*serverSession, bool) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	if s := sessions[token]; s != nil && !s.revoked && s.userId == ctx.UserId && s.bizKey == bizKey && s.bizId == bizId {
+		if s.expireTimer != nil {
+			s.expireTimer.Stop()
+			s.expireTimer = nil
+		}
+		s.generation++
+		s.endCallback = nil
+		s.endNow = false
+		return s, true
+	}
+
+	if token != "" && conf.Session.Store != nil {
+		if s := restoreSession(ctx, token, bizKey, bizId, conf); s != nil {
+			sessions[s.token] = s
+			return s, true
+		}
+	}
+
+	s := newServerSession(ctx, uuid.NewString(), bizKey, bizId, conf)
+	sessions[s.token] = s
+
+	return s, false
+}
+
+func newServerSession(ctx *dgctx.DgContext, token string, bizKey string, bizId string, conf *WebSocketHandlerConfig) *serverSession {
+	ackOptions := conf.Ack
+	if ackOptions == nil {
+		ackOptions = &AckOptions{}
+	}
+	grace := conf.Session.GraceWindow
+	if grace <= 0 {
+		grace = defaultSessionGraceWindow
+	}
+	s := &serverSession{
+		token:  token,
+		userId: ctx.UserId,
+		bizKey: bizKey,
+		bizId:  bizId,
+		grace:  grace,
+		acks:   newAckTracker(ackOptions, conf.Session.ReplayBufferSize),
+	}
+	s.acks.session = s
+	s.acks.store = conf.Session.Store
+	s.acks.retain = conf.Session.RetainMessages
+
+	return s
+}
+
+// restoreSession 进程内没有该会话时从ReplayStore重建, 存储中没有消息或归属不一致时返回nil
+func restoreSession(ctx *dgctx.DgContext, token string, bizKey string, bizId string, conf *WebSocketHandlerConfig) *serverSession {
+	owner, envs, err := conf.Session.Store.Load(ctx, token)
+	if err != nil {
+		dglogger.Errorf(ctx, "[%s: %s] load replay messages error: %v", bizKey, bizId, err)
+		return nil
+	}
+	if owner == nil || len(envs) == 0 || *owner != (ReplayOwner{UserId: ctx.UserId, BizKey: bizKey, BizId: bizId}) {
+		return nil
+	}
+
+	s := newServerSession(ctx, token, bizKey, bizId, conf)
+	if err := s.acks.restore(envs); err != nil {
+		dglogger.Errorf(ctx, "[%s: %s] restore replay messages error: %v", bizKey, bizId, err)
+		return nil
+	}
+
+	return s
+}
+
+func (s *serverSession) owner() *ReplayOwner {
+	return &ReplayOwner{UserId: s.userId, BizKey: s.bizKey, BizId: s.bizId}
+}
+
+// detached 连接断开后开始计时, 超过GraceWindow未恢复则释放会话并回调未确认的消息
+func (s *serverSession) detached() {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	s.generation++
+	generation := s.generation
+	grace := s.grace
+	if s.endNow || s.revoked {
+		grace = 0
+	}
+	s.expireTimer = time.AfterFunc(grace, func() {
+		s.expire(generation)
+	})
+}
+
+// deferEnd 连接异常断开, 会话过期时才执行endCallback; 期间会话被其他连接接管时不记录
+func (s *serverSession) deferEnd(c *Connection, endCallback func()) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	if s.acks.lastConn() == c {
+		s.endCallback = endCallback
+	}
+}
+
+// endOnDetach 客户端正常关闭, 不再等待恢复
+func (s *serverSession) endOnDetach(c *Connection) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	if s.acks.lastConn() == c {
+		s.endNow = true
+	}
+}
+
+// revokeSessions 选中的会话不能再恢复, 已断开等待恢复的立即结束, 连接中的在断开后立即结束
+func revokeSessions(match func(s *serverSession) bool) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	for _, s := range sessions {
+		if s.revoked || !match(s) {
+			continue
+		}
+		s.revoked = true
+		if s.expireTimer != nil && s.expireTimer.Stop() {
+			generation := s.generation
+			s.expireTimer = time.AfterFunc(0, func() {
+				s.expire(generation)
+			})
+		}
+	}
+}
+
+func (s *serverSession) expire(generation int) {
+	sessionsLock.Lock()
+	if s.generation != generation || sessions[s.token] != s {
+		sessionsLock.Unlock()
+		return
+	}
+	delete(sessions, s.token)
+	endCallback := s.endCallback
+	sessionsLock.Unlock()
+
+	s.acks.close()
+	if endCallback != nil {
+		endCallback()
+	}
+	if store := s.acks.store; store != nil {
+		ctx := s.acks.lastConn().Ctx
+		if err := store.Delete(ctx, s.token); err != nil {
+			dglogger.Warnf(ctx, "[%s: %s] delete replay messages error: %v", s.bizKey, s.bizId, err)
+		}
+	}
+}
+
+func writeSession(c *Connection, token string, resumed bool) error {
+	data, err := json.Marshal(&SessionInfo{Token: token, Resumed: resumed})
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&Envelope{Type: EnvelopeTypeSession, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return c.WriteMessage(websocket.TextMessage, msg)
+}
+
+// isNormalClose 客户端主动发送了正常关闭帧
+func isNormalClose(err error) bool {
+	return websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// SetSessionValue 保存会话级状态, 会话恢复后在新连接的ctx中仍可读取; 未开启会话时保存到当前连接
+func SetSessionValue(ctx *dgctx.DgContext, key string, value any) {
+	if s := getSession(ctx); s != nil {
+		s.values.Store(key, value)
+		return
+	}
+	GetConnState(ctx).values.Store(key, value)
+}
+
+func GetSessionValue(ctx *dgctx.DgContext, key string) any {
+	var value any
+	if s := getSession(ctx); s != nil {
+		value, _ = s.values.Load(key)
+	} else {
+		value, _ = GetConnState(ctx).values.Load(key)
+	}
+
+	return value
+}
+
+func getSession(ctx *dgctx.DgContext) *serverSession {
+	if c := GetConnection(ctx); c != nil && c.acks != nil {
+		return c.acks.session
+	}
+	return nil
+}
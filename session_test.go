@@ -0,0 +1,272 @@
+package dgws_test
+
+import (
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func readEnvelope(t *testing.T, conn *websocket.Conn) *dgws.Envelope {
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	env, ok := dgws.ParseEnvelope(data)
+	if !ok {
+		t.Fatalf("not an envelope: %s", string(data))
+	}
+
+	return env
+}
+
+func readSession(t *testing.T, conn *websocket.Conn) *dgws.SessionInfo {
+	env := readEnvelope(t, conn)
+	if env.Type != dgws.EnvelopeTypeSession {
+		t.Fatalf("expected session envelope, got %s", env.Type)
+	}
+	info := &dgws.SessionInfo{}
+	if err := json.Unmarshal(env.Data, info); err != nil {
+		t.Fatalf("unmarshal session: %v", err)
+	}
+
+	return info
+}
+
+func TestSessionResumeReplay(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Ack:     &dgws.AckOptions{AckTimeout: time.Second},
+		Session: &dgws.SessionOptions{GraceWindow: 2 * time.Second},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		_, err := dgws.GetConnection(ctx).SendReliable(&testData{Content: "notify"})
+		return err
+	})
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	info := readSession(t, first)
+	if info.Token == "" || info.Resumed {
+		t.Fatalf("unexpected new session: %+v", info)
+	}
+	if err := first.WriteMessage(websocket.TextMessage, []byte("push")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	sent := readEnvelope(t, first)
+	_ = first.Close()
+
+	header := http.Header{}
+	header.Set(dgws.ResumeTokenHeader, info.Token)
+	second, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	defer second.Close()
+	resumed := readSession(t, second)
+	if !resumed.Resumed || resumed.Token != info.Token {
+		t.Fatalf("session not resumed: %+v", resumed)
+	}
+
+	replayed := readEnvelope(t, second)
+	if replayed.Type != dgws.EnvelopeTypeMessage || replayed.Id != sent.Id || replayed.Seq != sent.Seq {
+		t.Fatalf("unexpected replay: %+v, sent: %+v", replayed, sent)
+	}
+
+	header.Set(dgws.ResumeTokenHeader, "unknown")
+	third, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial unknown token: %v", err)
+	}
+	defer third.Close()
+	if info := readSession(t, third); info.Resumed {
+		t.Fatal("unknown token should start a new session")
+	}
+}
+
+func TestSessionRestoreFromStore(t *testing.T) {
+	store := dgws.NewMemoryReplayStore(time.Minute, 10)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Session: &dgws.SessionOptions{GraceWindow: time.Minute, Store: store},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		_, err := dgws.GetConnection(ctx).SendReliable(&testData{Content: "notify"})
+		return err
+	})
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	info := readSession(t, first)
+	if err := first.WriteMessage(websocket.TextMessage, []byte("push")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	sent := readEnvelope(t, first)
+	_ = first.Close()
+	time.Sleep(100 * time.Millisecond)
+	dgws.ResetSessions()
+
+	header := http.Header{}
+	header.Set(dgws.ResumeTokenHeader, info.Token)
+	second, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	defer second.Close()
+	if resumed := readSession(t, second); !resumed.Resumed {
+		t.Fatal("session not restored from store")
+	}
+	replayed := readEnvelope(t, second)
+	if replayed.Id != sent.Id || replayed.Seq != sent.Seq {
+		t.Fatalf("unexpected replay: %+v, sent: %+v", replayed, sent)
+	}
+
+	ack, _ := json.Marshal(&dgws.Envelope{Type: dgws.EnvelopeTypeAck, Id: replayed.Id})
+	if err := second.WriteMessage(websocket.TextMessage, ack); err != nil {
+		t.Fatalf("write ack: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, envs, _ := store.Load(nil, info.Token)
+		if len(envs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("acked message still stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientRequestReplay(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Session: &dgws.SessionOptions{RetainMessages: 10},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		_, err := dgws.GetConnection(ctx).SendReliable(&testData{Content: string(wsm.MessageData)})
+		return err
+	})
+
+	received := make(chan string, 10)
+	client := newTestClient(t, &dgws.ClientConfig{
+		Url: url,
+		MessageHandler: func(_ *dgctx.DgContext, _ int, data []byte) error {
+			msg := &testData{}
+			_ = json.Unmarshal(data, msg)
+			received <- msg.Content
+			return nil
+		},
+	})
+	client.Start()
+	defer client.Close()
+	waitState(t, client, dgws.ClientStateConnected)
+
+	expect := func(contents ...string) {
+		for _, content := range contents {
+			select {
+			case msg := <-received:
+				if msg != content {
+					t.Fatalf("expected %s, got %s", content, msg)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("message %s not received", content)
+			}
+		}
+	}
+
+	for _, content := range []string{"m1", "m2", "m3"} {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(content)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		expect(content)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for client.LastSeq() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected last seq: %d", client.LastSeq())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := client.RequestReplay(2); err != nil {
+		t.Fatalf("request replay: %v", err)
+	}
+	expect("m2", "m3")
+}
+
+func TestSessionGraceDefersEndCallback(t *testing.T) {
+	ended := make(chan struct{}, 10)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		Session: &dgws.SessionOptions{GraceWindow: 300 * time.Millisecond},
+		EndCallbackHandler: func(_ *dgctx.DgContext, _ *websocket.Conn) error {
+			ended <- struct{}{}
+			return nil
+		},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "set" {
+			dgws.SetSessionValue(ctx, "room", "r1")
+			return nil
+		}
+		room, _ := dgws.GetSessionValue(ctx, "room").(string)
+		return dgws.GetConnection(ctx).WriteMessage(websocket.TextMessage, []byte(room))
+	})
+	expectEnded := func(within time.Duration, expected bool) {
+		select {
+		case <-ended:
+			if !expected {
+				t.Fatal("end callback fired during grace window")
+			}
+		case <-time.After(within):
+			if expected {
+				t.Fatal("end callback not fired")
+			}
+		}
+	}
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	info := readSession(t, first)
+	if err := first.WriteMessage(websocket.TextMessage, []byte("set")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = first.NetConn().Close()
+	expectEnded(100*time.Millisecond, false)
+
+	header := http.Header{}
+	header.Set(dgws.ResumeTokenHeader, info.Token)
+	second, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("redial: %v", err)
+	}
+	if !readSession(t, second).Resumed {
+		t.Fatal("session not resumed")
+	}
+	if err := second.WriteMessage(websocket.TextMessage, []byte("get")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = second.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := second.ReadMessage(); err != nil || string(data) != "r1" {
+		t.Fatalf("session value lost: %s, %v", string(data), err)
+	}
+	expectEnded(500*time.Millisecond, false)
+
+	_ = second.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	expectEnded(200*time.Millisecond, true)
+	_ = second.Close()
+
+	third, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	readSession(t, third)
+	_ = third.NetConn().Close()
+	expectEnded(100*time.Millisecond, false)
+	expectEnded(time.Second, true)
+}
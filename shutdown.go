@@ -0,0 +1,61 @@
+package dgws
+
+import (
+	"context"
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/result"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown Shutdown之后拒绝升级时返回的错误码
+var ErrShuttingDown = dgerr.NewDgError(5012, "服务正在停止")
+
+const (
+	shutdownReason       = "server shutdown"
+	shutdownPollInterval = 20 * time.Millisecond
+)
+
+var shuttingDown atomic.Bool
+
+// Shutdown 进入drain状态并停止接受新连接, 向本进程的所有连接发送1001(Going Away)的close帧, 等待客户端回应后读循环结束、
+// 处理中的BizHandler返回、连接注销; 之后才注册的连接同样以1001关闭, 直到ActiveConnections归零.
+// ctx到期时直接关闭剩余的连接并返回ctx.Err(). 需在http服务关闭前调用
+func Shutdown(ctx context.Context) error {
+	if !IsDraining() {
+		EnterDrainMode(nil)
+	}
+	shuttingDown.Store(true)
+	RangeConnections(func(c *Connection) bool {
+		c.closeGracefully(websocket.CloseGoingAway, shutdownReason)
+		return true
+	})
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for ActiveConnections() > 0 {
+		select {
+		case <-ctx.Done():
+			RangeConnections(func(c *Connection) bool {
+				c.closeWith(websocket.CloseGoingAway, shutdownReason)
+				return true
+			})
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// rejectShutdown Shutdown之后以503拒绝升级
+func rejectShutdown(c *gin.Context) bool {
+	if !shuttingDown.Load() {
+		return false
+	}
+
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, result.Fail[*result.Void](ErrShuttingDown.Code, ErrShuttingDown.Message))
+	return true
+}
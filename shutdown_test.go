@@ -0,0 +1,150 @@
+package dgws_test
+
+import (
+	"context"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Cleanup(dgws.ResetShutdown)
+	var handled atomic.Bool
+	started := make(chan struct{})
+	url := startTestServer(t, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		if string(wsm.MessageData) == "slow" {
+			close(started)
+			time.Sleep(300 * time.Millisecond)
+			handled.Store(true)
+		}
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=shutdown", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("slow")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	<-started
+
+	closed := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := dgws.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if !handled.Load() {
+		t.Fatal("shutdown returned before in-flight handler finished")
+	}
+	if dgws.ConnectionCount() != 0 {
+		t.Fatalf("connections left: %d", dgws.ConnectionCount())
+	}
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected going away, got %v", err)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?bizId=late", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after shutdown, got %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	t.Cleanup(dgws.ResetShutdown)
+	url := startTestServer(t, func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	// 客户端不读取, 不会回应close帧
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=stuck", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for dgws.ConnectionCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := dgws.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	for dgws.ConnectionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dgws.ConnectionCount() != 0 {
+		t.Fatal("connection not force closed")
+	}
+}
+
+func TestShutdownWaitsForStartingConnections(t *testing.T) {
+	t.Cleanup(dgws.ResetShutdown)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		StartHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *websocket.Conn) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		},
+	}, func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	waitActiveConnections(t, 0)
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=starting", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	<-started
+	closed := make(chan error, 1)
+	go func() {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- dgws.Shutdown(ctx)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned while a connection was still starting: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !dgws.IsDraining() {
+		t.Fatal("shutdown should enter drain mode")
+	}
+
+	// StartHandler返回后才注册的连接同样收到1001
+	close(release)
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected going away, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if dgws.ActiveConnections() != 0 {
+		t.Fatalf("active connections left: %d", dgws.ActiveConnections())
+	}
+}
@@ -0,0 +1,280 @@
+package dgws
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/result"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/utils"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// SSEConnectionIdParam 上行POST请求通过该query参数指定事件流, 取值为open事件中的connectionId
+	SSEConnectionIdParam = "connectionId"
+	SSEEventOpen         = "open"
+	SSEEventBinary       = "binary"
+	SSEEventClose        = "close"
+	SSEEventError        = "error"
+
+	defaultSSEKeepAlive = 15 * time.Second
+)
+
+var ErrSSEMessageType = errors.New("only text and binary messages are supported by sse connection")
+
+type SSEOpenEvent struct {
+	ConnectionId string `json:"connectionId"`
+}
+
+type SSECloseEvent struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// sseStream 实现messageWriter, lock保护closed和写入, 请求处理返回后不能再写ResponseWriter
+type sseStream struct {
+	w         gin.ResponseWriter
+	writeWait time.Duration
+	lock      sync.Mutex
+	closed    bool
+	done      chan struct{}
+	doneOnce  sync.Once
+}
+
+// WriteMessage 文本消息作为默认的message事件, 多行文本按SSE规范拆成多个data行, 客户端收到的换行统一为\n;
+// 二进制消息以base64编码后作为binary事件
+func (s *sseStream) WriteMessage(mt int, data []byte) error {
+	switch mt {
+	case websocket.TextMessage:
+		return s.writeEvent("", data)
+	case websocket.BinaryMessage:
+		return s.writeEvent(SSEEventBinary, []byte(base64.StdEncoding.EncodeToString(data)))
+	default:
+		return ErrSSEMessageType
+	}
+}
+
+// Close 对应websocket的close帧, 发送close事件后结束事件流
+func (s *sseStream) Close(code int, reason string) error {
+	data, _ := json.Marshal(&SSECloseEvent{Code: code, Reason: reason})
+	err := s.writeEvent(SSEEventClose, data)
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	s.finish()
+	return err
+}
+
+func (s *sseStream) writeEvent(event string, data []byte) error {
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return s.writeRaw(buf.Bytes())
+}
+
+func (s *sseStream) writeRaw(p []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrConnectionClosed
+	}
+	if s.writeWait > 0 {
+		rc := http.NewResponseController(s.w)
+		_ = rc.SetWriteDeadline(time.Now().Add(s.writeWait))
+		defer rc.SetWriteDeadline(time.Time{})
+	}
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+// finish 结束事件流, GetSSE的请求处理随之返回
+func (s *sseStream) finish() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// stop 请求处理返回前调用, 之后的写入返回ErrConnectionClosed
+func (s *sseStream) stop() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+}
+
+// IsSSE 连接是否通过GetSSE建立
+func (c *Connection) IsSSE() bool {
+	_, ok := c.writer.(*sseStream)
+	return ok
+}
+
+// GetSSE 为无法使用websocket的网络(代理或防火墙拦截升级请求)注册降级路由, 与Get共用BizHandler和连接注册表:
+// GET建立事件流, 第一个open事件携带connectionId, 之后的下行消息(含广播)以SSE事件推送;
+// 上行消息以POST请求体发送到同一路径并带上SSEConnectionIdParam, Content-Type为application/octet-stream时作为二进制消息.
+// 只支持conf中的BizKey、GetBizIdHandler、StartHandler、EndCallbackHandler、StateChangeHandler、PingPeriod(keepalive注释的间隔,
+// 默认15s)、WriteWait、MaxMessageSize(上行请求体大小)、Retry、Redirect和OnPanic; StartHandler和EndCallbackHandler的conn参数以及
+// WebSocketMessage.Connection为nil, BizHandler需通过GetConnection(ctx)回写消息
+func GetSSE(rh *wrapper.RequestHolder[WebSocketMessage, error], conf *WebSocketHandlerConfig) {
+	if conf.StartHandler == nil {
+		conf.StartHandler = DefaultStartHandler
+	}
+
+	streamHandler := func(c *gin.Context) {
+		d := conf.resolveDefaults()
+		adm, ok := admitConnection(c, conf, d)
+		if !ok {
+			return
+		}
+		defer adm.release()
+		path := c.FullPath()
+		ctx := utils.GetDgContext(c)
+		bizKey := adm.bizKey
+		bizId := adm.bizId
+		if adm.redirect != "" {
+			dglogger.Infof(ctx, "[%s: %s] redirect to %s", bizKey, bizId, adm.redirect)
+			redirectHTTP(c, adm.redirect)
+			return
+		}
+		if ctx.RemoteIp == "" {
+			ctx.RemoteIp = c.ClientIP()
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		stream := &sseStream{w: c.Writer, writeWait: d.WriteWait, done: make(chan struct{})}
+		state := GetConnState(ctx)
+		state.onStateChange = func(from ConnectionState, to ConnectionState) {
+			if conf.StateChangeHandler != nil {
+				conf.StateChangeHandler(ctx, from, to)
+			}
+		}
+		defer state.transition(ConnectionStateClosed)
+		defer state.initContext(c.Request.Context())()
+
+		if err := conf.StartHandler(c, ctx, nil); err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] start sse error: %v", bizKey, bizId, err)
+			var rtBytes []byte
+			var dgError *dgerr.DgError
+			if errors.As(err, &dgError) {
+				rtBytes, _ = json.Marshal(result.FailByError[*dgerr.DgError](dgError))
+			} else {
+				rtBytes, _ = json.Marshal(result.SimpleFail[string](err.Error()))
+			}
+			_ = stream.writeEvent(SSEEventError, rtBytes)
+			return
+		}
+
+		defer stream.stop()
+		connection := registerConnection(ctx, nil, stream, path, bizKey, bizId, d.WriteWait, nil, nil, nil)
+		defer unregisterConnection(connection)
+		openData, _ := json.Marshal(&SSEOpenEvent{ConnectionId: connection.Id})
+		if err := stream.writeEvent(SSEEventOpen, openData); err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] write sse open event error: %v", bizKey, bizId, err)
+			return
+		}
+		state.transition(ConnectionStateOpen)
+
+		dispatcher := newSerialDispatcher(defaultMaxPendingPerConn)
+		bizHandlerFunc := rh.BizHandler
+		if conf.Retry != nil {
+			bizHandlerFunc = withRetry(conf.Retry, bizKey, bizId, bizHandlerFunc)
+		}
+		bizHandlerFunc = withRecover(conf.OnPanic, bizKey, bizId, bizHandlerFunc)
+		bizHandlerFunc = withTrace(path, bizKey, bizId, withMetrics(path, bizHandlerFunc))
+		inbound := func(mt int, data []byte) {
+			state.pendingBytes.Add(int64(len(data)))
+			dispatcher.submit(func() {
+				defer state.pendingBytes.Add(-int64(len(data)))
+				if err := bizHandlerFunc(c, ctx, &WebSocketMessage{MessageType: mt, MessageData: data}); err != nil {
+					dglogger.Errorf(ctx, "[%s: %s] biz handle message error: %v", bizKey, bizId, err)
+				}
+			})
+		}
+		connection.inbound.Store(&inbound)
+
+		keepAlive := d.PingPeriod
+		if keepAlive <= 0 {
+			keepAlive = defaultSSEKeepAlive
+		}
+		ticker := getClock().NewTicker(keepAlive)
+		defer ticker.Stop()
+	loop:
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				dglogger.Infof(ctx, "[%s: %s] sse client disconnected", bizKey, bizId)
+				break loop
+			case <-stream.done:
+				break loop
+			case <-ticker.Chan():
+				if err := stream.writeRaw([]byte(": ping\n\n")); err != nil {
+					break loop
+				}
+			}
+		}
+
+		SetWsEnded(ctx)
+		dispatcher.wait()
+		if conf.EndCallbackHandler != nil {
+			if err := conf.EndCallbackHandler(ctx, nil); err != nil {
+				dglogger.Errorf(ctx, "[%s: %s] end callback error: %v", bizKey, bizId, err)
+			}
+		}
+	}
+
+	postHandler := func(c *gin.Context) {
+		ctx := utils.GetDgContext(c)
+		connection := GetConnectionById(c.Query(SSEConnectionIdParam))
+		// 只能向自己建立的事件流发送消息
+		if connection == nil || !connection.IsSSE() || connection.Path != c.FullPath() || connection.UserId != ctx.UserId {
+			c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.RECORD_NOT_EXISTS))
+			return
+		}
+
+		body := io.Reader(c.Request.Body)
+		if d := conf.resolveDefaults(); d.MaxMessageSize > 0 {
+			body = http.MaxBytesReader(c.Writer, c.Request.Body, d.MaxMessageSize)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			dglogger.Warnf(ctx, "[%s: %s] read sse message error: %v", connection.BizKey, connection.BizId, err)
+			c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.ARGUMENT_NOT_VALID))
+			return
+		}
+		mt := websocket.TextMessage
+		if c.ContentType() == "application/octet-stream" {
+			mt = websocket.BinaryMessage
+		}
+		if err := connection.SimulateInbound(mt, data); err != nil {
+			c.AbortWithStatusJSON(http.StatusOK, result.SimpleFail[string](err.Error()))
+			return
+		}
+		c.JSON(http.StatusOK, result.SimpleSuccess())
+	}
+
+	rh.GET(rh.RelativePath, handlersChain(rh, streamHandler)...)
+	rh.POST(rh.RelativePath, handlersChain(rh, postHandler)...)
+	registerRouteState(routePath(rh.BasePath(), rh.RelativePath), rh.Remark)
+}
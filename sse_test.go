@@ -0,0 +1,184 @@
+package dgws_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	event string
+	data  string
+}
+
+func readSSEEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("sse stream closed")
+		}
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatal("no sse event within 3s")
+	}
+	return sseEvent{}
+}
+
+// streamSSE 注释行(keepalive)被忽略, 流结束时关闭channel
+func streamSSE(resp *http.Response) <-chan sseEvent {
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		reader := bufio.NewReader(resp.Body)
+		var ev sseEvent
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if lines != nil {
+					ev.data = strings.Join(lines, "\n")
+					events <- ev
+				}
+				ev, lines = sseEvent{}, nil
+			case strings.HasPrefix(line, ":"):
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				lines = append(lines, strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}()
+	return events
+}
+
+func TestGetSSE(t *testing.T) {
+	ended := make(chan struct{})
+	engine := gin.New()
+	dgws.GetSSE(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/sse"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			return dgws.GetConnection(ctx).WriteMessage(wsm.MessageType, bytes.ToUpper(wsm.MessageData))
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+		EndCallbackHandler: func(_ *dgctx.DgContext, _ *websocket.Conn) error {
+			close(ended)
+			return nil
+		},
+	})
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	bizId := uuid.NewString()
+	resp, err := http.Get(server.URL + "/sse?bizId=" + bizId)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+	events := streamSSE(resp)
+
+	ev := readSSEEvent(t, events)
+	var open dgws.SSEOpenEvent
+	if ev.event != dgws.SSEEventOpen || json.Unmarshal([]byte(ev.data), &open) != nil || open.ConnectionId == "" {
+		t.Fatalf("unexpected open event: %+v", ev)
+	}
+	if c := dgws.GetConnectionById(open.ConnectionId); c == nil || !c.IsSSE() || c.BizId != bizId {
+		t.Fatalf("sse connection not registered: %+v", c)
+	}
+
+	post, err := http.Post(server.URL+"/sse?"+dgws.SSEConnectionIdParam+"="+open.ConnectionId, "text/plain", strings.NewReader("hello\nworld"))
+	if err != nil {
+		t.Fatalf("post message: %v", err)
+	}
+	post.Body.Close()
+	if ev := readSSEEvent(t, events); ev.event != "" || ev.data != "HELLO\nWORLD" {
+		t.Fatalf("unexpected echo event: %+v", ev)
+	}
+
+	sent, err := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.BinaryMessage, []byte{1, 2, 3})
+	if err != nil || sent != 1 {
+		t.Fatalf("broadcast sent %d, error: %v", sent, err)
+	}
+	if ev := readSSEEvent(t, events); ev.event != dgws.SSEEventBinary || ev.data != base64.StdEncoding.EncodeToString([]byte{1, 2, 3}) {
+		t.Fatalf("unexpected binary event: %+v", ev)
+	}
+
+	if kicked, err := dgws.Kick(&dgctx.DgContext{TraceId: uuid.NewString()}, &dgws.KickOptions{BizKey: "bizId", BizIds: []string{bizId}, Reason: "bye"}); err != nil || kicked != 1 {
+		t.Fatalf("kicked %d connections, error: %v", kicked, err)
+	}
+	if ev := readSSEEvent(t, events); ev.event != dgws.SSEEventClose || !strings.Contains(ev.data, `"reason":"bye"`) {
+		t.Fatalf("unexpected close event: %+v", ev)
+	}
+	select {
+	case <-ended:
+	case <-time.After(3 * time.Second):
+		t.Fatal("end callback not called")
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("stream should end after close event")
+	}
+}
+
+func TestGetSSERedirect(t *testing.T) {
+	ring := dgws.NewHashRing(0)
+	ring.Set(map[string]string{"self": "ws://self", "other": "wss://other.example.com"})
+	engine := gin.New()
+	dgws.GetSSE(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/sse"),
+		NonLogin:    true,
+		BizHandler: func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+			return nil
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+		Redirect: &dgws.RedirectOptions{Ring: ring, NodeId: "self"},
+	})
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	var bizId string
+	for bizId = uuid.NewString(); ; bizId = uuid.NewString() {
+		if nodeId, _, _ := ring.Locate("bizId:" + bizId); nodeId == "other" {
+			break
+		}
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(server.URL + "/sse?bizId=" + bizId)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "https://other.example.com/sse?bizId="+bizId {
+		t.Fatalf("unexpected redirect: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,489 @@
+package dgws
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// STOMP over websocket的子协议, 按客户端支持的最高版本协商
+const (
+	StompSubprotocol12 = "v12.stomp"
+	StompSubprotocol11 = "v11.stomp"
+	StompSubprotocol10 = "v10.stomp"
+)
+
+const (
+	StompConnect     = "CONNECT"
+	StompStomp       = "STOMP"
+	StompConnected   = "CONNECTED"
+	StompSend        = "SEND"
+	StompSubscribe   = "SUBSCRIBE"
+	StompUnsubscribe = "UNSUBSCRIBE"
+	StompAck         = "ACK"
+	StompNack        = "NACK"
+	StompBegin       = "BEGIN"
+	StompCommit      = "COMMIT"
+	StompAbort       = "ABORT"
+	StompDisconnect  = "DISCONNECT"
+	StompMessage     = "MESSAGE"
+	StompReceipt     = "RECEIPT"
+	StompError       = "ERROR"
+)
+
+const (
+	StompAckAuto             = "auto"
+	StompAckClient           = "client"
+	StompAckClientIndividual = "client-individual"
+)
+
+const stompStateKey = "WsStompState"
+
+var ErrStompFrame = errors.New("invalid stomp frame")
+
+// StompFrame Headers中重复的header只保留第一个, 与STOMP 1.2的规定一致
+type StompFrame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+func NewStompFrame(command string, headers map[string]string, body []byte) *StompFrame {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	return &StompFrame{Command: command, Headers: headers, Body: body}
+}
+
+// ParseStompFrames 一条websocket消息中可能有多个帧, 帧之间的换行(心跳)被忽略
+func ParseStompFrames(data []byte) ([]*StompFrame, error) {
+	var frames []*StompFrame
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+		frame, rest, err := parseStompFrame(data)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+		data = rest
+	}
+}
+
+func parseStompFrame(data []byte) (*StompFrame, []byte, error) {
+	end := bytes.Index(data, []byte("\n\n"))
+	headerEnd := end + 2
+	if crlf := bytes.Index(data, []byte("\r\n\r\n")); crlf >= 0 && (end < 0 || crlf < end) {
+		end, headerEnd = crlf, crlf+4
+	}
+	if end < 0 {
+		return nil, nil, ErrStompFrame
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data[:end]), "\r\n", "\n"), "\n")
+	frame := NewStompFrame(lines[0], nil, nil)
+	if frame.Command == "" {
+		return nil, nil, ErrStompFrame
+	}
+	escaped := frame.Command != StompConnect && frame.Command != StompConnected
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, ErrStompFrame
+		}
+		if escaped {
+			key, value = unescapeStompHeader(key), unescapeStompHeader(value)
+		}
+		if _, exists := frame.Headers[key]; !exists {
+			frame.Headers[key] = value
+		}
+	}
+
+	rest := data[headerEnd:]
+	if length, ok := frame.Headers["content-length"]; ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n >= len(rest) || rest[n] != 0 {
+			return nil, nil, ErrStompFrame
+		}
+		frame.Body = rest[:n]
+		return frame, rest[n+1:], nil
+	}
+	n := bytes.IndexByte(rest, 0)
+	if n < 0 {
+		return nil, nil, ErrStompFrame
+	}
+	frame.Body = rest[:n]
+	return frame, rest[n+1:], nil
+}
+
+// Bytes 带body时总是写出content-length, header按名称排序
+func (f *StompFrame) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	escaped := f.Command != StompConnect && f.Command != StompConnected
+	keys := make([]string, 0, len(f.Headers))
+	for key := range f.Headers {
+		if key != "content-length" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := f.Headers[key]
+		if escaped {
+			key, value = escapeStompHeader(key), escapeStompHeader(value)
+		}
+		buf.WriteString(key + ":" + value + "\n")
+	}
+	if len(f.Body) > 0 {
+		buf.WriteString("content-length:" + strconv.Itoa(len(f.Body)) + "\n")
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+var (
+	stompHeaderEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompHeaderUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+func escapeStompHeader(s string) string {
+	return stompHeaderEscaper.Replace(s)
+}
+
+func unescapeStompHeader(s string) string {
+	return stompHeaderUnescaper.Replace(s)
+}
+
+type StompOptions struct {
+	// OnConnect 校验CONNECT帧中的login、passcode等, 返回错误时回复ERROR并关闭连接
+	OnConnect func(ctx *dgctx.DgContext, frame *StompFrame) error
+	// OnSend 收到SEND帧时回调, 返回错误时回复ERROR并关闭连接; 为nil或返回nil时投递给该destination的订阅者
+	OnSend func(ctx *dgctx.DgContext, frame *StompFrame) error
+	// OnNack 客户端NACK了一条消息, message为当时发送的MESSAGE帧
+	OnNack func(ctx *dgctx.DgContext, message *StompFrame)
+}
+
+type stompSubscription struct {
+	session     *stompSession
+	id          string
+	destination string
+	ack         string
+	// pending 等待ACK的消息, 按发送顺序, ack为auto时不记录
+	pending []*StompFrame
+}
+
+type stompSession struct {
+	ctx       *dgctx.DgContext
+	lock      sync.Mutex
+	connected bool
+	ended     bool
+	subs      map[string]*stompSubscription
+}
+
+type stompBroker struct {
+	lock         sync.RWMutex
+	destinations map[string]map[*stompSubscription]struct{}
+	messageSeq   atomic.Int64
+}
+
+var defaultStompBroker = &stompBroker{destinations: make(map[string]map[*stompSubscription]struct{})}
+
+// Stomp 返回STOMP 1.2 broker-lite的BizHandler: 支持CONNECT/STOMP、SEND、SUBSCRIBE/UNSUBSCRIBE、ACK/NACK、DISCONNECT,
+// destination即本进程内的订阅主题, 服务端可通过StompPublish推送; 不支持事务和服务端心跳(CONNECTED中heart-beat为0,0).
+// 同时在conf中登记子协议并包装StateChangeHandler, 需在Get之前调用
+func Stomp(conf *WebSocketHandlerConfig, opts *StompOptions) wrapper.HandlerFunc[WebSocketMessage, error] {
+	if opts == nil {
+		opts = &StompOptions{}
+	}
+	conf.Subprotocols = append(conf.Subprotocols, StompSubprotocol12, StompSubprotocol11, StompSubprotocol10)
+
+	stateChangeHandler := conf.StateChangeHandler
+	conf.StateChangeHandler = func(ctx *dgctx.DgContext, from ConnectionState, to ConnectionState) {
+		if to >= ConnectionStateDraining {
+			if value, ok := GetConnState(ctx).values.Load(stompStateKey); ok {
+				value.(*stompSession).end()
+			}
+		}
+		if stateChangeHandler != nil {
+			stateChangeHandler(ctx, from, to)
+		}
+	}
+
+	return func(_ *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) error {
+		value, _ := GetConnState(ctx).values.LoadOrStore(stompStateKey, &stompSession{ctx: ctx, subs: make(map[string]*stompSubscription)})
+		session := value.(*stompSession)
+		frames, err := ParseStompFrames(wsm.MessageData)
+		if err != nil {
+			session.fail(nil, "malformed frame", err.Error())
+			return nil
+		}
+		for _, frame := range frames {
+			if !session.handle(opts, frame) {
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+// handle 返回false时连接已关闭, 不再处理之后的帧
+func (s *stompSession) handle(opts *StompOptions, frame *StompFrame) bool {
+	s.lock.Lock()
+	connected := s.connected
+	s.lock.Unlock()
+	if frame.Command == StompConnect || frame.Command == StompStomp {
+		if connected {
+			s.fail(frame, "already connected", "")
+			return false
+		}
+		return s.connect(opts, frame)
+	}
+	if !connected {
+		s.fail(frame, "not connected", "CONNECT frame expected")
+		return false
+	}
+
+	switch frame.Command {
+	case StompSend:
+		destination := frame.Headers["destination"]
+		if destination == "" {
+			s.fail(frame, "missing destination header", "")
+			return false
+		}
+		if opts.OnSend != nil {
+			if err := opts.OnSend(s.ctx, frame); err != nil {
+				s.fail(frame, "send rejected", err.Error())
+				return false
+			}
+		}
+		headers := make(map[string]string, len(frame.Headers))
+		for key, value := range frame.Headers {
+			if key != "receipt" && key != "transaction" {
+				headers[key] = value
+			}
+		}
+		defaultStompBroker.publish(destination, headers, frame.Body)
+	case StompSubscribe:
+		destination, id := frame.Headers["destination"], frame.Headers["id"]
+		ack := frame.Headers["ack"]
+		if ack == "" {
+			ack = StompAckAuto
+		}
+		if destination == "" || id == "" || (ack != StompAckAuto && ack != StompAckClient && ack != StompAckClientIndividual) {
+			s.fail(frame, "invalid subscribe frame", "destination and id headers are required")
+			return false
+		}
+		if !s.subscribe(&stompSubscription{session: s, id: id, destination: destination, ack: ack}) {
+			s.fail(frame, "duplicate subscription id", id)
+			return false
+		}
+	case StompUnsubscribe:
+		s.unsubscribe(frame.Headers["id"])
+	case StompAck, StompNack:
+		for _, message := range s.ack(frame.Headers["id"], frame.Command == StompNack) {
+			if opts.OnNack != nil {
+				opts.OnNack(s.ctx, message)
+			}
+		}
+	case StompDisconnect:
+		s.receipt(frame)
+		s.end()
+		if connection := GetConnection(s.ctx); connection != nil {
+			connection.closeWith(websocket.CloseNormalClosure, "")
+		}
+		return false
+	case StompBegin, StompCommit, StompAbort:
+		s.fail(frame, "transactions are not supported", "")
+		return false
+	default:
+		s.fail(frame, "unknown command", frame.Command)
+		return false
+	}
+	s.receipt(frame)
+	return true
+}
+
+func (s *stompSession) connect(opts *StompOptions, frame *StompFrame) bool {
+	if versions := frame.Headers["accept-version"]; versions != "" && !slices.Contains(strings.Split(versions, ","), "1.2") {
+		s.fail(frame, "unsupported version", "supported protocol versions are 1.2")
+		return false
+	}
+	if opts.OnConnect != nil {
+		if err := opts.OnConnect(s.ctx, frame); err != nil {
+			s.fail(frame, "connect rejected", err.Error())
+			return false
+		}
+	}
+
+	s.lock.Lock()
+	s.connected = true
+	s.lock.Unlock()
+	connected := NewStompFrame(StompConnected, map[string]string{"version": "1.2", "heart-beat": "0,0", "server": "dgws"}, nil)
+	if connection := GetConnection(s.ctx); connection != nil {
+		connected.Headers["session"] = connection.Id
+	}
+	return s.write(connected) == nil
+}
+
+func (s *stompSession) subscribe(sub *stompSubscription) bool {
+	s.lock.Lock()
+	if _, ok := s.subs[sub.id]; ok || s.ended {
+		s.lock.Unlock()
+		return false
+	}
+	s.subs[sub.id] = sub
+	s.lock.Unlock()
+
+	defaultStompBroker.lock.Lock()
+	defer defaultStompBroker.lock.Unlock()
+	subs := defaultStompBroker.destinations[sub.destination]
+	if subs == nil {
+		subs = make(map[*stompSubscription]struct{})
+		defaultStompBroker.destinations[sub.destination] = subs
+	}
+	subs[sub] = struct{}{}
+	return true
+}
+
+func (s *stompSession) unsubscribe(id string) {
+	s.lock.Lock()
+	sub := s.subs[id]
+	delete(s.subs, id)
+	s.lock.Unlock()
+	if sub != nil {
+		defaultStompBroker.remove(sub)
+	}
+}
+
+// ack 按message-id确认, client模式下同时确认该订阅中之前发送的消息; 返回被nack的消息
+func (s *stompSession) ack(messageId string, nack bool) []*StompFrame {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sub := range s.subs {
+		i := slices.IndexFunc(sub.pending, func(m *StompFrame) bool {
+			return m.Headers["message-id"] == messageId
+		})
+		if i < 0 {
+			continue
+		}
+		var acked []*StompFrame
+		if sub.ack == StompAckClient {
+			acked = slices.Clone(sub.pending[:i+1])
+			sub.pending = slices.Delete(sub.pending, 0, i+1)
+		} else {
+			acked = []*StompFrame{sub.pending[i]}
+			sub.pending = slices.Delete(sub.pending, i, i+1)
+		}
+		if nack {
+			return acked
+		}
+		return nil
+	}
+	return nil
+}
+
+func (s *stompSession) end() {
+	s.lock.Lock()
+	s.ended = true
+	subs := s.subs
+	s.subs = make(map[string]*stompSubscription)
+	s.lock.Unlock()
+	for _, sub := range subs {
+		defaultStompBroker.remove(sub)
+	}
+}
+
+func (s *stompSession) receipt(frame *StompFrame) {
+	if id := frame.Headers["receipt"]; id != "" {
+		_ = s.write(NewStompFrame(StompReceipt, map[string]string{"receipt-id": id}, nil))
+	}
+}
+
+// fail 发送ERROR帧后关闭连接, 规范要求服务端发送ERROR后必须关闭
+func (s *stompSession) fail(frame *StompFrame, message string, detail string) {
+	dglogger.Warnf(s.ctx, "stomp error: %s %s", message, detail)
+	errFrame := NewStompFrame(StompError, map[string]string{"message": message}, []byte(detail))
+	if frame != nil && frame.Headers["receipt"] != "" {
+		errFrame.Headers["receipt-id"] = frame.Headers["receipt"]
+	}
+	_ = s.write(errFrame)
+	s.end()
+	if connection := GetConnection(s.ctx); connection != nil {
+		connection.closeWith(websocket.ClosePolicyViolation, message)
+	}
+}
+
+func (s *stompSession) write(frame *StompFrame) error {
+	connection := GetConnection(s.ctx)
+	if connection == nil {
+		return ErrConnectionClosed
+	}
+	data := frame.Bytes()
+	mt := websocket.TextMessage
+	if !utf8.Valid(data) {
+		mt = websocket.BinaryMessage
+	}
+	return connection.WriteMessage(mt, data)
+}
+
+func (b *stompBroker) remove(sub *stompSubscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	subs := b.destinations[sub.destination]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.destinations, sub.destination)
+	}
+}
+
+func (b *stompBroker) publish(destination string, headers map[string]string, body []byte) int {
+	b.lock.RLock()
+	subs := make([]*stompSubscription, 0, len(b.destinations[destination]))
+	for sub := range b.destinations[destination] {
+		subs = append(subs, sub)
+	}
+	b.lock.RUnlock()
+
+	delivered := 0
+	for _, sub := range subs {
+		message := NewStompFrame(StompMessage, make(map[string]string, len(headers)+4), body)
+		for key, value := range headers {
+			message.Headers[key] = value
+		}
+		message.Headers["destination"] = destination
+		message.Headers["subscription"] = sub.id
+		message.Headers["message-id"] = fmt.Sprintf("%d", b.messageSeq.Add(1))
+		if sub.ack != StompAckAuto {
+			message.Headers["ack"] = message.Headers["message-id"]
+			sub.session.lock.Lock()
+			sub.pending = append(sub.pending, message)
+			sub.session.lock.Unlock()
+		}
+		if err := sub.session.write(message); err == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// StompPublish 以MESSAGE帧推送给本进程内订阅了destination的所有STOMP连接, 返回成功写出的订阅数
+func StompPublish(destination string, headers map[string]string, body []byte) int {
+	return defaultStompBroker.publish(destination, headers, body)
+}
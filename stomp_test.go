@@ -0,0 +1,144 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestParseStompFrames(t *testing.T) {
+	frame := dgws.NewStompFrame(dgws.StompSend, map[string]string{"destination": "/queue/a:b", "x-note": "line1\nline2"}, []byte("hello\x00world"))
+	frames, err := dgws.ParseStompFrames(append(append([]byte("\n"), frame.Bytes()...), "\r\nSUBSCRIBE\r\nid:1\r\ndestination:/topic/x\r\nid:2\r\n\r\n\x00"...))
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("parse frames: %v, %d", err, len(frames))
+	}
+	if f := frames[0]; f.Command != dgws.StompSend || f.Headers["destination"] != "/queue/a:b" || f.Headers["x-note"] != "line1\nline2" || string(f.Body) != "hello\x00world" {
+		t.Fatalf("unexpected send frame: %+v", f)
+	}
+	if f := frames[1]; f.Command != dgws.StompSubscribe || f.Headers["id"] != "1" || f.Headers["destination"] != "/topic/x" {
+		t.Fatalf("unexpected subscribe frame: %+v", f)
+	}
+
+	for _, bad := range []string{"SEND\ndestination:/a\n\nno terminator", "SEND\nbroken header\n\n\x00", "SEND\ncontent-length:10\n\nshort\x00"} {
+		if _, err := dgws.ParseStompFrames([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func dialStomp(t *testing.T, url string) *websocket.Conn {
+	dialer := &websocket.Dialer{Subprotocols: []string{dgws.StompSubprotocol12}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if conn.Subprotocol() != dgws.StompSubprotocol12 {
+		t.Fatalf("unexpected subprotocol: %q", conn.Subprotocol())
+	}
+	return conn
+}
+
+func writeStomp(t *testing.T, conn *websocket.Conn, command string, headers map[string]string, body string) {
+	if err := conn.WriteMessage(websocket.TextMessage, dgws.NewStompFrame(command, headers, []byte(body)).Bytes()); err != nil {
+		t.Fatalf("write %s: %v", command, err)
+	}
+}
+
+func readStomp(t *testing.T, conn *websocket.Conn, command string) *dgws.StompFrame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read %s: %v", command, err)
+	}
+	frames, err := dgws.ParseStompFrames(data)
+	if err != nil || len(frames) != 1 || frames[0].Command != command {
+		t.Fatalf("expected %s, got %q (%v)", command, data, err)
+	}
+	return frames[0]
+}
+
+func TestStompBroker(t *testing.T) {
+	nacked := make(chan string, 2)
+	conf := &dgws.WebSocketHandlerConfig{}
+	url := startTestServerWithConfig(t, conf, dgws.Stomp(conf, &dgws.StompOptions{
+		OnConnect: func(_ *dgctx.DgContext, frame *dgws.StompFrame) error {
+			if frame.Headers["login"] != "guest" {
+				return dgws.ErrStompFrame
+			}
+			return nil
+		},
+		OnNack: func(_ *dgctx.DgContext, message *dgws.StompFrame) {
+			nacked <- string(message.Body)
+		},
+	}))
+
+	subscriber := dialStomp(t, url)
+	writeStomp(t, subscriber, dgws.StompConnect, map[string]string{"accept-version": "1.1,1.2", "login": "guest"}, "")
+	if f := readStomp(t, subscriber, dgws.StompConnected); f.Headers["version"] != "1.2" {
+		t.Fatalf("unexpected connected frame: %+v", f)
+	}
+	writeStomp(t, subscriber, dgws.StompSubscribe, map[string]string{"id": "s1", "destination": "/topic/chat", "ack": dgws.StompAckClient, "receipt": "r1"}, "")
+	if f := readStomp(t, subscriber, dgws.StompReceipt); f.Headers["receipt-id"] != "r1" {
+		t.Fatalf("unexpected receipt: %+v", f)
+	}
+
+	sender := dialStomp(t, url)
+	writeStomp(t, sender, dgws.StompStomp, map[string]string{"login": "guest"}, "")
+	readStomp(t, sender, dgws.StompConnected)
+	writeStomp(t, sender, dgws.StompSend, map[string]string{"destination": "/topic/chat", "content-type": "text/plain"}, "hi")
+	message := readStomp(t, subscriber, dgws.StompMessage)
+	if message.Headers["subscription"] != "s1" || message.Headers["content-type"] != "text/plain" || string(message.Body) != "hi" || message.Headers["ack"] == "" {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+
+	if n := dgws.StompPublish("/topic/chat", nil, []byte("from server")); n != 1 {
+		t.Fatalf("published to %d subscriptions", n)
+	}
+	second := readStomp(t, subscriber, dgws.StompMessage)
+	writeStomp(t, subscriber, dgws.StompNack, map[string]string{"id": second.Headers["ack"]}, "")
+	// client模式下nack同时作用于之前未确认的消息
+	for _, want := range []string{"hi", "from server"} {
+		select {
+		case body := <-nacked:
+			if body != want {
+				t.Fatalf("unexpected nacked message: %s", body)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("nack not reported")
+		}
+	}
+
+	writeStomp(t, subscriber, dgws.StompUnsubscribe, map[string]string{"id": "s1", "receipt": "r2"}, "")
+	readStomp(t, subscriber, dgws.StompReceipt)
+	if n := dgws.StompPublish("/topic/chat", nil, []byte("nobody")); n != 0 {
+		t.Fatalf("published to %d subscriptions after unsubscribe", n)
+	}
+
+	writeStomp(t, sender, dgws.StompDisconnect, map[string]string{"receipt": "bye"}, "")
+	readStomp(t, sender, dgws.StompReceipt)
+	expectClosed(t, sender, websocket.CloseNormalClosure)
+}
+
+func TestStompErrors(t *testing.T) {
+	conf := &dgws.WebSocketHandlerConfig{}
+	url := startTestServerWithConfig(t, conf, dgws.Stomp(conf, nil))
+
+	conn := dialStomp(t, url)
+	writeStomp(t, conn, dgws.StompSend, map[string]string{"destination": "/topic/a"}, "x")
+	if f := readStomp(t, conn, dgws.StompError); f.Headers["message"] != "not connected" {
+		t.Fatalf("unexpected error frame: %+v", f)
+	}
+	expectClosed(t, conn, websocket.ClosePolicyViolation)
+
+	conn = dialStomp(t, url)
+	writeStomp(t, conn, dgws.StompConnect, nil, "")
+	readStomp(t, conn, dgws.StompConnected)
+	writeStomp(t, conn, dgws.StompBegin, map[string]string{"transaction": "tx1"}, "")
+	if f := readStomp(t, conn, dgws.StompError); f.Headers["message"] != "transactions are not supported" {
+		t.Fatalf("unexpected error frame: %+v", f)
+	}
+}
@@ -0,0 +1,252 @@
+package dgws
+
+import (
+	"encoding/json"
+	"errors"
+	dgcoll "github.com/darwinOrg/go-common/collection"
+	dgerr "github.com/darwinOrg/go-common/enums/error"
+	"github.com/darwinOrg/go-common/result"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/utils"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+)
+
+var ErrPreparedMessageUnsupported = errors.New("prepared message is not supported by non-websocket connection")
+
+// messageWriter 非websocket连接(SSE、Transport)的写入端, 由Connection在写锁内调用
+type messageWriter interface {
+	WriteMessage(mt int, data []byte) error
+	Close(code int, reason string) error
+}
+
+// Transport 非websocket的双向消息传输(如webtransport子包), 通过HandleTransport复用Get的配置、BizHandler和连接注册表
+type Transport interface {
+	// ReadMessage 对端关闭或连接断开时返回错误
+	ReadMessage() (mt int, data []byte, err error)
+	// WriteMessage 由Connection串行调用
+	WriteMessage(mt int, data []byte) error
+	// Close 把关闭码和原因通知对端后断开, 阻塞中的ReadMessage随之返回错误; 可能与WriteMessage并发调用, 可能被调用多次
+	Close(code int, reason string) error
+}
+
+// TransportUpgrader 准入检查通过后建立传输, 返回错误时需自行写出http响应
+type TransportUpgrader func(c *gin.Context) (Transport, error)
+
+// writeTo 写失败后关闭writer, 错误原样返回给调用方
+func (c *Connection) writeTo(mt int, data []byte) error {
+	c.lockWrite()
+	defer c.unlockWrite()
+	err := c.writer.WriteMessage(mt, data)
+	if err != nil {
+		if !errors.Is(err, ErrConnectionClosed) {
+			dglogger.Warnf(c.Ctx, "[%s: %s] write message error, close connection: %v", c.BizKey, c.BizId, err)
+		}
+		_ = c.writer.Close(websocket.CloseInternalServerErr, "write error")
+		return err
+	}
+	if c.journal != nil {
+		c.journal.record(JournalDirectionOut, mt, data)
+	}
+	return nil
+}
+
+// HandleTransport 以method注册rh.RelativePath, 请求经过与Get相同的登录和准入检查后由upgrade建立传输, 之后按Get的流程读取消息交给BizHandler;
+// 支持conf中的BizKey、GetBizIdHandler、StartHandler、IsEndedHandler(读取出错时mt为-1)、EndCallbackHandler、StateChangeHandler、Retry、Redirect和OnPanic,
+// StartHandler和EndCallbackHandler的conn参数以及WebSocketMessage.Connection为nil, BizHandler需通过GetConnection(ctx)回写消息
+func HandleTransport(rh *wrapper.RequestHolder[WebSocketMessage, error], conf *WebSocketHandlerConfig, method string, upgrade TransportUpgrader) {
+	if conf.StartHandler == nil {
+		conf.StartHandler = DefaultStartHandler
+	}
+	if conf.IsEndedHandler == nil {
+		conf.IsEndedHandler = DefaultIsEndHandler
+	}
+
+	transportHandler := func(c *gin.Context) {
+		d := conf.resolveDefaults()
+		adm, ok := admitConnection(c, conf, d)
+		if !ok {
+			return
+		}
+		defer adm.release()
+		path := c.FullPath()
+		ctx := utils.GetDgContext(c)
+		bizKey := adm.bizKey
+		bizId := adm.bizId
+		if adm.redirect != "" {
+			dglogger.Infof(ctx, "[%s: %s] redirect to %s", bizKey, bizId, adm.redirect)
+			redirectHTTP(c, adm.redirect)
+			return
+		}
+		if ctx.RemoteIp == "" {
+			ctx.RemoteIp = c.ClientIP()
+		}
+
+		t, err := upgrade(c)
+		if err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] upgrade transport error: %v", bizKey, bizId, err)
+			return
+		}
+		defer t.Close(websocket.CloseNormalClosure, "")
+
+		state := GetConnState(ctx)
+		state.onStateChange = func(from ConnectionState, to ConnectionState) {
+			if conf.StateChangeHandler != nil {
+				conf.StateChangeHandler(ctx, from, to)
+			}
+		}
+		defer state.transition(ConnectionStateClosed)
+		defer state.initContext(c.Request.Context())()
+
+		if err := conf.StartHandler(c, ctx, nil); err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] start transport error: %v", bizKey, bizId, err)
+			var rtBytes []byte
+			var dgError *dgerr.DgError
+			if errors.As(err, &dgError) {
+				rtBytes, _ = json.Marshal(result.FailByError[*dgerr.DgError](dgError))
+			} else {
+				rtBytes, _ = json.Marshal(result.SimpleFail[string](err.Error()))
+			}
+			_ = t.WriteMessage(websocket.TextMessage, rtBytes)
+			return
+		}
+
+		connection := registerConnection(ctx, nil, t, path, bizKey, bizId, d.WriteWait, nil, nil, nil)
+		defer unregisterConnection(connection)
+		state.transition(ConnectionStateOpen)
+
+		dispatcher := newSerialDispatcher(defaultMaxPendingPerConn)
+		defer dispatcher.wait()
+		// 读循环退出后先取消连接的context, 再等待处理中的消息
+		defer state.cancelContext()
+		bizHandlerFunc := rh.BizHandler
+		if conf.Retry != nil {
+			bizHandlerFunc = withRetry(conf.Retry, bizKey, bizId, bizHandlerFunc)
+		}
+		bizHandlerFunc = withRecover(conf.OnPanic, bizKey, bizId, bizHandlerFunc)
+		bizHandlerFunc = withTrace(path, bizKey, bizId, withMetrics(path, bizHandlerFunc))
+		inbound := func(mt int, data []byte) {
+			state.pendingBytes.Add(int64(len(data)))
+			dispatcher.submit(func() {
+				defer state.pendingBytes.Add(-int64(len(data)))
+				if err := bizHandlerFunc(c, ctx, &WebSocketMessage{MessageType: mt, MessageData: data}); err != nil {
+					dglogger.Errorf(ctx, "[%s: %s] biz handle message error: %v", bizKey, bizId, err)
+				}
+			})
+		}
+		connection.inbound.Store(&inbound)
+
+		for !IsWsEnded(ctx) {
+			mt, message, err := t.ReadMessage()
+			if err != nil {
+				mt = -1
+			}
+			if conf.IsEndedHandler(ctx, mt, message) {
+				SetWsEnded(ctx)
+				dglogger.Infof(ctx, "[%s: %s] transport closed, error: %v", bizKey, bizId, err)
+				dispatcher.wait()
+				if conf.EndCallbackHandler != nil {
+					if err := conf.EndCallbackHandler(ctx, nil); err != nil {
+						dglogger.Errorf(ctx, "[%s: %s] end callback error: %v", bizKey, bizId, err)
+					}
+				}
+				break
+			}
+			if err != nil {
+				dglogger.Errorf(ctx, "[%s: %s] transport read error: %v", bizKey, bizId, err)
+				break
+			}
+			inbound(mt, message)
+		}
+	}
+
+	rh.Handle(method, rh.RelativePath, handlersChain(rh, transportHandler)...)
+	registerRouteState(routePath(rh.BasePath(), rh.RelativePath), rh.Remark)
+}
+
+// admission 准入检查通过后的结果
+type admission struct {
+	bizKey string
+	bizId  string
+	// redirect 不为空时bizId属于其他节点, 见RedirectOptions
+	redirect string
+	// release 需在连接结束后调用
+	release func()
+}
+
+// admitConnection Get、GetSSE和HandleTransport共用的准入检查: 停止、维护模式(包括drain)、路由开关、限流、
+// 本实例和集群的连接数配额, 并计算重定向地址; 不通过时已写出响应. 新的准入规则只需加在这里
+func admitConnection(c *gin.Context, conf *WebSocketHandlerConfig, d Defaults) (*admission, bool) {
+	if rejectShutdown(c) || rejectMaintenance(c) || rejectDisabledRoute(c, c.FullPath()) {
+		return nil, false
+	}
+	if limiter := rateLimiter; limiter != nil && !limiter.wait(c.Request.Context(), c.ClientIP()) {
+		c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.SYSTEM_BUSY))
+		return nil, false
+	}
+	if !globalQuota.acquire(d.AcquireTimeout) {
+		c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.SYSTEM_BUSY))
+		return nil, false
+	}
+	// 占用配额后再检查一次, 与EnterDrainMode或Shutdown并发的请求要么被拒绝, 要么已计入ActiveConnections
+	if rejectShutdown(c) || rejectMaintenance(c) {
+		globalQuota.release()
+		return nil, false
+	}
+	quota := getRouteQuota(c.FullPath(), false)
+	if quota != nil && !quota.acquire(d.AcquireTimeout) {
+		globalQuota.release()
+		c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.SYSTEM_BUSY))
+		return nil, false
+	}
+	ctx := utils.GetDgContext(c)
+	userId := ctx.UserId
+	if !userQuotas.acquire(userId) {
+		if quota != nil {
+			quota.release()
+		}
+		globalQuota.release()
+		c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.SYSTEM_BUSY))
+		return nil, false
+	}
+	releaseLocal := func() {
+		userQuotas.release(userId)
+		if quota != nil {
+			quota.release()
+		}
+		globalQuota.release()
+	}
+
+	bizKey := conf.BizKey
+	bizId := conf.GetBizIdHandler(c)
+	lease, ok := acquireClusterConn(ctx, bizKey, bizId)
+	if !ok {
+		releaseLocal()
+		c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.SYSTEM_BUSY))
+		return nil, false
+	}
+
+	adm := &admission{bizKey: bizKey, bizId: bizId, release: func() {
+		if lease != nil {
+			lease.release(ctx)
+		}
+		releaseLocal()
+	}}
+	if conf.Redirect != nil {
+		if url, ok := conf.Redirect.target(bizKey, bizId, c.Request.URL.RequestURI()); ok {
+			adm.redirect = url
+		}
+	}
+
+	return adm, true
+}
+
+func handlersChain(rh *wrapper.RequestHolder[WebSocketMessage, error], handler gin.HandlerFunc) gin.HandlersChain {
+	chain := gin.HandlersChain{wrapper.LoginHandler(rh), wrapper.CheckProductHandler(rh), wrapper.CheckRolesHandler(rh), wrapper.CheckProfileHandler(), handler}
+	if len(rh.PreHandlersChain) > 0 {
+		chain = dgcoll.MergeToList(rh.PreHandlersChain, chain)
+	}
+	return chain
+}
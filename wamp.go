@@ -0,0 +1,623 @@
+package dgws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// WampSubprotocolJSON WAMP v2使用json序列化时的子协议
+const WampSubprotocolJSON = "wamp.2.json"
+
+// WAMP basic profile的消息类型
+const (
+	WampHello        = 1
+	WampWelcome      = 2
+	WampAbort        = 3
+	WampGoodbye      = 6
+	WampError        = 8
+	WampPublish      = 16
+	WampPublished    = 17
+	WampSubscribe    = 32
+	WampSubscribed   = 33
+	WampUnsubscribe  = 34
+	WampUnsubscribed = 35
+	WampEvent        = 36
+	WampCall         = 48
+	WampResult       = 50
+	WampRegister     = 64
+	WampRegistered   = 65
+	WampUnregister   = 66
+	WampUnregistered = 67
+	WampInvocation   = 68
+	WampYield        = 70
+)
+
+const (
+	WampErrorNoSuchRealm            = "wamp.error.no_such_realm"
+	WampErrorNotAuthorized          = "wamp.error.not_authorized"
+	WampErrorProtocolViolation      = "wamp.error.protocol_violation"
+	WampErrorInvalidArgument        = "wamp.error.invalid_argument"
+	WampErrorNoSuchProcedure        = "wamp.error.no_such_procedure"
+	WampErrorProcedureAlreadyExists = "wamp.error.procedure_already_exists"
+	WampErrorNoSuchSubscription     = "wamp.error.no_such_subscription"
+	WampErrorNoSuchRegistration     = "wamp.error.no_such_registration"
+	WampErrorCanceled               = "wamp.error.canceled"
+	WampErrorRuntime                = "wamp.error.runtime_error"
+	WampCloseGoodbyeAndOut          = "wamp.close.goodbye_and_out"
+)
+
+const (
+	wampStateKey = "WsWampState"
+	wampMaxId    = 1 << 53
+)
+
+// WampCallError 过程返回该错误时以URI和参数回复ERROR, 其他错误回复wamp.error.runtime_error
+type WampCallError struct {
+	URI    string
+	Args   []any
+	Kwargs map[string]any
+}
+
+func (e *WampCallError) Error() string {
+	return e.URI
+}
+
+// WampInvocationRequest 数字参数解码为json.Number
+type WampInvocationRequest struct {
+	Procedure string
+	Args      []any
+	Kwargs    map[string]any
+	Options   map[string]any
+}
+
+type WampInvocationResult struct {
+	Args   []any
+	Kwargs map[string]any
+}
+
+// WampProcedure 服务端实现的过程, ctx在会话结束时取消; 每次CALL在单独的goroutine中执行
+type WampProcedure func(ctx context.Context, dgCtx *dgctx.DgContext, req *WampInvocationRequest) (*WampInvocationResult, error)
+
+type WampOptions struct {
+	// Realm 该路由服务的realm, HELLO中的realm不一致时以wamp.error.no_such_realm中止; 为空时接受任意realm
+	Realm string
+	// Procedures 服务端实现的过程, 只对该路由的会话可见, 客户端不能再REGISTER同名过程
+	Procedures map[string]WampProcedure
+	// OnHello 校验HELLO的details(通常为authid、authextra等), 返回错误时以wamp.error.not_authorized中止
+	OnHello func(ctx *dgctx.DgContext, realm string, details map[string]any) error
+}
+
+type wampRegistration struct {
+	id     int64
+	callee *wampSession
+}
+
+type wampInvocation struct {
+	caller      *wampSession
+	callRequest int64
+	callee      *wampSession
+}
+
+// wampRealm 同一realm的会话共享订阅和客户端注册的过程
+type wampRealm struct {
+	lock        sync.Mutex
+	topics      map[string]map[*wampSession]int64
+	procedures  map[string]*wampRegistration
+	invocations map[int64]*wampInvocation
+}
+
+type wampSession struct {
+	dgCtx *dgctx.DgContext
+	opts  *WampOptions
+	lock  sync.Mutex
+	id    int64
+	realm *wampRealm
+	ended bool
+	// ctx 服务端过程调用的ctx, 离开realm时取消
+	ctx    context.Context
+	cancel context.CancelFunc
+	// subscriptions 订阅id到topic
+	subscriptions map[int64]string
+	// registrations 注册id到过程名
+	registrations map[int64]string
+}
+
+var (
+	wampRealms     = make(map[string]*wampRealm)
+	wampRealmsLock sync.Mutex
+	wampIdSeq      atomic.Int64
+)
+
+func getWampRealm(name string) *wampRealm {
+	wampRealmsLock.Lock()
+	defer wampRealmsLock.Unlock()
+	realm := wampRealms[name]
+	if realm == nil {
+		realm = &wampRealm{
+			topics:      make(map[string]map[*wampSession]int64),
+			procedures:  make(map[string]*wampRegistration),
+			invocations: make(map[int64]*wampInvocation),
+		}
+		wampRealms[name] = realm
+	}
+	return realm
+}
+
+// nextWampId router范围内的id, 取值在[1, 2^53]
+func nextWampId() int64 {
+	return wampIdSeq.Add(1)%wampMaxId + 1
+}
+
+// Wamp 返回WAMP v2 basic profile路由(broker和dealer)的BizHandler: 支持HELLO/WELCOME/ABORT/GOODBYE、发布订阅、RPC的注册和调用,
+// 只支持json序列化; 同时在conf中登记子协议并包装StateChangeHandler, 需在Get之前调用. 服务端可通过WampPublishEvent推送事件
+func Wamp(conf *WebSocketHandlerConfig, opts *WampOptions) wrapper.HandlerFunc[WebSocketMessage, error] {
+	if opts == nil {
+		opts = &WampOptions{}
+	}
+	conf.Subprotocols = append(conf.Subprotocols, WampSubprotocolJSON)
+
+	stateChangeHandler := conf.StateChangeHandler
+	conf.StateChangeHandler = func(ctx *dgctx.DgContext, from ConnectionState, to ConnectionState) {
+		if to >= ConnectionStateDraining {
+			if value, ok := GetConnState(ctx).values.Load(wampStateKey); ok {
+				session := value.(*wampSession)
+				session.lock.Lock()
+				session.ended = true
+				session.lock.Unlock()
+				session.leave()
+			}
+		}
+		if stateChangeHandler != nil {
+			stateChangeHandler(ctx, from, to)
+		}
+	}
+
+	return func(_ *gin.Context, ctx *dgctx.DgContext, wsm *WebSocketMessage) error {
+		value, _ := GetConnState(ctx).values.LoadOrStore(wampStateKey, &wampSession{dgCtx: ctx, opts: opts})
+		session := value.(*wampSession)
+		var msg []json.RawMessage
+		if wsm.MessageType != websocket.TextMessage || json.Unmarshal(wsm.MessageData, &msg) != nil || len(msg) == 0 {
+			session.abort(WampErrorProtocolViolation, "message must be a json array")
+			return nil
+		}
+		var code int
+		if json.Unmarshal(msg[0], &code) != nil {
+			session.abort(WampErrorProtocolViolation, "invalid message type")
+			return nil
+		}
+		session.handle(code, msg[1:])
+		return nil
+	}
+}
+
+func (s *wampSession) handle(code int, msg []json.RawMessage) {
+	s.lock.Lock()
+	realm := s.realm
+	s.lock.Unlock()
+	if code == WampHello {
+		if realm != nil {
+			s.abort(WampErrorProtocolViolation, "HELLO received after session was established")
+			return
+		}
+		s.hello(msg)
+		return
+	}
+	if realm == nil {
+		s.abort(WampErrorProtocolViolation, "HELLO expected")
+		return
+	}
+
+	var request int64
+	if code != WampGoodbye && code != WampAbort && (len(msg) < 2 || json.Unmarshal(msg[0], &request) != nil) {
+		s.abort(WampErrorProtocolViolation, "invalid message")
+		return
+	}
+	switch code {
+	case WampGoodbye:
+		s.leave()
+		_ = s.send(WampGoodbye, map[string]any{}, WampCloseGoodbyeAndOut)
+	case WampAbort:
+		s.leave()
+		s.close(websocket.CloseNormalClosure, "")
+	case WampPublish:
+		var topic string
+		options := map[string]any{}
+		if len(msg) < 3 || json.Unmarshal(msg[1], &options) != nil || json.Unmarshal(msg[2], &topic) != nil || topic == "" {
+			s.abort(WampErrorProtocolViolation, "invalid PUBLISH message")
+			return
+		}
+		var exclude *wampSession
+		if excludeMe, ok := options["exclude_me"].(bool); !ok || excludeMe {
+			exclude = s
+		}
+		publication := realm.publish(topic, exclude, msg[3:]...)
+		if acknowledge, _ := options["acknowledge"].(bool); acknowledge {
+			_ = s.send(WampPublished, request, publication)
+		}
+	case WampSubscribe:
+		var topic string
+		if len(msg) < 3 || json.Unmarshal(msg[2], &topic) != nil || topic == "" {
+			s.abort(WampErrorProtocolViolation, "invalid SUBSCRIBE message")
+			return
+		}
+		_ = s.send(WampSubscribed, request, s.subscribe(realm, topic))
+	case WampUnsubscribe:
+		var subscription int64
+		if json.Unmarshal(msg[1], &subscription) != nil {
+			s.abort(WampErrorProtocolViolation, "invalid UNSUBSCRIBE message")
+			return
+		}
+		if !s.unsubscribe(realm, subscription) {
+			_ = s.sendError(WampUnsubscribe, request, WampErrorNoSuchSubscription)
+			return
+		}
+		_ = s.send(WampUnsubscribed, request)
+	case WampCall:
+		var procedure string
+		options := map[string]any{}
+		if len(msg) < 3 || json.Unmarshal(msg[1], &options) != nil || json.Unmarshal(msg[2], &procedure) != nil || procedure == "" {
+			s.abort(WampErrorProtocolViolation, "invalid CALL message")
+			return
+		}
+		s.call(realm, request, procedure, options, msg[3:])
+	case WampRegister:
+		var procedure string
+		if len(msg) < 3 || json.Unmarshal(msg[2], &procedure) != nil || procedure == "" {
+			s.abort(WampErrorProtocolViolation, "invalid REGISTER message")
+			return
+		}
+		registration, ok := s.register(realm, procedure)
+		if !ok {
+			_ = s.sendError(WampRegister, request, WampErrorProcedureAlreadyExists)
+			return
+		}
+		_ = s.send(WampRegistered, request, registration)
+	case WampUnregister:
+		var registration int64
+		if json.Unmarshal(msg[1], &registration) != nil {
+			s.abort(WampErrorProtocolViolation, "invalid UNREGISTER message")
+			return
+		}
+		if !s.unregister(realm, registration) {
+			_ = s.sendError(WampUnregister, request, WampErrorNoSuchRegistration)
+			return
+		}
+		_ = s.send(WampUnregistered, request)
+	case WampYield:
+		// [YIELD, INVOCATION.Request, Options, Arguments?, ArgumentsKw?]
+		if invocation := realm.finishInvocation(s, request); invocation != nil {
+			_ = invocation.caller.send(WampResult, append([]any{invocation.callRequest, map[string]any{}}, rawArgs(msg[2:])...)...)
+		}
+	case WampError:
+		// [ERROR, INVOCATION, INVOCATION.Request, Details, Error, Arguments?, ArgumentsKw?], request此时为INVOCATION
+		var invocationRequest int64
+		var uri string
+		if request != WampInvocation || len(msg) < 4 || json.Unmarshal(msg[1], &invocationRequest) != nil || json.Unmarshal(msg[3], &uri) != nil {
+			s.abort(WampErrorProtocolViolation, "invalid ERROR message")
+			return
+		}
+		if invocation := realm.finishInvocation(s, invocationRequest); invocation != nil {
+			_ = invocation.caller.send(WampError, append([]any{WampCall, invocation.callRequest, msg[2], uri}, rawArgs(msg[4:])...)...)
+		}
+	default:
+		s.abort(WampErrorProtocolViolation, "unsupported message type")
+	}
+}
+
+func (s *wampSession) hello(msg []json.RawMessage) {
+	var realmName string
+	details := map[string]any{}
+	if len(msg) < 2 || json.Unmarshal(msg[0], &realmName) != nil || realmName == "" || json.Unmarshal(msg[1], &details) != nil {
+		s.abort(WampErrorProtocolViolation, "invalid HELLO message")
+		return
+	}
+	if s.opts.Realm != "" && realmName != s.opts.Realm {
+		s.abort(WampErrorNoSuchRealm, "no such realm: "+realmName)
+		return
+	}
+	if s.opts.OnHello != nil {
+		if err := s.opts.OnHello(s.dgCtx, realmName, details); err != nil {
+			s.abort(WampErrorNotAuthorized, err.Error())
+			return
+		}
+	}
+
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.id = rand.Int63n(wampMaxId) + 1
+	s.realm = getWampRealm(realmName)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.subscriptions = make(map[int64]string)
+	s.registrations = make(map[int64]string)
+	id := s.id
+	s.lock.Unlock()
+
+	_ = s.send(WampWelcome, id, map[string]any{
+		"roles": map[string]any{"broker": map[string]any{}, "dealer": map[string]any{}},
+	})
+}
+
+// leave 离开realm, 清理订阅和注册; 等待该会话作为callee处理的调用以wamp.error.canceled结束
+func (s *wampSession) leave() {
+	s.lock.Lock()
+	realm := s.realm
+	subscriptions, registrations := s.subscriptions, s.registrations
+	cancel := s.cancel
+	s.realm, s.subscriptions, s.registrations, s.cancel = nil, nil, nil, nil
+	s.lock.Unlock()
+	if realm == nil {
+		return
+	}
+	cancel()
+
+	var canceled []*wampInvocation
+	realm.lock.Lock()
+	for _, topic := range subscriptions {
+		realm.removeSubscriber(topic, s)
+	}
+	for _, procedure := range registrations {
+		delete(realm.procedures, procedure)
+	}
+	for id, invocation := range realm.invocations {
+		if invocation.callee == s || invocation.caller == s {
+			delete(realm.invocations, id)
+			if invocation.callee == s && invocation.caller != s {
+				canceled = append(canceled, invocation)
+			}
+		}
+	}
+	realm.lock.Unlock()
+
+	for _, invocation := range canceled {
+		_ = invocation.caller.sendError(WampCall, invocation.callRequest, WampErrorCanceled)
+	}
+}
+
+// subscribe 同一会话重复订阅同一topic时返回原来的订阅id
+func (s *wampSession) subscribe(realm *wampRealm, topic string) int64 {
+	realm.lock.Lock()
+	defer realm.lock.Unlock()
+	subscribers := realm.topics[topic]
+	if subscribers == nil {
+		subscribers = make(map[*wampSession]int64)
+		realm.topics[topic] = subscribers
+	}
+	if id, ok := subscribers[s]; ok {
+		return id
+	}
+	id := nextWampId()
+	subscribers[s] = id
+	s.lock.Lock()
+	s.subscriptions[id] = topic
+	s.lock.Unlock()
+	return id
+}
+
+func (s *wampSession) unsubscribe(realm *wampRealm, subscription int64) bool {
+	s.lock.Lock()
+	topic, ok := s.subscriptions[subscription]
+	delete(s.subscriptions, subscription)
+	s.lock.Unlock()
+	if !ok {
+		return false
+	}
+	realm.lock.Lock()
+	realm.removeSubscriber(topic, s)
+	realm.lock.Unlock()
+	return true
+}
+
+func (s *wampSession) register(realm *wampRealm, procedure string) (int64, bool) {
+	if _, ok := s.opts.Procedures[procedure]; ok {
+		return 0, false
+	}
+	realm.lock.Lock()
+	defer realm.lock.Unlock()
+	if _, ok := realm.procedures[procedure]; ok {
+		return 0, false
+	}
+	id := nextWampId()
+	realm.procedures[procedure] = &wampRegistration{id: id, callee: s}
+	s.lock.Lock()
+	s.registrations[id] = procedure
+	s.lock.Unlock()
+	return id, true
+}
+
+func (s *wampSession) unregister(realm *wampRealm, registration int64) bool {
+	s.lock.Lock()
+	procedure, ok := s.registrations[registration]
+	delete(s.registrations, registration)
+	s.lock.Unlock()
+	if !ok {
+		return false
+	}
+	realm.lock.Lock()
+	if reg := realm.procedures[procedure]; reg != nil && reg.id == registration {
+		delete(realm.procedures, procedure)
+	}
+	realm.lock.Unlock()
+	return true
+}
+
+// call 优先调用路由的服务端过程, 否则转发INVOCATION给注册了该过程的客户端
+func (s *wampSession) call(realm *wampRealm, request int64, procedure string, options map[string]any, args []json.RawMessage) {
+	if fn, ok := s.opts.Procedures[procedure]; ok {
+		req := &WampInvocationRequest{Procedure: procedure, Options: options}
+		if (len(args) > 0 && decodeWampValue(args[0], &req.Args) != nil) || (len(args) > 1 && decodeWampValue(args[1], &req.Kwargs) != nil) {
+			_ = s.sendError(WampCall, request, WampErrorInvalidArgument)
+			return
+		}
+		s.lock.Lock()
+		ctx := s.ctx
+		s.lock.Unlock()
+		go s.invoke(ctx, fn, request, req)
+		return
+	}
+
+	realm.lock.Lock()
+	reg := realm.procedures[procedure]
+	var invocationId int64
+	if reg != nil {
+		invocationId = nextWampId()
+		realm.invocations[invocationId] = &wampInvocation{caller: s, callRequest: request, callee: reg.callee}
+	}
+	realm.lock.Unlock()
+	if reg == nil {
+		_ = s.sendError(WampCall, request, WampErrorNoSuchProcedure)
+		return
+	}
+	if err := reg.callee.send(WampInvocation, append([]any{invocationId, reg.id, map[string]any{}}, rawArgs(args)...)...); err != nil {
+		if realm.finishInvocation(reg.callee, invocationId) != nil {
+			_ = s.sendError(WampCall, request, WampErrorCanceled)
+		}
+	}
+}
+
+func (s *wampSession) invoke(ctx context.Context, fn WampProcedure, request int64, req *WampInvocationRequest) {
+	res, err := fn(ctx, s.dgCtx, req)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		callErr := &WampCallError{URI: WampErrorRuntime, Args: []any{err.Error()}}
+		if !errors.As(err, &callErr) {
+			dglogger.Errorf(s.dgCtx, "wamp procedure %s error: %v", req.Procedure, err)
+		}
+		_ = s.send(WampError, append([]any{WampCall, request, map[string]any{}, callErr.URI}, wampArgs(callErr.Args, callErr.Kwargs)...)...)
+		return
+	}
+	if res == nil {
+		res = &WampInvocationResult{}
+	}
+	_ = s.send(WampResult, append([]any{request, map[string]any{}}, wampArgs(res.Args, res.Kwargs)...)...)
+}
+
+// abort 发送ABORT后关闭连接
+func (s *wampSession) abort(reason string, message string) {
+	dglogger.Warnf(s.dgCtx, "wamp abort: %s %s", reason, message)
+	_ = s.send(WampAbort, map[string]any{"message": message}, reason)
+	s.leave()
+	s.close(websocket.ClosePolicyViolation, reason)
+}
+
+func (s *wampSession) close(code int, reason string) {
+	if connection := GetConnection(s.dgCtx); connection != nil {
+		connection.closeWith(code, reason)
+	}
+}
+
+func (s *wampSession) sendError(requestType int, request int64, uri string) error {
+	return s.send(WampError, requestType, request, map[string]any{}, uri)
+}
+
+func (s *wampSession) send(code int, fields ...any) error {
+	connection := GetConnection(s.dgCtx)
+	if connection == nil {
+		return ErrConnectionClosed
+	}
+	data, err := json.Marshal(append([]any{code}, fields...))
+	if err != nil {
+		return err
+	}
+	return connection.WriteMessage(websocket.TextMessage, data)
+}
+
+// finishInvocation 只有该调用的callee可以结束调用
+func (r *wampRealm) finishInvocation(callee *wampSession, id int64) *wampInvocation {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	invocation := r.invocations[id]
+	if invocation == nil || invocation.callee != callee {
+		return nil
+	}
+	delete(r.invocations, id)
+	return invocation
+}
+
+// removeSubscriber 调用方持有lock
+func (r *wampRealm) removeSubscriber(topic string, s *wampSession) {
+	subscribers := r.topics[topic]
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(r.topics, topic)
+	}
+}
+
+// publish args为json编码的Arguments和ArgumentsKw, 原样转发给订阅者
+func (r *wampRealm) publish(topic string, exclude *wampSession, args ...json.RawMessage) int64 {
+	publication := nextWampId()
+	r.lock.Lock()
+	subscribers := make(map[*wampSession]int64, len(r.topics[topic]))
+	for s, id := range r.topics[topic] {
+		if s != exclude {
+			subscribers[s] = id
+		}
+	}
+	r.lock.Unlock()
+
+	for s, id := range subscribers {
+		_ = s.send(WampEvent, append([]any{id, publication, map[string]any{}}, rawArgs(args)...)...)
+	}
+	return publication
+}
+
+// WampPublishEvent 服务端向本进程内realm中订阅了topic的会话发布事件, 返回publication id; realm不存在时返回0
+func WampPublishEvent(realm string, topic string, args []any, kwargs map[string]any) (int64, error) {
+	wampRealmsLock.Lock()
+	r := wampRealms[realm]
+	wampRealmsLock.Unlock()
+	if r == nil {
+		return 0, nil
+	}
+	var raw []json.RawMessage
+	for _, v := range wampArgs(args, kwargs) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		raw = append(raw, data)
+	}
+	return r.publish(topic, nil, raw...), nil
+}
+
+// wampArgs 按规范省略空的Arguments和ArgumentsKw, 有ArgumentsKw时Arguments不能省略
+func wampArgs(args []any, kwargs map[string]any) []any {
+	if len(kwargs) > 0 {
+		if args == nil {
+			args = []any{}
+		}
+		return []any{args, kwargs}
+	}
+	if len(args) > 0 {
+		return []any{args}
+	}
+	return nil
+}
+
+func rawArgs(args []json.RawMessage) []any {
+	fields := make([]any, 0, 2)
+	for i := 0; i < len(args) && i < 2; i++ {
+		fields = append(fields, args[i])
+	}
+	return fields
+}
+
+func decodeWampValue(data json.RawMessage, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
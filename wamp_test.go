@@ -0,0 +1,170 @@
+package dgws_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func dialWamp(t *testing.T, url string, realm string) *websocket.Conn {
+	t.Helper()
+	dialer := &websocket.Dialer{Subprotocols: []string{dgws.WampSubprotocolJSON}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if conn.Subprotocol() != dgws.WampSubprotocolJSON {
+		t.Fatalf("unexpected subprotocol: %q", conn.Subprotocol())
+	}
+	writeWamp(t, conn, dgws.WampHello, realm, map[string]any{"roles": map[string]any{}})
+	return conn
+}
+
+func writeWamp(t *testing.T, conn *websocket.Conn, fields ...any) {
+	t.Helper()
+	if err := conn.WriteJSON(fields); err != nil {
+		t.Fatalf("write %v: %v", fields[0], err)
+	}
+}
+
+func readWamp(t *testing.T, conn *websocket.Conn, code int) []any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var msg []any
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read %d: %v", code, err)
+	}
+	if len(msg) == 0 || msg[0] != float64(code) {
+		t.Fatalf("expected %d, got %v", code, msg)
+	}
+	return msg
+}
+
+func TestWampPubSubAndRPC(t *testing.T) {
+	conf := &dgws.WebSocketHandlerConfig{}
+	url := startTestServerWithConfig(t, conf, dgws.Wamp(conf, &dgws.WampOptions{
+		Realm: "realm1",
+		Procedures: map[string]dgws.WampProcedure{
+			"com.example.add": func(_ context.Context, _ *dgctx.DgContext, req *dgws.WampInvocationRequest) (*dgws.WampInvocationResult, error) {
+				a, _ := req.Args[0].(json.Number).Int64()
+				b, _ := req.Args[1].(json.Number).Int64()
+				return &dgws.WampInvocationResult{Args: []any{a + b}}, nil
+			},
+			"com.example.fail": func(context.Context, *dgctx.DgContext, *dgws.WampInvocationRequest) (*dgws.WampInvocationResult, error) {
+				return nil, &dgws.WampCallError{URI: "com.example.error.denied", Kwargs: map[string]any{"why": "nope"}}
+			},
+		},
+	}))
+
+	subscriber := dialWamp(t, url, "realm1")
+	welcome := readWamp(t, subscriber, dgws.WampWelcome)
+	if roles := welcome[2].(map[string]any)["roles"].(map[string]any); roles["broker"] == nil || roles["dealer"] == nil {
+		t.Fatalf("unexpected welcome: %v", welcome)
+	}
+	publisher := dialWamp(t, url, "realm1")
+	readWamp(t, publisher, dgws.WampWelcome)
+
+	writeWamp(t, subscriber, dgws.WampSubscribe, 1, map[string]any{}, "com.example.topic")
+	subscription := readWamp(t, subscriber, dgws.WampSubscribed)[2]
+
+	writeWamp(t, publisher, dgws.WampPublish, 2, map[string]any{"acknowledge": true}, "com.example.topic", []any{"hello"}, map[string]any{"n": 1})
+	if published := readWamp(t, publisher, dgws.WampPublished); published[1] != float64(2) {
+		t.Fatalf("unexpected published: %v", published)
+	}
+	event := readWamp(t, subscriber, dgws.WampEvent)
+	if event[1] != subscription || event[4].([]any)[0] != "hello" || event[5].(map[string]any)["n"] != float64(1) {
+		t.Fatalf("unexpected event: %v", event)
+	}
+	if _, err := dgws.WampPublishEvent("realm1", "com.example.topic", []any{"server"}, nil); err != nil {
+		t.Fatalf("server publish: %v", err)
+	}
+	if event := readWamp(t, subscriber, dgws.WampEvent); len(event) != 5 || event[4].([]any)[0] != "server" {
+		t.Fatalf("unexpected server event: %v", event)
+	}
+
+	writeWamp(t, publisher, dgws.WampCall, 3, map[string]any{}, "com.example.add", []any{2, 3})
+	if res := readWamp(t, publisher, dgws.WampResult); res[1] != float64(3) || res[3].([]any)[0] != float64(5) {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	writeWamp(t, publisher, dgws.WampCall, 4, map[string]any{}, "com.example.fail")
+	if res := readWamp(t, publisher, dgws.WampError); res[1] != float64(dgws.WampCall) || res[2] != float64(4) || res[4] != "com.example.error.denied" || res[6].(map[string]any)["why"] != "nope" {
+		t.Fatalf("unexpected error: %v", res)
+	}
+	writeWamp(t, publisher, dgws.WampCall, 5, map[string]any{}, "com.example.missing")
+	if res := readWamp(t, publisher, dgws.WampError); res[4] != dgws.WampErrorNoSuchProcedure {
+		t.Fatalf("unexpected error: %v", res)
+	}
+
+	// 客户端注册的过程由router转发给callee
+	writeWamp(t, subscriber, dgws.WampRegister, 6, map[string]any{}, "com.example.echo")
+	registration := readWamp(t, subscriber, dgws.WampRegistered)[2]
+	writeWamp(t, publisher, dgws.WampRegister, 7, map[string]any{}, "com.example.echo")
+	if res := readWamp(t, publisher, dgws.WampError); res[4] != dgws.WampErrorProcedureAlreadyExists {
+		t.Fatalf("unexpected error: %v", res)
+	}
+	writeWamp(t, publisher, dgws.WampCall, 8, map[string]any{}, "com.example.echo", []any{"ping"})
+	invocation := readWamp(t, subscriber, dgws.WampInvocation)
+	if invocation[2] != registration || invocation[4].([]any)[0] != "ping" {
+		t.Fatalf("unexpected invocation: %v", invocation)
+	}
+	writeWamp(t, subscriber, dgws.WampYield, invocation[1], map[string]any{}, []any{"pong"})
+	if res := readWamp(t, publisher, dgws.WampResult); res[1] != float64(8) || res[3].([]any)[0] != "pong" {
+		t.Fatalf("unexpected result: %v", res)
+	}
+
+	// callee离开后未完成的调用以canceled结束
+	writeWamp(t, publisher, dgws.WampCall, 9, map[string]any{}, "com.example.echo")
+	readWamp(t, subscriber, dgws.WampInvocation)
+	writeWamp(t, subscriber, dgws.WampGoodbye, map[string]any{}, dgws.WampCloseGoodbyeAndOut)
+	readWamp(t, subscriber, dgws.WampGoodbye)
+	if res := readWamp(t, publisher, dgws.WampError); res[2] != float64(9) || res[4] != dgws.WampErrorCanceled {
+		t.Fatalf("unexpected error: %v", res)
+	}
+	writeWamp(t, publisher, dgws.WampCall, 10, map[string]any{}, "com.example.echo")
+	if res := readWamp(t, publisher, dgws.WampError); res[4] != dgws.WampErrorNoSuchProcedure {
+		t.Fatalf("unexpected error: %v", res)
+	}
+}
+
+func TestWampAbort(t *testing.T) {
+	conf := &dgws.WebSocketHandlerConfig{}
+	url := startTestServerWithConfig(t, conf, dgws.Wamp(conf, &dgws.WampOptions{
+		Realm: "realm1",
+		OnHello: func(_ *dgctx.DgContext, _ string, details map[string]any) error {
+			if details["authid"] == "blocked" {
+				return errors.New("blocked")
+			}
+			return nil
+		},
+	}))
+
+	conn := dialWamp(t, url, "other")
+	if abort := readWamp(t, conn, dgws.WampAbort); abort[2] != dgws.WampErrorNoSuchRealm {
+		t.Fatalf("unexpected abort: %v", abort)
+	}
+	expectClosed(t, conn, websocket.ClosePolicyViolation)
+
+	dialer := &websocket.Dialer{Subprotocols: []string{dgws.WampSubprotocolJSON}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	writeWamp(t, conn, dgws.WampHello, "realm1", map[string]any{"authid": "blocked"})
+	if abort := readWamp(t, conn, dgws.WampAbort); abort[2] != dgws.WampErrorNotAuthorized || abort[1].(map[string]any)["message"] != "blocked" {
+		t.Fatalf("unexpected abort: %v", abort)
+	}
+
+	conn = dialWamp(t, url, "realm1")
+	readWamp(t, conn, dgws.WampWelcome)
+	writeWamp(t, conn, dgws.WampHello, "realm1", map[string]any{})
+	if abort := readWamp(t, conn, dgws.WampAbort); abort[2] != dgws.WampErrorProtocolViolation {
+		t.Fatalf("unexpected abort: %v", abort)
+	}
+}
@@ -0,0 +1,282 @@
+package webhookbridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/darwinOrg/go-common/constants"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dglogger "github.com/darwinOrg/go-logger"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/kafkabridge"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// SignatureHeader 请求体的HMAC-SHA256签名, 格式为"sha256=<hex>", 见Sign
+	SignatureHeader = "X-Dgws-Signature"
+	// TimestampHeader 签名时的unix秒, 接收方可据此拒绝重放的请求
+	TimestampHeader = "X-Dgws-Timestamp"
+
+	defaultMaxAttempts     = 3
+	defaultBackoff         = 500 * time.Millisecond
+	defaultMaxBackoff      = 30 * time.Second
+	defaultTimeout         = 10 * time.Second
+	maxWriteBackBodyLength = 1 << 20
+)
+
+var ErrEmptyURL = errors.New("webhook url template produced an empty url")
+
+// InboundMessage 与kafkabridge的消息格式一致, 作为webhook的请求体和URL模板的数据
+type InboundMessage = kafkabridge.InboundMessage
+
+type Options struct {
+	// URL text/template模板, 数据为InboundMessage, 如"https://legacy/api/{{.BizKey}}/{{pathEscape .BizId}}";
+	// 可使用pathEscape和queryEscape函数
+	URL string
+	// Method 默认POST
+	Method string
+	Header http.Header
+	// Secret 不为空时对请求签名, 见Sign
+	Secret string
+	// Client 默认使用Timeout为10s的http.Client
+	Client *http.Client
+	// MaxAttempts 网络错误、5xx和429时共尝试的次数, 默认3; 其他非2xx响应不重试
+	MaxAttempts int
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍, 不超过MaxBackoff; 默认500ms和30s
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DeadLetterSink 投递失败的消息
+	DeadLetterSink dgws.DeadLetterSink
+	// WriteBack 2xx响应的响应体不为空时回写给客户端, Content-Type为application/octet-stream时作为二进制消息
+	WriteBack bool
+}
+
+// Bridge 把websocket消息转发给只支持http的后端
+type Bridge struct {
+	opts *Options
+	url  *template.Template
+}
+
+// StatusError webhook返回了非2xx响应
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+func New(opts *Options) (*Bridge, error) {
+	tmpl, err := template.New("url").Option("missingkey=error").Funcs(template.FuncMap{
+		"pathEscape":  url.PathEscape,
+		"queryEscape": url.QueryEscape,
+	}).Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bridge{opts: opts, url: tmpl}, nil
+}
+
+// Sign 签名内容为"<timestamp>.<body>", 接收方以相同的secret计算后与SignatureHeader比较
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验请求的签名, 供接收webhook的服务使用
+func Verify(secret string, r *http.Request, body []byte) bool {
+	expected := Sign(secret, r.Header.Get(TimestampHeader), body)
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get(SignatureHeader)))
+}
+
+// Inbound 包装BizHandler, 先把消息投递到webhook再交给next, next为nil时只投递;
+// 重试耗尽后交给DeadLetterSink并返回错误, 此时不调用next. 重试已在内部完成, 不需要再配置WebSocketHandlerConfig.Retry
+func (b *Bridge) Inbound(next wrapper.HandlerFunc[dgws.WebSocketMessage, error]) wrapper.HandlerFunc[dgws.WebSocketMessage, error] {
+	return func(c *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		msg := &InboundMessage{
+			UserId:      ctx.UserId,
+			RemoteIp:    ctx.RemoteIp,
+			TraceId:     ctx.TraceId,
+			MessageType: wsm.MessageType,
+			Data:        wsm.CopyData(),
+			ReceivedAt:  time.Now(),
+		}
+		connection := dgws.GetConnection(ctx)
+		if connection != nil {
+			msg.ConnectionId = connection.Id
+			msg.SessionId = connection.SessionId
+			msg.Path = connection.Path
+			msg.BizKey = connection.BizKey
+			msg.BizId = connection.BizId
+		}
+
+		resp, attempts, err := b.deliver(ctx, msg)
+		if err != nil {
+			dglogger.Errorf(ctx, "[%s: %s] webhook delivery failed after %d attempts: %v", msg.BizKey, msg.BizId, attempts, err)
+			b.deadLetter(ctx, msg, attempts, err)
+			return err
+		}
+
+		if b.opts.WriteBack && len(resp.body) > 0 && connection != nil {
+			mt := websocket.TextMessage
+			if resp.contentType == "application/octet-stream" {
+				mt = websocket.BinaryMessage
+			}
+			if err := connection.WriteMessage(mt, resp.body); err != nil {
+				dglogger.Warnf(ctx, "[%s: %s] write webhook response error: %v", msg.BizKey, msg.BizId, err)
+			}
+		}
+
+		if next != nil {
+			return next(c, ctx, wsm)
+		}
+		return nil
+	}
+}
+
+type webhookResponse struct {
+	contentType string
+	body        []byte
+}
+
+// deliver 返回最后一次的结果和尝试次数
+func (b *Bridge) deliver(ctx *dgctx.DgContext, msg *InboundMessage) (*webhookResponse, int, error) {
+	var target strings.Builder
+	if err := b.url.Execute(&target, msg); err != nil {
+		return nil, 0, err
+	}
+	if target.Len() == 0 {
+		return nil, 0, ErrEmptyURL
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attempts := b.opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultMaxAttempts
+	}
+	backoff := b.opts.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	maxBackoff := b.opts.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	innerCtx := innerContext(ctx)
+	for i := 1; ; i++ {
+		resp, retryable, err := b.post(innerCtx, ctx, target.String(), body)
+		if err == nil {
+			return resp, i, nil
+		}
+		if !retryable || i >= attempts {
+			return nil, i, err
+		}
+		dglogger.Warnf(ctx, "[%s: %s] webhook delivery error, attempt %d/%d: %v", msg.BizKey, msg.BizId, i, attempts, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-innerCtx.Done():
+			timer.Stop()
+			return nil, i, innerCtx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post 返回的bool表示错误是否可以重试
+func (b *Bridge) post(innerCtx context.Context, ctx *dgctx.DgContext, target string, body []byte) (*webhookResponse, bool, error) {
+	method := b.opts.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(innerCtx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	for key, values := range b.opts.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ctx.TraceId != "" {
+		req.Header.Set(constants.TraceId, ctx.TraceId)
+	}
+	if b.opts.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign(b.opts.Secret, timestamp, body))
+	}
+
+	client := b.opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, innerCtx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxWriteBackBodyLength))
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return &webhookResponse{contentType: strings.TrimSpace(contentType), body: respBody}, false, nil
+}
+
+func (b *Bridge) deadLetter(ctx *dgctx.DgContext, msg *InboundMessage, attempts int, err error) {
+	if b.opts.DeadLetterSink == nil {
+		return
+	}
+	letter := &dgws.DeadLetter{
+		ConnectionId: msg.ConnectionId,
+		BizKey:       msg.BizKey,
+		BizId:        msg.BizId,
+		UserId:       msg.UserId,
+		TraceId:      msg.TraceId,
+		MessageType:  msg.MessageType,
+		MessageData:  msg.Data,
+		Attempts:     attempts,
+		Error:        err.Error(),
+		FailedAt:     time.Now(),
+	}
+	if serr := b.opts.DeadLetterSink.Send(ctx, letter); serr != nil {
+		dglogger.Errorf(ctx, "[%s: %s] send dead letter error: %v", msg.BizKey, msg.BizId, serr)
+	}
+}
+
+func innerContext(ctx *dgctx.DgContext) context.Context {
+	if ctx.InnerContext() != nil {
+		return ctx.InnerContext()
+	}
+	return context.Background()
+}
@@ -0,0 +1,149 @@
+package webhookbridge_test
+
+import (
+	"encoding/json"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/webhookbridge"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, bizHandler wrapper.HandlerFunc[dgws.WebSocketMessage, error]) string {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/edge"),
+		NonLogin:    true,
+		BizHandler:  bizHandler,
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "room",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("room")
+		},
+	})
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/edge"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestBridgeWriteBack(t *testing.T) {
+	var calls atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhookbridge.Verify("s3cret", r, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// 第一次返回503, 验证重试
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		msg := &webhookbridge.InboundMessage{}
+		if err := json.Unmarshal(body, msg); err != nil || r.URL.EscapedPath() != "/hooks/room/r%201" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("ack:" + string(msg.Data)))
+	}))
+	t.Cleanup(webhook.Close)
+
+	bridge, err := webhookbridge.New(&webhookbridge.Options{
+		URL:       webhook.URL + "/hooks/{{.BizKey}}/{{pathEscape .BizId}}",
+		Secret:    "s3cret",
+		Backoff:   10 * time.Millisecond,
+		WriteBack: true,
+	})
+	if err != nil {
+		t.Fatalf("new bridge: %v", err)
+	}
+	handled := make(chan string, 1)
+	conn := dial(t, startServer(t, bridge.Inbound(func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		handled <- string(wsm.MessageData)
+		return nil
+	}))+"?room=r%201")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "ack:hello" {
+		t.Fatalf("unexpected write back %q, error: %v", data, err)
+	}
+	select {
+	case msg := <-handled:
+		if msg != "hello" {
+			t.Fatalf("unexpected handled message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not handled")
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 webhook calls, got %d", calls.Load())
+	}
+}
+
+func TestBridgeDeadLetter(t *testing.T) {
+	var calls atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("bad payload"))
+	}))
+	t.Cleanup(webhook.Close)
+
+	letters := make(chan *dgws.DeadLetter, 1)
+	bridge, err := webhookbridge.New(&webhookbridge.Options{
+		URL:     webhook.URL,
+		Backoff: 10 * time.Millisecond,
+		DeadLetterSink: dgws.DeadLetterFunc(func(_ *dgctx.DgContext, letter *dgws.DeadLetter) error {
+			letters <- letter
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatalf("new bridge: %v", err)
+	}
+	conn := dial(t, startServer(t, bridge.Inbound(nil))+"?room=r2")
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("lost")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case letter := <-letters:
+		if letter.BizId != "r2" || string(letter.MessageData) != "lost" || letter.Attempts != 1 || !strings.Contains(letter.Error, "bad payload") {
+			t.Fatalf("unexpected dead letter: %+v", letter)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("dead letter not sent")
+	}
+	// 4xx不重试
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 webhook call, got %d", calls.Load())
+	}
+
+	var statusErr *webhookbridge.StatusError
+	if _, err := webhookbridge.New(&webhookbridge.Options{URL: "{{.Missing"}); err == nil || errors.As(err, &statusErr) {
+		t.Fatalf("expected template error, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package webtransport 实验性的WebTransport(HTTP/3)传输, 与websocket路由共用WebSocketHandlerConfig和BizHandler.
+//
+// 会话建立后客户端打开一个双向流并先写入open帧, 之后双方在该流上收发消息帧:
+// 1字节类型(0 open, 1 文本, 2 二进制) + 4字节大端长度 + 数据; 关闭码和原因通过会话的CloseWithError传递.
+package webtransport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	frameOpen   = 0
+	frameHeader = 5
+
+	defaultAcceptTimeout = 10 * time.Second
+	closeReasonWait      = time.Second
+)
+
+var (
+	ErrMessageTooBig = errors.New("webtransport message exceeds max message size")
+	ErrFrameType     = errors.New("unknown webtransport frame type")
+)
+
+type upgradeKey struct{}
+
+type upgradeTarget struct {
+	server *webtransport.Server
+	w      http.ResponseWriter
+}
+
+// Server HTTP/3服务, handler通常为注册了Get路由的gin engine, 也可与TCP上的websocket服务共用同一个engine
+type Server struct {
+	wt *webtransport.Server
+}
+
+// NewServer checkOrigin为nil时要求Origin与Host一致
+func NewServer(addr string, tlsConfig *tls.Config, handler http.Handler, checkOrigin func(r *http.Request) bool) *Server {
+	s := &Server{}
+	s.wt = &webtransport.Server{
+		H3: http3.Server{
+			Addr:      addr,
+			TLSConfig: tlsConfig,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Upgrade需要http3原始的ResponseWriter, gin包装后无法使用
+				handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), upgradeKey{}, &upgradeTarget{server: s.wt, w: w})))
+			}),
+		},
+		CheckOrigin: checkOrigin,
+	}
+	return s
+}
+
+func (s *Server) ListenAndServe() error {
+	return s.wt.ListenAndServe()
+}
+
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.wt.Serve(conn)
+}
+
+func (s *Server) Close() error {
+	return s.wt.Close()
+}
+
+// Get 以CONNECT方法注册WebTransport路由, 请求需经过NewServer创建的Server; conf的用法和限制见dgws.HandleTransport,
+// MaxMessageSize限制单条消息大小, UpgradeTimeout为等待客户端打开消息流的时间(默认10s)
+func Get(rh *wrapper.RequestHolder[dgws.WebSocketMessage, error], conf *dgws.WebSocketHandlerConfig) {
+	dgws.HandleTransport(rh, conf, http.MethodConnect, func(c *gin.Context) (dgws.Transport, error) {
+		target, ok := c.Request.Context().Value(upgradeKey{}).(*upgradeTarget)
+		if !ok {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return nil, errors.New("request is not served by webtransport.Server")
+		}
+		session, err := target.server.Upgrade(target.w, c.Request)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return nil, err
+		}
+
+		acceptTimeout := conf.UpgradeTimeout
+		if acceptTimeout <= 0 {
+			acceptTimeout = defaultAcceptTimeout
+		}
+		ctx, cancel := context.WithTimeout(session.Context(), acceptTimeout)
+		defer cancel()
+		stream, err := session.AcceptStream(ctx)
+		if err != nil {
+			_ = session.CloseWithError(webtransport.SessionErrorCode(websocket.CloseProtocolError), "message stream expected")
+			return nil, err
+		}
+
+		maxMessageSize := conf.MaxMessageSize
+		if maxMessageSize <= 0 {
+			maxMessageSize = dgws.GetDefaults().MaxMessageSize
+		}
+		return newConn(session, stream, maxMessageSize), nil
+	})
+}
+
+// Conn 服务端和客户端共用的消息连接, 实现dgws.Transport
+type Conn struct {
+	session        *webtransport.Session
+	stream         *webtransport.Stream
+	reader         *bufio.Reader
+	writeLock      sync.Mutex
+	maxMessageSize int64
+}
+
+func newConn(session *webtransport.Session, stream *webtransport.Stream, maxMessageSize int64) *Conn {
+	return &Conn{session: session, stream: stream, reader: bufio.NewReader(stream), maxMessageSize: maxMessageSize}
+}
+
+// Dial 建立会话并打开消息流; dialer为nil时使用默认配置
+func Dial(ctx context.Context, url string, header http.Header, dialer *webtransport.Dialer) (*Conn, error) {
+	if dialer == nil {
+		dialer = &webtransport.Dialer{}
+	}
+	resp, session, err := dialer.Dial(ctx, url, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("webtransport handshake status %d: %w", resp.StatusCode, err)
+		}
+		return nil, err
+	}
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		_ = session.CloseWithError(0, "")
+		return nil, err
+	}
+
+	c := newConn(session, stream, 0)
+	// 流的首次写入才会通知对端, 服务端据此接受消息流
+	if err := c.writeFrame(frameOpen, nil); err != nil {
+		_ = session.CloseWithError(0, "")
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadMessage 对端关闭会话时返回*webtransport.SessionError, ErrorCode即关闭码
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	for {
+		var header [frameHeader]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return -1, nil, c.readError(err)
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if c.maxMessageSize > 0 && int64(size) > c.maxMessageSize {
+			_ = c.Close(websocket.CloseMessageTooBig, "message too big")
+			return -1, nil, ErrMessageTooBig
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return -1, nil, c.readError(err)
+		}
+
+		switch header[0] {
+		case frameOpen:
+			continue
+		case websocket.TextMessage, websocket.BinaryMessage:
+			return int(header[0]), data, nil
+		default:
+			_ = c.Close(websocket.CloseProtocolError, "unknown frame type")
+			return -1, nil, ErrFrameType
+		}
+	}
+}
+
+// readError 对端关闭会话时流先于关闭原因到达, 稍等会话结束后返回会话的关闭原因
+func (c *Conn) readError(err error) error {
+	select {
+	case <-c.session.Context().Done():
+	case <-time.After(closeReasonWait):
+		return err
+	}
+	// 会话结束后OpenUniStream必然返回关闭原因
+	if _, openErr := c.session.OpenUniStream(); openErr != nil {
+		var sessionErr *webtransport.SessionError
+		if errors.As(openErr, &sessionErr) {
+			return sessionErr
+		}
+	}
+	return err
+}
+
+// WriteMessage 只支持文本和二进制消息
+func (c *Conn) WriteMessage(mt int, data []byte) error {
+	if mt != websocket.TextMessage && mt != websocket.BinaryMessage {
+		return ErrFrameType
+	}
+	return c.writeFrame(byte(mt), data)
+}
+
+func (c *Conn) writeFrame(ft byte, data []byte) error {
+	frame := make([]byte, frameHeader+len(data))
+	frame[0] = ft
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	copy(frame[frameHeader:], data)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.stream.Write(frame)
+	return err
+}
+
+// Close 以code和reason关闭会话, 重复调用无效
+func (c *Conn) Close(code int, reason string) error {
+	return c.session.CloseWithError(webtransport.SessionErrorCode(code), reason)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
@@ -0,0 +1,142 @@
+package webtransport_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	dgwt "github.com/darwinOrg/go-websocket/webtransport"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/webtransport-go"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestGet(t *testing.T) {
+	started := make(chan struct{})
+	ended := make(chan struct{})
+	engine := gin.New()
+	dgwt.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/wt"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+			return dgws.GetConnection(ctx).WriteMessage(wsm.MessageType, bytes.ToUpper(wsm.MessageData))
+		},
+	}, &dgws.WebSocketHandlerConfig{
+		BizKey: "bizId",
+		GetBizIdHandler: func(c *gin.Context) string {
+			return c.Query("bizId")
+		},
+		StartHandler: func(_ *gin.Context, _ *dgctx.DgContext, conn *websocket.Conn) error {
+			if conn != nil {
+				t.Error("websocket conn should be nil")
+			}
+			close(started)
+			return nil
+		},
+		EndCallbackHandler: func(_ *dgctx.DgContext, _ *websocket.Conn) error {
+			close(ended)
+			return nil
+		},
+	})
+
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := dgwt.NewServer("", selfSignedTLS(t), engine, func(*http.Request) bool { return true })
+	go server.Serve(udpConn)
+	t.Cleanup(func() { _ = server.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bizId := uuid.NewString()
+	conn, err := dgwt.Dial(ctx, "https://"+udpConn.LocalAddr().String()+"/wt?bizId="+bizId, nil,
+		&webtransport.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("start handler not called")
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if mt, data, err := conn.ReadMessage(); err != nil || mt != websocket.TextMessage || string(data) != "HELLO" {
+		t.Fatalf("unexpected echo %d %q, error: %v", mt, data, err)
+	}
+
+	sent, err := dgws.BroadcastToBizIds("bizId", []string{bizId}, websocket.BinaryMessage, []byte{1, 2, 3})
+	if err != nil || sent != 1 {
+		t.Fatalf("broadcast sent %d, error: %v", sent, err)
+	}
+	if mt, data, err := conn.ReadMessage(); err != nil || mt != websocket.BinaryMessage || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected broadcast %d %v, error: %v", mt, data, err)
+	}
+
+	if kicked, err := dgws.Kick(&dgctx.DgContext{TraceId: uuid.NewString()}, &dgws.KickOptions{BizKey: "bizId", BizIds: []string{bizId}, Reason: "bye"}); err != nil || kicked != 1 {
+		t.Fatalf("kicked %d connections, error: %v", kicked, err)
+	}
+	_, _, err = conn.ReadMessage()
+	var sessionErr *webtransport.SessionError
+	if !errors.As(err, &sessionErr) || sessionErr.ErrorCode != websocket.ClosePolicyViolation || sessionErr.Message != "bye" {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	select {
+	case <-ended:
+	case <-time.After(3 * time.Second):
+		t.Fatal("end callback not called")
+	}
+}
+
+func TestGetWithoutServer(t *testing.T) {
+	engine := gin.New()
+	dgwt.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/wt"),
+		NonLogin:    true,
+		BizHandler: func(*gin.Context, *dgctx.DgContext, *dgws.WebSocketMessage) error {
+			return nil
+		},
+	}, &dgws.WebSocketHandlerConfig{GetBizIdHandler: func(*gin.Context) string { return "" }})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodConnect, "/wt", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package dgws
+
+import (
+	"sync"
+)
+
+const defaultMaxPendingPerConn = 256
+
+// WorkerPool 固定数量的worker在所有连接间共享; 每个连接的消息按顺序处理,
+// 各连接轮流获得worker, 单个繁忙连接无法独占处理能力
+type WorkerPool struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	ready   []*connQueue
+	size    int
+	pending int
+}
+
+type connQueue struct {
+	pool      *WorkerPool
+	lock      sync.Mutex
+	tasks     []func()
+	scheduled bool
+	slots     chan struct{}
+	wg        sync.WaitGroup
+}
+
+var workerPool *WorkerPool
+
+// InitWorkerPool 初始化全局worker池, maxPendingPerConn为单连接最多排队的消息数, 超过后读循环阻塞
+func InitWorkerPool(size int, maxPendingPerConn int) {
+	if maxPendingPerConn <= 0 {
+		maxPendingPerConn = defaultMaxPendingPerConn
+	}
+	workerPool = NewWorkerPool(size, maxPendingPerConn)
+}
+
+func NewWorkerPool(size int, maxPendingPerConn int) *WorkerPool {
+	p := &WorkerPool{size: size, pending: maxPendingPerConn}
+	p.cond = sync.NewCond(&p.lock)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) newQueue() *connQueue {
+	return &connQueue{pool: p, slots: make(chan struct{}, p.pending)}
+}
+
+func (p *WorkerPool) schedule(q *connQueue) {
+	p.lock.Lock()
+	p.ready = append(p.ready, q)
+	p.lock.Unlock()
+	p.cond.Signal()
+}
+
+func (p *WorkerPool) work() {
+	for {
+		p.lock.Lock()
+		for len(p.ready) == 0 {
+			p.cond.Wait()
+		}
+		q := p.ready[0]
+		p.ready[0] = nil
+		p.ready = p.ready[1:]
+		p.lock.Unlock()
+
+		q.runOne()
+	}
+}
+
+func (q *connQueue) submit(task func()) {
+	q.slots <- struct{}{}
+	q.wg.Add(1)
+
+	q.lock.Lock()
+	q.tasks = append(q.tasks, task)
+	schedule := !q.scheduled
+	q.scheduled = true
+	q.lock.Unlock()
+
+	if schedule {
+		q.pool.schedule(q)
+	}
+}
+
+// runOne 每次只执行一个任务, 还有剩余任务时排到就绪队列末尾, 保证连接间公平
+func (q *connQueue) runOne() {
+	q.lock.Lock()
+	task := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	q.lock.Unlock()
+
+	task()
+	<-q.slots
+	q.wg.Done()
+
+	q.lock.Lock()
+	more := len(q.tasks) > 0
+	if !more {
+		q.scheduled = false
+	}
+	q.lock.Unlock()
+
+	if more {
+		q.pool.schedule(q)
+	}
+}
+
+func (q *connQueue) wait() {
+	q.wg.Wait()
+}
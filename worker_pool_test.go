@@ -0,0 +1,70 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolKeepsConnectionOrder(t *testing.T) {
+	dgws.InitWorkerPool(4, 8)
+
+	received := make(chan string, 100)
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{EnableWorkerPool: true}, func(_ *gin.Context, _ *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		received <- string(wsm.MessageData)
+		return nil
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case msg := <-received:
+			if msg != strconv.Itoa(i) {
+				t.Fatalf("expected message %d, got %s", i, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %d not handled", i)
+		}
+	}
+}
+
+func TestIdleConnectionGoroutines(t *testing.T) {
+	url := startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{PingPeriod: time.Second}, func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		return nil
+	})
+
+	const connections = 50
+	before := runtime.NumGoroutine()
+	for i := 0; i < connections; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=idle", nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	waitConnections(t, "idle", connections)
+	time.Sleep(100 * time.Millisecond)
+
+	if delta := runtime.NumGoroutine() - before; delta > connections+10 {
+		t.Fatalf("%d idle connections use %d goroutines", connections, delta)
+	}
+}
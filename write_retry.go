@@ -0,0 +1,76 @@
+package dgws
+
+import (
+	"bufio"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// retryConn 写超时后按最近一次设置的写等待时长延长deadline, 从中断处继续写出剩余字节, 因此帧不会被截断;
+// websocket.Conn遇到任何写错误都会永久失败, 重试只能在它之下进行
+type retryConn struct {
+	net.Conn
+	maxRetries int
+	wait       atomic.Int64
+}
+
+func (c *retryConn) SetWriteDeadline(t time.Time) error {
+	if t.IsZero() {
+		c.wait.Store(0)
+	} else {
+		c.wait.Store(int64(time.Until(t)))
+	}
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *retryConn) Write(p []byte) (int, error) {
+	written := 0
+	for attempts := 0; ; attempts++ {
+		n, err := c.Conn.Write(p[written:])
+		written += n
+		if err == nil || attempts >= c.maxRetries || !isTimeout(err) {
+			return written, err
+		}
+
+		wait := time.Duration(c.wait.Load())
+		if wait <= 0 {
+			return written, err
+		}
+		if derr := c.Conn.SetWriteDeadline(time.Now().Add(wait)); derr != nil {
+			return written, err
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// hijackResponseWriter 替换Upgrader劫持到的net.Conn, 用于写超时重试和统计实际写出的字节数
+type hijackResponseWriter struct {
+	gin.ResponseWriter
+	maxWriteRetries int
+	counting        bool
+	wire            *countingConn
+}
+
+func (w *hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.ResponseWriter.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.maxWriteRetries > 0 {
+		conn = &retryConn{Conn: conn, maxRetries: w.maxWriteRetries}
+	}
+	if w.counting {
+		w.wire = &countingConn{Conn: conn}
+		conn = w.wire
+	}
+
+	return conn, brw, nil
+}
@@ -0,0 +1,100 @@
+package dgws_test
+
+import (
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+const floodMessageSize = 4 << 20
+
+func startFloodServer(t *testing.T, maxWriteRetries int, results chan error, states chan dgws.ConnectionState) string {
+	payload := make([]byte, floodMessageSize)
+	return startTestServerWithConfig(t, &dgws.WebSocketHandlerConfig{
+		WriteWait:       50 * time.Millisecond,
+		MaxWriteRetries: maxWriteRetries,
+		StateChangeHandler: func(_ *dgctx.DgContext, _ dgws.ConnectionState, to dgws.ConnectionState) {
+			if states != nil {
+				states <- to
+			}
+		},
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+		connection := dgws.GetConnection(ctx)
+		for i := 0; i < 10; i++ {
+			if err := connection.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+				results <- err
+				return err
+			}
+		}
+		results <- nil
+		return nil
+	})
+}
+
+func TestWriteRetrySlowReader(t *testing.T) {
+	results := make(chan error, 1)
+	url := startFloodServer(t, 20, results, nil)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("flood")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// 暂停读取, 服务端写入超过WriteWait后依靠重试继续
+	time.Sleep(200 * time.Millisecond)
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for i := 0; i < 10; i++ {
+		if _, data, err := conn.ReadMessage(); err != nil || len(data) != floodMessageSize {
+			t.Fatalf("read message %d: %d bytes, %v", i, len(data), err)
+		}
+	}
+	select {
+	case err := <-results:
+		if err != nil {
+			t.Fatalf("write failed despite retries: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes not finished")
+	}
+}
+
+func TestWriteFailureClosesConnection(t *testing.T) {
+	results := make(chan error, 1)
+	states := make(chan dgws.ConnectionState, 10)
+	url := startFloodServer(t, 1, results, states)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("flood")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case err := <-results:
+		if err == nil {
+			t.Fatal("expected write error from stalled reader")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write error not reported")
+	}
+	for {
+		select {
+		case state := <-states:
+			if state == dgws.ConnectionStateClosed {
+				return
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("connection not closed after write failure")
+		}
+	}
+}
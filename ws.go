@@ -1,9 +1,9 @@
 package dgws
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	dgcoll "github.com/darwinOrg/go-common/collection"
 	dgctx "github.com/darwinOrg/go-common/context"
 	dgerr "github.com/darwinOrg/go-common/enums/error"
 	"github.com/darwinOrg/go-common/result"
@@ -12,21 +12,37 @@ import (
 	"github.com/darwinOrg/go-web/wrapper"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/rolandhe/saber/gocc"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type GetBizIdHandler func(c *gin.Context) string
 type StartHandler func(c *gin.Context, ctx *dgctx.DgContext, conn *websocket.Conn) error
 type IsEndedHandler func(ctx *dgctx.DgContext, mt int, data []byte) bool
 type EndCallbackHandler func(ctx *dgctx.DgContext, conn *websocket.Conn) error
+type OnConnectHandler func(ctx *dgctx.DgContext, conn *websocket.Conn)
+type OnDisconnectHandler func(ctx *dgctx.DgContext, conn *websocket.Conn, closeCode int, reason string)
 
+// WebSocketMessage 未开启EnableMessagePool时MessageData归BizHandler所有, 可任意保留;
+// 开启后MessageData和消息本身在BizHandler返回后会被复用, 需要保留时调用CopyData拷贝或Retain接管所有权
 type WebSocketMessage struct {
+	// Connection 底层连接, 直接写入与框架内的写入并发是不安全的, 回写消息时使用GetSafeConn或GetConnection
 	Connection  *websocket.Conn
 	MessageType int
 	MessageData []byte
+	buf         *bytes.Buffer
+	retained    bool
+}
+
+func (wsm *WebSocketMessage) CopyData() []byte {
+	return append([]byte(nil), wsm.MessageData...)
+}
+
+// Retain 阻止框架回收该消息及其缓冲区, 调用后消息可在BizHandler返回后继续使用, 需在BizHandler返回前调用
+func (wsm *WebSocketMessage) Retain() {
+	wsm.retained = true
 }
 
 type WebSocketHandlerConfig struct {
@@ -35,8 +51,74 @@ type WebSocketHandlerConfig struct {
 	StartHandler       StartHandler
 	IsEndedHandler     IsEndedHandler
 	EndCallbackHandler EndCallbackHandler
+	PongWait           time.Duration
+	WriteWait          time.Duration
+	PingPeriod         time.Duration
+	// MaxPingPeriod 大于PingPeriod时按RTT和pong丢失情况在两者之间自适应调整ping间隔, 需小于PongWait
+	MaxPingPeriod time.Duration
+	// MaxMissedPongs 大于0且开启ping时取代PongWait读超时, 连续超过该次数的ping未收到pong才关闭连接,
+	// 避免GC或网络抖动导致单个pong延迟就断开
+	MaxMissedPongs int
+	// MissedPongHandler 每次发现ping未收到pong时回调, 需设置MaxMissedPongs
+	MissedPongHandler MissedPongHandler
+	UpgradeTimeout    time.Duration
+	// MaxMessageSize 单条消息的最大字节数, 超过时以1009关闭连接, 见OnMessageTooBig
+	MaxMessageSize int64
+	AcquireTimeout time.Duration
+	// MaxPendingBytes 单连接已读取但未处理完的消息总字节数上限, 超过后关闭连接, 0表示不限制
+	MaxPendingBytes int64
+	// MaxWriteRetries 写超过WriteWait时延长deadline继续写出剩余数据的最大次数, 仍失败则关闭连接并把错误返回给写入方
+	MaxWriteRetries int
+	// EnableMessagePool 开启后读缓冲区和WebSocketMessage会被复用, MessageData仅在BizHandler执行期间有效,
+	// 需要在BizHandler返回后继续使用时调用WebSocketMessage.CopyData或Retain
+	EnableMessagePool bool
+	// EnableWorkerPool 开启后BizHandler在InitWorkerPool创建的全局worker池中执行, 同一连接的消息仍按顺序处理
+	EnableWorkerPool bool
+	// PartitionKey 开启EnableWorkerPool时按该key而不是连接保证顺序, 不同key的消息并行处理, 见PartitionByEnvelopeField;
+	// 此时BizHandler收到的是连接ctx的副本, 对ctx字段和extra的修改只对当前消息可见, 连接状态仍通过GetConnState共享;
+	// 不作用于BatchHandler
+	PartitionKey PartitionKeyFunc
+	// BatchHandler 设置后替代BizHandler, 处理上一批期间到达的消息会合并成一批交给它, 减少突发消息的调度开销
+	BatchHandler BatchHandler
+	// MaxBatchSize 单批最多的消息数, 默认64
+	MaxBatchSize int
+	// Compression 不为nil时与客户端协商permessage-deflate, 并按路由统计压缩效果, 见GetCompressionStats
+	Compression *CompressionOptions
+	// Ack 不为nil时可通过Connection.SendReliable发送需要客户端确认的消息, 客户端的ack消息不会交给BizHandler
+	Ack *AckOptions
+	// Session 不为nil时开启会话恢复, 同时开启Ack(未设置Ack时使用默认值)
+	Session *SessionOptions
+	// Dedup 不为nil时带Id的Envelope消息只会被BizHandler成功处理一次
+	Dedup *DedupOptions
+	// Idempotency 不为nil时解析Envelope中的幂等键, 见GetIdempotencyKey和Respond
+	Idempotency *IdempotencyOptions
+	// Retry 不为nil时BizHandler失败会重试, 重试耗尽后交给DeadLetterSink; 不作用于BatchHandler
+	Retry *RetryOptions
+	// StateChangeHandler 连接状态迁移时回调, 见ConnectionState
+	StateChangeHandler ConnectionStateChangeHandler
+	// NotifyClientState 为true时连接状态迁移(closed除外)以state信封通知客户端
+	NotifyClientState bool
+	// Journal 不为nil时记录选中连接收发的数据帧, 见ReplayJournal
+	Journal *JournalOptions
+	// Redirect 不为nil时bizId不属于本节点的连接会被通知重连到归属节点, 不进入BizHandler;
+	// GetSSE和HandleTransport的请求以307重定向
+	Redirect *RedirectOptions
+	// Subprotocols 服务端支持的子协议, 按顺序选择第一个客户端也支持的, 见websocket.Conn.Subprotocol
+	Subprotocols []string
+	// OnConnect 连接注册后、读取第一条消息前回调一次, StartHandler返回错误的连接不会回调
+	OnConnect OnConnectHandler
+	// OnDisconnect 回调过OnConnect的连接结束时回调一次, 在处理中的BizHandler返回之后; 不论对端关闭、读取出错还是服务端关闭都会回调.
+	// closeCode和reason取服务端发出的close帧, 其次取客户端的close帧, 都没有时为1006和读取的错误
+	OnDisconnect OnDisconnectHandler
+	// OnPanic BizHandler或BatchHandler panic时在记录堆栈后回调, 之后连接以1011(internal error)关闭;
+	// 不论是否设置都会recover, 避免处理消息的goroutine带崩整个进程
+	OnPanic OnPanicHandler
+	// OnMessageTooBig 不为nil时消息超过MaxMessageSize先回调, 返回的内容作为文本消息发送后再以1009关闭连接;
+	// 为nil时由gorilla直接发送不带原因的1009. 可使用DefaultMessageTooBigHandler
+	OnMessageTooBig MessageTooBigHandler
 }
 
+// Deprecated: 连接状态已统一存放在ConnState中, 这些key不再使用
 const (
 	ConnKey                 = "WsConn"
 	EndedKey                = "WsEnded"
@@ -47,74 +129,60 @@ const (
 )
 
 func SetConn(ctx *dgctx.DgContext, conn *websocket.Conn) {
-	ctx.SetExtraKeyValue(ConnKey, conn)
+	GetConnState(ctx).conn.Store(conn)
 }
 
+// GetConn 返回底层连接, 直接写入与框架内的写入并发是不安全的, 回写消息时使用GetSafeConn或GetConnection
 func GetConn(ctx *dgctx.DgContext) *websocket.Conn {
-	conn := ctx.GetExtraValue(ConnKey)
-	if conn == nil {
-		return nil
-	}
-
-	return conn.(*websocket.Conn)
+	return GetConnState(ctx).conn.Load()
 }
 
+// SetWsEnded 将连接迁移到draining状态, 读循环随后结束
 func SetWsEnded(ctx *dgctx.DgContext) {
-	ctx.SetExtraKeyValue(EndedKey, true)
+	GetConnState(ctx).transition(ConnectionStateDraining)
 }
 
 func IsWsEnded(ctx *dgctx.DgContext) bool {
-	ended := ctx.GetExtraValue(EndedKey)
-	if ended == nil {
-		return false
-	}
-
-	e, ok := ended.(bool)
-	return ok && e
+	return GetConnState(ctx).Ended()
 }
 
 func SetForwardConn(ctx *dgctx.DgContext, forwardMark string, conn *websocket.Conn) {
-	ctx.SetExtraKeyValue(ForwardConnKey+forwardMark, conn)
+	GetConnState(ctx).forward(forwardMark, true).conn.Store(conn)
 }
 
 func GetForwardConn(ctx *dgctx.DgContext, forwardMark string) *websocket.Conn {
-	conn := ctx.GetExtraValue(ForwardConnKey + forwardMark)
-	if conn == nil {
+	fs := GetConnState(ctx).forward(forwardMark, false)
+	if fs == nil {
 		return nil
 	}
 
-	return conn.(*websocket.Conn)
+	return fs.conn.Load()
 }
 
 func SetForwardWsEnded(ctx *dgctx.DgContext, forwardMark string) {
-	ctx.SetExtraKeyValue(ForwardEndedKey+forwardMark, true)
+	GetConnState(ctx).forward(forwardMark, true).ended.Store(true)
 }
 
 func UnsetForwardWsEnded(ctx *dgctx.DgContext, forwardMark string) {
-	ctx.SetExtraKeyValue(ForwardEndedKey+forwardMark, false)
+	GetConnState(ctx).forward(forwardMark, true).ended.Store(false)
 }
 
 func IsForwardWsEnded(ctx *dgctx.DgContext, forwardMark string) bool {
-	ended := ctx.GetExtraValue(ForwardEndedKey + forwardMark)
-	if ended == nil {
-		return false
-	}
-
-	e, ok := ended.(bool)
-	return ok && e
+	fs := GetConnState(ctx).forward(forwardMark, false)
+	return fs != nil && fs.ended.Load()
 }
 
 func SetForwardConnTimestamp(ctx *dgctx.DgContext, forwardMark string, ts int64) {
-	ctx.SetExtraKeyValue(ForwardConnTimestampKey+forwardMark, ts)
+	GetConnState(ctx).forward(forwardMark, true).timestamp.Store(ts)
 }
 
 func GetForwardConnTimestamp(ctx *dgctx.DgContext, forwardMark string) int64 {
-	ts := ctx.GetExtraValue(ForwardConnTimestampKey + forwardMark)
-	if ts == nil {
+	fs := GetConnState(ctx).forward(forwardMark, false)
+	if fs == nil {
 		return 0
 	}
 
-	return ts.(int64)
+	return fs.timestamp.Load()
 }
 
 func InitWaitGroup(ctx *dgctx.DgContext) {
@@ -123,16 +191,11 @@ func InitWaitGroup(ctx *dgctx.DgContext) {
 }
 
 func SetWaitGroup(ctx *dgctx.DgContext, waitGroup *sync.WaitGroup) {
-	ctx.SetExtraKeyValue(WaitGroupKey, waitGroup)
+	GetConnState(ctx).waitGroup.Store(waitGroup)
 }
 
 func GetWaitGroup(ctx *dgctx.DgContext) *sync.WaitGroup {
-	waitGroup := ctx.GetExtraValue(WaitGroupKey)
-	if waitGroup == nil {
-		return nil
-	}
-
-	return waitGroup.(*sync.WaitGroup)
+	return GetConnState(ctx).waitGroup.Load()
 }
 
 func IncrWaitGroup(ctx *dgctx.DgContext) {
@@ -171,10 +234,9 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-var semaphore gocc.Semaphore
-
+// InitWsConnLimit 设置本实例的连接数上限, 运行时可通过SetConnLimit或管理接口调整, 见quota.go
 func InitWsConnLimit(limit uint) {
-	semaphore = gocc.NewDefaultSemaphore(limit)
+	SetConnLimit(limit)
 }
 
 func SetCheckOrigin(checkOriginFunc func(r *http.Request) bool) {
@@ -182,30 +244,101 @@ func SetCheckOrigin(checkOriginFunc func(r *http.Request) bool) {
 }
 
 func Get(rh *wrapper.RequestHolder[WebSocketMessage, error], conf *WebSocketHandlerConfig) {
+	if conf.StartHandler == nil {
+		conf.StartHandler = DefaultStartHandler
+	}
+	if conf.IsEndedHandler == nil {
+		conf.IsEndedHandler = DefaultIsEndHandler
+	}
+
 	bizHandler := func(c *gin.Context) {
-		if semaphore != nil {
-			if !semaphore.TryAcquire() {
-				c.AbortWithStatusJSON(http.StatusOK, result.FailByDgError[dgerr.DgError](dgerr.SYSTEM_BUSY))
-				return
-			}
-			defer semaphore.Release()
+		d := conf.resolveDefaults()
+		adm, ok := admitConnection(c, conf, d)
+		if !ok {
+			return
 		}
+		defer adm.release()
+		path := c.FullPath()
 		ctx := utils.GetDgContext(c)
-		bizKey := conf.BizKey
-		bizId := conf.GetBizIdHandler(c)
+		bizKey := adm.bizKey
+		bizId := adm.bizId
 
 		// 服务升级，对于来到的http连接进行服务升级，升级到ws
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		conn, wire, err := upgradeWithTimeout(c, d.UpgradeTimeout, conf.Subprotocols, conf.Compression != nil, conf.MaxWriteRetries)
 		if err != nil {
 			dglogger.Errorf(ctx, "[%s: %s] upgrade error: %v", bizKey, bizId, err)
 			return
 		}
+		if adm.redirect != "" {
+			dglogger.Infof(ctx, "[%s: %s] redirect to %s", bizKey, bizId, adm.redirect)
+			redirectConnection(ctx, conn, adm.redirect, conf.Redirect, d.WriteWait)
+			_ = conn.Close()
+			return
+		}
+		var compression *connCompression
+		if conf.Compression != nil {
+			if conf.Compression.Level != 0 {
+				if err := conn.SetCompressionLevel(conf.Compression.Level); err != nil {
+					dglogger.Warnf(ctx, "[%s: %s] set compression level error: %v", bizKey, bizId, err)
+				}
+			}
+			compression = &connCompression{options: conf.Compression, counter: getCompressionCounter(path), wire: wire}
+		}
 		SetConn(ctx, conn)
 		defer conn.Close()
 
-		if conf.StartHandler == nil {
-			conf.StartHandler = DefaultStartHandler
+		state := GetConnState(ctx)
+		state.onStateChange = func(from ConnectionState, to ConnectionState) {
+			if debugEnabled(path) {
+				dglogger.Infof(ctx, "[%s: %s] connection state %s -> %s", bizKey, bizId, from, to)
+			}
+			if conf.StateChangeHandler != nil {
+				conf.StateChangeHandler(ctx, from, to)
+			}
+			if connection := state.Connection(); conf.NotifyClientState && connection != nil {
+				if err := writeState(connection, to); err != nil {
+					dglogger.Warnf(ctx, "[%s: %s] write state %s error: %v", bizKey, bizId, to, err)
+				}
+			}
+		}
+		defer state.transition(ConnectionStateClosed)
+		defer state.initContext(c.Request.Context())()
+
+		// readLimit 设置OnMessageTooBig时自行检查消息大小, 以便在close帧之前发送错误消息
+		var readLimit int64
+		if d.MaxMessageSize > 0 {
+			if conf.OnMessageTooBig != nil {
+				readLimit = d.MaxMessageSize
+			} else {
+				conn.SetReadLimit(d.MaxMessageSize)
+			}
+		}
+		var ping *pingTask
+		pongWait := d.PongWait
+		if d.PingPeriod > 0 {
+			ping = schedulePing(ctx, conn, d.PingPeriod, d.MaxPingPeriod, d.WriteWait, conf.MaxMissedPongs, conf.MissedPongHandler)
+			defer ping.cancel()
+			if conf.MaxMissedPongs > 0 {
+				pongWait = 0
+			}
 		}
+		var deadline *readDeadline
+		if pongWait > 0 {
+			deadline = newReadDeadline(conn, pongWait)
+			defer deadline.stop()
+		}
+		if deadline != nil || ping != nil {
+			conn.SetPongHandler(func(string) error {
+				if ping != nil {
+					ping.pong()
+				}
+				if deadline != nil {
+					return deadline.extend()
+				}
+				return nil
+			})
+		}
+
 		err = conf.StartHandler(c, ctx, conn)
 		if err != nil {
 			dglogger.Errorf(ctx, "[%s: %s] start websocket error: %v", bizKey, bizId, err)
@@ -219,16 +352,171 @@ func Get(rh *wrapper.RequestHolder[WebSocketMessage, error], conf *WebSocketHand
 			return
 		}
 
-		if conf.IsEndedHandler == nil {
-			conf.IsEndedHandler = DefaultIsEndHandler
+		var acks *ackTracker
+		resumed := false
+		var session *serverSession
+		if conf.Session != nil {
+			session, resumed = resumeSession(ctx, c.GetHeader(ResumeTokenHeader), bizKey, bizId, conf)
+			acks = session.acks
+		} else if conf.Ack != nil {
+			acks = newAckTracker(conf.Ack, 0)
+		}
+
+		journal := newConnJournal(ctx, conf.Journal, bizKey, bizId)
+		connection := registerConnection(ctx, conn, nil, path, bizKey, bizId, d.WriteWait, compression, acks, journal)
+		defer unregisterConnection(connection)
+		if debugEnabled(path) {
+			dglogger.Infof(ctx, "[%s: %s] connection %s registered, path: %s, remote ip: %s, resumed: %v", bizKey, bizId, connection.Id, path, connection.RemoteIp, resumed)
+		}
+		if connection.SessionId != "" {
+			if err := writeSession(connection, connection.SessionId, resumed); err != nil {
+				dglogger.Warnf(ctx, "[%s: %s] write session error: %v", bizKey, bizId, err)
+			}
+		}
+		if resumed {
+			state.transition(ConnectionStateResuming)
 		}
+		if acks != nil {
+			acks.attach(connection)
+		}
+		state.transition(ConnectionStateOpen)
+		closeCode, closeReason := websocket.CloseAbnormalClosure, ""
+		if conf.OnConnect != nil {
+			conf.OnConnect(ctx, conn)
+		}
+		if conf.OnDisconnect != nil {
+			defer func() {
+				if frame := connection.closeFrame.Load(); frame != nil {
+					closeCode, closeReason = frame.code, frame.reason
+				}
+				conf.OnDisconnect(ctx, conn, closeCode, closeReason)
+			}()
+		}
+
+		var dispatcher messageDispatcher
+		var keyed *keyedDispatcher
+		if conf.EnableWorkerPool && workerPool != nil {
+			if conf.PartitionKey != nil {
+				keyed = workerPool.newKeyedDispatcher()
+				dispatcher = keyed
+			} else {
+				dispatcher = workerPool.newQueue()
+			}
+		} else {
+			dispatcher = newSerialDispatcher(defaultMaxPendingPerConn)
+		}
+		defer dispatcher.wait()
+		// 读循环退出后先取消连接的context, 再等待处理中的消息
+		defer state.cancelContext()
+
+		bizHandlerFunc := rh.BizHandler
+		if conf.Retry != nil {
+			bizHandlerFunc = withRetry(conf.Retry, bizKey, bizId, bizHandlerFunc)
+		}
+		bizHandlerFunc = withRecover(conf.OnPanic, bizKey, bizId, bizHandlerFunc)
+		bizHandlerFunc = withTrace(path, bizKey, bizId, withMetrics(path, bizHandlerFunc))
+		if conf.Idempotency != nil {
+			state.idempotency.Store(&idempotencyState{opts: conf.Idempotency, bizKey: bizKey})
+		}
+		handleMessage := func(msgCtx *dgctx.DgContext, wsm *WebSocketMessage) {
+			size := int64(len(wsm.MessageData))
+			defer state.pendingBytes.Add(-size)
+			if conf.Idempotency != nil {
+				key, handled := beginIdempotent(ctx, conf.Idempotency, bizKey, bizId, wsm)
+				if handled {
+					if conf.EnableMessagePool {
+						releaseMessage(wsm)
+					}
+					return
+				}
+				if key != "" {
+					if msgCtx == ctx {
+						msgCtx = newMessageContext(ctx)
+					}
+					msgCtx.SetExtraKeyValue(idempotencyKeyExtra, key)
+				}
+			}
+			if conf.Dedup != nil {
+				handleDedupMessage(c, msgCtx, conf.Dedup, bizKey, bizId, wsm, bizHandlerFunc)
+			} else if err := bizHandlerFunc(c, msgCtx, wsm); err != nil {
+				dglogger.Errorf(ctx, "[%s: %s] biz handle message error: %v", bizKey, bizId, err)
+			}
+			if conf.EnableMessagePool {
+				releaseMessage(wsm)
+			}
+		}
+
+		var batcher *messageBatcher
+		var handleBatch func()
+		if conf.BatchHandler != nil {
+			batcher = newMessageBatcher(conf.MaxBatchSize, defaultMaxPendingPerConn)
+			handleBatch = func() {
+				batch, more := batcher.take()
+				var size int64
+				for _, wsm := range batch {
+					size += int64(len(wsm.MessageData))
+				}
+				defer state.pendingBytes.Add(-size)
+				if more {
+					dispatcher.submit(handleBatch)
+				}
+				err := func() (err error) {
+					defer recoverPanic(ctx, conf.OnPanic, bizKey, bizId, &err)
+					return conf.BatchHandler(c, ctx, batch)
+				}()
+				getRouteWindow(path).record(time.Now(), len(batch), err != nil)
+				if err != nil {
+					dglogger.Errorf(ctx, "[%s: %s] biz handle batch error: %v", bizKey, bizId, err)
+				}
+				if conf.EnableMessagePool {
+					for _, wsm := range batch {
+						releaseMessage(wsm)
+					}
+				}
+			}
+		}
+
+		dispatch := func(wsm *WebSocketMessage) {
+			if batcher != nil {
+				if batcher.add(wsm) {
+					dispatcher.submit(handleBatch)
+				}
+				return
+			}
+			if keyed != nil {
+				// 不同分区的消息并行处理, 各自使用ctx的副本
+				keyed.submitKey(conf.PartitionKey(ctx, wsm), func() {
+					handleMessage(newMessageContext(ctx), wsm)
+				})
+			} else {
+				dispatcher.submit(func() {
+					handleMessage(ctx, wsm)
+				})
+			}
+		}
+		inbound := func(mt int, data []byte) {
+			state.pendingBytes.Add(int64(len(data)))
+			dispatch(&WebSocketMessage{Connection: conn, MessageType: mt, MessageData: data})
+		}
+		connection.inbound.Store(&inbound)
 
 		for {
 			if IsWsEnded(ctx) {
 				break
 			}
 
-			mt, message, err := conn.ReadMessage()
+			var (
+				mt      int
+				message []byte
+				buf     *bytes.Buffer
+			)
+			if conf.EnableMessagePool {
+				mt, message, buf, err = readPooledMessage(conn, readLimit)
+			} else if readLimit > 0 {
+				mt, message, err = readLimitedMessage(conn, readLimit)
+			} else {
+				mt, message, err = conn.ReadMessage()
+			}
 			if err != nil {
 				var ne net.Error
 				switch {
@@ -236,18 +524,49 @@ func Get(rh *wrapper.RequestHolder[WebSocketMessage, error], conf *WebSocketHand
 					dglogger.Errorf(ctx, "[%s: %s] server read message net error", bizKey, bizId)
 					break
 				}
+				if errors.Is(err, websocket.ErrReadLimit) {
+					dglogger.Warnf(ctx, "[%s: %s] message exceeds max size %d, close connection", bizKey, bizId, d.MaxMessageSize)
+					if conf.OnMessageTooBig != nil {
+						connection.closeMessageTooBig(conf.OnMessageTooBig(ctx, d.MaxMessageSize))
+					} else {
+						// gorilla已发送不带原因的1009
+						connection.closeFrame.CompareAndSwap(nil, &closeFrame{code: websocket.CloseMessageTooBig})
+					}
+				}
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					closeCode, closeReason = closeErr.Code, closeErr.Text
+				} else {
+					closeReason = err.Error()
+				}
 			}
 
 			if conf.IsEndedHandler(ctx, mt, message) {
 				SetWsEnded(ctx)
 				dglogger.Infof(ctx, "[%s: %s] server receive close message, error: %v", bizKey, bizId, err)
-				if conf.EndCallbackHandler != nil {
-					err := conf.EndCallbackHandler(ctx, conn)
-					if err != nil {
-						dglogger.Errorf(ctx, "[%s: %s] end callback error: %v", bizKey, bizId, err)
+				dispatcher.wait()
+				endCallback := func() {
+					if conf.EndCallbackHandler != nil {
+						if err := conf.EndCallbackHandler(ctx, conn); err != nil {
+							dglogger.Errorf(ctx, "[%s: %s] end callback error: %v", bizKey, bizId, err)
+						}
 					}
 				}
-				_ = conn.WriteMessage(websocket.CloseMessage, message)
+				// 开启会话时异常断开的连接等待恢复, 超过GraceWindow未恢复才回调EndCallbackHandler
+				if session != nil && !isNormalClose(err) {
+					session.deferEnd(connection, endCallback)
+				} else {
+					if session != nil {
+						session.endOnDetach(connection)
+					}
+					endCallback()
+				}
+				// 服务端已主动发出close帧(如1009)时不再重复发送
+				if connection.closeFrame.Load() == nil {
+					connection.lockWrite()
+					_ = conn.WriteMessage(websocket.CloseMessage, message)
+					connection.unlockWrite()
+				}
 				break
 			}
 
@@ -259,21 +578,71 @@ func Get(rh *wrapper.RequestHolder[WebSocketMessage, error], conf *WebSocketHand
 			if mt == websocket.PongMessage {
 				continue
 			}
+			if journal != nil {
+				journal.record(JournalDirectionIn, mt, message)
+			}
 
-			wsm := &WebSocketMessage{Connection: conn, MessageType: mt, MessageData: message}
-			err = rh.BizHandler(c, ctx, wsm)
-			if err != nil {
-				dglogger.Errorf(ctx, "[%s: %s] biz handle message error: %v", bizKey, bizId, err)
+			if connection.acks != nil && isAckEnvelope(mt, message) {
+				if env, ok := ParseEnvelope(message); ok {
+					connection.acks.handleEnvelope(env)
+				}
+				if buf != nil {
+					putBuffer(buf)
+				}
+				continue
+			}
+
+			if pending := state.pendingBytes.Add(int64(len(message))); d.MaxPendingBytes > 0 && pending > d.MaxPendingBytes {
+				state.pendingBytes.Add(-int64(len(message)))
+				dglogger.Warnf(ctx, "[%s: %s] pending bytes %d exceed budget %d, close connection", bizKey, bizId, pending, d.MaxPendingBytes)
+				state.transition(ConnectionStateDraining)
+				connection.closeFrame.CompareAndSwap(nil, &closeFrame{code: websocket.CloseMessageTooBig, reason: "memory budget exceeded"})
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "memory budget exceeded"), writeDeadline(d.WriteWait))
+				if buf != nil {
+					putBuffer(buf)
+				}
+				break
 			}
+
+			var wsm *WebSocketMessage
+			if conf.EnableMessagePool {
+				wsm = acquireMessage(conn, mt, message, buf)
+			} else {
+				wsm = &WebSocketMessage{Connection: conn, MessageType: mt, MessageData: message}
+			}
+			dispatch(wsm)
 		}
 	}
 
-	handlersChain := gin.HandlersChain{wrapper.LoginHandler(rh), wrapper.CheckProductHandler(rh), wrapper.CheckRolesHandler(rh), wrapper.CheckProfileHandler(), bizHandler}
-	if len(rh.PreHandlersChain) > 0 {
-		handlersChain = dgcoll.MergeToList(rh.PreHandlersChain, handlersChain)
+	rh.GET(rh.RelativePath, handlersChain(rh, bizHandler)...)
+	registerRouteState(routePath(rh.BasePath(), rh.RelativePath), rh.Remark)
+}
+
+// upgradeWithTimeout 开启压缩时同时返回统计写出字节数的底层连接
+func upgradeWithTimeout(c *gin.Context, timeout time.Duration, subprotocols []string, enableCompression bool, maxWriteRetries int) (*websocket.Conn, *countingConn, error) {
+	u := upgrader
+	if timeout > 0 {
+		u.HandshakeTimeout = timeout
+	}
+	u.Subprotocols = subprotocols
+	if !enableCompression && maxWriteRetries <= 0 {
+		conn, err := u.Upgrade(c.Writer, c.Request, nil)
+		return conn, nil, err
+	}
+
+	u.EnableCompression = enableCompression
+	w := &hijackResponseWriter{ResponseWriter: c.Writer, maxWriteRetries: maxWriteRetries, counting: enableCompression}
+	conn, err := u.Upgrade(w, c.Request, nil)
+
+	return conn, w.wire, err
+}
+
+func writeDeadline(writeWait time.Duration) time.Time {
+	if writeWait > 0 {
+		return time.Now().Add(writeWait)
 	}
 
-	rh.GET(rh.RelativePath, handlersChain...)
+	return time.Time{}
 }
 
 func WriteErrorResult(conn *websocket.Conn, err error) {
@@ -2,16 +2,15 @@ package dgws_test
 
 import (
 	"encoding/json"
-	"fmt"
 	dgctx "github.com/darwinOrg/go-common/context"
 	dglogger "github.com/darwinOrg/go-logger"
-	"github.com/darwinOrg/go-monitor"
-	"github.com/darwinOrg/go-web/wrapper"
 	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"net/url"
+	"os"
 	"testing"
 	"time"
 )
@@ -36,69 +35,74 @@ var datas = []testData{
 }
 
 func TestSendOwn(t *testing.T) {
-	dgws.InitWsConnLimit(10)
-	monitor.Start("test", 19002)
-	path := "/echo"
-	engine := wrapper.DefaultEngine()
-	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
-		RouterGroup: engine.Group(path),
-		BizHandler: func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
-			dglogger.Infof(ctx, "handle message: %s", string(wsm.MessageData))
-			return nil
-		},
-	}, &dgws.WebSocketHandlerConfig{
-		BizKey: "bizId",
-		GetBizIdHandler: func(c *gin.Context) string {
-			return c.Query("bizId")
-		},
-		StartHandler:       nil,
-		IsEndedHandler:     dgws.DefaultIsEndHandler,
-		EndCallbackHandler: nil,
+	received := make(chan string, len(datas))
+	dialer, _ := dgwstest.StartRoute(t, &dgws.WebSocketHandlerConfig{
+		IsEndedHandler: dgws.DefaultIsEndHandler,
+	}, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		dglogger.Infof(ctx, "handle message: %s", string(wsm.MessageData))
+		received <- string(wsm.MessageData)
+		return nil
 	})
-	go engine.Run(fmt.Sprintf(":%d", 8080))
-	time.Sleep(time.Second * 3)
 
 	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
-	sendMessage(ctx, "localhost:8080", path, datas, 5)
+	sendMessage(t, ctx, dialer.URL("own"), datas, 0)
+	for _, data := range datas {
+		body, _ := json.Marshal(data)
+		select {
+		case msg := <-received:
+			if msg != string(body) {
+				t.Fatalf("expected %s, got %s", body, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %s not handled", body)
+		}
+	}
 }
 
+// TestSendLocal 连接本地启动的服务, 需设置DGWS_E2E
 func TestSendLocal(t *testing.T) {
+	skipUnlessE2E(t)
 	dgws.InitWsConnLimit(10)
-	path := "/public/v1/ws/test"
+	u := url.URL{Scheme: "ws", Host: "localhost:9090", Path: "/public/v1/ws/test"}
 	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
-	sendMessage(ctx, "localhost:9090", path, datas, 5)
+	sendMessage(t, ctx, u.String(), datas, 5*time.Second)
 }
 
+// TestSendProd 连接线上服务, 需设置DGWS_E2E
 func TestSendProd(t *testing.T) {
+	skipUnlessE2E(t)
 	dgws.InitWsConnLimit(10)
-	path := "/ground/public/v1/ws/test"
+	u := url.URL{Scheme: "ws", Host: "e.globalpand.cn", Path: "/ground/public/v1/ws/test"}
 	ctx := &dgctx.DgContext{TraceId: uuid.NewString()}
-	sendMessage(ctx, "e.globalpand.cn", path, datas, 5)
+	sendMessage(t, ctx, u.String(), datas, 5*time.Second)
+}
+
+func skipUnlessE2E(t *testing.T) {
+	if os.Getenv("DGWS_E2E") == "" {
+		t.Skip("DGWS_E2E not set")
+	}
 }
 
-func sendMessage(ctx *dgctx.DgContext, host string, path string, datas []testData, intervalSeconds time.Duration) {
-	u := url.URL{Scheme: "ws", Host: host, Path: path}
-	dglogger.Infof(ctx, "client connecting to %s", u.String())
+func sendMessage(t *testing.T, ctx *dgctx.DgContext, wsUrl string, datas []testData, interval time.Duration) {
+	t.Helper()
+	dglogger.Infof(ctx, "client connecting to %s", wsUrl)
 
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	c, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
 	if err != nil {
-		dglogger.Fatalf(ctx, "dial server: %v", err)
+		t.Fatalf("dial server: %v", err)
 	}
 	defer c.Close()
 
 	for _, data := range datas {
 		body, _ := json.Marshal(data)
-		err := c.WriteMessage(websocket.TextMessage, body)
-		if err != nil {
-			dglogger.Errorf(ctx, "client write error: %v", err)
-			return
+		if err := c.WriteMessage(websocket.TextMessage, body); err != nil {
+			t.Fatalf("client write: %v", err)
 		}
-		time.Sleep(time.Second * intervalSeconds)
+		time.Sleep(interval)
 	}
 
 	err = c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end"))
 	if err != nil {
-		dglogger.Errorf(ctx, "client write close error: %v", err)
-		return
+		t.Fatalf("client write close: %v", err)
 	}
 }
@@ -0,0 +1,178 @@
+package wsbench_test
+
+import (
+	"context"
+	dgctx "github.com/darwinOrg/go-common/context"
+	"github.com/darwinOrg/go-web/wrapper"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/wsbench"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startServer(b testing.TB, conf *dgws.WebSocketHandlerConfig, handled *atomic.Int64) string {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	conf.BizKey = "bizId"
+	conf.GetBizIdHandler = func(c *gin.Context) string {
+		return c.Query("bizId")
+	}
+	dgws.Get(&wrapper.RequestHolder[dgws.WebSocketMessage, error]{
+		RouterGroup: engine.Group("/bench"),
+		NonLogin:    true,
+		BizHandler: func(_ *gin.Context, _ *dgctx.DgContext, _ *dgws.WebSocketMessage) error {
+			handled.Add(1)
+			return nil
+		},
+	}, conf)
+
+	server := httptest.NewServer(engine)
+	b.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/bench"
+}
+
+func benchmarkReadLoop(b *testing.B, conf *dgws.WebSocketHandlerConfig) {
+	var handled atomic.Int64
+	url := startServer(b, conf, &handled)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		b.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	payload := make([]byte, 256)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			b.Fatalf("write: %v", err)
+		}
+	}
+	for handled.Load() < int64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkReadLoop(b *testing.B) {
+	benchmarkReadLoop(b, &dgws.WebSocketHandlerConfig{})
+}
+
+func BenchmarkReadLoopPooled(b *testing.B) {
+	benchmarkReadLoop(b, &dgws.WebSocketHandlerConfig{EnableMessagePool: true})
+}
+
+func BenchmarkConnectionWrite(b *testing.B) {
+	var handled atomic.Int64
+	url := startServer(b, &dgws.WebSocketHandlerConfig{}, &handled)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=write", nil)
+	if err != nil {
+		b.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	go drain(conn)
+
+	connection := waitConnection(b, "write")
+	payload := make([]byte, 256)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := connection.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			b.Fatalf("write: %v", err)
+		}
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	var handled atomic.Int64
+	url := startServer(b, &dgws.WebSocketHandlerConfig{}, &handled)
+
+	const connections = 100
+	for i := 0; i < connections; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?bizId=broadcast", nil)
+		if err != nil {
+			b.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		go drain(conn)
+	}
+	for countConnections("broadcast") < connections {
+		time.Sleep(time.Millisecond)
+	}
+
+	payload := make([]byte, 256)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := dgws.BroadcastToBizIds("bizId", []string{"broadcast"}, websocket.BinaryMessage, payload); err != nil {
+			b.Fatalf("broadcast: %v", err)
+		}
+	}
+}
+
+func TestRunLoad(t *testing.T) {
+	var handled atomic.Int64
+	url := startServer(t, &dgws.WebSocketHandlerConfig{}, &handled)
+
+	report, err := wsbench.Run(context.Background(), &wsbench.LoadConfig{
+		Url:               url,
+		Connections:       5,
+		MessagesPerSecond: 100,
+		Duration:          300 * time.Millisecond,
+		Sizes:             []wsbench.SizeWeight{{Size: 16, Weight: 3}, {Size: 1024, Weight: 1}},
+	})
+	if err != nil {
+		t.Fatalf("run load: %v", err)
+	}
+	if report.FailedDials > 0 || report.MessagesSent == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func drain(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func countConnections(bizId string) int {
+	n := 0
+	dgws.RangeConnections(func(c *dgws.Connection) bool {
+		if c.BizId == bizId {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+func waitConnection(b testing.TB, bizId string) *dgws.Connection {
+	for {
+		var found *dgws.Connection
+		dgws.RangeConnections(func(c *dgws.Connection) bool {
+			if c.BizId == bizId {
+				found = c
+				return false
+			}
+			return true
+		})
+		if found != nil {
+			return found
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
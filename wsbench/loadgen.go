@@ -0,0 +1,171 @@
+package wsbench
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type SizeWeight struct {
+	Size   int
+	Weight int
+}
+
+type LoadConfig struct {
+	Url    string
+	Header http.Header
+	// Connections 并发连接数
+	Connections int
+	// MessagesPerSecond 每个连接每秒发送的消息数
+	MessagesPerSecond int
+	Duration          time.Duration
+	// Sizes 消息大小按权重随机选取, 为空时固定为64字节
+	Sizes       []SizeWeight
+	MessageType int
+}
+
+type Report struct {
+	Connections   int           `json:"connections"`
+	FailedDials   int64         `json:"failedDials"`
+	MessagesSent  int64         `json:"messagesSent"`
+	BytesSent     int64         `json:"bytesSent"`
+	WriteErrors   int64         `json:"writeErrors"`
+	MessagesRecv  int64         `json:"messagesRecv"`
+	Elapsed       time.Duration `json:"elapsed"`
+	SendRate      float64       `json:"sendRate"`
+	ReceivedBytes int64         `json:"receivedBytes"`
+}
+
+// Run 按配置建立连接并持续发送消息, 直到Duration结束或ctx取消
+func Run(ctx context.Context, conf *LoadConfig) (*Report, error) {
+	if conf.Url == "" || conf.Connections <= 0 {
+		return nil, errors.New("url and connections are required")
+	}
+	if conf.MessageType == 0 {
+		conf.MessageType = websocket.BinaryMessage
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, conf.Duration)
+	defer cancel()
+
+	report := &Report{Connections: conf.Connections}
+	sizes := newSizePicker(conf.Sizes)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < conf.Connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runConnection(ctx, conf, sizes, report)
+		}()
+	}
+	wg.Wait()
+
+	report.Elapsed = time.Since(start)
+	if seconds := report.Elapsed.Seconds(); seconds > 0 {
+		report.SendRate = float64(report.MessagesSent) / seconds
+	}
+
+	return report, nil
+}
+
+func runConnection(ctx context.Context, conf *LoadConfig, sizes *sizePicker, report *Report) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, conf.Url, conf.Header)
+	if err != nil {
+		atomic.AddInt64(&report.FailedDials, 1)
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&report.MessagesRecv, 1)
+			atomic.AddInt64(&report.ReceivedBytes, int64(len(data)))
+		}
+	}()
+
+	interval := time.Duration(0)
+	if conf.MessagesPerSecond > 0 {
+		interval = time.Second / time.Duration(conf.MessagesPerSecond)
+	}
+	payload := make([]byte, sizes.max())
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		default:
+		}
+
+		size := sizes.pick()
+		if err := conn.WriteMessage(conf.MessageType, payload[:size]); err != nil {
+			atomic.AddInt64(&report.WriteErrors, 1)
+			return
+		}
+		atomic.AddInt64(&report.MessagesSent, 1)
+		atomic.AddInt64(&report.BytesSent, int64(size))
+
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+type sizePicker struct {
+	sizes []SizeWeight
+	total int
+	lock  sync.Mutex
+	rand  *rand.Rand
+}
+
+func newSizePicker(sizes []SizeWeight) *sizePicker {
+	if len(sizes) == 0 {
+		sizes = []SizeWeight{{Size: 64, Weight: 1}}
+	}
+
+	p := &sizePicker{sizes: sizes, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, s := range sizes {
+		p.total += max(s.Weight, 0)
+	}
+	return p
+}
+
+func (p *sizePicker) pick() int {
+	if p.total == 0 {
+		return p.sizes[0].Size
+	}
+
+	p.lock.Lock()
+	n := p.rand.Intn(p.total)
+	p.lock.Unlock()
+
+	for _, s := range p.sizes {
+		if n < s.Weight {
+			return s.Size
+		}
+		n -= max(s.Weight, 0)
+	}
+	return p.sizes[len(p.sizes)-1].Size
+}
+
+func (p *sizePicker) max() int {
+	m := 0
+	for _, s := range p.sizes {
+		m = max(m, s.Size)
+	}
+	return m
+}
@@ -0,0 +1,134 @@
+package wsbench
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+const maxLatencySamples = 100000
+
+// Percentiles 单位毫秒, Count为样本总数(超过上限后按蓄水池抽样计算)
+type Percentiles struct {
+	Count int64   `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// latencyRecorder 最多保留maxLatencySamples个样本, 超出后蓄水池抽样, 内存占用固定
+type latencyRecorder struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+	rand    *rand.Rand
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *latencyRecorder) record(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.count++
+	r.sum += d
+	r.max = max(r.max, d)
+	if len(r.samples) < maxLatencySamples {
+		r.samples = append(r.samples, d)
+	} else if i := r.rand.Int63n(r.count); i < maxLatencySamples {
+		r.samples[i] = d
+	}
+}
+
+func (r *latencyRecorder) merge(other *latencyRecorder) {
+	other.lock.Lock()
+	samples := slices.Clone(other.samples)
+	count, sum, maxLatency := other.count, other.sum, other.max
+	other.lock.Unlock()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.count += count
+	r.sum += sum
+	r.max = max(r.max, maxLatency)
+	for _, d := range samples {
+		if len(r.samples) < maxLatencySamples {
+			r.samples = append(r.samples, d)
+		} else {
+			r.samples[r.rand.Intn(maxLatencySamples)] = d
+		}
+	}
+}
+
+func (r *latencyRecorder) percentiles() Percentiles {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.count == 0 {
+		return Percentiles{}
+	}
+
+	sorted := slices.Clone(r.samples)
+	slices.Sort(sorted)
+	at := func(q float64) float64 {
+		return millis(sorted[min(len(sorted)-1, int(q*float64(len(sorted))))])
+	}
+	return Percentiles{
+		Count: r.count,
+		Mean:  millis(r.sum) / float64(r.count),
+		P50:   at(0.5),
+		P90:   at(0.9),
+		P95:   at(0.95),
+		P99:   at(0.99),
+		Max:   millis(r.max),
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// PhaseReport 单个阶段的结果, Total中Kind为空
+type PhaseReport struct {
+	Name string    `json:"name"`
+	Kind PhaseKind `json:"kind,omitempty"`
+	// Elapsed 毫秒
+	Elapsed int64 `json:"elapsed"`
+	// PeakConnections 阶段内同时在线的最大连接数
+	PeakConnections int     `json:"peakConnections"`
+	Dials           int64   `json:"dials"`
+	FailedDials     int64   `json:"failedDials"`
+	MessagesSent    int64   `json:"messagesSent"`
+	BytesSent       int64   `json:"bytesSent"`
+	WriteErrors     int64   `json:"writeErrors"`
+	MessagesRecv    int64   `json:"messagesRecv"`
+	ReceivedBytes   int64   `json:"receivedBytes"`
+	SendRate        float64 `json:"sendRate"`
+	// DialLatency 握手耗时
+	DialLatency Percentiles `json:"dialLatency"`
+	// RoundTrip 服务端对每条消息按顺序回复一条时的往返延迟, 否则为空
+	RoundTrip Percentiles `json:"roundTrip"`
+}
+
+// ScenarioReport RunScenario的结果, 可直接序列化后归档, 用于对比各版本的容量
+type ScenarioReport struct {
+	Scenario  string         `json:"scenario"`
+	Url       string         `json:"url"`
+	StartedAt time.Time      `json:"startedAt"`
+	Phases    []*PhaseReport `json:"phases"`
+	Total     *PhaseReport   `json:"total"`
+}
+
+func (r *ScenarioReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,303 @@
+package wsbench
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rampStep          = 100 * time.Millisecond
+	maxPendingReplies = 1024
+)
+
+type PhaseKind string
+
+const (
+	// PhaseRamp 连接数在Duration内从上一阶段线性变化到Connections
+	PhaseRamp PhaseKind = "ramp"
+	// PhaseSustain 保持当前连接数
+	PhaseSustain PhaseKind = "sustain"
+	// PhaseSpike 立即增加到Connections, 持续Duration后恢复到之前的连接数
+	PhaseSpike PhaseKind = "spike"
+)
+
+type Phase struct {
+	// Name 为空时使用Kind
+	Name        string
+	Kind        PhaseKind
+	Duration    time.Duration
+	Connections int
+}
+
+func Ramp(connections int, d time.Duration) Phase {
+	return Phase{Kind: PhaseRamp, Duration: d, Connections: connections}
+}
+
+func Sustain(d time.Duration) Phase {
+	return Phase{Kind: PhaseSustain, Duration: d}
+}
+
+func Spike(connections int, d time.Duration) Phase {
+	return Phase{Kind: PhaseSpike, Duration: d, Connections: connections}
+}
+
+// Scenario 按Phases依次调整连接数, 每个连接按MessagesPerSecond持续发送, 见RunScenario
+type Scenario struct {
+	Name   string
+	Url    string
+	Header http.Header
+	// MessagesPerSecond 每个连接每秒发送的消息数, 0表示不限速
+	MessagesPerSecond int
+	// Sizes 同LoadConfig.Sizes
+	Sizes       []SizeWeight
+	MessageType int
+	Phases      []Phase
+}
+
+type phaseStats struct {
+	phase         Phase
+	elapsed       time.Duration
+	peak          atomic.Int64
+	dials         atomic.Int64
+	failedDials   atomic.Int64
+	messagesSent  atomic.Int64
+	bytesSent     atomic.Int64
+	writeErrors   atomic.Int64
+	messagesRecv  atomic.Int64
+	receivedBytes atomic.Int64
+	dialLatency   *latencyRecorder
+	roundTrip     *latencyRecorder
+}
+
+func newPhaseStats(phase Phase) *phaseStats {
+	return &phaseStats{phase: phase, dialLatency: newLatencyRecorder(), roundTrip: newLatencyRecorder()}
+}
+
+func (s *phaseStats) observeConnections(n int64) {
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+type scenarioRunner struct {
+	scenario *Scenario
+	sizes    *sizePicker
+	stats    atomic.Pointer[phaseStats]
+	active   atomic.Int64
+	workers  []context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// RunScenario 依次执行各阶段, ctx取消时提前结束并返回已完成部分的报告
+func RunScenario(ctx context.Context, s *Scenario) (*ScenarioReport, error) {
+	if s.Url == "" || len(s.Phases) == 0 {
+		return nil, errors.New("url and phases are required")
+	}
+	if s.MessageType == 0 {
+		s.MessageType = websocket.BinaryMessage
+	}
+
+	r := &scenarioRunner{scenario: s, sizes: newSizePicker(s.Sizes)}
+	report := &ScenarioReport{Scenario: s.Name, Url: s.Url, StartedAt: time.Now()}
+	var all []*phaseStats
+	for _, phase := range s.Phases {
+		if ctx.Err() != nil {
+			break
+		}
+		if phase.Name == "" {
+			phase.Name = string(phase.Kind)
+		}
+		stats := newPhaseStats(phase)
+		stats.peak.Store(r.active.Load())
+		r.stats.Store(stats)
+
+		start := time.Now()
+		r.runPhase(ctx, phase)
+		stats.elapsed = time.Since(start)
+		all = append(all, stats)
+	}
+	r.resize(ctx, 0)
+	r.wg.Wait()
+
+	total := newPhaseStats(Phase{Name: "total"})
+	for _, stats := range all {
+		report.Phases = append(report.Phases, stats.report())
+		total.elapsed += stats.elapsed
+		total.observeConnections(stats.peak.Load())
+		total.dials.Add(stats.dials.Load())
+		total.failedDials.Add(stats.failedDials.Load())
+		total.messagesSent.Add(stats.messagesSent.Load())
+		total.bytesSent.Add(stats.bytesSent.Load())
+		total.writeErrors.Add(stats.writeErrors.Load())
+		total.messagesRecv.Add(stats.messagesRecv.Load())
+		total.receivedBytes.Add(stats.receivedBytes.Load())
+		total.dialLatency.merge(stats.dialLatency)
+		total.roundTrip.merge(stats.roundTrip)
+	}
+	report.Total = total.report()
+
+	return report, nil
+}
+
+func (r *scenarioRunner) runPhase(ctx context.Context, phase Phase) {
+	timer := time.NewTimer(phase.Duration)
+	defer timer.Stop()
+
+	switch phase.Kind {
+	case PhaseRamp:
+		from := len(r.workers)
+		ticker := time.NewTicker(rampStep)
+		defer ticker.Stop()
+		start := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				r.resize(ctx, phase.Connections)
+				return
+			case <-ticker.C:
+				progress := float64(time.Since(start)) / float64(phase.Duration)
+				r.resize(ctx, from+int(float64(phase.Connections-from)*min(progress, 1)))
+			}
+		}
+	case PhaseSpike:
+		before := len(r.workers)
+		r.resize(ctx, phase.Connections)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		r.resize(ctx, before)
+	default:
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	}
+}
+
+// resize 增加时启动新连接, 减少时关闭最后启动的连接
+func (r *scenarioRunner) resize(ctx context.Context, n int) {
+	for len(r.workers) < n {
+		workerCtx, cancel := context.WithCancel(ctx)
+		r.workers = append(r.workers, cancel)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.runConnection(workerCtx)
+		}()
+	}
+	for len(r.workers) > n {
+		last := len(r.workers) - 1
+		r.workers[last]()
+		r.workers = r.workers[:last]
+	}
+}
+
+func (r *scenarioRunner) runConnection(ctx context.Context) {
+	s := r.scenario
+	dialStart := time.Now()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.Url, s.Header)
+	stats := r.stats.Load()
+	stats.dials.Add(1)
+	if err != nil {
+		if ctx.Err() == nil {
+			stats.failedDials.Add(1)
+		}
+		return
+	}
+	defer conn.Close()
+	stats.dialLatency.record(time.Since(dialStart))
+	stats.observeConnections(r.active.Add(1))
+	defer r.active.Add(-1)
+
+	// pending 已发送但尚未收到回复的消息的发送时间, 按顺序与收到的消息配对
+	var (
+		pendingLock sync.Mutex
+		pending     []time.Time
+	)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			stats := r.stats.Load()
+			stats.messagesRecv.Add(1)
+			stats.receivedBytes.Add(int64(len(data)))
+			pendingLock.Lock()
+			if len(pending) > 0 {
+				stats.roundTrip.record(time.Since(pending[0]))
+				pending = pending[1:]
+			}
+			pendingLock.Unlock()
+		}
+	}()
+
+	interval := time.Duration(0)
+	if s.MessagesPerSecond > 0 {
+		interval = time.Second / time.Duration(s.MessagesPerSecond)
+	}
+	payload := make([]byte, r.sizes.max())
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		default:
+		}
+
+		size := r.sizes.pick()
+		sentAt := time.Now()
+		pendingLock.Lock()
+		if len(pending) < maxPendingReplies {
+			pending = append(pending, sentAt)
+		}
+		pendingLock.Unlock()
+		stats := r.stats.Load()
+		if err := conn.WriteMessage(s.MessageType, payload[:size]); err != nil {
+			stats.writeErrors.Add(1)
+			return
+		}
+		stats.messagesSent.Add(1)
+		stats.bytesSent.Add(int64(size))
+
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+func (s *phaseStats) report() *PhaseReport {
+	report := &PhaseReport{
+		Name:            s.phase.Name,
+		Kind:            s.phase.Kind,
+		Elapsed:         s.elapsed.Milliseconds(),
+		PeakConnections: int(s.peak.Load()),
+		Dials:           s.dials.Load(),
+		FailedDials:     s.failedDials.Load(),
+		MessagesSent:    s.messagesSent.Load(),
+		BytesSent:       s.bytesSent.Load(),
+		WriteErrors:     s.writeErrors.Load(),
+		MessagesRecv:    s.messagesRecv.Load(),
+		ReceivedBytes:   s.receivedBytes.Load(),
+		DialLatency:     s.dialLatency.percentiles(),
+		RoundTrip:       s.roundTrip.percentiles(),
+	}
+	if seconds := s.elapsed.Seconds(); seconds > 0 {
+		report.SendRate = float64(report.MessagesSent) / seconds
+	}
+	return report
+}
@@ -0,0 +1,57 @@
+package wsbench_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	dgctx "github.com/darwinOrg/go-common/context"
+	dgws "github.com/darwinOrg/go-websocket"
+	"github.com/darwinOrg/go-websocket/dgwstest"
+	"github.com/darwinOrg/go-websocket/wsbench"
+	"github.com/gin-gonic/gin"
+	"testing"
+	"time"
+)
+
+func TestRunScenario(t *testing.T) {
+	dialer, _ := dgwstest.StartRoute(t, nil, func(_ *gin.Context, ctx *dgctx.DgContext, wsm *dgws.WebSocketMessage) error {
+		return dgws.GetConnection(ctx).WriteMessage(wsm.MessageType, wsm.MessageData)
+	})
+
+	report, err := wsbench.RunScenario(context.Background(), &wsbench.Scenario{
+		Name:              "echo",
+		Url:               dialer.URL("scenario"),
+		MessagesPerSecond: 50,
+		Phases: []wsbench.Phase{
+			wsbench.Ramp(4, 300*time.Millisecond),
+			wsbench.Sustain(200 * time.Millisecond),
+			wsbench.Spike(8, 200*time.Millisecond),
+		},
+	})
+	if err != nil {
+		t.Fatalf("run scenario: %v", err)
+	}
+
+	if len(report.Phases) != 3 || report.Phases[0].Kind != wsbench.PhaseRamp {
+		t.Fatalf("unexpected phases: %+v", report.Phases)
+	}
+	if report.Phases[1].PeakConnections != 4 || report.Phases[2].PeakConnections != 8 {
+		t.Fatalf("unexpected peak connections: %d, %d", report.Phases[1].PeakConnections, report.Phases[2].PeakConnections)
+	}
+	total := report.Total
+	if total.FailedDials > 0 || total.Dials != 8 || total.MessagesSent == 0 || total.RoundTrip.Count == 0 || total.DialLatency.Count != 8 {
+		t.Fatalf("unexpected total: %+v", total)
+	}
+	if total.RoundTrip.P50 > total.RoundTrip.P99 || total.RoundTrip.P99 > total.RoundTrip.Max {
+		t.Fatalf("percentiles out of order: %+v", total.RoundTrip)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &wsbench.ScenarioReport{}
+	if err := json.Unmarshal(buf.Bytes(), decoded); err != nil || decoded.Total.MessagesSent != total.MessagesSent {
+		t.Fatalf("report json: %v", err)
+	}
+}